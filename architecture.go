@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"maps"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// How a source names architectures when `ArchitectureNames` does not name them
+const (
+	// The names that `runtime.GOARCH` uses, like `amd64` and `arm64`, which are used by default
+	goArchitectureNaming = "go"
+	// The names that `uname -m` prints, like `x86_64` and `aarch64`, which most projects name their releases with
+	unameArchitectureNaming = "uname"
+)
+
+var architectureNamings = []string{goArchitectureNaming, unameArchitectureNaming}
+
+// Maps the names that `runtime.GOARCH` uses to the names that `uname -m` prints on the same architecture. Only 32-bit
+// arm uses several names, because `uname -m` prints the version of the CPU, so `armv7l` is used as it is the oldest
+// version that most distros still support.
+var unameArchitectureNames = map[string]string{
+	"386":      "i686",
+	"amd64":    "x86_64",
+	"arm":      "armv7l",
+	"arm64":    "aarch64",
+	"loong64":  "loongarch64",
+	"mips64le": "mips64",
+	"ppc64le":  "ppc64le",
+	"riscv64":  "riscv64",
+	"s390x":    "s390x",
+}
+
+// Returns the name that a source uses for an architecture, along with where the name comes from
+func resolveArchitectureName(unparsedSourceConf unparsedSourceConfig, architecture string) (string, string, error) {
+	if name, ok := unparsedSourceConf.ArchitectureNames[architecture]; ok {
+		return name, "set in `ArchitectureNames`", nil
+	}
+	switch unparsedSourceConf.ArchitectureNaming {
+	case "", goArchitectureNaming:
+		return architecture, "the name that Go uses", nil
+	case unameArchitectureNaming:
+		if name, ok := unameArchitectureNames[architecture]; ok {
+			return name, "the name that `uname -m` prints", nil
+		}
+		return architecture, "the name that Go uses, because bento does not know the name that `uname -m` prints for it", nil
+	}
+	return "", "", errors.New("Expected `ArchitectureNaming` to be either `" + strings.Join(architectureNamings, "`, or `") + "`, but got `" + unparsedSourceConf.ArchitectureNaming + "`")
+}
+
+// Prints how a source is resolved for an architecture, which can be a different architecture to the one that bento is
+// running on, so that packagers can check the URLs and checksums of every architecture that a source supports
+func printSourceInfo(bentoDir string, sourceName string, architecture string) {
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	r := newResolver(bentoDir, environmentToMap(os.Environ()), installedFeatures, config)
+	r.architecture = architecture
+	sourceConf, err := r.loadSource(sourceName)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	unparsedSourceConf, err := r.decodeSourceConfig(path.Join(r.sourcesDir, sourceName+".toml"))
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	architectureName, origin, err := resolveArchitectureName(unparsedSourceConf, architecture)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	printWarnings(r.warnings)
+
+	rows := [][]string{
+		{"Source:", sourceName},
+		{"Version:", sourceConf.version},
+		{"Architecture:", architecture + " is called `" + architectureName + "` (" + origin + ")"},
+	}
+	if architecture != runtime.GOARCH {
+		rows = append(rows, []string{"", utils.AnsiAttention + "this is not the architecture that bento is running on (" + runtime.GOARCH + ")" + utils.AnsiReset})
+	}
+	if len(unparsedSourceConf.ArchitectureNames) > 0 {
+		rows = append(rows, []string{"Named:", strings.Join(slices.Sorted(maps.Keys(unparsedSourceConf.ArchitectureNames)), ", ") + " in `ArchitectureNames`"})
+	}
+	rows = append(rows, []string{"Path:", sourceConf.path})
+	for _, artifact := range sourceConf.artifacts {
+		rows = append(rows, []string{"Artifact:", utils.AnsiBold + artifact.description + utils.AnsiReset})
+		for _, url := range artifact.parsedUrls {
+			rows = append(rows, []string{"", "from " + url})
+		}
+		if len(artifact.checksumUrls) > 0 {
+			rows = append(rows, []string{"", utils.AnsiAttention + "sha256 fetched from " + artifact.checksumUrls[0] + " when it is installed" + utils.AnsiReset})
+		} else {
+			rows = append(rows, []string{"", "sha256 " + hex.EncodeToString(artifact.parsedChecksum[:])})
+		}
+	}
+	if len(sourceConf.contactedDomains) > 0 {
+		rows = append(rows, []string{"Contacts:", strings.Join(sourceConf.contactedDomains, ", ")})
+	} else {
+		rows = append(rows, []string{"Contacts:", utils.AnsiAttention + "the source does not declare which domains it contacts" + utils.AnsiReset})
+	}
+	for _, line := range utils.AlignColumns(rows) {
+		println(line)
+	}
+}
+
+// Returns true if sources are resolved for the system that bento is running on, rather than for another system that
+// `bento fetch` is downloading them for
+func (r *resolver) resolvesForHost() bool {
+	return r.architecture == runtime.GOARCH && r.operatingSystem == runtime.GOOS
+}
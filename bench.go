@@ -0,0 +1,372 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// A benchmark that `bento bench` runs, so that a change that is meant to make bento faster can be measured, and a
+// change that makes it slower can be caught
+type benchmark struct {
+	name string
+	// Prepares the benchmark in an empty directory, and returns the function that is timed, which can be called more
+	// than once. Anything that has to be stopped once the benchmark is finished, like a server, is passed to `cleanup`.
+	prepare func(workDir string, cleanup func(func())) (func() error, error)
+}
+
+var benchmarks = []benchmark{
+	{"resolve-closure", prepareResolutionBenchmark},
+	{"download-concurrently", prepareDownloadBenchmark},
+	{"extract-tar-gz", func(workDir string, _ func(func())) (func() error, error) {
+		return prepareExtractionBenchmark(workDir, ".tar.gz")
+	}},
+	{"extract-zip", func(workDir string, _ func(func())) (func() error, error) {
+		return prepareExtractionBenchmark(workDir, ".zip")
+	}},
+}
+
+func benchmarkNames() []string {
+	names := []string{}
+	for _, bench := range benchmarks {
+		names = append(names, bench.name)
+	}
+	return names
+}
+
+// The environment variables that point to empty directories while the benchmarks run
+var benchmarkIsolatedVariables = []string{"XDG_CACHE_HOME", "XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME"}
+
+// How long a benchmark took, which is saved with `bento bench --save` so that later runs can be compared with it
+type benchmarkResult struct {
+	Runs               int
+	MedianNanoseconds  int64
+	FastestNanoseconds int64
+}
+
+type benchOptions struct {
+	runs int
+	// The names of the benchmarks to run, or nothing to run every benchmark
+	only []string
+	// The file to save the results to, if it is not empty
+	savePath string
+	// The file with the results to compare with, if it is not empty
+	comparePath string
+	// How much slower than the results that they are compared with the benchmarks can be, in percent
+	tolerance float64
+}
+
+// The number of sources in the closure that is resolved, where every source depends on the next two, like a binary tree
+const benchmarkClosureSize = 500
+
+// Creates a package repository with a large closure of sources that depend on each other, and returns a function that
+// resolves it from scratch
+func prepareResolutionBenchmark(workDir string, _ func(func())) (func() error, error) {
+	bentoDir := path.Join(workDir, "bento")
+	for _, directory := range []string{"sources", "lib"} {
+		err := os.MkdirAll(path.Join(bentoDir, directory), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for index := range benchmarkClosureSize {
+		name := "bench-" + strconv.Itoa(index)
+		dependencies := [][2]string{}
+		for _, dependency := range []int{2*index + 1, 2*index + 2} {
+			if dependency < benchmarkClosureSize {
+				dependencies = append(dependencies, [2]string{"bench-" + strconv.Itoa(dependency), "bin/tool"})
+			}
+		}
+		library := "libbench-" + strconv.Itoa(index%50)
+		// The sources are never downloaded, so their checksums only have to be valid
+		checksum := sha256.Sum256([]byte(name))
+		sourceConf := map[string]any{
+			"UrlInMirror":                     name + ".tar.gz",
+			"Mirrors":                         []string{"https://example.com"},
+			"Compression":                     ".tar.gz",
+			"Version":                         map[string]string{"v": "1.0"},
+			"Checksums":                       map[string]string{name + ".tar.gz": hex.EncodeToString(checksum[:])},
+			"ExecutableDependencies":          dependencies,
+			"Env":                             map[string]map[string]string{"bin/tool": {"BENCH_DATA": "${" + name + "}/share"}},
+			"DirectSharedLibraryDependencies": map[string][]string{"bin/tool": {library}},
+		}
+		err := writeToml(path.Join(bentoDir, "sources", name+".toml"), sourceConf)
+		if err != nil {
+			return nil, err
+		}
+		if index < 50 {
+			err = writeToml(path.Join(bentoDir, "lib", library+".toml"), unparsedLibrary{Source: name, Directory: "lib"})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return func() error {
+		r := newResolver(bentoDir, environmentToMap(os.Environ()), map[string][]string{}, userConfig{})
+		_, err := r.resolveCommand("bench-0", "bin/tool")
+		if err == nil && len(r.sources) != benchmarkClosureSize {
+			err = errors.New("Expected " + strconv.Itoa(benchmarkClosureSize) + " sources to be resolved, but " + strconv.Itoa(len(r.sources)) + " were")
+		}
+		return err
+	}, nil
+}
+
+func writeToml(filePath string, value any) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return toml.NewEncoder(file).Encode(value)
+}
+
+type benchmarkFile struct {
+	name string
+	size int
+}
+
+// Writes an archive that is like the archive of a typical source, with many small files and a few large ones. The
+// contents are random so that they do not compress much better than real files do.
+func writeBenchmarkArchive(writer io.Writer, compression string, seed int64, smallFiles int, largeFiles int) error {
+	random := rand.New(rand.NewSource(seed))
+	files := []benchmarkFile{}
+	for index := range smallFiles {
+		files = append(files, benchmarkFile{"tool/share/" + strconv.Itoa(index/100) + "/file-" + strconv.Itoa(index), 1024 + random.Intn(7*1024)})
+	}
+	for index := range largeFiles {
+		files = append(files, benchmarkFile{"tool/bin/large-" + strconv.Itoa(index), 4 * 1024 * 1024})
+	}
+	contents := func(size int) []byte {
+		// Half of every file is random, and the other half repeats, like the mix of code and data in a binary
+		data := make([]byte, size)
+		random.Read(data[:size/2])
+		for index := size / 2; index < size; index++ {
+			data[index] = byte(index % 64)
+		}
+		return data
+	}
+	switch compression {
+	case ".tar.gz":
+		compressed := gzip.NewWriter(writer)
+		archive := tar.NewWriter(compressed)
+		for _, file := range files {
+			err := archive.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(file.size), Typeflag: tar.TypeReg})
+			if err == nil {
+				_, err = archive.Write(contents(file.size))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		err := archive.Close()
+		if err != nil {
+			return err
+		}
+		return compressed.Close()
+	case ".zip":
+		archive := zip.NewWriter(writer)
+		for _, file := range files {
+			entry, err := archive.Create(file.name)
+			if err == nil {
+				_, err = entry.Write(contents(file.size))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return archive.Close()
+	}
+	return errors.New("Cannot create a benchmark archive with the compression `" + compression + "`")
+}
+
+// Creates an archive, and returns a function that extracts it
+func prepareExtractionBenchmark(workDir string, compression string) (func() error, error) {
+	archivePath := path.Join(workDir, "archive"+compression)
+	archiveFile, err := os.Create(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archiveFile.Close()
+	err = writeBenchmarkArchive(archiveFile, compression, 1, 2000, 4)
+	if err != nil {
+		return nil, err
+	}
+	destination := path.Join(workDir, "extracted")
+	return func() error {
+		err := os.RemoveAll(destination)
+		if err != nil {
+			return err
+		}
+		archive, err := os.Open(archivePath)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		return utils.Extract(archive, compression, destination, "tool/")
+	}, nil
+}
+
+// The number of archives that are downloaded at once by the download benchmark
+const benchmarkDownloads = 32
+
+// Starts a local server with several archives, and returns a function that downloads and extracts all of them at once
+func prepareDownloadBenchmark(workDir string, cleanup func(func())) (func() error, error) {
+	archives := map[string][]byte{}
+	downloads := []utils.DownloadOptions{}
+	for index := range benchmarkDownloads {
+		name := "archive-" + strconv.Itoa(index) + ".tar.gz"
+		archive := &bytesWriter{}
+		err := writeBenchmarkArchive(archive, ".tar.gz", int64(index), 100, 0)
+		if err != nil {
+			return nil, err
+		}
+		archives["/"+name] = archive.data
+		downloads = append(downloads, utils.DownloadOptions{
+			Name:                             name,
+			Compression:                      ".tar.gz",
+			UseChecksum:                      true,
+			Checksum:                         sha256.Sum256(archive.data),
+			RootPath:                         "tool/",
+			Destination:                      path.Join(workDir, "downloads", strconv.Itoa(index)),
+			DeleteExistingFilesAtDestination: true,
+		})
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		archive, found := archives[request.URL.Path]
+		if !found {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+		writer.Write(archive)
+	}))
+	cleanup(server.Close)
+	for index := range downloads {
+		downloads[index].Urls = []string{server.URL + "/" + downloads[index].Name}
+	}
+	return func() error {
+		return errors.Join(utils.DownloadConcurrently(downloads, maxParrellelDownloads, nil)...)
+	}, nil
+}
+
+type bytesWriter struct {
+	data []byte
+}
+
+func (w *bytesWriter) Write(data []byte) (int, error) {
+	w.data = append(w.data, data...)
+	return len(data), nil
+}
+
+// Runs a benchmark several times, and returns how long it took
+func runBenchmark(bench benchmark, runs int) (benchmarkResult, error) {
+	workDir, err := os.MkdirTemp("", "bento-bench-"+bench.name+"-*")
+	if err != nil {
+		return benchmarkResult{}, err
+	}
+	defer os.RemoveAll(workDir)
+	cleanups := []func(){}
+	defer func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}()
+	run, err := bench.prepare(workDir, func(cleanup func()) { cleanups = append(cleanups, cleanup) })
+	if err != nil {
+		return benchmarkResult{}, utils.WrapError("Failed to prepare the benchmark", err)
+	}
+	durations := []time.Duration{}
+	for range runs {
+		start := time.Now()
+		err := run()
+		if err != nil {
+			return benchmarkResult{}, err
+		}
+		durations = append(durations, time.Since(start))
+	}
+	slices.Sort(durations)
+	return benchmarkResult{Runs: runs, MedianNanoseconds: int64(durations[len(durations)/2]), FastestNanoseconds: int64(durations[0])}, nil
+}
+
+// Runs the benchmarks, prints how long they took, and compares them with the results of an earlier run if there are
+// any. Returns false if a benchmark failed, or was slower than the results that it was compared with by more than the
+// tolerance.
+func runBenchmarks(options benchOptions) bool {
+	baseline := map[string]benchmarkResult{}
+	if options.comparePath != "" {
+		_, err := toml.DecodeFile(options.comparePath, &baseline)
+		if err != nil {
+			utils.Fail("Failed to load the results to compare with from `" + options.comparePath + "`: " + err.Error())
+		}
+	}
+	// Benchmarks must not use or change the bento directory, the config, or the caches of the user
+	isolatedDir, err := os.MkdirTemp("", "bento-bench-home-*")
+	if err != nil {
+		utils.Fail("Failed to create a directory for the benchmarks: " + err.Error())
+	}
+	defer os.RemoveAll(isolatedDir)
+	for _, variable := range benchmarkIsolatedVariables {
+		os.Setenv(variable, path.Join(isolatedDir, variable))
+	}
+
+	results := map[string]benchmarkResult{}
+	rows := [][]string{{utils.AnsiBold + "benchmark", "median", "fastest", "compared" + utils.AnsiReset}}
+	passed := true
+	for _, bench := range benchmarks {
+		if len(options.only) > 0 && !slices.Contains(options.only, bench.name) {
+			continue
+		}
+		println("Running `" + bench.name + "` " + utils.CreateNoun(options.runs, "once", "times"))
+		result, err := runBenchmark(bench, options.runs)
+		if err != nil {
+			println(utils.AnsiFgRed + "The benchmark `" + bench.name + "` failed: " + err.Error() + utils.AnsiReset)
+			passed = false
+			continue
+		}
+		results[bench.name] = result
+		comparison := ""
+		if previous, found := baseline[bench.name]; found && previous.MedianNanoseconds > 0 {
+			change := float64(result.MedianNanoseconds-previous.MedianNanoseconds) / float64(previous.MedianNanoseconds) * 100
+			comparison = strconv.FormatFloat(change, 'f', 1, 64) + "%"
+			if change > 0 {
+				comparison = "+" + comparison
+			}
+			if change > options.tolerance {
+				passed = false
+				comparison = utils.AnsiFgRed + comparison + utils.AnsiReset
+			} else {
+				comparison = utils.AnsiFgGreen + comparison + utils.AnsiReset
+			}
+		}
+		rows = append(rows, []string{bench.name, formatDuration(time.Duration(result.MedianNanoseconds)), formatDuration(time.Duration(result.FastestNanoseconds)), comparison})
+	}
+	for _, line := range utils.AlignColumns(rows) {
+		println(line)
+	}
+	if options.savePath != "" {
+		err := writeToml(options.savePath, results)
+		if err != nil {
+			utils.Fail("Failed to save the results to `" + options.savePath + "`: " + err.Error())
+		}
+		println("Saved the results to `" + options.savePath + "`")
+	}
+	if !passed && options.comparePath != "" {
+		println(utils.AnsiFgRed + "A benchmark failed, or was more than " + strconv.FormatFloat(options.tolerance, 'f', -1, 64) + "% slower than the results in `" + options.comparePath + "`" + utils.AnsiReset)
+	}
+	return passed
+}
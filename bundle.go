@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// Returns the members of a bundle, which is a source config that has no artifacts of its own and only lists other
+// sources to install together, like a toolchain. Returns false if the source is not a bundle.
+func (r *resolver) loadBundleMembers(sourceName string) ([]string, bool, error) {
+	err := r.fetchRepositoryFileIfMissing(path.Join("sources", sourceName+".toml"))
+	if err != nil {
+		return []string{}, false, &sourceLoadingError{sourceName, err.Error(), err}
+	}
+	unparsedSourceConf, err := r.decodeSourceConfig(path.Join(r.sourcesDir, sourceName+".toml"))
+	if err != nil {
+		return []string{}, false, &sourceLoadingError{sourceName, err.Error(), err}
+	}
+	if len(unparsedSourceConf.Members) == 0 {
+		return []string{}, false, nil
+	}
+	if unparsedSourceConf.UrlInMirror != "" || unparsedSourceConf.OciBlob != "" || len(unparsedSourceConf.Artifacts) > 0 {
+		return []string{}, true, &sourceLoadingError{sourceName, "A bundle cannot have `UrlInMirror`, `OciBlob`, or `Artifacts`, since it is only a list of the sources in `Members`", nil}
+	}
+	return unparsedSourceConf.Members, true, nil
+}
+
+// Replaces the bundles in a list of sources with their members, including the members of bundles that are members of
+// other bundles. Returns the sources without bundles, and the names of the bundles that were replaced.
+func (r *resolver) expandBundles(sourceNames []string) ([]string, []string, error) {
+	expanded := []string{}
+	bundles := []string{}
+	var expand func(sourceName string, parents []string) error
+	expand = func(sourceName string, parents []string) error {
+		if slices.Contains(parents, sourceName) {
+			return &sourceLoadingError{sourceName, "The bundle is a member of itself through " + strings.Join(append(parents, sourceName), " -> "), nil}
+		}
+		members, isBundle, err := r.loadBundleMembers(sourceName)
+		if err != nil {
+			return err
+		}
+		if !isBundle {
+			if !slices.Contains(expanded, sourceName) {
+				expanded = append(expanded, sourceName)
+			}
+			return nil
+		}
+		if !slices.Contains(bundles, sourceName) {
+			bundles = append(bundles, sourceName)
+		}
+		for _, member := range members {
+			err := expand(member, append(slices.Clone(parents), sourceName))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, sourceName := range sourceNames {
+		err := expand(sourceName, []string{})
+		if err != nil {
+			return []string{}, []string{}, err
+		}
+	}
+	return expanded, bundles, nil
+}
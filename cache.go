@@ -0,0 +1,353 @@
+package main
+
+import (
+	"cmp"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// A directory in the bento directory that `bento cache` reports on
+type cacheLocation struct {
+	description string
+	paths       []string
+}
+
+// The directories that bento stores downloads and records of downloads in. Installed sources come first, because the
+// files that are shared between sources are counted in the first location that they are found in.
+func cacheLocations(bentoDir string) []cacheLocation {
+	return []cacheLocation{
+		{"Installed sources", []string{path.Join(bentoDir, installedSourcesDirName)}},
+		{"Content-addressed store", []string{path.Join(bentoDir, contentStoreDirName)}},
+		{"Executables from URLs", []string{path.Join(bentoDir, urlSourcesDirName)}},
+		{"Quarantined downloads", []string{path.Join(bentoDir, quarantineDirName)}},
+		{"Journals and jobs", []string{path.Join(bentoDir, journalsDirName), jobsDir(bentoDir)}},
+		{"HTTP responses", []string{path.Join(bentoDir, httpCacheDirName)}},
+	}
+}
+
+func printCachePaths(bentoDir string) {
+	rows := [][]string{{"Bento directory:", bentoDir}}
+	for _, location := range cacheLocations(bentoDir) {
+		rows = append(rows, []string{location.description + ":", strings.Join(location.paths, ", ")})
+	}
+	rows = append(rows, []string{"Source data:", path.Join(getDataDir(), "data")})
+	for _, line := range utils.AlignColumns(rows) {
+		os.Stdout.WriteString(line + "\n")
+	}
+}
+
+func measureCache(usage *utils.DiskUsage, paths ...string) int64 {
+	total := int64(0)
+	for _, cachePath := range paths {
+		size, _, err := usage.Measure(cachePath)
+		if err != nil {
+			utils.Fail("Failed to measure `" + cachePath + "`: " + err.Error())
+		}
+		total += size
+	}
+	return total
+}
+
+func printCacheUsage(bentoDir string) {
+	usage := utils.NewDiskUsage()
+	rows := [][]string{{"", "Size", "Apparent size"}}
+	total := int64(0)
+	for _, location := range cacheLocations(bentoDir) {
+		apparentSizeBefore := usage.ApparentSize
+		size := measureCache(usage, location.paths...)
+		total += size
+		rows = append(rows, []string{location.description + ":", utils.FormatSize(size), utils.FormatSize(usage.ApparentSize - apparentSizeBefore)})
+	}
+	// Everything that was not counted yet is the package repository, along with the shims and the installed features.
+	// Measuring the bento directory measures the locations above again, so their apparent size is subtracted.
+	locationsApparentSize := usage.ApparentSize
+	size := measureCache(usage, bentoDir)
+	total += size
+	apparentTotal := usage.ApparentSize - locationsApparentSize
+	rows = append(rows, []string{"Package repository:", utils.FormatSize(size), utils.FormatSize(apparentTotal - locationsApparentSize)})
+	rows = append(rows, []string{utils.AnsiBold + "Total:" + utils.AnsiReset, utils.AnsiBold + utils.FormatSize(total) + utils.AnsiReset, utils.AnsiBold + utils.FormatSize(apparentTotal) + utils.AnsiReset})
+	for _, line := range utils.AlignColumns(rows) {
+		println(line)
+	}
+	println("Files that are hard linked into several places, like the files that are shared between versions of a source or through the content-addressed store, are only counted once in the size, but once for each place in the apparent size.")
+}
+
+// Something in the cache that can be removed without uninstalling anything, because it can be downloaded again
+type prunableEntry struct {
+	description string
+	// The files and directories that are removed together
+	paths    []string
+	size     int64
+	lastUsed time.Time
+}
+
+// Returns the path of the version of each installed source that the package repository currently selects, which is
+// never pruned. When a source cannot be loaded, every version of it is kept, since which one is in use is not known.
+func currentSourceVersions(bentoDir string, sourceNames []string) map[string]bool {
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	r := newResolver(bentoDir, map[string]string{}, installedFeatures, config)
+	current := map[string]bool{}
+	for _, sourceName := range sourceNames {
+		sourceConf, err := r.loadSource(sourceName)
+		if err != nil {
+			current[path.Join(r.installedSourcesDir, sourceName)] = true
+			continue
+		}
+		current[path.Join(r.installedSourcesDir, sourceName, sourceConf.version)] = true
+	}
+	return current
+}
+
+// Returns everything in the cache that can be pruned. The versions of sources that are in use are measured first, so
+// that the size of an old version only includes the files that it does not share with them.
+func findPrunableEntries(bentoDir string) []prunableEntry {
+	installedSourcesDir := path.Join(bentoDir, installedSourcesDirName)
+	sourceNames := []string{}
+	entries, err := os.ReadDir(installedSourcesDir)
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the installed sources: " + err.Error())
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			sourceNames = append(sourceNames, entry.Name())
+		}
+	}
+	current := currentSourceVersions(bentoDir, sourceNames)
+	usage := utils.NewDiskUsage()
+	for currentPath := range current {
+		measureCache(usage, currentPath)
+	}
+
+	prunable := []prunableEntry{}
+	addEntry := func(description string, paths ...string) {
+		entry := prunableEntry{description: description, paths: paths}
+		for _, entryPath := range paths {
+			size, lastUsed, err := usage.Measure(entryPath)
+			if err != nil {
+				utils.Fail("Failed to measure `" + entryPath + "`: " + err.Error())
+			}
+			entry.size += size
+			if lastUsed.After(entry.lastUsed) {
+				entry.lastUsed = lastUsed
+			}
+		}
+		prunable = append(prunable, entry)
+	}
+	for _, sourceName := range sourceNames {
+		if current[path.Join(installedSourcesDir, sourceName)] {
+			continue
+		}
+		versions, err := os.ReadDir(path.Join(installedSourcesDir, sourceName))
+		if err != nil {
+			utils.Fail("Failed to read the installed versions of `" + sourceName + "`: " + err.Error())
+		}
+		for _, version := range versions {
+			versionPath := path.Join(installedSourcesDir, sourceName, version.Name())
+			if version.IsDir() && !strings.HasPrefix(version.Name(), ".") && !current[versionPath] {
+				addEntry("version "+version.Name()+" of "+sourceName, versionPath, manifestPath(versionPath), installedSourceInfoPath(versionPath))
+			}
+		}
+	}
+	urlSources, err := os.ReadDir(path.Join(bentoDir, urlSourcesDirName))
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the executables from URLs: " + err.Error())
+	}
+	for _, urlSource := range urlSources {
+		if urlSource.IsDir() && !strings.HasPrefix(urlSource.Name(), ".") {
+			urlSourcePath := path.Join(bentoDir, urlSourcesDirName, urlSource.Name())
+			addEntry("the URL download "+urlSource.Name(), urlSourcePath, manifestPath(urlSourcePath))
+		}
+	}
+	quarantined, err := os.ReadDir(path.Join(bentoDir, quarantineDirName))
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the quarantined downloads: " + err.Error())
+	}
+	for _, entry := range quarantined {
+		if checksum, isRecord := strings.CutSuffix(entry.Name(), ".toml"); isRecord {
+			payloadPath := path.Join(bentoDir, quarantineDirName, checksum)
+			addEntry("the quarantined download "+checksum, payloadPath, payloadPath+".toml")
+		}
+	}
+	slices.SortFunc(prunable, func(a prunableEntry, b prunableEntry) int {
+		return cmp.Or(a.lastUsed.Compare(b.lastUsed), utils.CompareNames(a.description, b.description))
+	})
+	return prunable
+}
+
+type pruneOptions struct {
+	// Prune everything that was last used longer ago than this, if it is not zero
+	olderThan time.Duration
+	// Prune the least recently used entries until the bento directory is no larger than this, if it is not zero
+	maxSize int64
+	dryRun  bool
+}
+
+// Removes old versions of sources that are not in use, executables that were run from URLs, and quarantined downloads,
+// which are all downloaded again if they are needed. When each was last used is found from the access times of its
+// files, so entries on filesystems that are mounted with `noatime` are considered to be last used when they were
+// downloaded.
+func pruneCache(bentoDir string, options pruneOptions) {
+	if !options.dryRun {
+		lock, locked, err := lockInstalledSources(bentoDir, true, false)
+		if err != nil {
+			utils.Fail("Failed to lock the installed sources: " + err.Error())
+		}
+		if !locked {
+			utils.Fail("Another bento process is downloading sources. Try again when it has finished.")
+		}
+		defer lock.Close()
+	}
+	prunable := findPrunableEntries(bentoDir)
+	size := measureCache(utils.NewDiskUsage(), bentoDir)
+	freed := int64(0)
+	pruned := 0
+	for _, entry := range prunable {
+		tooOld := options.olderThan != 0 && time.Since(entry.lastUsed) > options.olderThan
+		tooLarge := options.maxSize != 0 && size-freed > options.maxSize
+		if !tooOld && !tooLarge {
+			continue
+		}
+		println("Pruning " + entry.description + " (" + utils.FormatSize(entry.size) + ", last used " + utils.FormatDate(entry.lastUsed) + ")")
+		if !options.dryRun {
+			for _, entryPath := range entry.paths {
+				err := os.RemoveAll(entryPath)
+				if err != nil {
+					utils.Fail("Failed to remove `" + entryPath + "`: " + err.Error())
+				}
+			}
+		}
+		freed += entry.size
+		pruned += 1
+	}
+	if !options.dryRun {
+		// The files of the pruned versions that were in the content-addressed store are only freed once they are
+		// removed from it
+		pruneContentStore(bentoDir)
+	}
+	verb := "Pruned "
+	if options.dryRun {
+		verb = "Would prune "
+	}
+	println(verb + utils.CreateNoun(pruned, "1 entry", "entries") + ", freeing " + utils.FormatSize(freed))
+	if options.maxSize != 0 && size-freed > options.maxSize {
+		println("The bento directory is still " + utils.FormatSize(size-freed) + ", because the rest of it is the package repository and the versions of sources that are in use")
+	}
+}
+
+// Hashes every file of every installed version of a source, and every executable that was run from a URL, and compares
+// them to the manifests that were recorded when they were installed. Returns false if any of them were changed.
+func verifyCache(bentoDir string) bool {
+	trees := []string{}
+	installedSourcesDir := path.Join(bentoDir, installedSourcesDirName)
+	sources, err := os.ReadDir(installedSourcesDir)
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the installed sources: " + err.Error())
+	}
+	for _, source := range sources {
+		versions, err := os.ReadDir(path.Join(installedSourcesDir, source.Name()))
+		if err != nil || strings.HasPrefix(source.Name(), ".") {
+			continue
+		}
+		for _, version := range versions {
+			if version.IsDir() && !strings.HasPrefix(version.Name(), ".") {
+				trees = append(trees, path.Join(installedSourcesDir, source.Name(), version.Name()))
+			}
+		}
+	}
+	urlSources, err := os.ReadDir(path.Join(bentoDir, urlSourcesDirName))
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the executables from URLs: " + err.Error())
+	}
+	for _, urlSource := range urlSources {
+		if urlSource.IsDir() && !strings.HasPrefix(urlSource.Name(), ".") {
+			trees = append(trees, path.Join(bentoDir, urlSourcesDirName, urlSource.Name()))
+		}
+	}
+
+	corruptedTrees := 0
+	unverified := []string{}
+	for _, tree := range trees {
+		relativePath := strings.TrimPrefix(tree, bentoDir+"/")
+		manifest := map[string]utils.ManifestEntry{}
+		_, err := toml.DecodeFile(manifestPath(tree), &manifest)
+		if err != nil {
+			unverified = append(unverified, relativePath)
+			continue
+		}
+		corrupted := utils.FindCorruptedFiles(tree, manifest)
+		if len(corrupted) == 0 {
+			continue
+		}
+		corruptedTrees += 1
+		println(utils.AnsiFgRed + "- " + utils.AnsiReset + relativePath + ": " + strings.Join(corrupted, ", "))
+	}
+	if len(unverified) > 0 {
+		println("Skipped " + utils.CreateNoun(len(unverified), "1 tree that was", "trees that were") + " installed before bento recorded manifests: " + strings.Join(unverified, ", "))
+	}
+	println("Verified " + utils.CreateNoun(len(trees)-len(unverified), "1 tree", "trees") + ", and found " + utils.CreateNoun(corruptedTrees, "1 tree", "trees") + " with changed files")
+	if corruptedTrees > 0 {
+		println("Run an executable from a changed source with `bento exec --heal` to download the source again.")
+	}
+	return corruptedTrees == 0
+}
+
+func cacheCommand(bentoDir string, index int) {
+	action := utils.TakeOneArg(&index, "The cache subcommand to run (either `du`, `prune`, `verify`, or `path`)")
+	switch action {
+	case "path":
+		utils.ExpectAllArgsParsed(index)
+		printCachePaths(bentoDir)
+	case "du":
+		utils.ExpectAllArgsParsed(index)
+		printCacheUsage(bentoDir)
+	case "verify":
+		utils.ExpectAllArgsParsed(index)
+		if !verifyCache(bentoDir) {
+			os.Exit(1)
+		}
+	case "prune":
+		options := pruneOptions{}
+		for index < len(os.Args) {
+			arg := utils.TakeOneArg(&index, "")
+			switch arg {
+			case "--older-than":
+				value := utils.TakeOneArg(&index, "How long ago something has to have been last used to be pruned, like `720h`")
+				duration, err := time.ParseDuration(value)
+				if err != nil || duration <= 0 {
+					utils.Fail("Expected a duration like `720h`, but got `" + value + "`")
+				}
+				options.olderThan = duration
+			case "--max-size":
+				value := utils.TakeOneArg(&index, "The size to prune the bento directory to, like `2GiB`")
+				size, err := utils.ParseSize(value)
+				if err != nil || size <= 0 {
+					utils.Fail("Expected a size like `2GiB`, but got `" + value + "`")
+				}
+				options.maxSize = size
+			case "--dry-run":
+				options.dryRun = true
+			default:
+				utils.Fail("Expected either `--older-than`, `--max-size`, or `--dry-run`, but got `" + arg + "`")
+			}
+		}
+		if options.olderThan == 0 && options.maxSize == 0 {
+			utils.Fail("Expected `--older-than`, `--max-size`, or both, to choose what to prune")
+		}
+		pruneCache(bentoDir, options)
+	default:
+		utils.Fail("`" + action + "` is not a valid action. Expected either `du`, `prune`, `verify`, or `path`")
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// Returns the candidates for an argument, given the arguments before it that follow the subcommand
+type completionValue func(c *completer, previous []string) []string
+
+// Describes the arguments that a subcommand takes, so that they can be completed
+type commandCompletion struct {
+	// What each positional argument is
+	positional []completionValue
+	// What the positional arguments after those are, like the sources that `install` takes
+	rest completionValue
+	// Maps each option to the value that it takes, which is nil for options that do not take a value
+	options map[string]completionValue
+}
+
+func choices(candidates ...string) completionValue {
+	return func(*completer, []string) []string {
+		return candidates
+	}
+}
+
+// Lets the shell complete the argument, which is usually a file
+var noCompletion completionValue = func(*completer, []string) []string {
+	return nil
+}
+
+func completeSourceName(c *completer, _ []string) []string {
+	names, _ := listTomlNames(path.Join(c.bentoDir, "sources"))
+	return names
+}
+
+// The source that an argument refers to is the last positional argument before it
+func lastSourceName(previous []string) string {
+	for index := len(previous) - 1; index >= 0; index-- {
+		if !strings.HasPrefix(previous[index], "-") && (index == 0 || !strings.HasPrefix(previous[index-1], "-")) {
+			return previous[index]
+		}
+	}
+	return ""
+}
+
+func completeExecutable(c *completer, previous []string) []string {
+	executables, _, err := sourceExecutables(c.resolver(), lastSourceName(previous))
+	if err != nil {
+		return nil
+	}
+	return executables
+}
+
+func completeFeature(c *completer, previous []string) []string {
+	sourceConf, err := c.resolver().loadSource(lastSourceName(previous))
+	if err != nil {
+		return nil
+	}
+	return slices.Collect(maps.Keys(sourceConf.features))
+}
+
+func completeInstalledSourceName(c *completer, _ []string) []string {
+	names, _ := installedSourceNames(c.bentoDir)
+	return names
+}
+
+func completeInstalledVersion(c *completer, previous []string) []string {
+	sourceName := ""
+	if len(previous) > 0 {
+		sourceName = previous[0]
+	}
+	entries, _ := os.ReadDir(path.Join(c.bentoDir, installedSourcesDirName, sourceName))
+	versions := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			versions = append(versions, entry.Name())
+		}
+	}
+	return versions
+}
+
+func completeCompression(*completer, []string) []string {
+	compressions := []string{"none"}
+	for _, compression := range compressionsByExtension {
+		compressions = append(compressions, compression[1])
+	}
+	return compressions
+}
+
+var installCompletionOptions = map[string]completionValue{
+	"--with":             completeFeature,
+	"--reproducible":     nil,
+	"--background":       nil,
+	"--skip":             completeSourceName,
+	"--report-endpoints": nil,
+	"--refresh":          completeSourceName,
+	"--include":          noCompletion,
+	"--exclude":          noCompletion,
+}
+
+// The arguments of every subcommand that is shown to users
+var commandCompletions = map[string]commandCompletion{
+	"help":    {},
+	"update":  {},
+	"install": {rest: completeSourceName, options: installCompletionOptions},
+	"fetch": {rest: completeSourceName, options: map[string]completionValue{
+		"--arch":   choices(slices.Collect(maps.Keys(unameArchitectureNames))...),
+		"--os":     choices("linux", "darwin", "freebsd", "windows"),
+		"--output": noCompletion,
+	}},
+	"exec": {positional: []completionValue{completeSourceName, completeExecutable}, options: map[string]completionValue{
+		"--list-executables":      nil,
+		"--arg":                   noCompletion,
+		"--trace":                 nil,
+		"--reproducible":          nil,
+		"--isolate-home":          nil,
+		"--no-network":            nil,
+		"--only-declared-domains": nil,
+		"--heal":                  nil,
+		"--time":                  nil,
+		"--ldd":                   nil,
+		"--report-endpoints":      nil,
+		"--refresh":               completeSourceName,
+		"--":                      nil,
+	}},
+	"exec-url": {positional: []completionValue{noCompletion}, options: map[string]completionValue{
+		"--sha256":      noCompletion,
+		"--compression": completeCompression,
+		"--path":        noCompletion,
+		"--trace":       nil,
+	}},
+	"dev": {positional: []completionValue{choices("--watch"), completeSourceName, completeExecutable}},
+	"env": {positional: []completionValue{completeSourceName, completeExecutable}, options: map[string]completionValue{
+		"--format": choices("shell", "vscode", "jetbrains"),
+		"--export": choices(exportFormats...),
+	}},
+	"daemon": {},
+	"jobs":   {positional: []completionValue{choices("wait", "resume")}},
+	"history": {options: map[string]completionValue{
+		"--source": completeSourceName,
+		"--action": choices(historyActions...),
+		"--since":  noCompletion,
+	}},
+	"hash":     {rest: noCompletion, options: map[string]completionValue{"--algorithm": choices(slices.Collect(maps.Keys(hashAlgorithms))...)}},
+	"resume":   {},
+	"rollback": {positional: []completionValue{choices("--transaction")}},
+	"shims":    {positional: []completionValue{choices("sync")}},
+	"repo":     {positional: []completionValue{choices("status")}},
+	"diff":     {positional: []completionValue{completeSourceName, completeInstalledVersion, completeInstalledVersion}},
+	"info":     {positional: []completionValue{completeSourceName}, options: map[string]completionValue{"--arch": choices(slices.Collect(maps.Keys(unameArchitectureNames))...)}},
+	"why": {positional: []completionValue{completeSourceName}, rest: completeSourceName, options: map[string]completionValue{
+		"--exec":    completeSourceName,
+		"--install": nil,
+	}},
+	"lint-repo": {positional: []completionValue{noCompletion}},
+	"gc":        {},
+	"cache": {positional: []completionValue{choices("du", "prune", "verify", "path")}, options: map[string]completionValue{
+		"--older-than": noCompletion,
+		"--max-size":   noCompletion,
+		"--dry-run":    nil,
+	}},
+	"completion": {positional: []completionValue{choices("bash", "zsh", "fish")}},
+	"upgrade":    {rest: completeInstalledSourceName, options: map[string]completionValue{"--preview": nil}},
+	"env-diff":   {positional: []completionValue{completeSourceName, completeExecutable}, options: map[string]completionValue{"--isolate-home": nil}},
+	"path-setup": {options: map[string]completionValue{"--shell": choices("bash", "zsh", "fish"), "--remove": nil, "--print": nil}},
+	"doctor":     {positional: []completionValue{choices("network")}},
+}
+
+type completer struct {
+	bentoDir string
+	// Created when it is first needed, since most completions do not load any sources
+	r *resolver
+}
+
+func (c *completer) resolver() *resolver {
+	if c.r == nil {
+		installedFeatures, _ := readInstalledFeatures(c.bentoDir)
+		config, _ := loadUserConfig()
+		c.r = newResolver(c.bentoDir, map[string]string{}, installedFeatures, config)
+	}
+	return c.r
+}
+
+// Returns the candidates for the last argument in `args`, which are the arguments after `bento` on the command line
+// that is being completed, with the last one being the argument that the cursor is on
+func (c *completer) complete(args []string) []string {
+	if len(args) > 1 && args[0] == "--system" {
+		args = args[1:]
+	}
+	if len(args) <= 1 {
+		return slices.Collect(maps.Keys(commandCompletions))
+	}
+	command, known := commandCompletions[args[0]]
+	if !known {
+		return nil
+	}
+	previous, current := args[1:len(args)-1], args[len(args)-1]
+	value := command.rest
+	positionalCount := 0
+	for index := 0; index < len(previous); index++ {
+		if _, separates := command.options["--"]; separates && previous[index] == "--" {
+			// The arguments after `--` are passed to another program, so they are not completed
+			return nil
+		}
+		if optionValue, isOption := command.options[previous[index]]; isOption {
+			if optionValue == nil {
+				continue
+			}
+			if index == len(previous)-1 {
+				return optionValue(c, previous)
+			}
+			index += 1
+			continue
+		}
+		positionalCount += 1
+	}
+	if strings.HasPrefix(current, "-") && len(command.options) > 0 {
+		return slices.Collect(maps.Keys(command.options))
+	}
+	if positionalCount < len(command.positional) {
+		value = command.positional[positionalCount]
+	}
+	if value == nil {
+		return nil
+	}
+	return value(c, previous)
+}
+
+// Prints the candidates for the last argument that is given to `bento __complete`, one per line, which the completion
+// scripts that `bento completion` prints run whenever the user presses tab
+func printCompletions(bentoDir string, args []string) {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	c := completer{bentoDir: bentoDir}
+	candidates := c.complete(args)
+	utils.SortNames(candidates)
+	for _, candidate := range slices.Compact(candidates) {
+		if strings.HasPrefix(candidate, args[len(args)-1]) {
+			os.Stdout.WriteString(candidate + "\n")
+		}
+	}
+}
+
+var completionScripts = map[string]string{
+	"bash": `_bento() {
+	local IFS=$'\n'
+	COMPREPLY=($(bento __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _bento bento
+`,
+	"zsh": `#compdef bento
+_bento() {
+	local -a candidates
+	candidates=("${(@f)$(bento __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -n "${candidates[1]}" ]]; then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+compdef _bento bento
+`,
+	"fish": `complete -c bento -f -a '(bento __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`,
+}
+
+// Prints a script that completes the arguments of bento in a shell, by running `bento __complete`
+func printCompletionScript(shell string) {
+	script, ok := completionScripts[shell]
+	if !ok {
+		utils.Fail("`" + shell + "` is not a supported shell. Expected either `bash`, `zsh`, or `fish`")
+	}
+	os.Stdout.WriteString(script)
+}
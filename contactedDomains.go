@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// The variables that programs find an HTTP proxy through. Some programs only read the lowercase names, and others only
+// read the uppercase names.
+var proxyVariables = []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "http_proxy", "https_proxy", "all_proxy"}
+
+// Starts a proxy that only lets an executable contact the domains that its source declares in `ContactedDomains`, and
+// makes the executable use it. Executables that ignore the proxy variables are not limited, so `--no-network` is still
+// the only way to be sure that an executable cannot contact anything.
+func limitToContactedDomains(environment map[string]string, sourceName string, sourceConf parsedSourceConfig, trace *utils.Tracer) *utils.DomainProxy {
+	if len(sourceConf.contactedDomains) == 0 {
+		printWarnings([]string{"`" + sourceName + "` does not declare which domains it contacts, so it cannot contact any"})
+	}
+	proxy, err := utils.StartDomainProxy(sourceConf.contactedDomains)
+	if err != nil {
+		utils.Fail("Failed to start the proxy that limits the domains that `" + sourceName + "` contacts: " + err.Error())
+	}
+	for _, variable := range proxyVariables {
+		environment[variable] = proxy.Url()
+	}
+	// Hosts in `NO_PROXY` would be contacted without going through the proxy
+	delete(environment, "NO_PROXY")
+	delete(environment, "no_proxy")
+	trace.Log("Only letting `" + sourceName + "` contact " + strings.Join(sourceConf.contactedDomains, ", ") + " through the proxy at " + proxy.Url())
+	return proxy
+}
+
+// Prints the domains that an executable tried to contact, but that the proxy stopped it from contacting
+func reportBlockedDomains(proxy *utils.DomainProxy) {
+	blocked := proxy.Blocked()
+	if len(blocked) > 0 {
+		printWarnings([]string{"Stopped the executable from contacting " + utils.CreateNoun(len(blocked), "a domain", "domains") + " that its source does not declare in `ContactedDomains`: " + strings.Join(blocked, ", ")})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The signals that a program is killed with when it crashes, rather than when it is stopped by the user or another
+// program
+var crashSignals = []syscall.Signal{syscall.SIGSEGV, syscall.SIGBUS, syscall.SIGILL, syscall.SIGFPE, syscall.SIGABRT, syscall.SIGTRAP, syscall.SIGSYS}
+
+// What bento knows about an executable that it runs, which is saved in a crash report if the executable crashes, when
+// the user has enabled `CrashReports`
+type crashReportContext struct {
+	source       string
+	version      string
+	executable   string
+	libraryPaths []string
+}
+
+// A record of an executable that crashed, which is saved in the state directory so that it can be attached to a bug
+// report for the executable. It does not include the arguments or the environment of the executable, since they can
+// contain secrets.
+type crashReport struct {
+	Time       time.Time
+	Source     string
+	Version    string
+	Executable string
+	Command    []string
+	Signal     string
+	// Whether the kernel dumped the core of the executable, and where it puts core dumps
+	CoreDumped  bool
+	CorePattern string
+	// Whether the kernel log, which often says why a program crashed, can be read without root with `dmesg`
+	KernelLogReadable bool
+	LibraryPaths      []string
+	BentoVersion      string
+	OperatingSystem   string
+	Architecture      string
+}
+
+func crashReportsDir() string {
+	return path.Join(getStateDir(), "crashes")
+}
+
+// Returns the signal that a command crashed with, or false if it did not crash. Executables that are scripts run their
+// program as a child, and usually exit in the same way that a shell does when the program crashes.
+func crashSignal(result utils.CommandResult) (syscall.Signal, bool) {
+	if slices.Contains(crashSignals, result.Signal) {
+		return result.Signal, true
+	}
+	for _, signal := range crashSignals {
+		if result.Signal == 0 && result.ExitCode == 128+int(signal) {
+			return signal, true
+		}
+	}
+	return 0, false
+}
+
+func writeCrashReport(report crashReport) (string, error) {
+	err := os.MkdirAll(crashReportsDir(), 0755)
+	if err != nil {
+		return "", explainWriteError(err)
+	}
+	reportPath := path.Join(crashReportsDir(), report.Time.Format("2006-01-02T15-04-05")+"-"+report.Source+".toml")
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return "", explainWriteError(err)
+	}
+	defer file.Close()
+	return reportPath, toml.NewEncoder(file).Encode(report)
+}
+
+// Saves a crash report if a command crashed, and tells the user where it is
+func (c *crashReportContext) recordIfCrashed(command []string, result utils.CommandResult) {
+	signal, crashed := crashSignal(result)
+	if !crashed {
+		return
+	}
+	report := crashReport{
+		Time:            time.Now(),
+		Source:          c.source,
+		Version:         c.version,
+		Executable:      c.executable,
+		Command:         command,
+		Signal:          signal.String() + " (signal " + strconv.Itoa(int(signal)) + ")",
+		CoreDumped:      result.CoreDumped,
+		LibraryPaths:    c.libraryPaths,
+		BentoVersion:    currentBentoVersion(),
+		OperatingSystem: runtime.GOOS,
+		Architecture:    runtime.GOARCH,
+	}
+	if corePattern, err := os.ReadFile("/proc/sys/kernel/core_pattern"); err == nil {
+		report.CorePattern = strings.TrimSpace(string(corePattern))
+	}
+	if restricted, err := os.ReadFile("/proc/sys/kernel/dmesg_restrict"); err == nil {
+		report.KernelLogReadable = strings.TrimSpace(string(restricted)) == "0"
+	}
+	reportPath, err := writeCrashReport(report)
+	if err != nil {
+		println("`" + c.executable + "` from `" + c.source + "` crashed with " + signal.String() + ", but the crash report could not be saved: " + err.Error())
+		return
+	}
+	println("`" + c.executable + "` from `" + c.source + "` crashed with " + signal.String() + ". A crash report was saved to `" + reportPath + "`, which you can attach to a bug report.")
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// A request sent to the daemon to resolve an executable
+type daemonRequest struct {
+	BentoDir   string
+	Source     string
+	Executable string
+	// The environment of the client, which `${env.NAME}` interpolations read from
+	Environment map[string]string
+}
+
+// The daemon's response to a `daemonRequest`, which contains everything needed to run the executable
+type daemonResponse struct {
+	Command        []string
+	Environment    map[string]string
+	LibraryPaths   []string
+	ResourceLimits utils.ResourceLimits
+	PathMappings   map[string]string
+	// The version of the requested source, and whether the user wants crash reports for it
+	Version              string
+	CrashReports         bool
+	Warnings             []string
+	AllSourcesDownloaded bool
+	// Whether the requested source says that it needs network access
+	NeedsNetwork bool
+	Error        string
+}
+
+// Returns the directory that the socket of the daemon is in. Only the user can access it, so that another user cannot
+// put a socket there that `bento exec` would trust.
+func daemonSocketDir() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return path.Join(runtimeDir, "bento")
+	}
+	return path.Join(os.TempDir(), "bento-"+strconv.Itoa(os.Geteuid()))
+}
+
+func daemonSocketPath() string {
+	return path.Join(daemonSocketDir(), "daemon.sock")
+}
+
+// Returns an error unless `dir` is a directory that is owned by the current user, and that no other user can access
+func checkDaemonSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Geteuid() || info.Mode().Perm()&0o077 != 0 {
+		return errors.New("`" + dir + "` is not a directory that only you can access, so a socket in it cannot be trusted")
+	}
+	return nil
+}
+
+// Asks the daemon to resolve an executable. Returns an error if the daemon is not running.
+func requestResolutionFromDaemon(request daemonRequest) (daemonResponse, error) {
+	var response daemonResponse
+	absoluteBentoDir, err := filepath.Abs(request.BentoDir)
+	if err != nil {
+		return response, err
+	}
+	request.BentoDir = absoluteBentoDir
+	err = checkDaemonSocketDir(daemonSocketDir())
+	if err != nil {
+		return response, err
+	}
+	connection, err := net.DialTimeout("unix", daemonSocketPath(), 100*time.Millisecond)
+	if err != nil {
+		return response, err
+	}
+	defer connection.Close()
+	// The response is run as the user, so it must come from a daemon that the user started
+	peerUid, err := utils.SocketPeerUid(connection)
+	if err == nil && peerUid != os.Geteuid() {
+		return response, errors.New("The daemon is run by the user " + strconv.Itoa(peerUid) + " instead of you")
+	} else if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		return response, err
+	}
+	connection.SetDeadline(time.Now().Add(5 * time.Second))
+	err = json.NewEncoder(connection).Encode(request)
+	if err != nil {
+		return response, err
+	}
+	err = json.NewDecoder(connection).Decode(&response)
+	return response, err
+}
+
+func resolveForDaemon(request daemonRequest, cache *tomlCache) daemonResponse {
+	installedFeatures, err := readInstalledFeatures(request.BentoDir)
+	if err != nil {
+		return daemonResponse{Error: err.Error()}
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		return daemonResponse{Error: err.Error()}
+	}
+	r := newResolver(request.BentoDir, map[string]string{}, installedFeatures, config)
+	r.tomlCache = cache
+	if request.Environment != nil {
+		r.hostEnvironment = request.Environment
+	}
+	command, err := r.resolveCommand(request.Source, request.Executable)
+	if err != nil {
+		return daemonResponse{Error: err.Error()}
+	}
+	r.checkSourceIntegrity(request.Source)
+	allSourcesDownloaded := true
+	for _, sourceConf := range r.sources {
+		if _, err := os.Stat(sourceConf.path); err != nil {
+			allSourcesDownloaded = false
+			break
+		}
+	}
+	return daemonResponse{
+		Command:              command,
+		Environment:          r.environment,
+		LibraryPaths:         r.libraryPaths(),
+		ResourceLimits:       r.resourceLimits(request.Source, request.Executable),
+		PathMappings:         r.pathMappings(request.Source),
+		Version:              r.sources[request.Source].version,
+		CrashReports:         config.CrashReports,
+		Warnings:             r.warnings,
+		AllSourcesDownloaded: allSourcesDownloaded,
+		NeedsNetwork:         r.sources[request.Source].needsNetwork,
+	}
+}
+
+func handleDaemonConnection(connection net.Conn, cache *tomlCache, cacheMutex *sync.Mutex) {
+	defer connection.Close()
+	connection.SetDeadline(time.Now().Add(10 * time.Second))
+	var request daemonRequest
+	err := json.NewDecoder(connection).Decode(&request)
+	if err != nil {
+		os.Stderr.WriteString("Failed to decode request: " + err.Error() + "\n")
+		return
+	}
+	cacheMutex.Lock()
+	response := resolveForDaemon(request, cache)
+	cacheMutex.Unlock()
+	err = json.NewEncoder(connection).Encode(response)
+	if err != nil {
+		os.Stderr.WriteString("Failed to send response: " + err.Error() + "\n")
+	}
+}
+
+// Keeps the package repository metadata that has been loaded in memory, and answers requests to resolve executables
+// over a unix socket, so that `bento exec` does not have to load it every time that it is run
+func runDaemon() {
+	socketDir := daemonSocketDir()
+	err := os.Mkdir(socketDir, 0700)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		utils.Fail("Failed to create `" + socketDir + "`: " + err.Error())
+	}
+	err = checkDaemonSocketDir(socketDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	socketPath := daemonSocketPath()
+	if connection, err := net.Dial("unix", socketPath); err == nil {
+		connection.Close()
+		utils.Fail("A bento daemon is already listening on `" + socketPath + "`")
+	}
+	// Remove the socket left behind by a daemon that did not exit cleanly
+	err = os.Remove(socketPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		utils.Fail("Failed to remove `" + socketPath + "`: " + err.Error())
+	}
+	// The socket is created with the permissions that the umask allows, so it is never accessible to other users, even
+	// for a moment
+	previousUmask := syscall.Umask(0o177)
+	listener, err := net.Listen("unix", socketPath)
+	syscall.Umask(previousUmask)
+	if err != nil {
+		utils.Fail("Failed to listen on `" + socketPath + "`: " + err.Error())
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+		os.Exit(0)
+	}()
+
+	println("Listening on " + socketPath)
+	cache := &tomlCache{entries: map[string]tomlCacheEntry{}}
+	var cacheMutex sync.Mutex
+	for {
+		connection, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			os.Stderr.WriteString("Failed to accept connection: " + err.Error() + "\n")
+			continue
+		}
+		go handleDaemonConnection(connection, cache, &cacheMutex)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// An edge in the graph of the sources that have been loaded, which is used to explain why a source is needed
+type sourceDependency struct {
+	source string
+	reason string
+	// The dependency comes from a feature that the user chose to install
+	optional bool
+}
+
+func (r *resolver) recordDependency(dependentSource string, dependencySource string, reason string, optional bool) {
+	if dependentSource == dependencySource {
+		return
+	}
+	dependency := sourceDependency{source: dependencySource, reason: reason, optional: optional}
+	if !slices.Contains(r.dependencies[dependentSource], dependency) {
+		r.dependencies[dependentSource] = append(r.dependencies[dependentSource], dependency)
+	}
+}
+
+// Records that a source depends on the source that provides a library, if the library is not provided by the system
+func (r *resolver) recordLibraryDependency(dependentSource string, libraryName string, reason string, optional bool) {
+	if library, ok := r.libraries[libraryName]; ok && library.source != "" {
+		r.recordDependency(dependentSource, library.source, reason, optional)
+	}
+}
+
+// Returns how many dependencies away from one of `requestedSources` each loaded source is, counting the required
+// dependencies before the optional ones, so that every source that is only needed by an optional feature is further
+// away than every source that is required
+func (r *resolver) dependencyDepths(requestedSources []string) map[string]int {
+	depths := map[string]int{}
+	queue := []string{}
+	for _, requestedSource := range requestedSources {
+		depths[requestedSource] = 0
+		queue = append(queue, requestedSource)
+	}
+	search := func(followOptional bool) {
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			for _, dependency := range r.dependencies[current] {
+				if _, found := depths[dependency.source]; !found && (followOptional || !dependency.optional) {
+					depths[dependency.source] = depths[current] + 1
+					queue = append(queue, dependency.source)
+				}
+			}
+		}
+	}
+	search(false)
+	// Sources that are only needed by optional features are searched for from the sources that are required
+	requiredDepth := 0
+	for _, depth := range depths {
+		requiredDepth = max(requiredDepth, depth)
+	}
+	requiredSources := utils.SortedNames(maps.Keys(depths))
+	slices.SortStableFunc(requiredSources, func(a string, b string) int {
+		return depths[a] - depths[b]
+	})
+	for _, source := range requiredSources {
+		for _, dependency := range r.dependencies[source] {
+			if _, found := depths[dependency.source]; !found && dependency.optional {
+				depths[dependency.source] = requiredDepth + depths[source] + 1
+				queue = append(queue, dependency.source)
+			}
+		}
+	}
+	search(true)
+	return depths
+}
+
+// Executable dependencies that are not needed by a specific executable are loaded with an empty path
+func describeDependentExecutable(sourceExecutableRelativePath string) string {
+	if sourceExecutableRelativePath == "" {
+		return "it"
+	}
+	return "`" + sourceExecutableRelativePath + "`"
+}
+
+// Returns an explanation of why `source` is needed by `requestedSources` if it is needed, or false if it is only
+// needed by optional features
+func (r *resolver) explainRequiredSource(requestedSources []string, source string) (string, bool) {
+	// Search for the shortest chain of required dependencies from a requested source to the source
+	type step struct {
+		source   string
+		previous *step
+		reason   string
+	}
+	queue := []*step{}
+	visited := map[string]bool{}
+	for _, requestedSource := range requestedSources {
+		queue = append(queue, &step{source: requestedSource})
+		visited[requestedSource] = true
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current.source == source {
+			if current.previous == nil {
+				return "you asked for it", true
+			}
+			explanation := []string{}
+			for s := current; s.previous != nil; s = s.previous {
+				explanation = append(explanation, "`"+s.previous.source+"` needs `"+s.source+"` because "+s.reason)
+			}
+			slices.Reverse(explanation)
+			return strings.Join(explanation, ", and "), true
+		}
+		for _, dependency := range r.dependencies[current.source] {
+			if !dependency.optional && !visited[dependency.source] {
+				visited[dependency.source] = true
+				queue = append(queue, &step{source: dependency.source, previous: current, reason: dependency.reason})
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"maps"
+	"os"
+	osExec "os/exec"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// How often `bento dev --watch` checks whether the files that it is watching have changed
+const watchInterval = 300 * time.Millisecond
+
+// How long the executable is given to exit after it is asked to, before it is killed so that it can be run again
+const developmentStopTimeout = 2 * time.Second
+
+// Maps each watched file to its modification time and size, which are both zero for files that do not exist
+type watchedFiles map[string][2]int64
+
+func statWatchedFiles(filePaths []string) watchedFiles {
+	files := watchedFiles{}
+	for _, filePath := range filePaths {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			files[filePath] = [2]int64{}
+		} else {
+			files[filePath] = [2]int64{info.ModTime().UnixNano(), info.Size()}
+		}
+	}
+	return files
+}
+
+// Returns the first file that is different in `current`, or false if none of them changed
+func (files watchedFiles) findChange(current watchedFiles) (string, bool) {
+	for _, filePath := range slices.Sorted(maps.Keys(files)) {
+		if current[filePath] != files[filePath] {
+			return filePath, true
+		}
+	}
+	return "", false
+}
+
+// Returns every file that resolving a source read, along with the overrides that would change the sources if they were
+// created, and the user config
+func filesToWatch(r *resolver, cache *tomlCache, sourceName string) []string {
+	filePaths := []string{path.Join(getUserConfigDir(), "config.toml")}
+	filePaths = append(filePaths, slices.Collect(maps.Keys(cache.entries))...)
+	// The requested source is watched even if it could not be decoded, so that fixing it is noticed
+	for _, name := range append(slices.Collect(maps.Keys(r.sources)), sourceName) {
+		filePaths = append(filePaths, path.Join(r.sourcesDir, name+".toml"))
+		if r.sourceOverridesDir != "" {
+			filePaths = append(filePaths, path.Join(r.sourceOverridesDir, name+".toml"))
+		}
+	}
+	slices.Sort(filePaths)
+	return slices.Compact(filePaths)
+}
+
+type developmentSession struct {
+	bentoDir   string
+	sourceName string
+	executable string
+	argsToPass []string
+	cache      *tomlCache
+	// The executable that is running, which is nil when it is not running
+	running *osExec.Cmd
+	exited  chan error
+}
+
+// Resolves the executable, downloads any sources that it needs, and starts it. Returns the files to watch for changes,
+// which are returned even if the executable could not be resolved, so that fixing the problem is noticed.
+func (s *developmentSession) start() []string {
+	installedFeatures, err := readInstalledFeatures(s.bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		println(utils.AnsiFgRed + err.Error() + utils.AnsiReset)
+		return []string{path.Join(getUserConfigDir(), "config.toml")}
+	}
+	environment := environmentToMap(os.Environ())
+	r := newResolver(s.bentoDir, environment, installedFeatures, config)
+	r.tomlCache = s.cache
+	command, err := r.resolveCommand(s.sourceName, s.executable)
+	watched := filesToWatch(r, s.cache, s.sourceName)
+	if err != nil {
+		println(utils.AnsiFgRed + err.Error() + utils.AnsiReset)
+		return watched
+	}
+	printWarnings(r.warnings)
+	options := installOptions{returnOnFailure: true}
+	if !downloadMissingSources(r, []string{s.sourceName}, "to run the binary "+s.executable+" from the source "+s.sourceName+" with `bento dev`", options) {
+		println("`" + s.executable + "` was not run, because the sources that it needs were not downloaded")
+		return watched
+	}
+
+	s.running = osExec.Command(command[0], append(slices.Clone(command[1:]), s.argsToPass...)...)
+	s.running.Env = commandEnvironment(environment, r.libraryPaths(), r.trace)
+	s.running.Stdin = os.Stdin
+	s.running.Stdout = os.Stdout
+	s.running.Stderr = os.Stderr
+	println(utils.AnsiBold + "Running `" + strings.Join(append(slices.Clone(command), s.argsToPass...), " ") + "`" + utils.AnsiReset)
+	err = s.running.Start()
+	if err != nil {
+		println(utils.AnsiFgRed + "Failed to execute binary `" + command[0] + "`: " + err.Error() + utils.AnsiReset)
+		s.running = nil
+		return watched
+	}
+	s.exited = make(chan error, 1)
+	go func(running *osExec.Cmd, exited chan error) {
+		exited <- running.Wait()
+	}(s.running, s.exited)
+	return watched
+}
+
+// Asks the executable to exit, and kills it if it does not exit in time
+func (s *developmentSession) stop() {
+	if s.running == nil {
+		return
+	}
+	s.running.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-s.exited:
+	case <-time.After(developmentStopTimeout):
+		s.running.Process.Kill()
+		<-s.exited
+	}
+	s.running = nil
+}
+
+// Runs an executable from a source, and runs it again whenever its source config, the source configs of its
+// dependencies, or their overrides change, so that packaging a source can be tested without running bento by hand
+func watchSource(bentoDir string, sourceName string, executable string, argsToPass []string) {
+	s := developmentSession{
+		bentoDir:   bentoDir,
+		sourceName: sourceName,
+		executable: executable,
+		argsToPass: argsToPass,
+		cache:      &tomlCache{entries: map[string]tomlCacheEntry{}},
+	}
+	for {
+		watched := statWatchedFiles(s.start())
+		println("Watching " + utils.CreateNoun(len(watched), "1 file", "files") + " for changes")
+		for {
+			select {
+			case err := <-s.exited:
+				s.running = nil
+				if err != nil {
+					println("`" + executable + "` exited: " + err.Error())
+				} else {
+					println("`" + executable + "` exited successfully")
+				}
+				continue
+			case <-time.After(watchInterval):
+			}
+			if changedFile, changed := watched.findChange(statWatchedFiles(slices.Collect(maps.Keys(watched)))); changed {
+				println("`" + changedFile + "` changed, so resolving `" + executable + "` again")
+				break
+			}
+		}
+		s.stop()
+	}
+}
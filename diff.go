@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The manifest of an installed version of a source is stored next to it, with a name that starts with `.` so that it
+// is not mistaken for another version
+func manifestPath(sourcePath string) string {
+	return path.Join(path.Dir(sourcePath), "."+path.Base(sourcePath)+".manifest.toml")
+}
+
+// Records every file in a newly installed version of a source, so that versions can be compared with `bento diff`
+func writeManifest(treePath string, sourcePath string) error {
+	manifest, err := utils.CreateManifest(treePath)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(manifestPath(sourcePath))
+	if err != nil {
+		return explainWriteError(err)
+	}
+	defer file.Close()
+	return toml.NewEncoder(file).Encode(manifest)
+}
+
+// Reads the manifest of an installed version of a source. Versions that were installed before bento recorded
+// manifests do not have one, so it is created from the installed files instead.
+func readManifest(sourcePath string) (map[string]utils.ManifestEntry, error) {
+	manifest := map[string]utils.ManifestEntry{}
+	_, err := toml.DecodeFile(manifestPath(sourcePath), &manifest)
+	if os.IsNotExist(err) {
+		return utils.CreateManifest(sourcePath)
+	}
+	return manifest, err
+}
+
+func describeManifestChange(a utils.ManifestEntry, b utils.ManifestEntry) string {
+	switch {
+	case a.LinkTarget != b.LinkTarget:
+		return "link target changed from `" + a.LinkTarget + "` to `" + b.LinkTarget + "`"
+	case a.Sha256 != b.Sha256 && a.Size != b.Size:
+		return "contents changed, " + utils.FormatSize(a.Size) + " -> " + utils.FormatSize(b.Size)
+	case a.Sha256 != b.Sha256:
+		return "contents changed"
+	case a.Mode != b.Mode:
+		return "mode changed from " + fs.FileMode(a.Mode).String() + " to " + fs.FileMode(b.Mode).String()
+	}
+	return ""
+}
+
+func diffSourceVersions(bentoDir string, sourceName string, versionA string, versionB string) {
+	manifests := [2]map[string]utils.ManifestEntry{}
+	for index, version := range []string{versionA, versionB} {
+		sourcePath := path.Join(bentoDir, installedSourcesDirName, sourceName, version)
+		_, err := os.Stat(sourcePath)
+		if errors.Is(err, os.ErrNotExist) {
+			utils.Fail("Version `" + version + "` of the source `" + sourceName + "` is not installed. " + describeInstalledVersions(bentoDir, sourceName))
+		}
+		manifests[index], err = readManifest(sourcePath)
+		if err != nil {
+			utils.Fail("Failed to read the files in version `" + version + "` of `" + sourceName + "`: " + err.Error())
+		}
+	}
+
+	added, removed, changed := 0, 0, 0
+	allPaths := maps.Clone(manifests[0])
+	maps.Copy(allPaths, manifests[1])
+	for _, filePath := range slices.Sorted(maps.Keys(allPaths)) {
+		entryA, inA := manifests[0][filePath]
+		entryB, inB := manifests[1][filePath]
+		switch {
+		case !inA:
+			added += 1
+			println(utils.AnsiFgGreen + "+ " + filePath + utils.AnsiReset)
+		case !inB:
+			removed += 1
+			println(utils.AnsiFgRed + "- " + filePath + utils.AnsiReset)
+		default:
+			if change := describeManifestChange(entryA, entryB); change != "" {
+				changed += 1
+				println(utils.AnsiFgYellow + "~ " + filePath + utils.AnsiReset + " (" + change + ")")
+			}
+		}
+	}
+	println(strconv.Itoa(added) + " added, " + strconv.Itoa(removed) + " removed, and " + strconv.Itoa(changed) + " changed between `" + versionA + "` and `" + versionB + "`")
+}
+
+func describeInstalledVersions(bentoDir string, sourceName string) string {
+	entries, err := os.ReadDir(path.Join(bentoDir, installedSourcesDirName, sourceName))
+	if err != nil {
+		return "No versions of it are installed."
+	}
+	versions := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			versions = append(versions, "`"+entry.Name()+"`")
+		}
+	}
+	if len(versions) == 0 {
+		return "No versions of it are installed."
+	}
+	return "The installed versions are " + strings.Join(versions, ", ") + "."
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// Checks that every library in the package repository that is expected to be provided by the host system is present.
+// Returns false if any of them are missing.
+func checkSystemLibraries(bentoDir string) bool {
+	librariesDir := path.Join(bentoDir, "lib")
+	entries, err := os.ReadDir(librariesDir)
+	if err != nil {
+		utils.Fail("Failed to read the libraries in the package repository: " + err.Error())
+	}
+	systemLibraryDirectories := utils.SystemLibraryDirectories()
+	missingLibraries := []string{}
+	numberOfSystemLibraries := 0
+	for _, entry := range entries {
+		libraryName, isToml := strings.CutSuffix(entry.Name(), ".toml")
+		if !isToml {
+			continue
+		}
+		var library unparsedLibrary
+		_, err := toml.DecodeFile(path.Join(librariesDir, entry.Name()), &library)
+		if err != nil {
+			utils.Fail("Failed to load library " + libraryName + ": " + err.Error())
+		}
+		if library.Source != "system" {
+			continue
+		}
+		numberOfSystemLibraries += 1
+		err = checkSystemLibrary(libraryName, library, systemLibraryDirectories)
+		if err != nil {
+			missingLibraries = append(missingLibraries, utils.AnsiFgRed+libraryName+utils.AnsiReset+": "+err.Error())
+		}
+	}
+	utils.SortNames(missingLibraries)
+	for _, missingLibrary := range missingLibraries {
+		println(missingLibrary)
+	}
+	if len(missingLibraries) > 0 {
+		println(utils.CreateNoun(len(missingLibraries), "A library", "libraries") + " that bento expects your system to provide could not be found")
+		return false
+	}
+	println(utils.AnsiFgGreen + "Found " + utils.CreateNoun(numberOfSystemLibraries, "the library", "libraries") + " that bento expects your system to provide" + utils.AnsiReset)
+	return true
+}
+
+// Tests the connection to every host that the package repository and its sources are fetched from, so that users
+// behind firewalls and proxies can find out which hop is blocked. Returns false if any host cannot be reached.
+func diagnoseNetwork(bentoDir string) bool {
+	for _, variable := range []string{"HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY"} {
+		for _, name := range []string{variable, strings.ToLower(variable)} {
+			if value := os.Getenv(name); value != "" {
+				println("Using `" + name + "=" + value + "`")
+			}
+		}
+	}
+
+	// Only one URL from each host is tested, since most sources are fetched from the same few hosts
+	urlsByHost := map[string]string{}
+	addUrl := func(rawUrl string) {
+		parsedUrl, err := url.Parse(rawUrl)
+		if err == nil {
+			if _, ok := urlsByHost[parsedUrl.Host]; !ok {
+				urlsByHost[parsedUrl.Host] = rawUrl
+			}
+		}
+	}
+	for _, rawUrl := range utils.PackageRepositoryUrls() {
+		addUrl(rawUrl)
+	}
+	r := newResolver(bentoDir, map[string]string{}, map[string][]string{}, userConfig{})
+	sourceNames, err := listTomlNames(r.sourcesDir)
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the sources in the package repository: " + err.Error())
+	}
+	for _, sourceName := range sourceNames {
+		sourceConf, err := r.loadSource(sourceName)
+		if err != nil {
+			continue
+		}
+		for _, artifact := range sourceConf.artifacts {
+			for _, rawUrl := range artifact.parsedUrls {
+				addUrl(rawUrl)
+			}
+		}
+	}
+
+	hosts := slices.Sorted(maps.Keys(urlsByHost))
+	diagnoses := make([][]utils.DiagnosticStep, len(hosts))
+	var wait sync.WaitGroup
+	for index, host := range hosts {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			diagnoses[index] = utils.DiagnoseUrl(urlsByHost[host])
+		}()
+	}
+	wait.Wait()
+
+	unreachableHosts := 0
+	for index, host := range hosts {
+		println(utils.AnsiBold + host + utils.AnsiReset)
+		for _, step := range diagnoses[index] {
+			line := "  " + utils.AnsiFgGreen + "ok" + utils.AnsiReset + "     " + step.Name
+			if step.Err != nil {
+				line = "  " + utils.AnsiFgRed + "failed" + utils.AnsiReset + " " + step.Name
+			}
+			line += " (" + step.Duration.Round(time.Millisecond).String() + ")"
+			if step.Err != nil {
+				line += ": " + step.Err.Error()
+			} else if step.Detail != "" {
+				line += ": " + step.Detail
+			}
+			println(line)
+		}
+		if steps := diagnoses[index]; len(steps) == 0 || steps[len(steps)-1].Err != nil {
+			unreachableHosts += 1
+		}
+	}
+	if unreachableHosts > 0 {
+		println(utils.CreateNoun(unreachableHosts, "1 host", "hosts") + " out of " + strconv.Itoa(len(hosts)) + " could not be reached")
+		return false
+	}
+	println(utils.AnsiFgGreen + "Reached " + utils.CreateNoun(len(hosts), "the host", "hosts") + " that bento fetches from" + utils.AnsiReset)
+	return true
+}
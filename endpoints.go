@@ -0,0 +1,74 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// Records the reasons that each host would be contacted
+type endpointReport map[string][]string
+
+func (e endpointReport) add(rawUrl string, purpose string) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil || parsedUrl.Host == "" {
+		return
+	}
+	if !slices.Contains(e[parsedUrl.Host], purpose) {
+		e[parsedUrl.Host] = append(e[parsedUrl.Host], purpose)
+	}
+	// Every connection goes through the proxy when there is one, so the proxy is contacted too
+	request, err := http.NewRequest(http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return
+	}
+	if proxyUrl, err := http.ProxyFromEnvironment(request); err == nil && proxyUrl != nil && !slices.Contains(e[proxyUrl.Host], "proxy") {
+		e[proxyUrl.Host] = append(e[proxyUrl.Host], "proxy")
+	}
+}
+
+// Prints every host that downloading the sources would contact, so that the hosts can be reviewed before consenting to
+// download the sources. Each artifact lists every mirror, since any of them can be used if the others fail.
+func printEndpoints(r *resolver, sourcesToDownload []string) {
+	report := endpointReport{}
+	info, err := readRepositoryInfo(r.bentoDir)
+	if os.IsNotExist(err) || (err == nil && info.Partial) {
+		// Source configs and libraries that are not downloaded yet are fetched from the package repository
+		for _, repositoryUrl := range utils.PackageRepositoryUrls()[1:] {
+			report.add(repositoryUrl, "package repository")
+		}
+	}
+	utils.SortNames(sourcesToDownload)
+	for _, sourceName := range sourcesToDownload {
+		for _, artifact := range r.sources[sourceName].artifacts {
+			for _, artifactUrl := range artifact.parsedUrls {
+				purpose := "mirror of " + sourceName
+				if artifact.isOciBlob {
+					purpose = "OCI registry of " + sourceName + ", and the token endpoint that it names"
+				}
+				report.add(artifactUrl, purpose)
+			}
+			for _, checksumUrl := range artifact.checksumUrls {
+				report.add(checksumUrl, "checksum file of "+sourceName)
+			}
+		}
+	}
+
+	if len(report) == 0 {
+		println("No hosts would be contacted, since every source is already downloaded")
+		return
+	}
+	rows := [][]string{}
+	for _, host := range slices.Sorted(maps.Keys(report)) {
+		rows = append(rows, []string{host, strings.Join(report[host], "; ")})
+	}
+	for _, line := range utils.AlignColumns(rows) {
+		os.Stdout.WriteString(line + "\n")
+	}
+	println("Mirrors can redirect downloads to other hosts, which are not listed, since they are only known once the mirrors are contacted. Bento warns when a mirror redirects to a host on another site.")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// Variables that are lists of directories, which are compared directory by directory, since a directory that moved
+// earlier or later in them changes which files are found
+var directoryListVariables = []string{"PATH", "LD_LIBRARY_PATH", "LD_PRELOAD", "XDG_DATA_DIRS", "XDG_CONFIG_DIRS", "MANPATH", "PKG_CONFIG_PATH", "PYTHONPATH"}
+
+func isLocaleVariable(name string) bool {
+	return name == "LANG" || name == "LANGUAGE" || strings.HasPrefix(name, "LC_")
+}
+
+// Returns the lines that describe how a list of directories changed
+func describeDirectoryListChange(oldValue string, newValue string) []string {
+	oldDirectories := strings.Split(oldValue, ":")
+	newDirectories := strings.Split(newValue, ":")
+	if oldValue == "" {
+		oldDirectories = []string{}
+	}
+	if newValue == "" {
+		newDirectories = []string{}
+	}
+	lines := []string{}
+	for _, directory := range newDirectories {
+		if !slices.Contains(oldDirectories, directory) {
+			lines = append(lines, utils.AnsiFgGreen+"+ "+directory+utils.AnsiReset)
+		}
+	}
+	for _, directory := range oldDirectories {
+		if !slices.Contains(newDirectories, directory) {
+			lines = append(lines, utils.AnsiFgRed+"- "+directory+utils.AnsiReset)
+		}
+	}
+	keptOld := slices.DeleteFunc(slices.Clone(oldDirectories), func(directory string) bool { return !slices.Contains(newDirectories, directory) })
+	keptNew := slices.DeleteFunc(slices.Clone(newDirectories), func(directory string) bool { return !slices.Contains(oldDirectories, directory) })
+	if !slices.Equal(keptOld, keptNew) {
+		lines = append(lines, utils.AnsiAttention+"the directories are in a different order: "+strings.Join(keptNew, ":")+utils.AnsiReset)
+	}
+	return lines
+}
+
+// Returns the lines that describe how a variable changes from the current environment to the environment that bento
+// runs an executable with, or nothing if it does not change
+func describeVariableChange(name string, oldValue string, wasSet bool, newValue string, isSet bool) []string {
+	if oldValue == newValue && wasSet == isSet {
+		return []string{}
+	}
+	heading := utils.AnsiBold + name + utils.AnsiReset
+	if isLocaleVariable(name) {
+		heading += " " + utils.AnsiAttention + "(locale)" + utils.AnsiReset
+	}
+	switch {
+	case !wasSet:
+		heading += " is set to `" + newValue + "`"
+	case !isSet:
+		heading += " is unset, but your shell has `" + oldValue + "`"
+	case slices.Contains(directoryListVariables, name):
+		return append([]string{heading + " changes:"}, describeDirectoryListChange(oldValue, newValue)...)
+	default:
+		heading += " changes from `" + oldValue + "` to `" + newValue + "`"
+	}
+	return []string{heading}
+}
+
+// Prints how the environment that bento runs an executable with differs from the environment of the current shell,
+// which explains why an executable works differently when it is run with bento
+func diffExecEnvironment(bentoDir string, sourceName string, sourceExecutableRelativePath string, withIsolatedHome bool) {
+	currentEnvironment := environmentToMap(os.Environ())
+	executableEnvironment := maps.Clone(currentEnvironment)
+	if withIsolatedHome {
+		isolateHome(executableEnvironment, sourceName, utils.NewTracer(false))
+	}
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	r := newResolver(bentoDir, executableEnvironment, installedFeatures, config)
+	hideProgress := r.showProgress(false)
+	command, err := r.resolveCommand(sourceName, sourceExecutableRelativePath)
+	hideProgress()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	printWarnings(r.warnings)
+	environment := environmentToMap(commandEnvironment(executableEnvironment, r.libraryPaths(), utils.NewTracer(false)))
+
+	println("`" + sourceExecutableRelativePath + "` from `" + sourceName + "` is run as `" + strings.Join(command, " ") + "`")
+	changes := [][]string{}
+	names := slices.Collect(maps.Keys(currentEnvironment))
+	for name := range environment {
+		if _, wasSet := currentEnvironment[name]; !wasSet {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		oldValue, wasSet := currentEnvironment[name]
+		newValue, isSet := environment[name]
+		if change := describeVariableChange(name, oldValue, wasSet, newValue, isSet); len(change) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) == 0 {
+		println("It is run with the same environment as your shell")
+		return
+	}
+	println(utils.CreateNoun(len(changes), "A variable differs", "variables differ") + " from the environment of your shell:")
+	for _, change := range changes {
+		println("  " + change[0])
+		for _, line := range change[1:] {
+			println("      " + line)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// The directory in the bento directory that executables run with `bento exec-url` are stored in, keyed by their sha256
+// checksum
+const urlSourcesDirName = "urlSources"
+
+// The name that a file which is not an archive is stored under in the directory named after its checksum. It does not
+// depend on the URL, since the same file can be run from URLs with different names.
+const urlSourceExecutableName = "executable"
+
+// The file extensions that the compression format of a URL is guessed from, and the compression format that each one
+// means
+var compressionsByExtension = [][2]string{
+	{".tar.gz", ".tar.gz"},
+	{".tgz", ".tar.gz"},
+	{".tar.xz", ".tar.xz"},
+	{".txz", ".tar.xz"},
+	{".tar.zst", ".tar.zst"},
+	{".tar.bz2", ".tbz"},
+	{".tbz", ".tbz"},
+	{".zip", ".zip"},
+	{".gz", ".gz"},
+}
+
+func guessCompression(fileName string) string {
+	for _, compression := range compressionsByExtension {
+		if strings.HasSuffix(fileName, compression[0]) {
+			return compression[1]
+		}
+	}
+	return "none"
+}
+
+type execUrlOptions struct {
+	sha256      string
+	compression string
+	// The path of the executable in the archive, which is not used for files that are not archives
+	executablePath string
+	trace          bool
+}
+
+// Downloads a file or archive from a URL if it has not been downloaded yet, verifies it against a checksum, and runs
+// an executable from it, without needing a source config. Files are stored by their checksum, so the same file is
+// only downloaded once even if it is run from different URLs.
+func execUrl(bentoDir string, fileUrl string, argsToPass []string, options execUrlOptions) {
+	trace := utils.NewTracer(options.trace)
+	checksumBytes, err := hex.DecodeString(options.sha256)
+	if err != nil || len(checksumBytes) != 32 {
+		utils.Fail("Expected the sha256 checksum to be 64 hexadecimal characters, but got `" + options.sha256 + "`")
+	}
+	parsedUrl, err := url.Parse(fileUrl)
+	if err != nil {
+		utils.Fail("Failed to parse the URL `" + fileUrl + "`: " + err.Error())
+	}
+	fileName := path.Base(parsedUrl.Path)
+	if options.compression == "" {
+		options.compression = guessCompression(fileName)
+		trace.Log("Guessed that the compression of `" + fileName + "` is `" + options.compression + "`")
+	}
+
+	// Files that are not archives are extracted to a single file, which is the executable
+	isArchive := options.compression != "none" && options.compression != ".gz"
+	executablePath := options.executablePath
+	if !isArchive {
+		executablePath = urlSourceExecutableName
+	} else if executablePath == "" {
+		utils.Fail("`" + fileUrl + "` is an archive, so the path of the executable in the archive must be given with `--path`")
+	}
+
+	if err := utils.ValidateRelativePath(executablePath); err != nil {
+		utils.Fail(err.Error())
+	}
+
+	sourcePath := path.Join(bentoDir, urlSourcesDirName, strings.ToLower(options.sha256))
+	if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
+		// The user config is only loaded for its memory budget and the HTTPS policy
+		_, err := loadUserConfig()
+		if err != nil {
+			utils.Fail(err.Error())
+		}
+		policy, policyPath, err := loadSourcePolicy()
+		if err != nil {
+			utils.Fail(err.Error())
+		}
+		if !policy.allowsDomain(fileUrl) && !confirmPolicyViolations(policyPath, []string{"`" + parsedUrl.Hostname() + "` is not an allowed domain"}, "`"+fileName+"`") {
+			os.Exit(1)
+		}
+		lock, _, err := lockInstalledSources(bentoDir, false, true)
+		if err != nil {
+			utils.Fail("Failed to lock the installed sources: " + err.Error())
+		}
+		temporaryPath := temporarySourcePath(sourcePath)
+		destination := temporaryPath
+		filesToMakeExecutable := []string{executablePath}
+		if !isArchive {
+			// The destination of a file that is not an archive is the file itself
+			destination = path.Join(temporaryPath, executablePath)
+			filesToMakeExecutable = []string{""}
+		}
+		errs := utils.DownloadConcurrently([]utils.DownloadOptions{{
+			Name:                  fileName,
+			Urls:                  []string{fileUrl},
+			Compression:           options.compression,
+			Checksum:              [32]byte(checksumBytes),
+			UseChecksum:           true,
+			FilesToMakeExecutable: filesToMakeExecutable,
+			Destination:           destination,
+			QuarantineDir:         path.Join(bentoDir, quarantineDirName),
+		}}, maxParrellelDownloads, nil)
+		if len(errs) > 0 {
+			os.RemoveAll(temporaryPath)
+			os.Exit(1)
+		}
+		// The manifest lets `bento cache verify` check the files, since the archive that they came from is not kept
+		err = writeManifest(temporaryPath, sourcePath)
+		if err != nil {
+			println("Failed to record the files in `" + sourcePath + "`: " + err.Error())
+		}
+		err = utils.SyncExtractedTree(temporaryPath)
+		if err != nil {
+			os.RemoveAll(temporaryPath)
+			utils.Fail("Failed to sync `" + temporaryPath + "` to disk: " + err.Error())
+		}
+		err = os.Rename(temporaryPath, sourcePath)
+		if err != nil {
+			if _, statErr := os.Stat(sourcePath); statErr != nil {
+				utils.Fail("Failed to move `" + temporaryPath + "` to `" + sourcePath + "`: " + err.Error())
+			}
+			os.RemoveAll(temporaryPath)
+		} else {
+			err = utils.SyncDirectory(path.Dir(sourcePath))
+			if err != nil {
+				println("Failed to sync `" + path.Dir(sourcePath) + "` to disk: " + err.Error())
+			}
+			recordHistory(historyRecord{
+				Action:    historyInstall,
+				Source:    fileUrl,
+				Checksums: []string{strings.ToLower(options.sha256)},
+				Reason:    "to run it with `bento exec-url`",
+			})
+		}
+		lock.Close()
+	} else if err != nil {
+		utils.Fail("Failed to stat `" + sourcePath + "`: " + err.Error())
+	} else {
+		trace.Log("`" + fileUrl + "` is already downloaded to " + sourcePath)
+	}
+
+	executable := path.Join(sourcePath, executablePath)
+	if _, err := os.Stat(executable); err != nil {
+		utils.Fail("Failed to find the executable `" + executablePath + "` in `" + fileUrl + "`: " + err.Error())
+	}
+	executeResolvedCommand([]string{executable}, argsToPass, environmentToMap(os.Environ()), []string{}, utils.ResourceLimits{}, nil, false, nil, nil, trace, nil)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// Returns the path of every executable file in an installed source, relative to the source
+func findExecutables(sourcePath string) ([]string, error) {
+	executables := []string{}
+	err := filepath.WalkDir(sourcePath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&0111 != 0 {
+			relativePath, err := filepath.Rel(sourcePath, filePath)
+			if err != nil {
+				return err
+			}
+			executables = append(executables, filepath.ToSlash(relativePath))
+		}
+		return nil
+	})
+	return executables, err
+}
+
+// Returns the paths of the executables that a source config mentions, for sources that have not been downloaded yet
+func executablesInSourceConfig(sourceConf parsedSourceConfig) []string {
+	executables := []string{}
+	for _, artifact := range sourceConf.artifacts {
+		for _, filePath := range artifact.filesToMakeExecutable {
+			executables = append(executables, path.Join(artifact.subdirectory, filePath))
+		}
+	}
+	executables = append(executables, slices.Collect(maps.Keys(sourceConf.env))...)
+	executables = append(executables, slices.Collect(maps.Keys(sourceConf.directSharedLibraryDependencies))...)
+	utils.SortNames(executables)
+	return slices.Compact(executables)
+}
+
+// Returns the paths that can be passed to `bento exec SOURCE`. Returns false if the source has not been downloaded yet,
+// in which case the paths are only the executables that its source config mentions.
+func sourceExecutables(r *resolver, sourceName string) ([]string, bool, error) {
+	sourceConf, err := r.loadSource(sourceName)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := os.Stat(sourceConf.path); err != nil {
+		return executablesInSourceConfig(sourceConf), false, nil
+	}
+	executables, err := findExecutables(sourceConf.path)
+	if err != nil {
+		return nil, true, utils.WrapError("Failed to find the executables in `"+sourceConf.path+"`", err)
+	}
+	utils.SortNames(executables)
+	return executables, true, nil
+}
+
+// Prints the paths that can be passed to `bento exec SOURCE`. The paths are printed to stdout, one per line, so that
+// they can be used by scripts.
+func listExecutables(bentoDir string, sourceName string) {
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	r := newResolver(bentoDir, map[string]string{}, installedFeatures, config)
+	executables, downloaded, err := sourceExecutables(r, sourceName)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	if !downloaded {
+		println("`" + sourceName + "` has not been downloaded yet, so these are only the executables that its source config mentions, which may include glob patterns")
+	}
+	if len(executables) == 0 {
+		println("`" + sourceName + "` does not have any executables")
+	}
+	for _, executable := range executables {
+		os.Stdout.WriteString(executable + "\n")
+	}
+}
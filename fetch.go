@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// The directory that `bento fetch` downloads to when `--output` is not given, relative to the working directory
+const defaultFetchOutputDir = "bento-fetch"
+
+// The file in the output directory of `bento fetch` that lists the checksum of every download, in the format that
+// `sha256sum -c` reads, so that the downloads can be checked after they are copied to another machine
+const fetchChecksumsFileName = "SHA256SUMS"
+
+type fetchOptions struct {
+	architecture    string
+	operatingSystem string
+	outputDir       string
+}
+
+// Returns the name that a download of an artifact is saved with, which is the name of the file in the mirrors, or the
+// digest of a blob in an OCI registry
+func fetchedArtifactFileName(artifact parsedArtifact) string {
+	if artifact.isOciBlob {
+		return strings.ReplaceAll(path.Base(artifact.parsedUrls[0]), ":", "-")
+	}
+	return path.Base(artifact.description)
+}
+
+// Returns true if a file exists and has the checksum that it is expected to have, so that running `bento fetch` again
+// does not download it again
+func hasChecksum(filePath string, checksum [32]byte) bool {
+	hash := sha256.New()
+	if hashFile(filePath, hash) != nil {
+		return false
+	}
+	return [32]byte(hash.Sum(nil)) == checksum
+}
+
+// Downloads the artifacts of some sources and their dependencies for another architecture or operating system, without
+// extracting them, so that a machine that cannot download them itself can be provisioned. Each artifact is saved to
+// `OUTPUT/SOURCE/VERSION/FILE`.
+func fetchForPlatform(bentoDir string, sourceNames []string, options fetchOptions) {
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	// Executable overrides point at executables on this machine, so they would not exist on the other machine
+	config.ExecutableOverrides = nil
+	r := newResolver(bentoDir, map[string]string{}, installedFeatures, config)
+	r.architecture = options.architecture
+	r.operatingSystem = options.operatingSystem
+	hideProgress := r.showProgress(false)
+	sourceNames, _, err = r.expandBundles(sourceNames)
+	if err != nil {
+		hideProgress()
+		utils.Fail(err.Error())
+	}
+	for _, sourceName := range sourceNames {
+		err := r.loadAllExecutables(sourceName)
+		if err != nil {
+			hideProgress()
+			utils.Fail(err.Error())
+		}
+	}
+	hideProgress()
+	printWarnings(r.warnings)
+	checksumWarnings, err := r.fetchPublishedChecksums(utils.Collect(maps.Keys(r.sources)))
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	printWarnings(checksumWarnings)
+
+	downloads := []utils.DownloadOptions{}
+	checksums := []string{}
+	alreadyFetched := 0
+	for _, sourceName := range utils.SortedNames(maps.Keys(r.sources)) {
+		sourceConf := r.sources[sourceName]
+		for _, artifact := range sourceConf.artifacts {
+			relativePath := path.Join(sourceName, sourceConf.version, fetchedArtifactFileName(artifact))
+			checksums = append(checksums, hex.EncodeToString(artifact.parsedChecksum[:])+"  "+relativePath)
+			destination := path.Join(options.outputDir, relativePath)
+			if hasChecksum(destination, artifact.parsedChecksum) {
+				alreadyFetched += 1
+				continue
+			}
+			downloads = append(downloads, utils.DownloadOptions{
+				Name:          sourceName + " (" + artifact.description + ")",
+				Urls:          artifact.parsedUrls,
+				Compression:   "none",
+				UseChecksum:   true,
+				Checksum:      artifact.parsedChecksum,
+				Destination:   destination,
+				QuarantineDir: path.Join(bentoDir, quarantineDirName),
+			})
+		}
+	}
+	if alreadyFetched > 0 {
+		println("Skipped " + utils.CreateNoun(alreadyFetched, "1 download", "downloads") + " that `" + options.outputDir + "` already has")
+	}
+	errs := utils.DownloadConcurrently(downloads, maxParrellelDownloads, nil)
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+
+	err = os.MkdirAll(options.outputDir, 0755)
+	if err == nil {
+		err = os.WriteFile(path.Join(options.outputDir, fetchChecksumsFileName), []byte(strings.Join(checksums, "\n")+"\n"), 0644)
+	}
+	if err != nil {
+		utils.Fail("Failed to write the checksums of the downloads: " + err.Error())
+	}
+	println("Fetched " + utils.CreateNoun(len(r.sources), "1 source", "sources") + " for " + options.operatingSystem + "/" + options.architecture + " into `" + options.outputDir + "`. Check them on the other machine with `sha256sum -c " + fetchChecksumsFileName + "`.")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// How old a temporary file has to be before it is removed automatically. Temporary files are only removed when no
+// other bento process is downloading sources, so this is just an extra precaution.
+const staleTemporaryFileAge = time.Hour
+
+// Sources are downloaded into a temporary directory next to the directory that they are installed to, and then the
+// temporary directory is renamed, so that a source that was only partially extracted is never used
+func temporarySourcePath(sourcePath string) string {
+	return path.Join(path.Dir(sourcePath), "."+path.Base(sourcePath)+".tmp-"+strconv.Itoa(os.Getpid()))
+}
+
+// Takes a lock that is shared by every process that is downloading sources, and which `removeStaleTemporaryFiles`
+// takes exclusively, so that it never removes a temporary directory that a source is being extracted into
+func lockInstalledSources(bentoDir string, exclusive bool, wait bool) (*os.File, bool, error) {
+	installedSourcesDir := path.Join(bentoDir, installedSourcesDirName)
+	err := os.MkdirAll(installedSourcesDir, 0755)
+	if err != nil {
+		return nil, false, explainWriteError(err)
+	}
+	lock, locked, err := utils.LockFile(path.Join(installedSourcesDir, ".lock"), exclusive, wait)
+	return lock, locked, explainWriteError(err)
+}
+
+// Removes the temporary files that are older than `maxAge` and were left behind by bento processes that crashed or
+// were killed. Returns false if nothing was removed because another bento process is downloading sources.
+func removeStaleTemporaryFiles(bentoDir string, maxAge time.Duration) ([]string, bool, error) {
+	lock, locked, err := lockInstalledSources(bentoDir, true, false)
+	if err != nil || !locked {
+		return []string{}, locked, err
+	}
+	defer lock.Close()
+
+	candidates := []string{}
+	installedSourcesDir := path.Join(bentoDir, installedSourcesDirName)
+	sources, err := os.ReadDir(installedSourcesDir)
+	if err != nil {
+		return []string{}, true, err
+	}
+	for _, source := range sources {
+		if !source.IsDir() {
+			continue
+		}
+		versions, err := os.ReadDir(path.Join(installedSourcesDir, source.Name()))
+		if err != nil {
+			return []string{}, true, err
+		}
+		for _, version := range versions {
+			if strings.HasPrefix(version.Name(), ".") && strings.Contains(version.Name(), ".tmp-") {
+				candidates = append(candidates, path.Join(installedSourcesDir, source.Name(), version.Name()))
+			}
+		}
+	}
+	urlSources, err := os.ReadDir(path.Join(bentoDir, urlSourcesDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return []string{}, true, err
+	}
+	for _, urlSource := range urlSources {
+		if strings.HasPrefix(urlSource.Name(), ".") && strings.Contains(urlSource.Name(), ".tmp-") {
+			candidates = append(candidates, path.Join(bentoDir, urlSourcesDirName, urlSource.Name()))
+		}
+	}
+	jobs, err := os.ReadDir(jobsDir(bentoDir))
+	if err != nil && !os.IsNotExist(err) {
+		return []string{}, true, err
+	}
+	for _, job := range jobs {
+		if strings.HasSuffix(job.Name(), ".tmp") {
+			candidates = append(candidates, path.Join(jobsDir(bentoDir), job.Name()))
+		}
+	}
+
+	removed := []string{}
+	for _, candidate := range candidates {
+		info, err := os.Lstat(candidate)
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		err = os.RemoveAll(candidate)
+		if err != nil {
+			return removed, true, err
+		}
+		removed = append(removed, candidate)
+	}
+	return removed, true, nil
+}
+
+func collectGarbage(bentoDir string) {
+	removed, locked, err := removeStaleTemporaryFiles(bentoDir, 0)
+	if err != nil {
+		utils.Fail("Failed to remove temporary files: " + err.Error())
+	}
+	if !locked {
+		utils.Fail("Another bento process is downloading sources. Try again when it has finished.")
+	}
+	for _, removedPath := range removed {
+		println("Removed `" + removedPath + "`")
+	}
+	println("Removed " + utils.CreateNoun(len(removed), "1 temporary file", "temporary files"))
+
+	lock, locked, err := lockInstalledSources(bentoDir, true, false)
+	if err != nil {
+		utils.Fail("Failed to lock the installed sources: " + err.Error())
+	}
+	if !locked {
+		utils.Fail("Another bento process is downloading sources. Try again when it has finished.")
+	}
+	bytesFreed := pruneContentStore(bentoDir)
+	lock.Close()
+	if bytesFreed > 0 {
+		println("Freed " + utils.FormatSize(bytesFreed) + " by removing files from the content-addressed store that no source uses")
+	}
+
+	// The temporary files that the journals refer to were removed, so the journals are only useful for downloading
+	// the sources again without asking, which the user can do by installing them again
+	entries, err := os.ReadDir(path.Join(bentoDir, journalsDirName))
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the journals of the downloads: " + err.Error())
+	}
+	for _, entry := range entries {
+		transaction, isJournal := strings.CutSuffix(entry.Name(), ".toml")
+		if !isJournal {
+			continue
+		}
+		journalLock, locked, err := lockJournal(bentoDir, transaction)
+		if err != nil || !locked {
+			continue
+		}
+		removeJournal(bentoDir, transaction)
+		journalLock.Close()
+		println("Removed the journal of transaction " + transaction + ", so its downloads can no longer be resumed")
+	}
+}
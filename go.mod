@@ -6,4 +6,7 @@ require (
 	github.com/BurntSushi/toml v1.5.0 // direct
 	github.com/ulikunitz/xz v0.5.12 // direct
 	github.com/klauspost/compress v1.18.0 // direct
+	golang.org/x/net v0.43.0 // direct
+	golang.org/x/sync v0.16.0 // direct
+	golang.org/x/sys v0.35.0 // direct
 )
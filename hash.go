@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// The hash algorithms that `bento hash` supports. Source configs only accept sha256 checksums, so the others are for
+// comparing with the checksums that projects publish.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Prints the checksum of each file or URL in the format of the `Checksums` table of a source config, so that it can be
+// pasted into a source config. A file called `-` is read from stdin.
+func printChecksums(fileOrUrls []string, algorithm string) {
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		utils.Fail("`" + algorithm + "` is not a supported hash algorithm. Expected either `sha256`, or `sha512`")
+	}
+	failed := false
+	for _, fileOrUrl := range fileOrUrls {
+		hash := newHash()
+		name := path.Base(fileOrUrl)
+		var err error
+		if parsedUrl, parseErr := url.Parse(fileOrUrl); parseErr == nil && (parsedUrl.Scheme == "http" || parsedUrl.Scheme == "https") {
+			name = path.Base(parsedUrl.Path)
+			err = utils.FetchInto(fileOrUrl, hash)
+		} else {
+			err = hashFile(fileOrUrl, hash)
+		}
+		if err != nil {
+			println(utils.AnsiFgRed + "Failed to hash `" + fileOrUrl + "`: " + err.Error() + utils.AnsiReset)
+			failed = true
+			continue
+		}
+		// The checksum is printed to stdout, so that it can be piped into an editor
+		os.Stdout.WriteString(strconv.Quote(name) + " = " + strconv.Quote(hex.EncodeToString(hash.Sum(nil))) + "\n")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func hashFile(filePath string, hash hash.Hash) error {
+	var file *os.File
+	if filePath == "-" {
+		file = os.Stdin
+	} else {
+		var err error
+		file, err = os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+	_, err := io.Copy(hash, file)
+	return err
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godalming123/bento/utils"
+)
+
+type historyAction = string
+
+const (
+	// The user was asked whether to download a source, and the answer is in `Consent`
+	historyConsent historyAction = "consent"
+	// A version of a source was installed when no other version of it was installed
+	historyInstall historyAction = "install"
+	// A version of a source was installed alongside the versions in `PreviousVersions`
+	historyUpgrade historyAction = "upgrade"
+	// An installed version of a source was downloaded and extracted again, and replaced the files that were installed
+	historyRefresh historyAction = "refresh"
+	// The features that the user chose to install were changed from `PreviousFeatures`
+	historyFeatures historyAction = "features"
+	// A version of a source was removed
+	historyRemove historyAction = "remove"
+	// The changes that were made by `UndoneTransaction` were undone
+	historyRollback historyAction = "rollback"
+	// `bento exec --time` measured how long each step of running an executable took, which is in `Timings`
+	historyTiming historyAction = "timing"
+)
+
+// The actions that `bento history --action` accepts
+var historyActions = []historyAction{historyConsent, historyInstall, historyUpgrade, historyRefresh, historyFeatures, historyRemove, historyRollback, historyTiming}
+
+// Identifies the records of this invocation of bento, so that everything that it changed can be undone together
+var currentTransaction = time.Now().UTC().Format("20060102T150405") + "-" + strconv.Itoa(os.Getpid())
+
+// The answers that are recorded for a `consent` record
+const (
+	consentApproved           = "approved"
+	consentDeclined           = "declined"
+	consentSkipped            = "skipped"
+	consentPreviouslyApproved = "previously approved"
+)
+
+// A line of the history file, which records a change that bento made to the installed sources, or a decision that the
+// user made about one
+type historyRecord struct {
+	Time             time.Time
+	Action           historyAction
+	Source           string
+	Version          string
+	PreviousVersions []string
+	// The sha256 checksums of the artifacts that were downloaded, in hexadecimal
+	Checksums []string
+	Consent   string
+	// Why the source was needed, like `to install the source tool`
+	Reason           string
+	Transaction      string
+	PreviousFeatures map[string][]string
+	// The transaction that a `rollback` record undid
+	UndoneTransaction string
+	// How long each step of running an executable took, for a `timing` record
+	Timings map[string]time.Duration
+}
+
+// The directory that bento stores state that should persist between runs, but which is not important enough to back up,
+// in
+func getStateDir() string {
+	// The history of the sources that are installed for every user is kept with them
+	if systemInstall {
+		return path.Join(systemBentoDir(), "state")
+	}
+	if stateDir, isSet := writableDirOverride("state"); isSet {
+		return stateDir
+	}
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			utils.Fail("Failed to get home directory: " + err.Error() + ". " + writableDirHint)
+		}
+		stateHome = path.Join(homeDir, ".local", "state")
+	}
+	return path.Join(stateHome, "bento")
+}
+
+func historyFilePath() string {
+	return path.Join(getStateDir(), "history.jsonl")
+}
+
+// Appends records to the history file. Failing to record history does not stop bento from working, so errors are
+// printed instead of being returned.
+func recordHistory(records ...historyRecord) {
+	if len(records) == 0 {
+		return
+	}
+	encoded := strings.Builder{}
+	encoder := json.NewEncoder(&encoded)
+	for _, record := range records {
+		if record.Time.IsZero() {
+			record.Time = time.Now()
+		}
+		if record.Transaction == "" {
+			record.Transaction = currentTransaction
+		}
+		err := encoder.Encode(record)
+		if err != nil {
+			println("Failed to record history: " + err.Error())
+			return
+		}
+	}
+	err := os.MkdirAll(getStateDir(), 0755)
+	if err != nil {
+		println("Failed to record history: " + explainWriteError(err).Error())
+		return
+	}
+	file, err := os.OpenFile(historyFilePath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		println("Failed to record history: " + explainWriteError(err).Error())
+		return
+	}
+	defer file.Close()
+	// Every record is written in one call, so that records from bento processes running at the same time are not
+	// interleaved
+	_, err = file.WriteString(encoded.String())
+	if err != nil {
+		println("Failed to record history: " + err.Error())
+	}
+}
+
+// Records the answer that the user gave for each source that they were asked to download
+func recordConsent(sources map[string]parsedSourceConfig, sourceNames []string, consent string, reason string) {
+	records := []historyRecord{}
+	for _, sourceName := range sourceNames {
+		records = append(records, historyRecord{
+			Action:  historyConsent,
+			Source:  sourceName,
+			Version: sources[sourceName].version,
+			Consent: consent,
+			Reason:  reason,
+		})
+	}
+	recordHistory(records...)
+}
+
+// Returns the record of installing a source, which is an upgrade if other versions of the source are installed. This
+// must be called before the source is moved into place.
+func installHistoryRecord(sourceName string, sourceConf parsedSourceConfig, reason string) historyRecord {
+	record := historyRecord{Action: historyInstall, Source: sourceName, Version: sourceConf.version, Reason: reason}
+	for _, artifact := range sourceConf.artifacts {
+		record.Checksums = append(record.Checksums, hex.EncodeToString(artifact.parsedChecksum[:]))
+	}
+	entries, _ := os.ReadDir(path.Dir(sourceConf.path))
+	for _, entry := range entries {
+		// Names that start with `.` are temporary directories that sources are being extracted into
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && entry.Name() != sourceConf.version {
+			record.PreviousVersions = append(record.PreviousVersions, entry.Name())
+		}
+	}
+	if len(record.PreviousVersions) > 0 {
+		record.Action = historyUpgrade
+	}
+	return record
+}
+
+type historyFilter struct {
+	source string
+	action historyAction
+	since  time.Time
+}
+
+func (f historyFilter) matches(record historyRecord) bool {
+	return (f.source == "" || record.Source == f.source) &&
+		(f.action == "" || record.Action == f.action) &&
+		!record.Time.Before(f.since)
+}
+
+func describeHistoryRecord(record historyRecord) string {
+	description := utils.FormatTime(record.Time) + " " + utils.AnsiBold + record.Action + utils.AnsiReset + " " + record.Source
+	if record.Version != "" {
+		description += " " + record.Version
+	}
+	switch record.Action {
+	case historyConsent:
+		description += ": " + record.Consent
+	case historyUpgrade:
+		description += " alongside " + strings.Join(record.PreviousVersions, ", ")
+	case historyFeatures:
+		description += "the installed features were changed"
+	case historyRollback:
+		description += "undid transaction " + record.UndoneTransaction
+	case historyTiming:
+		description += ": " + describeTimings(record.Timings)
+	}
+	if record.Reason != "" {
+		description += " (" + record.Reason + ")"
+	}
+	if len(record.Checksums) > 0 {
+		description += "\n    sha256 " + strings.Join(record.Checksums, ", ")
+	}
+	return description
+}
+
+// Reads every record in the history file, in the order that they were recorded
+func readHistory() []historyRecord {
+	records := []historyRecord{}
+	file, err := os.Open(historyFilePath())
+	if os.IsNotExist(err) {
+		return records
+	} else if err != nil {
+		utils.Fail("Failed to read the history: " + err.Error())
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var record historyRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			// A line can be partially written if bento was killed while recording it
+			println(utils.AnsiFgYellow + "Skipped line " + strconv.Itoa(lineNumber) + " of the history: " + err.Error() + utils.AnsiReset)
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		utils.Fail("Failed to read the history: " + err.Error())
+	}
+	return records
+}
+
+func printHistory(filter historyFilter) {
+	records := readHistory()
+	if len(records) == 0 {
+		println("Bento has not recorded any history yet")
+	}
+	for _, record := range records {
+		if filter.matches(record) {
+			println(describeHistoryRecord(record))
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// The most modified files that are named in the warning about a modified source
+const maxModifiedFilesToName = 3
+
+// Returns the files in the installed tree of a source that were removed or changed since it was installed, using the
+// manifest that was recorded when it was installed. Sources that are not installed, or that were installed before
+// bento recorded manifests, are never considered to be modified.
+func (r *resolver) findModifiedFiles(sourceName string) []string {
+	sourcePath := r.sources[sourceName].path
+	manifest, err := decodeTomlFile[map[string]utils.ManifestEntry](r.tomlCache, manifestPath(sourcePath))
+	if err != nil {
+		return []string{}
+	}
+	if _, err := os.Stat(sourcePath); err != nil {
+		return []string{}
+	}
+	return utils.FindModifiedFiles(sourcePath, manifest)
+}
+
+func modifiedSourceWarning(sourceName string, modifiedFiles []string) string {
+	named := modifiedFiles[:min(len(modifiedFiles), maxModifiedFilesToName)]
+	description := "`" + strings.Join(named, "`, `") + "`"
+	if len(modifiedFiles) > len(named) {
+		description += ", and " + strconv.Itoa(len(modifiedFiles)-len(named)) + " more"
+	}
+	return "The source `" + sourceName + "` was modified since it was installed (" + description + "), so it may not work. Run it with `--heal` to download it again and restore it."
+}
+
+// Warns about files in the installed tree of a source that were modified since it was installed. Returns true if the
+// source was modified.
+func (r *resolver) checkSourceIntegrity(sourceName string) bool {
+	modifiedFiles := r.findModifiedFiles(sourceName)
+	if len(modifiedFiles) == 0 {
+		return false
+	}
+	r.warnings = append(r.warnings, modifiedSourceWarning(sourceName, modifiedFiles))
+	return true
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"os"
+	osExec "os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The directory in the bento directory that the state and logs of background jobs are stored in
+const jobsDirName = "jobs"
+
+type jobStatus = string
+
+const (
+	jobQueued   jobStatus = "queued"
+	jobRunning  jobStatus = "running"
+	jobFinished jobStatus = "finished"
+	jobFailed   jobStatus = "failed"
+	// The process running the job exited without recording whether the job succeeded, for example because it was
+	// killed
+	jobInterrupted jobStatus = "interrupted"
+)
+
+// A background job, which is stored in `jobs/ID.toml`
+type job struct {
+	// The arguments that were passed to `bento install`, excluding `--background`
+	Args     []string
+	Pid      int
+	Status   jobStatus
+	Progress []string
+	Started  time.Time
+	Finished time.Time
+}
+
+func jobsDir(bentoDir string) string {
+	return path.Join(bentoDir, jobsDirName)
+}
+
+func jobFilePath(bentoDir string, id int) string {
+	return path.Join(jobsDir(bentoDir), strconv.Itoa(id)+".toml")
+}
+
+func jobLogPath(bentoDir string, id int) string {
+	return path.Join(jobsDir(bentoDir), strconv.Itoa(id)+".log")
+}
+
+func readJob(bentoDir string, id int) (job, error) {
+	var j job
+	_, err := toml.DecodeFile(jobFilePath(bentoDir, id), &j)
+	if err != nil {
+		return j, utils.WrapError("Failed to read job "+strconv.Itoa(id), err)
+	}
+	// The PID is 0 until the process running the job records it
+	if (j.Status == jobQueued || j.Status == jobRunning) && j.Pid != 0 && !processIsRunning(j.Pid) {
+		j.Status = jobInterrupted
+	}
+	return j, nil
+}
+
+// Writes the job to a temporary file and renames it into place, so that `bento jobs` never reads a partially written
+// job
+func writeJob(bentoDir string, id int, j job) error {
+	temporaryPath := jobFilePath(bentoDir, id) + ".tmp"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return explainWriteError(err)
+	}
+	err = toml.NewEncoder(file).Encode(j)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, jobFilePath(bentoDir, id))
+}
+
+// Returns the IDs of every job in ascending order
+func listJobIds(bentoDir string) ([]int, error) {
+	entries, err := os.ReadDir(jobsDir(bentoDir))
+	if os.IsNotExist(err) {
+		return []int{}, nil
+	} else if err != nil {
+		return []int{}, err
+	}
+	ids := []int{}
+	for _, entry := range entries {
+		idString, isJobFile := strings.CutSuffix(entry.Name(), ".toml")
+		if !isJobFile {
+			continue
+		}
+		id, err := strconv.Atoi(idString)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func processIsRunning(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
+
+// Starts `bento install` with `args` in a new process that keeps running after the current process exits, and
+// returns the ID of the job
+func startInstallJob(bentoDir string, args []string) (int, error) {
+	err := os.MkdirAll(jobsDir(bentoDir), 0755)
+	if err != nil {
+		return 0, explainWriteError(err)
+	}
+	ids, err := listJobIds(bentoDir)
+	if err != nil {
+		return 0, err
+	}
+	id := 1
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+	// The log is created before the job file, so another `bento install --background` that is started at the same
+	// time can find the same ID. Creating the log fails if it already exists, in which case the next ID is tried.
+	var logFile *os.File
+	for {
+		logFile, err = os.OpenFile(jobLogPath(bentoDir, id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+		id += 1
+	}
+	if err != nil {
+		return 0, explainWriteError(err)
+	}
+	defer logFile.Close()
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	command := osExec.Command(executable, append(append([]string{"install"}, args...), "--job", strconv.Itoa(id))...)
+	command.Stdout = logFile
+	command.Stderr = logFile
+	// Start the job in a new session, so that it is not stopped when the terminal that started it is closed
+	command.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = writeJob(bentoDir, id, job{Args: args, Status: jobQueued, Started: time.Now()})
+	if err != nil {
+		return 0, err
+	}
+	err = command.Start()
+	if err != nil {
+		os.Remove(jobFilePath(bentoDir, id))
+		return 0, err
+	}
+	command.Process.Release()
+	return id, nil
+}
+
+// Tracks the job that the current process is running
+type jobReporter struct {
+	bentoDir        string
+	id              int
+	job             job
+	lastWriteTime   time.Time
+	queueLockHandle *os.File
+}
+
+// Waits until no other job is running, then marks the job as running. Jobs are run one at a time, so that several
+// background installs do not compete for bandwidth.
+func startRunningJob(bentoDir string, id int) (*jobReporter, error) {
+	j, err := readJob(bentoDir, id)
+	if err != nil {
+		return nil, err
+	}
+	j.Pid = os.Getpid()
+	j.Status = jobQueued
+	err = writeJob(bentoDir, id, j)
+	if err != nil {
+		return nil, err
+	}
+	lockFile, _, err := utils.LockFile(path.Join(jobsDir(bentoDir), "queue.lock"), true, true)
+	if err != nil {
+		return nil, err
+	}
+	j.Status = jobRunning
+	reporter := &jobReporter{bentoDir: bentoDir, id: id, job: j, queueLockHandle: lockFile}
+	return reporter, writeJob(bentoDir, id, j)
+}
+
+func (r *jobReporter) reportProgress(progress []string) {
+	r.job.Progress = progress
+	// Writing the job on every redraw of the download statuses would be wasteful, so only write it twice a second
+	if time.Since(r.lastWriteTime) > 500*time.Millisecond {
+		r.lastWriteTime = time.Now()
+		writeJob(r.bentoDir, r.id, r.job)
+	}
+}
+
+func (r *jobReporter) finish(succeeded bool) {
+	r.job.Status = jobFinished
+	if !succeeded {
+		r.job.Status = jobFailed
+	}
+	r.job.Finished = time.Now()
+	err := writeJob(r.bentoDir, r.id, r.job)
+	if err != nil {
+		println("Failed to record the status of job " + strconv.Itoa(r.id) + ": " + err.Error())
+	}
+	r.queueLockHandle.Close()
+}
+
+func jobStatusToAnsiString(status jobStatus) string {
+	switch status {
+	case jobQueued:
+		return utils.AnsiFgYellow + status + utils.AnsiReset
+	case jobRunning:
+		return utils.AnsiFgCyan + status + utils.AnsiReset
+	case jobFinished:
+		return utils.AnsiFgGreen + status + utils.AnsiReset
+	default:
+		return utils.AnsiFgRed + status + utils.AnsiReset
+	}
+}
+
+func printJobs(bentoDir string) {
+	ids, err := listJobIds(bentoDir)
+	if err != nil {
+		utils.Fail("Failed to list jobs: " + err.Error())
+	}
+	if len(ids) == 0 {
+		println("There are no jobs")
+		return
+	}
+	jobs := make([]job, len(ids))
+	rows := [][]string{}
+	for index, id := range ids {
+		jobs[index], err = readJob(bentoDir, id)
+		if err != nil {
+			utils.Fail(err.Error())
+		}
+		rows = append(rows, []string{utils.AnsiBold + strconv.Itoa(id) + utils.AnsiReset, jobStatusToAnsiString(jobs[index].Status), "bento install " + strings.Join(jobs[index].Args, " ")})
+	}
+	lines := utils.AlignColumns(rows)
+	for index, id := range ids {
+		j := jobs[index]
+		println(lines[index])
+		switch j.Status {
+		case jobRunning:
+			for _, line := range j.Progress {
+				println("  - " + line)
+			}
+		case jobFailed, jobInterrupted:
+			println("  See `" + jobLogPath(bentoDir, id) + "` for details, or run `bento jobs resume " + strconv.Itoa(id) + "` to try again")
+		}
+	}
+}
+
+// Blocks until every job in `ids` has stopped, and returns false if any of them did not finish successfully
+func waitForJobs(bentoDir string, ids []int) bool {
+	for {
+		allStopped := true
+		allSucceeded := true
+		for _, id := range ids {
+			j, err := readJob(bentoDir, id)
+			if err != nil {
+				utils.Fail(err.Error())
+			}
+			switch j.Status {
+			case jobQueued, jobRunning:
+				allStopped = false
+			case jobFailed, jobInterrupted:
+				allSucceeded = false
+			}
+		}
+		if allStopped {
+			return allSucceeded
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// Starts a job that failed or was interrupted again. Sources that were fully downloaded by the job are not
+// downloaded again.
+func resumeJob(bentoDir string, id int) {
+	j, err := readJob(bentoDir, id)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	if j.Status != jobFailed && j.Status != jobInterrupted {
+		utils.Fail("Job " + strconv.Itoa(id) + " is " + j.Status + ", so it cannot be resumed")
+	}
+	newId, err := startInstallJob(bentoDir, j.Args)
+	if err != nil {
+		utils.Fail("Failed to start job: " + err.Error())
+	}
+	os.Remove(jobFilePath(bentoDir, id))
+	os.Remove(jobLogPath(bentoDir, id))
+	println("Resumed job " + strconv.Itoa(id) + " as job " + strconv.Itoa(newId))
+}
+
+func jobsCommand(bentoDir string, index int) {
+	if index >= len(os.Args) {
+		printJobs(bentoDir)
+		return
+	}
+	action := utils.TakeOneArg(&index, "")
+	switch action {
+	case "wait":
+		ids := []int{}
+		for index < len(os.Args) {
+			id, err := strconv.Atoi(utils.TakeOneArg(&index, ""))
+			if err != nil {
+				utils.Fail("Expected the ID of a job: " + err.Error())
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			allIds, err := listJobIds(bentoDir)
+			if err != nil {
+				utils.Fail("Failed to list jobs: " + err.Error())
+			}
+			for _, id := range allIds {
+				j, err := readJob(bentoDir, id)
+				if err != nil {
+					utils.Fail(err.Error())
+				}
+				if j.Status == jobQueued || j.Status == jobRunning {
+					ids = append(ids, id)
+				}
+			}
+		}
+		if !waitForJobs(bentoDir, ids) {
+			printJobs(bentoDir)
+			os.Exit(1)
+		}
+	case "resume":
+		var idString string
+		utils.TakeArgs(&index, []utils.Argument{{Desc: "The ID of the job to resume", Value: &idString}})
+		utils.ExpectAllArgsParsed(index)
+		id, err := strconv.Atoi(idString)
+		if err != nil {
+			utils.Fail("Expected the ID of a job: " + err.Error())
+		}
+		resumeJob(bentoDir, id)
+	default:
+		utils.Fail("`" + action + "` is not a valid action. Expected either `wait`, or `resume`")
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The directory in the bento directory that the journals of the downloads that are in progress are stored in, so that
+// `bento resume` can finish them if bento is killed
+const journalsDirName = "journals"
+
+type journaledSourceState = string
+
+const (
+	sourcePlanned journaledSourceState = "planned"
+	// Every artifact of the source has been extracted into its temporary path
+	sourceDownloaded journaledSourceState = "downloaded"
+	sourceInstalled  journaledSourceState = "installed"
+)
+
+type journaledSource struct {
+	Name          string
+	Path          string
+	TemporaryPath string
+	State         journaledSourceState
+	Downloads     []utils.DownloadOptions
+	History       historyRecord
+	// Shown after the source is installed
+	InstallationWarnings []string
+	KnownIssues          []string
+	FileChecksums        map[string]string
+	// The source was already installed, and the installed tree is replaced once the source is downloaded again
+	Refresh bool
+	// Saved next to the source once it is installed
+	Info installedSourceInfo
+}
+
+// Everything that is needed to finish downloading a set of sources that the user agreed to download, without loading
+// their source configs or asking the user again. It is stored in `journals/TRANSACTION.toml`.
+type downloadJournal struct {
+	Transaction  string
+	Reproducible bool
+	// Adds the files of the sources to the content-addressed store when they are installed
+	ContentAddressedStore bool
+	Sources               []journaledSource
+	// The features to save once every source is installed, which are nil if the features are not changed
+	Features         map[string][]string
+	PreviousFeatures map[string][]string
+}
+
+func journalPath(bentoDir string, transaction string) string {
+	return path.Join(bentoDir, journalsDirName, transaction+".toml")
+}
+
+// Writes the journal to a temporary file and renames it into place, so that a journal is never partially written
+func writeJournal(bentoDir string, journal downloadJournal) error {
+	temporaryPath := journalPath(bentoDir, journal.Transaction) + ".tmp"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return explainWriteError(err)
+	}
+	err = toml.NewEncoder(file).Encode(journal)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, journalPath(bentoDir, journal.Transaction))
+}
+
+// Takes the lock that the process which is finishing a journal holds, so that a journal is never finished by two
+// processes at once. Returns false if another process holds the lock.
+func lockJournal(bentoDir string, transaction string) (*os.File, bool, error) {
+	err := os.MkdirAll(path.Join(bentoDir, journalsDirName), 0755)
+	if err != nil {
+		return nil, false, explainWriteError(err)
+	}
+	lock, locked, err := utils.LockFile(journalPath(bentoDir, transaction)+".lock", true, false)
+	return lock, locked, explainWriteError(err)
+}
+
+func removeJournal(bentoDir string, transaction string) {
+	os.Remove(journalPath(bentoDir, transaction))
+	os.Remove(journalPath(bentoDir, transaction) + ".lock")
+}
+
+// Plans the downloads of sources that the user agreed to download. The sources that are fewer dependencies away from
+// `requestedSources` are downloaded first, so that the executable that was asked for can be extracted sooner.
+func planDownloads(r *resolver, requestedSources []string, sourceNames []string, reason string, options installOptions) downloadJournal {
+	journal := downloadJournal{
+		Transaction:           currentTransaction,
+		Reproducible:          options.reproducible,
+		ContentAddressedStore: r.config.ContentAddressedStore,
+		Features:              options.installedFeatures,
+		PreviousFeatures:      options.previousFeatures,
+	}
+	depths := r.dependencyDepths(requestedSources)
+	for _, sourceName := range sourceNames {
+		sourceConf := r.sources[sourceName]
+		source := journaledSource{
+			Name:                 sourceName,
+			Path:                 sourceConf.path,
+			TemporaryPath:        temporarySourcePath(sourceConf.path),
+			State:                sourcePlanned,
+			History:              installHistoryRecord(sourceName, sourceConf, reason),
+			InstallationWarnings: sourceConf.installationWarnings,
+			KnownIssues:          sourceConf.knownIssues,
+			FileChecksums:        sourceConf.fileChecksums,
+			Refresh:              slices.Contains(options.refreshedSources, sourceName),
+			Info:                 newInstalledSourceInfo(sourceConf),
+		}
+		if source.Refresh {
+			source.History.Action = historyRefresh
+			source.History.PreviousVersions = nil
+		}
+		depth, found := depths[sourceName]
+		if !found {
+			depth = len(r.sources)
+		}
+		for _, artifact := range sourceConf.artifacts {
+			name := sourceName
+			if len(sourceConf.artifacts) > 1 {
+				name += " (" + artifact.description + ")"
+			}
+			filter := utils.ExtractionFilter{
+				Include: slices.Concat(artifact.filter.Include, options.extractionFilter.Include),
+				Exclude: slices.Concat(artifact.filter.Exclude, options.extractionFilter.Exclude),
+			}
+			source.Downloads = append(source.Downloads, utils.DownloadOptions{
+				Name:                             name,
+				Urls:                             artifact.parsedUrls,
+				Compression:                      artifact.compression,
+				Checksum:                         artifact.parsedChecksum,
+				UseChecksum:                      true,
+				FilesToMakeExecutable:            artifact.filesToMakeExecutable,
+				RootPath:                         artifact.parsedRootPath,
+				InnerArchives:                    artifact.innerArchives,
+				Filter:                           filter,
+				Destination:                      path.Join(source.TemporaryPath, artifact.subdirectory),
+				DeleteExistingFilesAtDestination: false,
+				QuarantineDir:                    path.Join(r.bentoDir, quarantineDirName),
+				DestinationOwner:                 sourceName,
+				Priority:                         -depth,
+				RefuseCrossSiteRedirects:         artifact.refuseCrossSiteRedirects,
+			})
+		}
+		journal.Sources = append(journal.Sources, source)
+	}
+	return journal
+}
+
+// Downloads and installs the sources in a journal that are not installed yet, and records the progress in the journal
+// as it goes. The journal must be locked, and the installed sources must be locked with a shared lock. Returns false if
+// a download failed, in which case the journal is kept so that the sources can be installed with `bento resume`.
+func finishJournal(bentoDir string, journal *downloadJournal, reportProgress func(progress []string)) bool {
+	var journalMutex sync.Mutex
+	saveJournal := func() {
+		err := writeJournal(bentoDir, *journal)
+		if err != nil {
+			println("Failed to update the journal of the downloads: " + err.Error())
+		}
+	}
+
+	downloads := []utils.DownloadOptions{}
+	for index := range journal.Sources {
+		source := &journal.Sources[index]
+		if source.State == sourceInstalled {
+			continue
+		}
+		if _, err := os.Stat(source.TemporaryPath); os.IsNotExist(err) {
+			if _, err := os.Stat(source.Path); err == nil && !source.Refresh {
+				// Bento was killed after the source was moved into place, but before the journal was updated
+				source.State = sourceInstalled
+				continue
+			}
+			// The temporary path was removed as a stale temporary file, so the source is downloaded again
+			source.State = sourcePlanned
+		}
+		if source.State == sourceDownloaded {
+			continue
+		}
+		// Artifacts that were partially extracted before bento was killed cannot be extracted on top of
+		os.RemoveAll(source.TemporaryPath)
+		artifactsLeft := len(source.Downloads)
+		for _, download := range source.Downloads {
+			download.OnFinished = func() {
+				journalMutex.Lock()
+				defer journalMutex.Unlock()
+				artifactsLeft -= 1
+				if artifactsLeft == 0 {
+					source.State = sourceDownloaded
+					saveJournal()
+				}
+			}
+			downloads = append(downloads, download)
+		}
+	}
+	saveJournal()
+
+	errs := utils.DownloadConcurrently(downloads, maxParrellelDownloads, reportProgress)
+	if len(errs) > 0 {
+		println("Run `bento resume` to try the downloads that failed again, without downloading the sources that were downloaded successfully again.")
+		return false
+	}
+
+	installedSources := []journaledSource{}
+	for index := range journal.Sources {
+		source := &journal.Sources[index]
+		if source.State != sourceDownloaded {
+			continue
+		}
+		err := installDownloadedSource(bentoDir, *source, journal.Reproducible, journal.ContentAddressedStore)
+		if errors.Is(err, os.ErrExist) {
+			// Another bento process installed the same version of the source at the same time
+			os.RemoveAll(source.TemporaryPath)
+		} else if err != nil {
+			utils.Fail(err.Error())
+		} else {
+			recordHistory(source.History)
+			installedSources = append(installedSources, *source)
+		}
+		source.State = sourceInstalled
+		saveJournal()
+	}
+	printInstallationSummary(installedSources)
+	removeJournal(bentoDir, journal.Transaction)
+	return true
+}
+
+// Moves a source that has been extracted into its temporary path to its path. Returns an error that wraps `os.ErrExist`
+// if the source was already installed.
+func installDownloadedSource(bentoDir string, source journaledSource, reproducible bool, contentAddressed bool) error {
+	temporaryPath, sourcePath := source.TemporaryPath, source.Path
+	err := utils.VerifyFileChecksums(temporaryPath, source.FileChecksums)
+	if err != nil {
+		os.RemoveAll(temporaryPath)
+		return utils.WrapError("The files of `"+source.Name+"` do not match the checksums in its source config, so it was not installed. This can mean that its archive was built from compromised files", err)
+	}
+	if systemInstall {
+		// Sources are sometimes extracted into a directory from `os.MkdirTemp`, which only its owner can read
+		err := os.Chmod(temporaryPath, 0755)
+		if err != nil {
+			return utils.WrapError("Failed to let every user read `"+temporaryPath+"`", err)
+		}
+	}
+	if reproducible {
+		// This is done after every artifact of a source is extracted, because extracting an artifact can change the
+		// modification times of directories that other artifacts are extracted into
+		err := utils.NormalizeTree(temporaryPath)
+		if err != nil {
+			return utils.WrapError("Failed to normalize the files in `"+temporaryPath+"`", err)
+		}
+	}
+	if contentAddressed {
+		// Files that are shared between versions of the source are shared through the store too
+		addSourceToContentStore(bentoDir, temporaryPath, sourcePath)
+	} else {
+		deduplicateSourceVersions(temporaryPath, sourcePath)
+	}
+	err = writeManifest(temporaryPath, sourcePath)
+	if err != nil {
+		println("Failed to record the files in `" + sourcePath + "`: " + err.Error())
+	}
+	err = writeInstalledSourceInfo(sourcePath, source.Info)
+	if err != nil {
+		println("Failed to record the licenses and dependencies of `" + sourcePath + "`: " + err.Error())
+	}
+	err = utils.SyncExtractedTree(temporaryPath)
+	if err != nil {
+		return utils.WrapError("Failed to sync `"+temporaryPath+"` to disk", err)
+	}
+	if source.Refresh {
+		err = replaceInstalledSource(temporaryPath, sourcePath)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = os.Rename(temporaryPath, sourcePath)
+		if err != nil {
+			if _, statErr := os.Stat(sourcePath); statErr == nil {
+				return os.ErrExist
+			}
+			return utils.WrapError("Failed to move `"+temporaryPath+"` to `"+sourcePath+"`", err)
+		}
+	}
+	// The rename is only on disk once the directory that it is in is synced
+	return utils.SyncDirectory(path.Dir(sourcePath))
+}
+
+// Replaces the installed tree of a source that is being refreshed with the tree in its temporary path. The installed
+// tree is moved aside first, and moved back if the new tree cannot be moved into place, so that the source is only
+// missing for as long as it takes to rename a directory.
+func replaceInstalledSource(temporaryPath string, sourcePath string) error {
+	// The name contains `.tmp-` so that it is removed as a stale temporary file if bento is killed before removing it
+	replacedPath := path.Join(path.Dir(sourcePath), "."+path.Base(sourcePath)+".tmp-replaced-"+strconv.Itoa(os.Getpid()))
+	err := os.Rename(sourcePath, replacedPath)
+	if err != nil && !os.IsNotExist(err) {
+		return utils.WrapError("Failed to move `"+sourcePath+"` out of the way to replace it", err)
+	}
+	err = os.Rename(temporaryPath, sourcePath)
+	if err != nil {
+		os.Rename(replacedPath, sourcePath)
+		return utils.WrapError("Failed to move `"+temporaryPath+"` to `"+sourcePath+"`", err)
+	}
+	err = os.RemoveAll(replacedPath)
+	if err != nil {
+		println("Failed to remove the replaced files in `" + replacedPath + "`: " + err.Error())
+	}
+	return nil
+}
+
+// Finishes the downloads of bento processes that were killed, or that failed to download some sources
+func resumeJournals(bentoDir string) {
+	entries, err := os.ReadDir(path.Join(bentoDir, journalsDirName))
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the journals of the downloads: " + err.Error())
+	}
+	resumed := 0
+	for _, entry := range entries {
+		transaction, isJournal := strings.CutSuffix(entry.Name(), ".toml")
+		if !isJournal {
+			continue
+		}
+		journalLock, locked, err := lockJournal(bentoDir, transaction)
+		if err != nil {
+			utils.Fail("Failed to lock the journal of transaction " + transaction + ": " + err.Error())
+		}
+		if !locked {
+			println("Transaction " + transaction + " is still being downloaded by another bento process")
+			continue
+		}
+		var journal downloadJournal
+		_, err = toml.DecodeFile(journalPath(bentoDir, transaction), &journal)
+		if err != nil {
+			utils.Fail("Failed to read the journal of transaction " + transaction + ": " + err.Error())
+		}
+		// The records of the resumed downloads are part of the transaction that planned them, so that they can be
+		// rolled back together
+		currentTransaction = journal.Transaction
+		println("Resuming transaction " + transaction)
+		lock, _, err := lockInstalledSources(bentoDir, false, true)
+		if err != nil {
+			utils.Fail("Failed to lock the installed sources: " + err.Error())
+		}
+		if !finishJournal(bentoDir, &journal, nil) {
+			os.Exit(1)
+		}
+		lock.Close()
+		if journal.Features != nil {
+			saveInstalledFeatures(bentoDir, journal.PreviousFeatures, journal.Features)
+		}
+		journalLock.Close()
+		resumed += 1
+	}
+	if resumed == 0 {
+		println("There are no downloads to resume")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// Collects the problems that are found in a package repository
+type repositoryLinter struct {
+	repositoryDir string
+	sourceNames   []string
+	libraryNames  []string
+	problems      []string
+}
+
+func (l *repositoryLinter) report(problem string) {
+	l.problems = append(l.problems, problem)
+}
+
+func (l *repositoryLinter) checkLibraryExists(referencedBy string, libraryName string) {
+	if !slices.Contains(l.libraryNames, libraryName) {
+		l.report(referencedBy + " depends on the library `" + libraryName + "`, but there is no `lib/" + libraryName + ".toml`")
+	}
+}
+
+func (l *repositoryLinter) checkSourceExists(referencedBy string, sourceName string) {
+	if !slices.Contains(l.sourceNames, sourceName) {
+		l.report(referencedBy + " refers to the source `" + sourceName + "`, but there is no `sources/" + sourceName + ".toml`")
+	}
+}
+
+func (l *repositoryLinter) lintSource(sourceName string) {
+	r := newResolver(l.repositoryDir, map[string]string{}, map[string][]string{}, userConfig{})
+	// The overrides of the user are not part of the package repository, and files that are missing from it are errors
+	r.sourceOverridesDir = ""
+	r.onlyLocalFiles = true
+	unparsedSourceConf, err := r.decodeSourceConfig(path.Join(r.sourcesDir, sourceName+".toml"))
+	if err != nil {
+		l.report("Failed to decode the source `" + sourceName + "`: " + err.Error())
+		return
+	}
+	if len(unparsedSourceConf.Members) > 0 {
+		if _, _, err := r.loadBundleMembers(sourceName); err != nil {
+			l.report(err.Error())
+		}
+		for _, member := range unparsedSourceConf.Members {
+			l.checkSourceExists("The bundle `"+sourceName+"`", member)
+		}
+		return
+	}
+
+	// Every architecture that the source supports has a different URL, and so needs a different checksum
+	architectures := slices.Sorted(maps.Keys(unparsedSourceConf.ArchitectureNames))
+	if len(architectures) == 0 {
+		architectures = []string{runtime.GOARCH}
+	}
+	var sourceConf parsedSourceConfig
+	loaded := false
+	for _, architecture := range architectures {
+		r := newResolver(l.repositoryDir, map[string]string{}, map[string][]string{}, userConfig{})
+		r.architecture = architecture
+		r.sourceOverridesDir = ""
+		r.onlyLocalFiles = true
+		sourceConfForArchitecture, err := r.loadSource(sourceName)
+		if err != nil {
+			l.report("For the architecture `" + architecture + "`: " + err.Error())
+			continue
+		}
+		sourceConf = sourceConfForArchitecture
+		loaded = true
+	}
+	if !loaded {
+		return
+	}
+
+	sourceDescription := "The source `" + sourceName + "`"
+	for executable, libraries := range sourceConf.directSharedLibraryDependencies {
+		for _, library := range libraries {
+			l.checkLibraryExists(sourceDescription+" (for `"+executable+"`)", library)
+		}
+	}
+	for _, executable := range sourceConf.executableDependencies {
+		l.checkSourceExists(sourceDescription, executable[0])
+	}
+	if len(sourceConf.interpreter) > 0 {
+		l.checkSourceExists(sourceDescription+" (in `Interpreter`)", sourceConf.interpreter[0])
+	}
+	if sourceConf.deprecatedInFavorOf != "" {
+		l.checkSourceExists(sourceDescription+" (in `DeprecatedInFavorOf`)", sourceConf.deprecatedInFavorOf)
+	}
+	for featureName, feature := range sourceConf.features {
+		featureDescription := "The feature `" + featureName + "` of the source `" + sourceName + "`"
+		for _, libraries := range feature.DirectSharedLibraryDependencies {
+			for _, library := range libraries {
+				l.checkLibraryExists(featureDescription, library)
+			}
+		}
+		for _, executable := range feature.ExecutableDependencies {
+			l.checkSourceExists(featureDescription, executable[0])
+		}
+	}
+	for executable, environment := range sourceConf.env {
+		for name, value := range environment {
+			_, err := utils.InterpolateStringLiteral(value, func(interpolation string) (string, error) {
+				if _, isEnvironmentVariable := utils.TrimPrefix(interpolation, "env."); isEnvironmentVariable || interpolation == "data" {
+					return "", nil
+				}
+				if !slices.Contains(l.sourceNames, interpolation) {
+					return "", errors.New("There is no source called `" + interpolation + "`")
+				}
+				return "", nil
+			})
+			if err != nil {
+				l.report(sourceDescription + " has an invalid value for `" + name + "` in the environment of `" + executable + "`: " + err.Error())
+			}
+		}
+	}
+}
+
+func (l *repositoryLinter) lintLibrary(libraryName string) {
+	library, err := decodeTomlFile[unparsedLibrary](nil, path.Join(l.repositoryDir, "lib", libraryName+".toml"))
+	if err != nil {
+		l.report("Failed to decode the library `" + libraryName + "`: " + err.Error())
+		return
+	}
+	libraryDescription := "The library `" + libraryName + "`"
+	if library.Source == "" {
+		l.report(libraryDescription + " does not specify a `Source`")
+	} else if library.Source != "system" {
+		l.checkSourceExists(libraryDescription, library.Source)
+	}
+	for _, dependency := range library.DirectSharedLibraryDependencies {
+		l.checkLibraryExists(libraryDescription, dependency)
+	}
+}
+
+// Checks every source and library in a package repository, and returns false if there are any problems. This is meant
+// to be run in the CI of a package repository.
+func lintRepository(repositoryDir string) bool {
+	sourceNames, err := listTomlNames(path.Join(repositoryDir, "sources"))
+	if err != nil {
+		utils.Fail("Failed to read the sources in `" + repositoryDir + "`: " + err.Error())
+	}
+	libraryNames, err := listTomlNames(path.Join(repositoryDir, "lib"))
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the libraries in `" + repositoryDir + "`: " + err.Error())
+	}
+	l := repositoryLinter{repositoryDir: repositoryDir, sourceNames: sourceNames, libraryNames: libraryNames}
+	for _, sourceName := range sourceNames {
+		l.lintSource(sourceName)
+	}
+	for _, libraryName := range libraryNames {
+		l.lintLibrary(libraryName)
+	}
+	for _, problem := range l.problems {
+		println(utils.AnsiFgRed + "- " + utils.AnsiReset + problem)
+	}
+	println("Checked " + utils.CreateNoun(len(sourceNames), "1 source", "sources") + " and " + utils.CreateNoun(len(libraryNames), "1 library", "libraries") + ", and found " + utils.CreateNoun(len(l.problems), "1 problem", "problems"))
+	return len(l.problems) == 0
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The libraries of the C runtime, which every Linux system provides, so they are expected to be loaded from the system
+// even when the package repository does not say so
+var cRuntimeSonamePrefixes = []string{"ld-linux", "libc.so", "libm.so", "libmvec.so", "libpthread.so", "libdl.so", "librt.so", "libutil.so", "libresolv.so", "libanl.so", "libgcc_s.so"}
+
+// Returns the sonames of the libraries that the package repository expects the system to provide
+func expectedSystemSonames(librariesDir string) map[string]bool {
+	sonames := map[string]bool{}
+	entries, _ := os.ReadDir(librariesDir)
+	for _, entry := range entries {
+		libraryName, isToml := strings.CutSuffix(entry.Name(), ".toml")
+		if !isToml {
+			continue
+		}
+		var library unparsedLibrary
+		_, err := toml.DecodeFile(path.Join(librariesDir, entry.Name()), &library)
+		if err != nil || library.Source != "system" {
+			continue
+		}
+		if library.Soname == "" {
+			library.Soname = libraryName
+		}
+		sonames[library.Soname] = true
+	}
+	return sonames
+}
+
+// Returns the ELF file that the kernel runs for a command, by following the shebangs of scripts
+func commandElfFile(command []string, environment map[string]string) (string, error) {
+	file := command[0]
+	for range 4 {
+		interpreter, err := utils.ScriptInterpreter(file)
+		if err != nil {
+			return file, nil
+		}
+		file = interpreter[0]
+		if path.Base(file) != "env" {
+			continue
+		}
+		// `/usr/bin/env` runs the first of its arguments that is not an option or a variable from the `PATH`
+		program := ""
+		for _, arg := range interpreter[1:] {
+			if !strings.HasPrefix(arg, "-") && !strings.Contains(arg, "=") {
+				program = arg
+				break
+			}
+		}
+		found := false
+		for _, directory := range strings.Split(environment["PATH"], ":") {
+			if info, err := os.Stat(path.Join(directory, program)); program != "" && err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+				file, found = path.Join(directory, program), true
+				break
+			}
+		}
+		if !found {
+			return "", errors.New("The script `" + command[0] + "` is run by `" + strings.Join(interpreter, " ") + "`, but `" + program + "` is not in the `PATH` that it is run with")
+		}
+	}
+	return file, nil
+}
+
+// Returns where a library is loaded from, for the user to read
+func describeLibraryOrigin(r *resolver, libraryPath string) string {
+	for _, library := range r.libraries {
+		if path.Dir(libraryPath) == library.absoluteDirectory {
+			return "from the source `" + library.source + "`"
+		}
+	}
+	if relativePath, inBentoDir := utils.TrimPrefix(libraryPath, r.installedSourcesDir+"/"); inBentoDir {
+		return "from the source `" + strings.Split(relativePath, "/")[0] + "`"
+	}
+	return "from the system"
+}
+
+// Prints the file that the dynamic linker loads for every shared library that a resolved command needs, when it is
+// run with the library paths from bento, and flags the libraries that are loaded from the system even though the
+// package repository does not expect the system to provide them. Returns false if a library cannot be found.
+func printLoadedLibraries(r *resolver, command []string, environment map[string]string) bool {
+	executable, err := commandElfFile(command, environment)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	libraries, interpreter, err := utils.ResolveSharedLibraries(executable, r.libraryPaths())
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	if executable != command[0] {
+		println("`" + command[0] + "` is a script that is run by `" + executable + "`")
+	}
+	if interpreter == "" {
+		println("`" + executable + "` is statically linked")
+		return true
+	}
+	println("`" + executable + "` is loaded by the dynamic linker `" + interpreter + "`")
+	expectedSonames := expectedSystemSonames(r.librariesDir)
+	rows := [][]string{}
+	missing := 0
+	unexpected := 0
+	for _, library := range libraries {
+		if library.Path == "" {
+			missing += 1
+			rows = append(rows, []string{library.Soname, "=>", utils.AnsiFgRed + "not found" + utils.AnsiReset, "needed by `" + library.NeededBy + "`"})
+			continue
+		}
+		origin := describeLibraryOrigin(r, library.Path)
+		isExpected := expectedSonames[library.Soname] || slices.ContainsFunc(cRuntimeSonamePrefixes, func(prefix string) bool {
+			return strings.HasPrefix(library.Soname, prefix)
+		})
+		if library.FromSystem && !isExpected {
+			unexpected += 1
+			origin = utils.AnsiAttention + "from the system, but bento does not expect the system to provide it" + utils.AnsiReset
+		}
+		rows = append(rows, []string{library.Soname, "=>", library.Path, origin})
+	}
+	for _, line := range utils.AlignColumns(rows) {
+		println("  " + line)
+	}
+	if unexpected > 0 {
+		println(utils.AnsiAttention + utils.CreateNoun(unexpected, "A library is", "libraries are") + " loaded from the system instead of from a source, so the executable may behave differently on other systems" + utils.AnsiReset)
+	}
+	if missing > 0 {
+		println(utils.AnsiFgRed + utils.CreateNoun(missing, "A library", "libraries") + " could not be found, so the executable will fail to start" + utils.AnsiReset)
+		return false
+	}
+	return true
+}
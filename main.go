@@ -1,26 +1,38 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"maps"
 	"os"
+	osExec "os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/godalming123/bento/utils"
 )
 
 type unparsedSourceConfig struct {
+	// The name of a template in the `templates` directory of the package repository that the source inherits fields
+	// from. Tables are merged with the tables of the template, and every other field replaces the field of the
+	// template. Templates can extend other templates.
+	Extends                         string
 	UrlInMirror                     string
+	OciBlob                         string
 	Mirrors                         []string
 	Compression                     string
 	Checksums                       map[string]string
+	Sizes                           map[string]int64
 	FilesToMakeExecutable           []string
 	RootPath                        string
 	Version                         map[string]string
@@ -34,82 +46,323 @@ type unparsedSourceConfig struct {
 	ExecutableDependencies          [][2]string
 	InstallationWarnings            []string
 	KnownIssues                     []string
+	Artifacts                       []unparsedArtifact
+	Features                        map[string]sourceFeature
+	// The program that the executables of the source are run with, like `wine` for windows executables. This is
+	// either the name of a source that has the executable `bin/NAME`, `SOURCE/EXECUTABLE`, or the name of an
+	// executable in the `PATH` of the host system if there is no source with that name.
+	Runner string
+	// The executable from another source that the executables of the source are passed to, followed by the arguments
+	// that come before them, like `["openjdk", "bin/java", "-jar"]` for a source that is a jar. Unlike `Runner`, the
+	// interpreter is always a source, so it is downloaded like any other dependency.
+	Interpreter []string
+	// Maps the paths of executables in the source to the limits on the resources that they can use, like the number of
+	// files that they can have open. The limits in the bento config of the user replace these.
+	ResourceLimits map[string]utils.ResourceLimits
+	// Maps absolute paths that the executables of the source expect files to be at, like `/usr/share/NAME` for data
+	// that is hard-coded into a binary, to paths in the source that are mounted there when the executables are run. The
+	// mounts are made in a mount namespace that only the executable sees, which needs unprivileged user namespaces.
+	FhsPaths map[string]string
+	// Whether the executables of the source need network access to work, which is shown before the source is
+	// downloaded, and is used to warn when the source is run with `bento exec --no-network`
+	NeedsNetwork bool
+	// Glob patterns of the paths in the main download of the source, relative to its root path, that are extracted,
+	// and that are not extracted. These can be used to skip documentation, tests, and source code.
+	Include []string
+	Exclude []string
+	// Archives inside the main download of the source that contain its files, which work in the same way as the
+	// `InnerArchives` of an artifact
+	InnerArchives []utils.InnerArchive
+	// Maps mirrors to the region that they are in, like `eu` or `us`, so that users can prefer the mirrors that are
+	// near them. This is used for the mirrors of the artifacts too.
+	MirrorRegions map[string]string
+	// The name of the source that replaces this source, which users are told to install instead
+	DeprecatedInFavorOf string
+	// The oldest version of bento that understands the source config, which is used when a source config uses a
+	// feature that older versions of bento would ignore or misunderstand
+	MinimumBentoVersion string
+	// Maps the paths of important files in the source, like its main executable, to their sha256 checksums. These are
+	// checked after the source is extracted, so that a compromised archive is caught even if its own checksum was
+	// updated to match it.
+	FileChecksums map[string]string
+	// The path in the mirrors of a checksum file that is published next to `UrlInMirror`, like
+	// `tool-${version.number}.tar.gz.sha256` or `SHA256SUMS`, which works in the same way as the `ChecksumUrlInMirror`
+	// of an artifact
+	ChecksumUrlInMirror string
+	// The sources that are installed when this source is installed, which makes this source a bundle, like a toolchain
+	// that is made of a compiler, a build tool, and a language server. A bundle does not have any artifacts, so it
+	// cannot be run.
+	Members []string
+	// How architectures that are not in `ArchitectureNames` are named, which is either `go` for the names that Go uses,
+	// like `amd64`, or `uname` for the names that `uname -m` prints, like `x86_64`. Defaults to `go`.
+	ArchitectureNaming string
+	// The domains that the executables of the source are expected to contact, like `api.example.com`, including their
+	// subdomains. They are shown by `bento info` and before the source is downloaded, so that users know what a source
+	// phones home to, and `bento exec --only-declared-domains` stops the executables from contacting other domains.
+	ContactedDomains []string
+}
+
+// A set of optional dependencies that a user can choose to install with a source
+type sourceFeature struct {
+	Description                     string
+	DirectSharedLibraryDependencies map[string][]string
+	ExecutableDependencies          [][2]string
+}
+
+// An extra download that is extracted into the same tree as the main download of a source
+type unparsedArtifact struct {
+	UrlInMirror string
+	// A blob in an OCI registry, like `ghcr.io/OWNER/REPOSITORY@sha256:DIGEST`, which is downloaded with the registry
+	// API instead of from the mirrors. The digest is the checksum of the blob, so it is not in `Checksums`.
+	OciBlob               string
+	Mirrors               []string
+	Compression           string
+	Checksums             map[string]string
+	Sizes                 map[string]int64
+	FilesToMakeExecutable []string
+	RootPath              string
+	Subdirectory          string
+	Include               []string
+	Exclude               []string
+	// Archives inside the download that contain the files of the source, in the order that they are nested, like
+	// `[{ Path = "package.tar.gz", Compression = ".tar.gz" }]` for a zip that contains a tarball. `RootPath`, `Include`,
+	// and `Exclude` apply to the innermost archive.
+	InnerArchives []utils.InnerArchive
+	// The path in the mirrors of a checksum file that is published next to `UrlInMirror`, like a `.sha256` file or a
+	// `SHA256SUMS` file, which is used when `Checksums` has no checksum for it. It is fetched over HTTPS from the same
+	// mirrors when the source is installed. This is meant for nightly builds that change too often to keep their
+	// checksums in the source config, and users can forbid it with `ForbidChecksumUrls` in their bento config.
+	ChecksumUrlInMirror string
+}
+
+type parsedArtifact struct {
+	description           string
+	compression           string
+	filesToMakeExecutable []string
+	parsedUrls            []string
+	parsedChecksum        [32]byte
+	parsedRootPath        string
+	subdirectory          string
+	filter                utils.ExtractionFilter
+	innerArchives         []utils.InnerArchive
+	// The artifact is a blob in an OCI registry, rather than a file in mirrors
+	isOciBlob bool
+	// The URLs of the checksum file that the checksum is fetched from when the source is installed, which are only set
+	// until it is fetched
+	checksumUrls []string
+	// The size of the download in bytes, which is 0 if the source config does not declare it
+	size int64
+	// Mirrors that redirect to a host on another site are not used, since the policy of the project requires these
+	// redirects to be confirmed
+	refuseCrossSiteRedirects bool
 }
 
 type parsedSourceConfig struct {
-	compression                     string
-	filesToMakeExecutable           []string
 	env                             map[string]map[string]string
 	directSharedLibraryDependencies map[string][]string
 	executableDependencies          [][2]string
 	installationWarnings            []string
+	features                        map[string]sourceFeature
+	runner                          string
+	interpreter                     []string
+	resourceLimits                  map[string]utils.ResourceLimits
+	fhsPaths                        map[string]string
+	homepage                        string
+	knownIssues                     []string
+	needsNetwork                    bool
+	deprecatedInFavorOf             string
+	fileChecksums                   map[string]string
 
 	licenseDescription string
+	licenses           []string
 	interpolationFunc  func(string) (string, error)
+	version            string
 	path               string
-	parsedUrls         []string
-	parsedChecksum     [32]byte
-	parsedRootPath     string
+	artifacts          []parsedArtifact
+	contactedDomains   []string
 }
 
 type unparsedLibrary struct {
 	Source                          string
 	Directory                       string
 	DirectSharedLibraryDependencies []string
+	// Only used when `Source` is `system`. The soname defaults to the name of the library, and the distro packages map
+	// an `ID` from `/etc/os-release` to the name of the package that provides the library on that distro.
+	Soname         string
+	DistroPackages map[string]string
 }
 
 type parsedLibrary struct {
 	absoluteDirectory string
+	source            string
 }
 
 type sourceLoadingError struct {
 	sourceName string
 	message    string
+	// The error that caused the source to fail to load, which is nil if the source config itself is invalid
+	err error
 }
 
 func (e *sourceLoadingError) Error() string {
 	return "Failed to load source `" + e.sourceName + "`: " + e.message
 }
 
-func loadSource(sourcesDirPath string, downloadedSourcesDirPath string, loadedSources map[string]parsedSourceConfig, nameOfSourceToLoad string) (parsedSourceConfig, error) {
-	parsedSourceConf, sourceLoaded := loadedSources[nameOfSourceToLoad]
+func (e *sourceLoadingError) Unwrap() error {
+	return e.err
+}
+
+// Caches decoded TOML files, so that a long running process like the daemon does not decode files that have not changed
+// since they were last decoded
+type tomlCache struct {
+	entries map[string]tomlCacheEntry
+}
+
+type tomlCacheEntry struct {
+	modTime time.Time
+	size    int64
+	value   any
+}
+
+func decodeTomlFile[T any](cache *tomlCache, filePath string) (T, error) {
+	var value T
+	if cache == nil {
+		_, err := toml.DecodeFile(filePath, &value)
+		return value, err
+	}
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return value, err
+	}
+	if entry, ok := cache.entries[filePath]; ok && entry.modTime.Equal(fileInfo.ModTime()) && entry.size == fileInfo.Size() {
+		if cachedValue, ok := entry.value.(T); ok {
+			return cachedValue, nil
+		}
+	}
+	_, err = toml.DecodeFile(filePath, &value)
+	if err != nil {
+		return value, err
+	}
+	cache.entries[filePath] = tomlCacheEntry{modTime: fileInfo.ModTime(), size: fileInfo.Size(), value: value}
+	return value, nil
+}
+
+// Stores the sources, libraries, and executables that have been loaded while working out what an executable needs to run
+type resolver struct {
+	bentoDir            string
+	sourcesDir          string
+	installedSourcesDir string
+	librariesDir        string
+
+	sources          map[string]parsedSourceConfig
+	libraries        map[string]parsedLibrary
+	executables      map[string]string
+	environment      map[string]string
+	selectedFeatures map[string][]string
+	config           userConfig
+	trace            *utils.Tracer
+	tomlCache        *tomlCache
+	warnings         []string
+
+	systemLibraryDirectories []string
+	// The sources that each source depends on, and why
+	dependencies map[string][]sourceDependency
+	// The architecture that sources are downloaded for, using the names that `runtime.GOARCH` uses
+	architecture string
+	// The operating system that sources are downloaded for, using the names that `runtime.GOOS` uses
+	operatingSystem string
+	// The environment of the host system, which `${env.NAME}` interpolations read from
+	hostEnvironment map[string]string
+	// The directory that the user can put patches for broken source configs in, which is empty if patches are not used
+	sourceOverridesDir string
+	// Shows how many of the sources that have been found so far are loaded, which is nil when progress is not shown
+	progress *utils.StatusLine
+	// Every source that has been asked for, or that a loaded source depends on
+	discoveredSources map[string]bool
+	// The features in `selectedFeatures` that were asked for explicitly, rather than read from the installed features.
+	// A source that does not have one of them fails to load, while installed features that a source no longer has are
+	// ignored with a warning, and removed from `selectedFeatures`.
+	requestedFeatures map[string][]string
+	// Only read the files of the package repository that are already in the bento directory, without fetching missing
+	// files or copying them from the fallback repository, so that `bento lint-repo` only checks a checkout as it is
+	onlyLocalFiles bool
+}
+
+func newResolver(bentoDir string, environment map[string]string, selectedFeatures map[string][]string, config userConfig) *resolver {
+	return &resolver{
+		bentoDir:            bentoDir,
+		sourcesDir:          path.Join(bentoDir, "sources"),
+		installedSourcesDir: path.Join(bentoDir, installedSourcesDirName),
+		librariesDir:        path.Join(bentoDir, "lib"),
+		sources:             map[string]parsedSourceConfig{},
+		libraries:           map[string]parsedLibrary{},
+		executables:         map[string]string{},
+		environment:         environment,
+		hostEnvironment:     environmentToMap(os.Environ()),
+		sourceOverridesDir:  path.Join(getUserConfigDir(), "overrides"),
+		architecture:        runtime.GOARCH,
+		operatingSystem:     runtime.GOOS,
+		dependencies:        map[string][]sourceDependency{},
+		discoveredSources:   map[string]bool{},
+		selectedFeatures:    selectedFeatures,
+		config:              config,
+		trace:               utils.NewTracer(false),
+	}
+}
+
+const unknownLicenseDescription = "with an unknown license"
+
+func (r *resolver) loadSource(nameOfSourceToLoad string) (parsedSourceConfig, error) {
+	parsedSourceConf, sourceLoaded := r.sources[nameOfSourceToLoad]
 	if sourceLoaded {
+		r.trace.Log("Source `" + nameOfSourceToLoad + "` is already loaded")
 		return parsedSourceConf, nil
 	}
 
-	contents, err := os.ReadFile(path.Join(sourcesDirPath, nameOfSourceToLoad+".toml"))
+	r.discoveredSources[nameOfSourceToLoad] = true
+	r.reportProgress()
+	sourceConfPath := path.Join(r.sourcesDir, nameOfSourceToLoad+".toml")
+	err := r.fetchRepositoryFileIfMissing(path.Join("sources", nameOfSourceToLoad+".toml"))
 	if err != nil {
-		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, err.Error()}
+		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, err.Error(), err}
 	}
-	var unparsedSourceConf unparsedSourceConfig
-	_, err = toml.Decode(string(contents), &unparsedSourceConf)
+	r.trace.Log("Loading source `" + nameOfSourceToLoad + "` from " + sourceConfPath)
+	unparsedSourceConf, err := r.decodeSourceConfig(sourceConfPath)
 	if err != nil {
-		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, err.Error()}
+		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, err.Error(), err}
+	}
+	if message, ok := checkMinimumBentoVersion(unparsedSourceConf.MinimumBentoVersion); !ok {
+		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, message, nil}
+	}
+	if len(unparsedSourceConf.Members) > 0 {
+		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "The source is a bundle of " + strings.Join(unparsedSourceConf.Members, ", ") + ", so it cannot be used on its own. Install it with `bento install " + nameOfSourceToLoad + "`, or use one of its members instead.", nil}
 	}
 
 	licenseDescription := ""
 	switch len(unparsedSourceConf.Licenses) {
 	case 0:
-		licenseDescription = "with an unknown license"
+		licenseDescription = unknownLicenseDescription
 	case 1:
 		licenseDescription = "licensed under " + unparsedSourceConf.Licenses[0]
 	default:
 		licenseDescription = "licensed under "
-		slices.Sort(unparsedSourceConf.Licenses)
+		utils.SortNames(unparsedSourceConf.Licenses)
 		for _, license := range unparsedSourceConf.Licenses[0 : len(unparsedSourceConf.Licenses)-2] {
 			licenseDescription += license + ", "
 		}
 		licenseDescription += "and " + unparsedSourceConf.Licenses[len(unparsedSourceConf.Licenses)-1]
 	}
 
-	architecture, ok := unparsedSourceConf.ArchitectureNames[runtime.GOARCH]
-	if !ok {
-		architecture = runtime.GOARCH
+	architecture, _, err := resolveArchitectureName(unparsedSourceConf, r.architecture)
+	if err != nil {
+		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, err.Error(), nil}
 	}
 
 	interpolationFunc := func(s string) (string, error) {
 		if s == "architecture" {
 			return architecture, nil
+		} else if s == "os" {
+			return r.operatingSystem, nil
 		} else if trimmedStr, didTrim := utils.TrimPrefix(s, "version."); didTrim {
 			version, ok := unparsedSourceConf.Version[trimmedStr]
 			if !ok {
@@ -117,312 +370,1990 @@ func loadSource(sourcesDirPath string, downloadedSourcesDirPath string, loadedSo
 			}
 			return version, nil
 		}
-		return "", &sourceLoadingError{nameOfSourceToLoad, "Expected either `architecture`, or `version.` followed by a key in the `version` value. Got " + s}
+		return "", &sourceLoadingError{nameOfSourceToLoad, "Expected either `architecture`, `os`, or `version.` followed by a key in the `version` value. Got " + s, nil}
 	}
 
-	urlInMirror, err := utils.InterpolateStringLiteral(unparsedSourceConf.UrlInMirror, interpolationFunc)
-	if err != nil {
-		return parsedSourceConfig{}, err
+	artifacts := make([]parsedArtifact, 0, len(unparsedSourceConf.Artifacts)+1)
+	if unparsedSourceConf.UrlInMirror != "" || unparsedSourceConf.OciBlob != "" {
+		artifact, err := r.parseArtifact(nameOfSourceToLoad, unparsedArtifact{
+			UrlInMirror:           unparsedSourceConf.UrlInMirror,
+			ChecksumUrlInMirror:   unparsedSourceConf.ChecksumUrlInMirror,
+			OciBlob:               unparsedSourceConf.OciBlob,
+			Compression:           unparsedSourceConf.Compression,
+			FilesToMakeExecutable: unparsedSourceConf.FilesToMakeExecutable,
+			RootPath:              unparsedSourceConf.RootPath,
+			Include:               unparsedSourceConf.Include,
+			Exclude:               unparsedSourceConf.Exclude,
+			InnerArchives:         unparsedSourceConf.InnerArchives,
+		}, unparsedSourceConf, interpolationFunc)
+		if err != nil {
+			return parsedSourceConfig{}, err
+		}
+		artifacts = append(artifacts, artifact)
+	} else if len(unparsedSourceConf.FilesToMakeExecutable) > 0 {
+		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "`FilesToMakeExecutable` can only be used when `UrlInMirror` or `OciBlob` is specified. Use `FilesToMakeExecutable` in each of the artifacts instead.", nil}
 	}
-
-	// Ideally checksum parsing would use https://github.com/BurntSushi/toml/issues/448
-	checksumString, exists := unparsedSourceConf.Checksums[urlInMirror]
-	if !exists {
-		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "The checksum for " + urlInMirror + " is not specified. Bento requires checksums to be specified."}
+	for _, unparsedArtifactConf := range unparsedSourceConf.Artifacts {
+		artifact, err := r.parseArtifact(nameOfSourceToLoad, unparsedArtifactConf, unparsedSourceConf, interpolationFunc)
+		if err != nil {
+			return parsedSourceConfig{}, err
+		}
+		artifacts = append(artifacts, artifact)
 	}
-	if len(checksumString) != 64 {
-		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "Expected checksum to be 64 characters, but it is " + fmt.Sprint(len(checksumString)) + " characters"}
+	if len(artifacts) == 0 {
+		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "Expected either `UrlInMirror`, `OciBlob`, or `Artifacts` to be specified", nil}
 	}
-	checksumSlice, err := hex.DecodeString(checksumString)
-	if err != nil {
-		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "Failed to decode checksum: " + err.Error()}
+	if len(unparsedSourceConf.Interpreter) == 1 {
+		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "Expected `Interpreter` to be like `[\"SOURCE\", \"EXECUTABLE\", \"ARGUMENTS...\"]`, but it only has `" + unparsedSourceConf.Interpreter[0] + "`", nil}
 	}
-	if len(checksumSlice) != 32 {
-		panic("Unexpected internal state: len(parsedChecksumSlice) = " + fmt.Sprint(len(checksumSlice)))
+	if len(unparsedSourceConf.Interpreter) > 0 && unparsedSourceConf.Runner != "" {
+		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "A source cannot have both `Runner` and `Interpreter`", nil}
 	}
-	var checksum [32]byte
-	copy(checksum[:], checksumSlice)
-
-	rootPath, err := utils.InterpolateStringLiteral(unparsedSourceConf.RootPath, interpolationFunc)
-	if err != nil {
-		return parsedSourceConfig{}, err
+	for executable, limits := range unparsedSourceConf.ResourceLimits {
+		if err := limits.Validate(); err != nil {
+			return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "Invalid resource limits for `" + executable + "`: " + err.Error(), nil}
+		}
+	}
+	for target, sourcePath := range unparsedSourceConf.FhsPaths {
+		if !path.IsAbs(target) || path.Clean(target) == "/" {
+			return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "Expected the paths in `FhsPaths` to be absolute paths other than `/`, but got `" + target + "`", nil}
+		}
+		if err := utils.ValidateRelativePath(sourcePath); err != nil {
+			return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "Invalid path in `FhsPaths` for `" + target + "`: " + err.Error(), nil}
+		}
+	}
+	for _, domain := range unparsedSourceConf.ContactedDomains {
+		if err := utils.ValidateDomain(domain); err != nil {
+			return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "Invalid domain in `ContactedDomains`: " + err.Error(), nil}
+		}
+	}
+	for filePath, checksum := range unparsedSourceConf.FileChecksums {
+		if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != 32 {
+			return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "Expected the checksum of `" + filePath + "` in `FileChecksums` to be 64 hexadecimal characters, but got `" + checksum + "`", nil}
+		}
 	}
 
-	urls := make([]string, len(unparsedSourceConf.Mirrors))
-	for i, mirror := range unparsedSourceConf.Mirrors {
-		urls[i] = mirror + "/" + urlInMirror
+	// Each version is installed to a different directory, so that multiple versions can be installed at once. The
+	// version ends with a short hash of the checksums of the artifacts, so that a source config that fixes a checksum
+	// without changing `Version` is downloaded again, instead of the old tree being kept.
+	versionParts := []string{}
+	for _, key := range slices.Sorted(maps.Keys(unparsedSourceConf.Version)) {
+		versionParts = append(versionParts, unparsedSourceConf.Version[key])
+	}
+	version := strings.ReplaceAll(strings.Join(versionParts, "-"), "/", "_")
+	if version == "" && len(artifacts[0].checksumUrls) > 0 {
+		return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "Expected `Version` to be specified, since the checksum of the source is only known once it is installed", nil}
+	}
+	checksums := sha256.New()
+	for _, artifact := range artifacts {
+		checksums.Write(artifact.parsedChecksum[:])
+	}
+	if version == "" {
+		version = hex.EncodeToString(checksums.Sum(nil)[:6])
+	} else {
+		version += "-" + hex.EncodeToString(checksums.Sum(nil)[:4])
 	}
 
 	parsedSourceConf = parsedSourceConfig{
-		compression:                     unparsedSourceConf.Compression,
-		filesToMakeExecutable:           unparsedSourceConf.FilesToMakeExecutable,
 		env:                             unparsedSourceConf.Env,
 		directSharedLibraryDependencies: unparsedSourceConf.DirectSharedLibraryDependencies,
 		executableDependencies:          unparsedSourceConf.ExecutableDependencies,
 		installationWarnings:            unparsedSourceConf.InstallationWarnings,
+		features:                        unparsedSourceConf.Features,
+		runner:                          unparsedSourceConf.Runner,
+		interpreter:                     unparsedSourceConf.Interpreter,
+		resourceLimits:                  unparsedSourceConf.ResourceLimits,
+		fhsPaths:                        unparsedSourceConf.FhsPaths,
+		homepage:                        unparsedSourceConf.Homepage,
+		knownIssues:                     unparsedSourceConf.KnownIssues,
+		needsNetwork:                    unparsedSourceConf.NeedsNetwork,
+		deprecatedInFavorOf:             unparsedSourceConf.DeprecatedInFavorOf,
+		fileChecksums:                   unparsedSourceConf.FileChecksums,
 		licenseDescription:              licenseDescription,
+		licenses:                        unparsedSourceConf.Licenses,
 		interpolationFunc:               interpolationFunc,
-		path:                            path.Join(downloadedSourcesDirPath, nameOfSourceToLoad),
-		parsedUrls:                      utils.ShuffleSlice(urls),
-		parsedChecksum:                  checksum,
-		parsedRootPath:                  rootPath,
+		version:                         version,
+		path:                            r.installedSourcePath(nameOfSourceToLoad, version),
+		artifacts:                       artifacts,
+		contactedDomains:                unparsedSourceConf.ContactedDomains,
+	}
+	if err := r.checkSourcePath(nameOfSourceToLoad, parsedSourceConf.path); err != nil {
+		return parsedSourceConfig{}, err
+	}
+	existingFeatures := []string{}
+	for _, feature := range r.selectedFeatures[nameOfSourceToLoad] {
+		if _, ok := parsedSourceConf.features[feature]; ok {
+			existingFeatures = append(existingFeatures, feature)
+		} else if slices.Contains(r.requestedFeatures[nameOfSourceToLoad], feature) {
+			return parsedSourceConfig{}, &sourceLoadingError{nameOfSourceToLoad, "The source does not have a feature called `" + feature + "`. " + describeFeatures(parsedSourceConf.features), nil}
+		} else {
+			r.warnings = append(r.warnings, "The feature `"+feature+"` of `"+nameOfSourceToLoad+"` is installed, but the source no longer has it, so it is ignored. Run `bento install "+nameOfSourceToLoad+"` to stop installing it.")
+		}
+	}
+	if len(existingFeatures) == 0 {
+		delete(r.selectedFeatures, nameOfSourceToLoad)
+	} else if len(existingFeatures) < len(r.selectedFeatures[nameOfSourceToLoad]) {
+		r.selectedFeatures[nameOfSourceToLoad] = existingFeatures
 	}
-	loadedSources[nameOfSourceToLoad] = parsedSourceConf
+	r.sources[nameOfSourceToLoad] = parsedSourceConf
+	for _, executable := range parsedSourceConf.executableDependencies {
+		r.discoveredSources[executable[0]] = true
+	}
+	for _, featureName := range r.selectedFeatures[nameOfSourceToLoad] {
+		for _, executable := range parsedSourceConf.features[featureName].ExecutableDependencies {
+			r.discoveredSources[executable[0]] = true
+		}
+	}
+	r.reportProgress()
 	return parsedSourceConf, nil
 }
 
-func loadLibrary(
-	librariesDirPath string,
-	sourcesDirPath string,
-	downloadedSourcesDirPath string,
-	loadedLibraries map[string]parsedLibrary,
-	loadedSources map[string]parsedSourceConfig,
-	nameOfLibraryToLoad string,
-) error {
-	_, libraryLoaded := loadedLibraries[nameOfLibraryToLoad]
-	if libraryLoaded {
+func (r *resolver) reportProgress() {
+	r.progress.Update("Resolving " + strconv.Itoa(len(r.sources)) + "/" + strconv.Itoa(len(r.discoveredSources)) + " sources")
+}
+
+// Shows the progress of loading sources until the returned function is called, unless `trace` is true, since the
+// trace already shows every source that is loaded
+func (r *resolver) showProgress(trace bool) func() {
+	if trace {
+		return func() {}
+	}
+	r.progress = utils.NewStatusLine()
+	return func() {
+		r.progress.Clear()
+		r.progress = nil
+	}
+}
+
+// Returns the directory that a version of a source is installed to. Versions that are installed in a system store are
+// used from there, and every other version is downloaded into the bento directory of the user.
+func (r *resolver) installedSourcePath(sourceName string, version string) string {
+	for _, systemStore := range r.config.systemStores() {
+		if systemStore == r.bentoDir {
+			continue
+		}
+		systemSourcePath := path.Join(systemStore, installedSourcesDirName, sourceName, version)
+		if info, err := os.Stat(systemSourcePath); err == nil && info.IsDir() {
+			r.trace.Log("Using version `" + version + "` of `" + sourceName + "` from the system store " + systemStore)
+			return systemSourcePath
+		}
+	}
+	return path.Join(r.installedSourcesDir, sourceName, version)
+}
+
+// Returns an error if the path that a source would be installed to is not inside the installed sources of the bento
+// directory or of a system store, which a source name or version with `..` in it could otherwise cause. The paths in
+// archives are already kept inside the path that they are extracted to, but that path comes from the source config.
+func (r *resolver) checkSourcePath(sourceName string, sourcePath string) error {
+	if r.config.AllowDestinationsOutsideBentoDir {
 		return nil
 	}
-	contents, err := os.ReadFile(path.Join(librariesDirPath, nameOfLibraryToLoad+".toml"))
-	if err != nil {
-		return errors.New("Failed to load library " + nameOfLibraryToLoad + ": " + err.Error())
+	roots := []string{r.installedSourcesDir}
+	for _, systemStore := range r.config.systemStores() {
+		roots = append(roots, path.Join(systemStore, installedSourcesDirName))
 	}
-	var unparsedLibraryConfig unparsedLibrary
-	_, err = toml.Decode(string(contents), &unparsedLibraryConfig)
-	if err != nil {
-		return errors.New("Failed to load library " + nameOfLibraryToLoad + ": " + err.Error())
+	for _, root := range roots {
+		if path.Clean(sourcePath) != path.Clean(root) && utils.IsWithin(sourcePath, root) {
+			return nil
+		}
 	}
-	for _, directSharedLibraryDependency := range unparsedLibraryConfig.DirectSharedLibraryDependencies {
-		err := loadLibrary(librariesDirPath, sourcesDirPath, downloadedSourcesDirPath, loadedLibraries, loadedSources, directSharedLibraryDependency)
-		if err != nil {
-			return err
+	return &sourceLoadingError{sourceName, "The source would be installed to `" + sourcePath + "`, which is not inside `" + r.installedSourcesDir + "`. Set `AllowDestinationsOutsideBentoDir` in your bento config if this is intended.", nil}
+}
+
+// Decodes a source config, along with the templates that it extends and the override that the user has for it. An
+// override is a TOML file with the same name as the source config in `~/.config/bento/overrides`, and the fields in it
+// are merged over the fields in the source config, so that a broken source config can be fixed locally.
+func (r *resolver) decodeSourceConfig(sourceConfPath string) (unparsedSourceConfig, error) {
+	defer r.trace.Measure(metadataTiming)()
+	overridePath := ""
+	if r.sourceOverridesDir != "" {
+		overridePath = path.Join(r.sourceOverridesDir, path.Base(sourceConfPath))
+		if _, err := os.Stat(overridePath); err != nil {
+			overridePath = ""
 		}
 	}
-	if unparsedLibraryConfig.Source != "system" {
-		sourceConf, err := loadSource(sourcesDirPath, downloadedSourcesDirPath, loadedSources, unparsedLibraryConfig.Source)
+	unparsedSourceConf, err := decodeTomlFile[unparsedSourceConfig](r.tomlCache, sourceConfPath)
+	if err != nil || (unparsedSourceConf.Extends == "" && overridePath == "") {
+		return unparsedSourceConf, err
+	}
+	fields, err := r.decodeFieldsWithTemplates(sourceConfPath, []string{})
+	if err != nil {
+		return unparsedSourceConf, err
+	}
+	if overridePath != "" {
+		r.trace.Log("Merging the override " + overridePath + " over " + sourceConfPath)
+		overrideFields, err := decodeTomlFile[map[string]any](r.tomlCache, overridePath)
 		if err != nil {
-			return errors.New("Failed to load library " + nameOfLibraryToLoad + ": " + err.Error())
+			return unparsedSourceConf, utils.WrapError("Failed to load the override `"+overridePath+"`", err)
 		}
-		loadedLibraries[nameOfLibraryToLoad] = parsedLibrary{absoluteDirectory: path.Join(sourceConf.path, unparsedLibraryConfig.Directory)}
+		fields = mergeTomlTables(fields, overrideFields)
+		r.warnings = append(r.warnings, "Using the override `"+overridePath+"` instead of some of the fields from the package repository for `"+strings.TrimSuffix(path.Base(sourceConfPath), ".toml")+"`. Remove it once the package repository has been fixed.")
 	}
-	return nil
+	return decodeSourceConfigFields(fields)
 }
 
-const maxParrellelDownloads = 10
+// Decodes the fields of a source config or a template, and merges in the fields of the templates that it extends
+func (r *resolver) decodeFieldsWithTemplates(filePath string, extendedTemplates []string) (map[string]any, error) {
+	fields, err := decodeTomlFile[map[string]any](r.tomlCache, filePath)
+	if err != nil {
+		return fields, err
+	}
+	extends, ok := fields["Extends"]
+	if !ok {
+		return fields, nil
+	}
+	templateName, ok := extends.(string)
+	if !ok {
+		return fields, errors.New("Expected `Extends` in `" + filePath + "` to be a string")
+	}
+	if slices.Contains(extendedTemplates, templateName) {
+		return fields, errors.New("The template `" + templateName + "` extends itself: " + strings.Join(append(extendedTemplates, templateName), " -> "))
+	}
+	err = r.fetchRepositoryFileIfMissing(path.Join("templates", templateName+".toml"))
+	if err != nil {
+		return fields, err
+	}
+	templatePath := path.Join(r.bentoDir, "templates", templateName+".toml")
+	r.trace.Log("Loading template `" + templateName + "` from " + templatePath)
+	templateFields, err := r.decodeFieldsWithTemplates(templatePath, append(slices.Clip(extendedTemplates), templateName))
+	if err != nil {
+		return fields, utils.WrapError("Failed to load template `"+templateName+"`", err)
+	}
+	merged := mergeTomlTables(templateFields, fields)
+	delete(merged, "Extends")
+	return merged, nil
+}
 
-func main() {
-	index := 1
-	subcommand := utils.TakeOneArg(&index, "the subcommand to run (either `help`, `update`, or `exec`)")
-	switch subcommand {
-	case "help":
-		utils.ExpectAllArgsParsed(index)
-		// TODO: Improve help message
-		println("Bento is a cross-distro package manager that can be used without root. For more information, see https://github.com/godalming123/bento.")
-	case "update":
-		cacheDir, err := os.UserCacheDir()
-		if err != nil {
-			utils.Fail("Failed to get cache directory: " + err.Error())
-		}
-		packageCacheDir := path.Join(cacheDir, "bento")
-		utils.ExpectAllArgsParsed(index)
-		errs := utils.FetchPackageRepository(packageCacheDir, maxParrellelDownloads)
-		if len(errs) != 0 {
-			os.Exit(1)
-		}
-	case "exec":
-		var sourceName, sourceExecutableRelativePath, lastArg string
-		lastArgDesc := "Either `--arg` followed by an argument to pass to the " +
-			"executable, or the bento directory plus some characters, `/`, and some " +
-			"more characters (normally this is passed in by `/usr/bin/env`, which " +
-			"sends some arguments like [`bento`, `exec`, `SOURCE_NAME`, " +
-			"`EXECUTABLE_NAME`, `SCRIPT_PATH`, `ARG1`, ...] when bento is invoked from" +
-			"a shebang like `#!/usr/bin/env -S bento exec SOURCE_NAME EXECUTABLE_NAME`)"
-		utils.TakeArgs(&index, []utils.Argument{
-			{Desc: "The name of the source", Value: &sourceName},
-			{Desc: "The path of the executable within the source", Value: &sourceExecutableRelativePath},
-			{Desc: lastArgDesc, Value: &lastArg},
-		})
-		argsToPass := []string{}
-		for lastArg == "--arg" {
-			var argValue string
-			utils.TakeArgs(&index, []utils.Argument{
-				{Desc: "The value of the argument to pass to the executable", Value: &argValue},
-				{Desc: lastArgDesc, Value: &lastArg},
-			})
-			argsToPass = append(argsToPass, argValue)
-		}
-		// For some reason argcomplete (https://github.com/kislyuk/argcomplete/) executes `bento exec SOURCE_NAME EXECUTABLE_NAME -m argcomplete._check_console_script PATH_TO_SCRIPT`, when these 4 conditions are simultaneously met:
-		// - Argcomplete is setup in the users shell using the "global completion" strategy
-		// - The user has typed the name of a script that is in their path and a space into their shell prompt
-		// - The script uses a shebang like `#!/usr/bin/env bento exec SOURCE_NAME EXECUTABLE_NAME`
-		// - The user presses tab
-		// This causes a problem if bento ignores `lastArg` and the executable EXECUTABLE_NAME runs forever when there is no user input to stdin, because then when the user presses tab to autocomplete options for the script which has a shebang:
-		// 1. The users shell executes argcomplete
-		// 2. Argcomplete executes bento with the above arguments
-		// 3. Bento would execute the executable as normal
-		// 4. The users shell would freeze because bento never exits
-		// To mitagate this, this condition is necersarry
-		if lastArg == "-m" {
-			os.Exit(1)
+// Returns a copy of `base` with the fields of `overrides` added. Tables that are in both are merged, and every other
+// field in `overrides` replaces the field in `base`. Neither of the tables are modified, because they can be cached.
+func mergeTomlTables(base map[string]any, overrides map[string]any) map[string]any {
+	merged := maps.Clone(base)
+	for key, overrideValue := range overrides {
+		baseTable, baseIsTable := merged[key].(map[string]any)
+		overrideTable, overrideIsTable := overrideValue.(map[string]any)
+		if baseIsTable && overrideIsTable {
+			merged[key] = mergeTomlTables(baseTable, overrideTable)
+		} else {
+			merged[key] = overrideValue
 		}
-		argsToPass = append(argsToPass, os.Args[index:]...)
-		exec(sourceName, sourceExecutableRelativePath, path.Dir(path.Dir(lastArg)), argsToPass)
-	default:
-		utils.Fail("`" + subcommand + "` is not a valid subcommand. Expected either `help`, `update`, or `exec`")
 	}
+	return merged
 }
 
-func loadExecutable(
-	sourcesDir string,
-	downloadedSourcesDir string,
-	loadedSources map[string]parsedSourceConfig,
-
-	librariesDir string,
-	loadedLibraries map[string]parsedLibrary,
-
-	sourceName string,
-	sourceExecutableRelativePath string,
-	loadedExecutables map[string]string,
-	executableEnvironment map[string]string,
-) (string, error) {
-	if executable, ok := loadedExecutables[sourceName+" "+sourceExecutableRelativePath]; ok {
-		return executable, nil
+func decodeSourceConfigFields(fields map[string]any) (unparsedSourceConfig, error) {
+	var unparsedSourceConf unparsedSourceConfig
+	// The merged fields are encoded again so that they can be decoded into the source config in the same way as a
+	// source config that does not extend a template
+	var encodedFields bytes.Buffer
+	err := toml.NewEncoder(&encodedFields).Encode(fields)
+	if err != nil {
+		return unparsedSourceConf, err
 	}
+	_, err = toml.Decode(encodedFields.String(), &unparsedSourceConf)
+	return unparsedSourceConf, err
+}
 
-	sourceConf, err := loadSource(sourcesDir, downloadedSourcesDir, loadedSources, sourceName)
+// Parses an artifact of a source. If the artifact does not specify its own mirrors, then the mirrors of the source are
+// used, and if the checksum of the artifact is not in the checksums of the artifact, then it is looked up in the
+// checksums of the source.
+func (r *resolver) parseArtifact(sourceName string, unparsedArtifactConf unparsedArtifact, unparsedSourceConf unparsedSourceConfig, interpolationFunc func(string) (string, error)) (parsedArtifact, error) {
+	var description string
+	var urls []string
+	var checksum [32]byte
+	var checksumUrls []string
+	var err error
+	if unparsedArtifactConf.OciBlob != "" {
+		if unparsedArtifactConf.UrlInMirror != "" {
+			return parsedArtifact{}, &sourceLoadingError{sourceName, "An artifact cannot have both `UrlInMirror` and `OciBlob`", nil}
+		}
+		description, urls, checksum, err = r.parseOciBlob(sourceName, unparsedArtifactConf.OciBlob, interpolationFunc)
+	} else {
+		description, urls, checksum, checksumUrls, err = r.parseUrlInMirror(sourceName, unparsedArtifactConf, unparsedSourceConf, interpolationFunc)
+	}
 	if err != nil {
-		return "", err
+		return parsedArtifact{}, err
+	}
+	size, err := r.parseSize(sourceName, unparsedArtifactConf, unparsedSourceConf, interpolationFunc)
+	if err != nil {
+		return parsedArtifact{}, err
 	}
-	sourceExecutable := path.Join(sourceConf.path, sourceExecutableRelativePath)
 
-	for _, executable := range sourceConf.executableDependencies {
-		_, err := loadExecutable(
-			sourcesDir,
-			downloadedSourcesDir,
-			loadedSources,
-			librariesDir,
-			loadedLibraries,
-			executable[0],
-			executable[1],
-			loadedExecutables,
-			executableEnvironment,
-		)
-		if err != nil {
-			return "", err
+	rootPath, err := utils.InterpolateStringLiteral(unparsedArtifactConf.RootPath, interpolationFunc)
+	if err != nil {
+		return parsedArtifact{}, err
+	}
+	if rootPath != unparsedArtifactConf.RootPath {
+		r.trace.Log("Interpolated `" + unparsedArtifactConf.RootPath + "` to `" + rootPath + "`")
+	}
+	rootPath, err = utils.NormalizeRootPath(rootPath)
+	if err != nil {
+		return parsedArtifact{}, &sourceLoadingError{sourceName, err.Error(), err}
+	}
+	for _, relativePath := range append([]string{unparsedArtifactConf.Subdirectory}, unparsedArtifactConf.FilesToMakeExecutable...) {
+		if err := utils.ValidateRelativePath(relativePath); err != nil {
+			return parsedArtifact{}, &sourceLoadingError{sourceName, err.Error(), err}
 		}
 	}
 
-	executableEnvironmentConfig, _ := sourceConf.env[sourceExecutableRelativePath]
-	for envName, envValue := range executableEnvironmentConfig {
-		replacedValue, err := utils.InterpolateStringLiteral(envValue, func(interpolation string) (string, error) {
-			source, err := loadSource(sourcesDir, downloadedSourcesDir, loadedSources, interpolation)
-			if err != nil {
-				return "", err
-			}
-			return source.path, nil
-		})
+	innerArchives := []utils.InnerArchive{}
+	for _, innerArchive := range unparsedArtifactConf.InnerArchives {
+		innerArchive.Path, err = utils.InterpolateStringLiteral(innerArchive.Path, interpolationFunc)
 		if err != nil {
-			return "", err
+			return parsedArtifact{}, err
 		}
-		executableEnvironment[envName] = replacedValue
+		innerArchives = append(innerArchives, innerArchive)
 	}
 
-	directSharedLibraryDependencies, _ := sourceConf.directSharedLibraryDependencies[sourceExecutableRelativePath]
-	for _, directSharedLibraryDependency := range directSharedLibraryDependencies {
-		err := loadLibrary(librariesDir, sourcesDir, downloadedSourcesDir, loadedLibraries, loadedSources, directSharedLibraryDependency)
-		if err != nil {
-			return "", err
+	for _, pattern := range slices.Concat(unparsedArtifactConf.Include, unparsedArtifactConf.Exclude) {
+		if err := utils.ValidateGlob(pattern); err != nil {
+			return parsedArtifact{}, &sourceLoadingError{sourceName, err.Error(), err}
 		}
 	}
 
-	loadedExecutables[sourceName+" "+sourceExecutableRelativePath] = sourceExecutable
-	return sourceExecutable, nil
+	return parsedArtifact{
+		description:           description,
+		compression:           unparsedArtifactConf.Compression,
+		filesToMakeExecutable: unparsedArtifactConf.FilesToMakeExecutable,
+		parsedUrls:            urls,
+		parsedChecksum:        checksum,
+		checksumUrls:          checksumUrls,
+		parsedRootPath:        rootPath,
+		subdirectory:          unparsedArtifactConf.Subdirectory,
+		filter:                utils.ExtractionFilter{Include: unparsedArtifactConf.Include, Exclude: unparsedArtifactConf.Exclude},
+		innerArchives:         innerArchives,
+		isOciBlob:             unparsedArtifactConf.OciBlob != "",
+		size:                  size,
+	}, nil
+}
+
+// Finds the size in bytes of the download of an artifact in the `Sizes` of the artifact or of the source, which is
+// looked up in the same way as its checksum. Returns 0 if the size is not declared.
+func (r *resolver) parseSize(sourceName string, unparsedArtifactConf unparsedArtifact, unparsedSourceConf unparsedSourceConfig, interpolationFunc func(string) (string, error)) (int64, error) {
+	download := unparsedArtifactConf.UrlInMirror
+	if unparsedArtifactConf.OciBlob != "" {
+		download = unparsedArtifactConf.OciBlob
+	}
+	download, err := utils.InterpolateStringLiteral(download, interpolationFunc)
+	if err != nil {
+		return 0, err
+	}
+	size, _, err := findByUrlInMirror(download, "Sizes", []map[string]int64{unparsedArtifactConf.Sizes, unparsedSourceConf.Sizes}, interpolationFunc)
+	if err != nil {
+		return 0, &sourceLoadingError{sourceName, err.Error(), err}
+	}
+	if size < 0 {
+		return 0, &sourceLoadingError{sourceName, "Expected the size of `" + download + "` in `Sizes` to be a positive number of bytes, but got " + strconv.FormatInt(size, 10), nil}
+	}
+	return size, nil
 }
 
-func exec(sourceName string, sourceExecutableRelativePath string, bentoDir string, argsToPass []string) {
-	libraries := map[string]parsedLibrary{}
-	sources := map[string]parsedSourceConfig{}
-	executables := map[string]string{}
+// Returns the description, the URLs in every mirror, and the checksum of an artifact that is downloaded from mirrors.
+// If the checksum is fetched from a checksum file when the source is installed, then the URLs of the checksum file are
+// returned instead of the checksum.
+func (r *resolver) parseUrlInMirror(sourceName string, unparsedArtifactConf unparsedArtifact, unparsedSourceConf unparsedSourceConfig, interpolationFunc func(string) (string, error)) (string, []string, [32]byte, []string, error) {
+	urlInMirror, err := utils.InterpolateStringLiteral(unparsedArtifactConf.UrlInMirror, interpolationFunc)
+	if err != nil {
+		return "", nil, [32]byte{}, nil, err
+	}
+	if urlInMirror != unparsedArtifactConf.UrlInMirror {
+		r.trace.Log("Interpolated `" + unparsedArtifactConf.UrlInMirror + "` to `" + urlInMirror + "`")
+	}
 
-	executableEnvironmentUnparsed := os.Environ()
-	executableEnvironment := map[string]string{}
-	for _, environmentVariable := range executableEnvironmentUnparsed {
-		environmentVariableSplit := strings.SplitN(environmentVariable, "=", 2)
-		executableEnvironment[environmentVariableSplit[0]] = environmentVariableSplit[1]
+	mirrors := unparsedArtifactConf.Mirrors
+	if len(mirrors) == 0 {
+		mirrors = unparsedSourceConf.Mirrors
+	}
+	for _, mirror := range mirrors {
+		if r.config.RequireHttps && !strings.HasPrefix(mirror, "https://") {
+			return "", nil, [32]byte{}, nil, &sourceLoadingError{sourceName, "The mirror `" + mirror + "` does not use HTTPS, which `RequireHttps` in your bento config requires", nil}
+		}
+	}
+	urls := []string{}
+	for _, mirror := range r.config.sortMirrors(mirrors, unparsedSourceConf.MirrorRegions) {
+		urls = append(urls, mirror+"/"+urlInMirror)
 	}
 
-	sourceExecutable, err := loadExecutable(
-		path.Join(bentoDir, "sources"),
-		path.Join(bentoDir, "downloadedSources"),
-		sources, path.Join(bentoDir, "lib"),
-		libraries,
-		sourceName,
-		sourceExecutableRelativePath,
-		executables,
-		executableEnvironment,
-	)
+	// Ideally checksum parsing would use https://github.com/BurntSushi/toml/issues/448
+	checksumString, exists, err := findByUrlInMirror(urlInMirror, "Checksums", []map[string]string{unparsedArtifactConf.Checksums, unparsedSourceConf.Checksums}, interpolationFunc)
 	if err != nil {
-		utils.Fail(err.Error())
+		return "", nil, [32]byte{}, nil, &sourceLoadingError{sourceName, err.Error(), err}
+	}
+	if !exists && unparsedArtifactConf.ChecksumUrlInMirror != "" {
+		checksumUrls, err := r.parseChecksumUrlInMirror(sourceName, unparsedArtifactConf.ChecksumUrlInMirror, urls, urlInMirror, interpolationFunc)
+		return path.Base(urlInMirror), urls, [32]byte{}, checksumUrls, err
+	}
+	if !exists {
+		return "", nil, [32]byte{}, nil, &sourceLoadingError{sourceName, "The checksum for " + urlInMirror + " is not specified. Bento requires checksums to be specified.", nil}
+	}
+	if len(checksumString) != 64 {
+		return "", nil, [32]byte{}, nil, &sourceLoadingError{sourceName, "Expected checksum to be 64 characters, but it is " + fmt.Sprint(len(checksumString)) + " characters", nil}
 	}
+	checksumSlice, err := hex.DecodeString(checksumString)
+	if err != nil {
+		return "", nil, [32]byte{}, nil, &sourceLoadingError{sourceName, "Failed to decode checksum: " + err.Error(), err}
+	}
+	if len(checksumSlice) != 32 {
+		panic("Unexpected internal state: len(parsedChecksumSlice) = " + fmt.Sprint(len(checksumSlice)))
+	}
+	var checksum [32]byte
+	copy(checksum[:], checksumSlice)
+	return path.Base(urlInMirror), urls, checksum, nil, nil
+}
 
-	downloads := make([]utils.DownloadOptions, 0, len(sources))
-	downloadsSortedByLicense := map[string][][]string{}
-	for sourceName, sourceConf := range sources {
-		_, err := os.Stat(sourceConf.path)
-		if os.IsNotExist(err) {
-			downloadsSortedByLicense[sourceConf.licenseDescription] = append(
-				downloadsSortedByLicense[sourceConf.licenseDescription],
-				append([]string{sourceName}, sourceConf.installationWarnings...),
-			)
-			downloads = append(downloads, utils.DownloadOptions{
-				Name:                             sourceName,
-				Urls:                             sourceConf.parsedUrls,
-				Compression:                      sourceConf.compression,
-				Checksum:                         sourceConf.parsedChecksum,
-				UseChecksum:                      true,
-				FilesToMakeExecutable:            sourceConf.filesToMakeExecutable,
-				RootPath:                         sourceConf.parsedRootPath,
-				Destination:                      sourceConf.path,
-				DeleteExistingFilesAtDestination: false,
-			})
-		} else if err != nil {
-			utils.Fail("Failed to stat `" + sourceConf.path + "`: " + err.Error())
+// Returns the URLs of the checksum file of an artifact in each of the mirrors that it is downloaded from, which must
+// use HTTPS, so that the checksum comes from the same hosts that the source config trusts for the artifact
+func (r *resolver) parseChecksumUrlInMirror(sourceName string, unparsedChecksumUrl string, artifactUrls []string, urlInMirror string, interpolationFunc func(string) (string, error)) ([]string, error) {
+	if r.config.ForbidChecksumUrls {
+		return nil, &sourceLoadingError{sourceName, "The checksum for " + urlInMirror + " is fetched from `ChecksumUrlInMirror` instead of being in the source config, which `ForbidChecksumUrls` in your bento config forbids", nil}
+	}
+	checksumUrlInMirror, err := utils.InterpolateStringLiteral(unparsedChecksumUrl, interpolationFunc)
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.ValidateRelativePath(checksumUrlInMirror); err != nil {
+		return nil, &sourceLoadingError{sourceName, "Invalid `ChecksumUrlInMirror`: " + err.Error(), err}
+	}
+	checksumUrls := []string{}
+	for _, artifactUrl := range artifactUrls {
+		if strings.HasPrefix(artifactUrl, "https://") {
+			mirror := strings.TrimSuffix(artifactUrl, urlInMirror)
+			checksumUrls = append(checksumUrls, mirror+checksumUrlInMirror)
 		}
 	}
-	if len(downloads) > 0 {
-		println("Download the following " + utils.CreateNoun(len(downloads), "source", "sources") + " to run the binary " + sourceExecutableRelativePath + " from the source " + sourceName + "?")
-		for licenseHeader, sources := range downloadsSortedByLicense {
-			println("- " + utils.AnsiBold + utils.CreateNoun(len(sources), "A source", "sources") + " " + licenseHeader + utils.AnsiReset)
-			for _, source := range sources {
-				println("  - " + source[0])
-				for _, installationWarning := range source[1:] {
-					println("    - " + installationWarning)
-				}
-			}
+	if len(checksumUrls) == 0 {
+		return nil, &sourceLoadingError{sourceName, "The checksum for " + urlInMirror + " is fetched from `ChecksumUrlInMirror`, which needs a mirror that uses HTTPS", nil}
+	}
+	return checksumUrls, nil
+}
+
+// Finds the checksum, or another value like the size, of the file at `urlInMirror` in a table like `Checksums`. The
+// table of the artifact is searched before the table of the source, and in each of them the value is looked up by:
+//  1. The interpolated URL in the mirror, like `tool-1.0-x86_64.tar.gz`
+//  2. The name that `${architecture}` is interpolated to, like `x86_64`, so that a source whose URL only changes with
+//     the architecture can have one checksum for each architecture
+//  3. Keys that contain interpolations, like `tool-${version.number}-${architecture}.tar.gz`, which are interpolated
+//     and compared to the interpolated URL, so that the keys do not need to be updated when the version changes
+func findByUrlInMirror[T any](urlInMirror string, tableName string, tables []map[string]T, interpolationFunc func(string) (string, error)) (T, bool, error) {
+	var notFound T
+	architecture, err := interpolationFunc("architecture")
+	if err != nil {
+		return notFound, false, err
+	}
+	for _, table := range tables {
+		if value, exists := table[urlInMirror]; exists {
+			return value, true, nil
 		}
-		if !utils.GetBoolDefaultYes() {
-			return
+		if value, exists := table[architecture]; exists {
+			return value, true, nil
 		}
-		errs := utils.DownloadConcurrently(downloads, maxParrellelDownloads)
-		if len(errs) > 0 {
-			os.Exit(1)
+		for _, key := range slices.Sorted(maps.Keys(table)) {
+			if !strings.Contains(key, "${") {
+				continue
+			}
+			interpolatedKey, err := utils.InterpolateStringLiteral(key, interpolationFunc)
+			if err != nil {
+				return notFound, false, utils.WrapError("Failed to interpolate the key `"+key+"` in `"+tableName+"`", err)
+			}
+			if interpolatedKey == urlInMirror {
+				return table[key], true, nil
+			}
 		}
 	}
+	return notFound, false, nil
+}
 
-	// Use a hash map to de-duplicate libraries with the same path
-	librariesPathsMap := map[string]struct{}{}
-	for _, library := range libraries {
-		librariesPathsMap[library.absoluteDirectory] = struct{}{}
+// Returns the description, the URL, and the checksum of an artifact that is a blob in an OCI registry. The blob is
+// fetched from the registry that it is stored in, so the mirrors of the source are not used.
+func (r *resolver) parseOciBlob(sourceName string, unparsedOciBlob string, interpolationFunc func(string) (string, error)) (string, []string, [32]byte, error) {
+	ociBlob, err := utils.InterpolateStringLiteral(unparsedOciBlob, interpolationFunc)
+	if err != nil {
+		return "", nil, [32]byte{}, err
+	}
+	if ociBlob != unparsedOciBlob {
+		r.trace.Log("Interpolated `" + unparsedOciBlob + "` to `" + ociBlob + "`")
+	}
+	blobUrl, checksum, err := utils.ParseOciBlob(ociBlob)
+	if err != nil {
+		return "", nil, [32]byte{}, &sourceLoadingError{sourceName, err.Error(), err}
 	}
-	librariesPathsList := utils.Collect(maps.Keys(librariesPathsMap))
-	executableEnvironment["LD_LIBRARY_PATH"] = strings.Join(librariesPathsList, ":")
+	repository, _, _ := strings.Cut(ociBlob, "@")
+	return path.Base(repository) + "@" + hex.EncodeToString(checksum[:6]), []string{blobUrl}, checksum, nil
+}
 
-	executableEnv := make([]string, 0, len(executableEnvironment))
-	for key, value := range executableEnvironment {
-		executableEnv = append(executableEnv, key+"="+value)
+func describeFeatures(features map[string]sourceFeature) string {
+	if len(features) == 0 {
+		return "The source does not have any features."
+	}
+	featureNames := utils.Collect(maps.Keys(features))
+	utils.SortNames(featureNames)
+	description := "The source has the following features:"
+	for _, featureName := range featureNames {
+		description += "\n- " + featureName
+		if features[featureName].Description != "" {
+			description += ": " + features[featureName].Description
+		}
+	}
+	return description
+}
+
+func (r *resolver) loadLibrary(nameOfLibraryToLoad string) error {
+	_, libraryLoaded := r.libraries[nameOfLibraryToLoad]
+	if libraryLoaded {
+		r.trace.Log("Library `" + nameOfLibraryToLoad + "` is already loaded")
+		return nil
+	}
+	libraryConfPath := path.Join(r.librariesDir, nameOfLibraryToLoad+".toml")
+	err := r.fetchRepositoryFileIfMissing(path.Join("lib", nameOfLibraryToLoad+".toml"))
+	if err != nil {
+		return utils.WrapError("Failed to load library "+nameOfLibraryToLoad, err)
+	}
+	r.trace.Log("Loading library `" + nameOfLibraryToLoad + "` from " + libraryConfPath)
+	endMetadata := r.trace.Measure(metadataTiming)
+	unparsedLibraryConfig, err := decodeTomlFile[unparsedLibrary](r.tomlCache, libraryConfPath)
+	endMetadata()
+	if err != nil {
+		return utils.WrapError("Failed to load library "+nameOfLibraryToLoad, err)
+	}
+	for _, directSharedLibraryDependency := range unparsedLibraryConfig.DirectSharedLibraryDependencies {
+		r.trace.Log("Library `" + nameOfLibraryToLoad + "` depends on library `" + directSharedLibraryDependency + "`")
+		err := r.loadLibrary(directSharedLibraryDependency)
+		if err != nil {
+			return err
+		}
+		if unparsedLibraryConfig.Source != "system" {
+			r.recordLibraryDependency(unparsedLibraryConfig.Source, directSharedLibraryDependency, "the library `"+nameOfLibraryToLoad+"` uses the library `"+directSharedLibraryDependency+"`", false)
+		}
+	}
+	if unparsedLibraryConfig.Source == "system" && !r.resolvesForHost() {
+		r.trace.Log("Library `" + nameOfLibraryToLoad + "` is expected to be provided by the system that the sources are downloaded for")
+	} else if unparsedLibraryConfig.Source == "system" {
+		if r.systemLibraryDirectories == nil {
+			r.systemLibraryDirectories = utils.SystemLibraryDirectories()
+		}
+		err := checkSystemLibrary(nameOfLibraryToLoad, unparsedLibraryConfig, r.systemLibraryDirectories)
+		if err != nil {
+			return utils.WrapError("Failed to load library "+nameOfLibraryToLoad, err)
+		}
+		r.trace.Log("Library `" + nameOfLibraryToLoad + "` is provided by the system")
+	} else {
+		r.trace.Log("Library `" + nameOfLibraryToLoad + "` is provided by the source `" + unparsedLibraryConfig.Source + "`")
+		sourceConf, err := r.loadSource(unparsedLibraryConfig.Source)
+		if err != nil {
+			return utils.WrapError("Failed to load library "+nameOfLibraryToLoad, err)
+		}
+		r.libraries[nameOfLibraryToLoad] = parsedLibrary{absoluteDirectory: path.Join(sourceConf.path, unparsedLibraryConfig.Directory), source: unparsedLibraryConfig.Source}
+	}
+	return nil
+}
+
+// Checks that a library that is expected to be provided by the host system is present, and if it is not, returns an
+// error that explains which package provides it
+func checkSystemLibrary(libraryName string, library unparsedLibrary, systemLibraryDirectories []string) error {
+	soname := library.Soname
+	if soname == "" {
+		soname = libraryName
+	}
+	if _, found := utils.FindSystemLibrary(soname, systemLibraryDirectories); found {
+		return nil
+	}
+	message := "Expected your system to provide `" + soname + "`, but it is not in any of the directories that the dynamic linker searches. "
+	for _, distroId := range utils.DistroIds() {
+		if distroPackage, ok := library.DistroPackages[distroId]; ok {
+			return errors.New(message + "Install the `" + distroPackage + "` package using your distro's package manager.")
+		}
+	}
+	if len(library.DistroPackages) == 0 {
+		return errors.New(message + "Install the package that provides it using your distro's package manager.")
+	}
+	message += "It is provided by the following packages:"
+	distroIds := utils.Collect(maps.Keys(library.DistroPackages))
+	slices.Sort(distroIds)
+	for _, distroId := range distroIds {
+		message += "\n- `" + library.DistroPackages[distroId] + "` on " + distroId
+	}
+	return errors.New(message)
+}
+
+const maxParrellelDownloads = 10
+
+// The directory that bento stores data that is not part of the package repository in, like the writable data of each
+// source
+func getDataDir() string {
+	if dataDir, isSet := writableDirOverride("data"); isSet {
+		return dataDir
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			utils.Fail("Failed to get home directory: " + err.Error() + ". " + writableDirHint)
+		}
+		dataHome = path.Join(homeDir, ".local", "share")
+	}
+	return path.Join(dataHome, "bento")
+}
+
+// The directory that a source can write data to
+func getSourceDataDir(sourceName string) string {
+	return path.Join(getDataDir(), "data", sourceName)
+}
+
+// The directory in the bento directory that sources are installed to, with each version of a source installed to
+// `installedSources/SOURCE_NAME/VERSION`
+const installedSourcesDirName = "installedSources"
+
+// The directory in the bento directory that downloads which do not match their checksum are saved to, along with the
+// URL and headers that they were served with
+const quarantineDirName = "quarantine"
+
+// The directory in the bento directory that the files of installed sources are stored in by checksum, when the user has
+// enabled `ContentAddressedStore`
+const contentStoreDirName = "contentStore"
+
+// Returns the bento directory, which is `BENTO_DIR` if it is set, `bento` in `BENTO_WRITABLE_DIR` if that is set, and
+// otherwise `bento` in the cache directory of the user
+func getBentoDir() string {
+	if systemInstall {
+		return systemBentoDir()
+	}
+	if bentoDir := os.Getenv("BENTO_DIR"); bentoDir != "" {
+		return bentoDir
+	}
+	if bentoDir, isSet := writableDirOverride("bento"); isSet {
+		return bentoDir
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		utils.Fail("Failed to get cache directory: " + err.Error() + ". " + writableDirHint)
+	}
+	return path.Join(cacheDir, "bento")
+}
+
+// Returns true if a directory looks like a bento directory, which always has the sources of the package repository
+func isBentoDir(directory string) bool {
+	info, err := os.Stat(path.Join(directory, "sources"))
+	return err == nil && info.IsDir()
+}
+
+// Returns the bento directory of a script that runs bento from its shebang, which is normally a shim in the `bin`
+// directory of the bento directory. The script may have been run through a symlink or a relative path, so the
+// symlinks in its path are resolved if the bento directory is not found without resolving them. If the script is not
+// in a bento directory, then the bento directory from `getBentoDir` is used instead, after warning the user.
+func inferBentoDir(scriptPath string) string {
+	candidates := []string{path.Dir(path.Dir(scriptPath))}
+	if resolvedPath, err := filepath.EvalSymlinks(scriptPath); err == nil {
+		if absolutePath, err := filepath.Abs(resolvedPath); err == nil {
+			candidates = append(candidates, path.Dir(path.Dir(absolutePath)))
+		}
+	}
+	for _, candidate := range candidates {
+		if isBentoDir(candidate) {
+			return candidate
+		}
+	}
+	// The shims of sources that are installed for every user are not in a bento directory
+	if path.Dir(scriptPath) == systemShimsDir {
+		if bentoDir := systemBentoDir(); isBentoDir(bentoDir) {
+			return bentoDir
+		}
+	}
+	bentoDir := getBentoDir()
+	diagnostic := "Expected the script `" + scriptPath + "`, which runs bento from its shebang, to be in a directory of a bento directory, like its `bin` directory, but `" + candidates[len(candidates)-1] + "` is not a bento directory, since it has no `sources` directory."
+	if !isBentoDir(bentoDir) {
+		utils.Fail(diagnostic + " The bento directory at `" + bentoDir + "` does not have one either, so run `bento update` to download the package repository, or set `BENTO_DIR` to the bento directory.")
+	}
+	printWarnings([]string{diagnostic + " Using the bento directory at `" + bentoDir + "` instead. To run a script that is outside of the bento directory, put `--` after the name of the executable in its shebang."})
+	return bentoDir
+}
+
+func main() {
+	utils.HandleMountHelper()
+	index := 1
+	if len(os.Args) > 1 && os.Args[1] == "--system" {
+		index += 1
+		enableSystemInstall()
+	} else if runAsRootWithoutBentoDir() {
+		enableSystemInstall()
+	}
+	subcommand := utils.TakeOneArg(&index, "the subcommand to run (either `help`, `update`, `install`, `fetch`, `exec`, `exec-url`, `dev`, `env`, `daemon`, `jobs`, `history`, `hash`, `resume`, `rollback`, `shims`, `repo`, `diff`, `info`, `why`, `lint-repo`, `gc`, `cache`, `completion`, `path-setup`, `upgrade`, `env-diff`, or `doctor`)")
+	switch subcommand {
+	case "help":
+		utils.ExpectAllArgsParsed(index)
+		// TODO: Improve help message
+		println("Bento is a cross-distro package manager that can be used without root. For more information, see https://github.com/godalming123/bento.")
+	case "update":
+		utils.ExpectAllArgsParsed(index)
+		bentoDir := getBentoDir()
+		config, err := loadUserConfig()
+		if err != nil {
+			utils.Fail(err.Error())
+		}
+		updatePackageRepository(bentoDir)
+		if config.PopularityHints {
+			err := fetchPopularityHints(bentoDir)
+			if err != nil {
+				printWarnings([]string{err.Error()})
+			}
+		}
+		// Updating replaces the shims with the shims in the package repository, which do not include shims for
+		// installed sources that are no longer in the package repository
+		syncShims(bentoDir, true)
+	case "shims":
+		action := utils.TakeOneArg(&index, "The shims subcommand to run (`sync`)")
+		if action != "sync" {
+			utils.Fail("Expected the shims subcommand to be `sync`, but got `" + action + "`")
+		}
+		utils.ExpectAllArgsParsed(index)
+		if !syncShims(getBentoDir(), false) {
+			os.Exit(1)
+		}
+	case "install":
+		sourceNames := []string{}
+		selectedFeatures := map[string][]string{}
+		options := installOptions{}
+		for index < len(os.Args) {
+			arg := utils.TakeOneArg(&index, "")
+			switch arg {
+			case "--with":
+				if len(sourceNames) == 0 {
+					utils.Fail("Expected the name of a source before `--with`")
+				}
+				lastSourceName := sourceNames[len(sourceNames)-1]
+				feature := utils.TakeOneArg(&index, "The name of the feature of `"+lastSourceName+"` to install")
+				selectedFeatures[lastSourceName] = append(selectedFeatures[lastSourceName], feature)
+			case "--reproducible":
+				options.reproducible = true
+			case "--background":
+				options.background = true
+			case "--skip":
+				options.skippedSources = append(options.skippedSources, utils.TakeOneArg(&index, "The name of the source to skip"))
+			case "--report-endpoints":
+				options.reportEndpoints = true
+			case "--refresh":
+				options.refreshedSources = append(options.refreshedSources, utils.TakeOneArg(&index, "The name of the source to download and extract again"))
+			case "--include", "--exclude":
+				pattern := utils.TakeOneArg(&index, "A glob pattern of the paths to "+strings.TrimPrefix(arg, "--")+" when extracting sources")
+				if err := utils.ValidateGlob(pattern); err != nil {
+					utils.Fail(err.Error())
+				}
+				if arg == "--include" {
+					options.extractionFilter.Include = append(options.extractionFilter.Include, pattern)
+				} else {
+					options.extractionFilter.Exclude = append(options.extractionFilter.Exclude, pattern)
+				}
+			case "--allow-cross-site-redirects":
+				// This is used internally to tell a job which sources the user agreed can redirect to another site
+				options.redirectingSources = append(options.redirectingSources, utils.TakeOneArg(&index, "The name of the source whose mirrors can redirect to another site"))
+			case "--job":
+				// This is used internally to run a job that was started with `--background`
+				id, err := strconv.Atoi(utils.TakeOneArg(&index, "The ID of the job"))
+				if err != nil {
+					utils.Fail("Expected the ID of a job: " + err.Error())
+				}
+				options.job, err = startRunningJob(getBentoDir(), id)
+				if err != nil {
+					utils.Fail("Failed to start job " + strconv.Itoa(id) + ": " + err.Error())
+				}
+			default:
+				sourceNames = append(sourceNames, arg)
+			}
+		}
+		if len(sourceNames) == 0 {
+			utils.Fail("Expected another argument: The name of the source to install")
+		}
+		install(getBentoDir(), sourceNames, selectedFeatures, options)
+	case "fetch":
+		sourceNames := []string{}
+		options := fetchOptions{architecture: runtime.GOARCH, operatingSystem: runtime.GOOS, outputDir: defaultFetchOutputDir}
+		for index < len(os.Args) {
+			arg := utils.TakeOneArg(&index, "")
+			switch arg {
+			case "--arch":
+				options.architecture = utils.TakeOneArg(&index, "The architecture to download the sources for, using the names that Go uses, like `amd64` or `arm64`")
+			case "--os":
+				options.operatingSystem = utils.TakeOneArg(&index, "The operating system to download the sources for, using the names that Go uses, like `linux`")
+			case "--output":
+				options.outputDir = utils.TakeOneArg(&index, "The directory to download the sources to")
+			default:
+				sourceNames = append(sourceNames, arg)
+			}
+		}
+		if len(sourceNames) == 0 {
+			utils.Fail("Expected another argument: The name of the source to fetch")
+		}
+		fetchForPlatform(getBentoDir(), sourceNames, options)
+	case "env":
+		format := "shell"
+		export := false
+		executable := []string{}
+		for index < len(os.Args) {
+			arg := utils.TakeOneArg(&index, "")
+			switch {
+			case arg == "--format":
+				format = utils.TakeOneArg(&index, "The format to print the environment in (either `shell`, `vscode`, or `jetbrains`)")
+				export = false
+			case arg == "--export":
+				format = utils.TakeOneArg(&index, "The format to export the environment in (either `dotenv`, `github`, or `shell`)")
+				export = true
+			case len(executable) < 2 && !strings.HasPrefix(arg, "--"):
+				executable = append(executable, arg)
+			default:
+				utils.Fail("Expected either `--format`, `--export`, or the name of a source followed by the name of one of its executables, but got `" + arg + "`")
+			}
+		}
+		if len(executable) == 1 {
+			utils.Fail("Expected another argument: The name of the executable of `" + executable[0] + "` to print the environment of")
+		}
+		printProjectEnvironment(getBentoDir(), executable, format, export)
+	case "daemon":
+		utils.ExpectAllArgsParsed(index)
+		runDaemon()
+	case "jobs":
+		jobsCommand(getBentoDir(), index)
+	case "hash":
+		algorithm := "sha256"
+		fileOrUrls := []string{}
+		for index < len(os.Args) {
+			arg := utils.TakeOneArg(&index, "")
+			if arg == "--algorithm" {
+				algorithm = utils.TakeOneArg(&index, "The hash algorithm to use (either `sha256`, or `sha512`)")
+			} else {
+				fileOrUrls = append(fileOrUrls, arg)
+			}
+		}
+		if len(fileOrUrls) == 0 {
+			utils.Fail("Expected the files or URLs to hash")
+		}
+		printChecksums(fileOrUrls, algorithm)
+	case "dev":
+		var sourceName, executable string
+		utils.TakeArgs(&index, []utils.Argument{
+			{Desc: "`--watch`"},
+			{Desc: "The name of the source", Value: &sourceName},
+			{Desc: "The path of the executable within the source", Value: &executable},
+		})
+		if os.Args[index-3] != "--watch" {
+			utils.Fail("Expected `--watch`, but got `" + os.Args[index-3] + "`")
+		}
+		argsToPass := []string{}
+		if index < len(os.Args) {
+			if os.Args[index] != "--" {
+				utils.Fail("Expected `--` before the arguments to pass to the executable, but got `" + os.Args[index] + "`")
+			}
+			argsToPass = os.Args[index+1:]
+		}
+		watchSource(getBentoDir(), sourceName, executable, argsToPass)
+	case "resume":
+		utils.ExpectAllArgsParsed(index)
+		resumeJournals(getBentoDir())
+	case "rollback":
+		scope := utils.TakeOneArg(&index, "What to roll back (`--transaction`, which is everything that the most recent install changed)")
+		if scope != "--transaction" {
+			utils.Fail("Expected `--transaction`, but got `" + scope + "`")
+		}
+		utils.ExpectAllArgsParsed(index)
+		rollbackLastTransaction(getBentoDir())
+	case "history":
+		filter := historyFilter{}
+		for index < len(os.Args) {
+			arg := utils.TakeOneArg(&index, "")
+			switch arg {
+			case "--source":
+				filter.source = utils.TakeOneArg(&index, "The name of the source to show the history of")
+			case "--action":
+				filter.action = utils.TakeOneArg(&index, "The action to show the history of (either `"+strings.Join(historyActions, "`, `")+"`)")
+				if !slices.Contains(historyActions, filter.action) {
+					utils.Fail("`" + filter.action + "` is not a valid action. Expected either `" + strings.Join(historyActions, "`, `") + "`")
+				}
+			case "--since":
+				since := utils.TakeOneArg(&index, "The date to show the history since, like `2024-01-31`")
+				var err error
+				filter.since, err = time.ParseInLocation(time.DateOnly, since, time.Local)
+				if err != nil {
+					utils.Fail("Expected the date to be in the format `YYYY-MM-DD`, but got `" + since + "`")
+				}
+			default:
+				utils.Fail("Expected either `--source`, `--action`, or `--since`, but got `" + arg + "`")
+			}
+		}
+		printHistory(filter)
+	case "diff":
+		var sourceName, versionA, versionB string
+		utils.TakeArgs(&index, []utils.Argument{
+			{Desc: "The name of the source", Value: &sourceName},
+			{Desc: "The version to compare from", Value: &versionA},
+			{Desc: "The version to compare to", Value: &versionB},
+		})
+		utils.ExpectAllArgsParsed(index)
+		diffSourceVersions(getBentoDir(), sourceName, versionA, versionB)
+	case "info":
+		var sourceName string
+		utils.TakeArgs(&index, []utils.Argument{{Desc: "The name of the source", Value: &sourceName}})
+		architecture := runtime.GOARCH
+		if index < len(os.Args) && os.Args[index] == "--arch" {
+			index += 1
+			architecture = utils.TakeOneArg(&index, "The architecture to resolve the source for, using the names that Go uses, like `amd64` or `arm64`")
+		}
+		utils.ExpectAllArgsParsed(index)
+		printSourceInfo(getBentoDir(), sourceName, architecture)
+	case "exec-url":
+		var fileUrl string
+		utils.TakeArgs(&index, []utils.Argument{{Desc: "The URL of the executable, or of an archive that contains the executable", Value: &fileUrl}})
+		options := execUrlOptions{}
+		argsToPass := []string{}
+		for index < len(os.Args) {
+			arg := utils.TakeOneArg(&index, "")
+			switch arg {
+			case "--sha256":
+				options.sha256 = utils.TakeOneArg(&index, "The sha256 checksum of the file at the URL")
+			case "--compression":
+				options.compression = utils.TakeOneArg(&index, "The compression format of the file at the URL")
+			case "--path":
+				options.executablePath = utils.TakeOneArg(&index, "The path of the executable in the archive")
+			case "--trace":
+				options.trace = true
+			case "--":
+				argsToPass = os.Args[index:]
+				index = len(os.Args)
+			default:
+				utils.Fail("Expected either `--sha256`, `--compression`, `--path`, `--trace`, or `--` followed by the arguments to pass to the executable, but got `" + arg + "`")
+			}
+		}
+		if options.sha256 == "" {
+			utils.Fail("Expected `--sha256` followed by the sha256 checksum of the file at the URL, so that it can be verified")
+		}
+		execUrl(getBentoDir(), fileUrl, argsToPass, options)
+	case "lint-repo":
+		repositoryDir := getBentoDir()
+		if index < len(os.Args) {
+			utils.TakeArgs(&index, []utils.Argument{{Desc: "The directory of the package repository to check", Value: &repositoryDir}})
+		}
+		utils.ExpectAllArgsParsed(index)
+		if !lintRepository(repositoryDir) {
+			os.Exit(1)
+		}
+	case "gc":
+		utils.ExpectAllArgsParsed(index)
+		collectGarbage(getBentoDir())
+	case "cache":
+		cacheCommand(getBentoDir(), index)
+	case "why":
+		var sourceName string
+		utils.TakeArgs(&index, []utils.Argument{{Desc: "The name of the source to explain", Value: &sourceName}})
+		plan := whyPlan{}
+		if index < len(os.Args) {
+			switch arg := utils.TakeOneArg(&index, ""); arg {
+			case "--exec":
+				utils.TakeArgs(&index, []utils.Argument{
+					{Desc: "The name of the source to run", Value: &plan.execSource},
+					{Desc: "The path of the executable within the source", Value: &plan.execExecutable},
+				})
+			case "--install":
+				plan.installSources = os.Args[index:]
+				index = len(os.Args)
+				if len(plan.installSources) == 0 {
+					utils.Fail("Expected another argument: The name of the source to install")
+				}
+			default:
+				utils.Fail("Expected either `--exec` or `--install`, but got `" + arg + "`")
+			}
+		}
+		utils.ExpectAllArgsParsed(index)
+		explainWhySourceIsNeeded(getBentoDir(), sourceName, plan)
+	case "completion":
+		var shell string
+		utils.TakeArgs(&index, []utils.Argument{{Desc: "The shell to print the completion script for (either `bash`, `zsh`, or `fish`)", Value: &shell}})
+		utils.ExpectAllArgsParsed(index)
+		printCompletionScript(shell)
+	case "bench":
+		// Not listed with the other subcommands, since it is only useful when working on bento itself
+		options := benchOptions{runs: 5, tolerance: 20}
+		for index < len(os.Args) {
+			switch arg := utils.TakeOneArg(&index, ""); arg {
+			case "--runs":
+				runs, err := strconv.Atoi(utils.TakeOneArg(&index, "The number of times to run each benchmark"))
+				if err != nil || runs < 1 {
+					utils.Fail("Expected `--runs` to be followed by a positive number")
+				}
+				options.runs = runs
+			case "--only":
+				name := utils.TakeOneArg(&index, "The name of a benchmark to run")
+				if !slices.ContainsFunc(benchmarks, func(bench benchmark) bool { return bench.name == name }) {
+					utils.Fail("`" + name + "` is not a benchmark. Expected one of: " + strings.Join(benchmarkNames(), ", "))
+				}
+				options.only = append(options.only, name)
+			case "--save":
+				options.savePath = utils.TakeOneArg(&index, "The file to save the results to")
+			case "--compare":
+				options.comparePath = utils.TakeOneArg(&index, "The file with the results to compare with")
+			case "--tolerance":
+				tolerance, err := strconv.ParseFloat(utils.TakeOneArg(&index, "How much slower than the results that they are compared with the benchmarks can be, in percent"), 64)
+				if err != nil || tolerance < 0 {
+					utils.Fail("Expected `--tolerance` to be followed by a percentage")
+				}
+				options.tolerance = tolerance
+			default:
+				utils.Fail("`" + arg + "` is not a valid option. Expected either `--runs`, `--only`, `--save`, `--compare`, or `--tolerance`")
+			}
+		}
+		if !runBenchmarks(options) {
+			os.Exit(1)
+		}
+	case "make-test-archive":
+		// Not listed with the other subcommands, since it is only useful when working on bento itself, or on a package
+		// repository
+		outputDir := utils.TakeOneArg(&index, "The directory to write the test archives to")
+		layoutNames, compressions := []string{}, []string{}
+		check := false
+		for index < len(os.Args) {
+			switch arg := utils.TakeOneArg(&index, ""); arg {
+			case "--layout":
+				layoutNames = append(layoutNames, utils.TakeOneArg(&index, "The name of a layout to write an archive of"))
+			case "--compression":
+				compressions = append(compressions, utils.TakeOneArg(&index, "A compression to write the archives with"))
+			case "--check":
+				check = true
+			default:
+				utils.Fail("`" + arg + "` is not a valid option. Expected either `--layout`, `--compression`, or `--check`")
+			}
+		}
+		if !makeTestArchives(outputDir, layoutNames, compressions, check) {
+			os.Exit(1)
+		}
+	case "upgrade":
+		preview := false
+		sourceNames := []string{}
+		for index < len(os.Args) {
+			if arg := utils.TakeOneArg(&index, ""); arg == "--preview" {
+				preview = true
+			} else {
+				sourceNames = append(sourceNames, arg)
+			}
+		}
+		upgradeSources(getBentoDir(), sourceNames, preview)
+	case "env-diff":
+		var sourceName, sourceExecutableRelativePath string
+		utils.TakeArgs(&index, []utils.Argument{
+			{Desc: "The name of the source", Value: &sourceName},
+			{Desc: "The path of the executable within the source", Value: &sourceExecutableRelativePath},
+		})
+		withIsolatedHome := false
+		if index < len(os.Args) {
+			if arg := utils.TakeOneArg(&index, ""); arg == "--isolate-home" {
+				withIsolatedHome = true
+			} else {
+				utils.Fail("Expected `--isolate-home`, but got `" + arg + "`")
+			}
+		}
+		utils.ExpectAllArgsParsed(index)
+		diffExecEnvironment(getBentoDir(), sourceName, sourceExecutableRelativePath, withIsolatedHome)
+	case "path-setup":
+		options := pathSetupOptions{}
+		for index < len(os.Args) {
+			switch arg := utils.TakeOneArg(&index, ""); arg {
+			case "--shell":
+				options.shell = utils.TakeOneArg(&index, "The shell to set up (either `bash`, `zsh`, or `fish`)")
+			case "--remove":
+				options.remove = true
+			case "--print":
+				options.print = true
+			default:
+				utils.Fail("Expected either `--shell`, `--remove`, or `--print`, but got `" + arg + "`")
+			}
+		}
+		setupPath(getBentoDir(), options)
+	case "__complete":
+		// Run by the completion scripts, so it is not listed with the other subcommands
+		printCompletions(getBentoDir(), os.Args[index:])
+	case "repo":
+		var action string
+		utils.TakeArgs(&index, []utils.Argument{{Desc: "The action to perform on the package repository (`status`)", Value: &action}})
+		utils.ExpectAllArgsParsed(index)
+		if action != "status" {
+			utils.Fail("`" + action + "` is not a valid action. Expected `status`")
+		}
+		printRepositoryStatus(getBentoDir())
+	case "doctor":
+		if index < len(os.Args) && os.Args[index] == "network" {
+			utils.ExpectAllArgsParsed(index + 1)
+			if !diagnoseNetwork(getBentoDir()) {
+				os.Exit(1)
+			}
+			break
+		}
+		utils.ExpectAllArgsParsed(index)
+		if !checkSystemLibraries(getBentoDir()) {
+			os.Exit(1)
+		}
+	case "exec":
+		if index+1 < len(os.Args) && os.Args[index+1] == "--list-executables" {
+			sourceName := os.Args[index]
+			utils.ExpectAllArgsParsed(index + 2)
+			listExecutables(getBentoDir(), sourceName)
+			break
+		}
+		var sourceName, sourceExecutableRelativePath, lastArg string
+		lastArgDesc := "Either `--arg` followed by an argument to pass to the " +
+			"executable, `--` followed by every argument to pass to the executable, `--trace`, `--reproducible`, `--isolate-home`, `--no-network`, `--only-declared-domains`, `--heal`, `--time`, `--ldd`, `--report-endpoints`, `--refresh` followed by a source, or the bento directory plus some characters, `/`, and some " +
+			"more characters (normally this is passed in by `/usr/bin/env`, which " +
+			"sends some arguments like [`bento`, `exec`, `SOURCE_NAME`, " +
+			"`EXECUTABLE_NAME`, `SCRIPT_PATH`, `ARG1`, ...] when bento is invoked from" +
+			"a shebang like `#!/usr/bin/env -S bento exec SOURCE_NAME EXECUTABLE_NAME`)"
+		utils.TakeArgs(&index, []utils.Argument{
+			{Desc: "The name of the source", Value: &sourceName},
+			{Desc: "The path of the executable within the source", Value: &sourceExecutableRelativePath},
+			{Desc: lastArgDesc, Value: &lastArg},
+		})
+		argsToPass := []string{}
+		options := execOptions{}
+		// The arguments after `--` are passed to the executable as they are, instead of being preceded by the path of a
+		// shim or a script, which says where the bento directory is
+		separated := false
+	parseOptions:
+		for {
+			switch lastArg {
+			case "--arg":
+				var argValue string
+				utils.TakeArgs(&index, []utils.Argument{
+					{Desc: "The value of the argument to pass to the executable", Value: &argValue},
+					{Desc: lastArgDesc, Value: &lastArg},
+				})
+				argsToPass = append(argsToPass, argValue)
+			case "--trace":
+				options.trace = true
+				utils.TakeArgs(&index, []utils.Argument{{Desc: lastArgDesc, Value: &lastArg}})
+			case "--reproducible":
+				options.reproducible = true
+				utils.TakeArgs(&index, []utils.Argument{{Desc: lastArgDesc, Value: &lastArg}})
+			case "--isolate-home":
+				options.isolateHome = true
+				utils.TakeArgs(&index, []utils.Argument{{Desc: lastArgDesc, Value: &lastArg}})
+			case "--no-network":
+				options.noNetwork = true
+				utils.TakeArgs(&index, []utils.Argument{{Desc: lastArgDesc, Value: &lastArg}})
+			case "--only-declared-domains":
+				options.onlyDeclaredDomains = true
+				utils.TakeArgs(&index, []utils.Argument{{Desc: lastArgDesc, Value: &lastArg}})
+			case "--heal":
+				options.heal = true
+				utils.TakeArgs(&index, []utils.Argument{{Desc: lastArgDesc, Value: &lastArg}})
+			case "--time":
+				options.time = true
+				utils.TakeArgs(&index, []utils.Argument{{Desc: lastArgDesc, Value: &lastArg}})
+			case "--ldd":
+				options.ldd = true
+				utils.TakeArgs(&index, []utils.Argument{{Desc: lastArgDesc, Value: &lastArg}})
+			case "--report-endpoints":
+				options.reportEndpoints = true
+				utils.TakeArgs(&index, []utils.Argument{{Desc: lastArgDesc, Value: &lastArg}})
+			case "--refresh":
+				var refreshedSource string
+				utils.TakeArgs(&index, []utils.Argument{
+					{Desc: "The name of the source to download and extract again", Value: &refreshedSource},
+					{Desc: lastArgDesc, Value: &lastArg},
+				})
+				options.refreshedSources = append(options.refreshedSources, refreshedSource)
+			case "--":
+				separated = true
+				break parseOptions
+			default:
+				break parseOptions
+			}
+		}
+		if separated {
+			argsToPass = append(argsToPass, os.Args[index:]...)
+			exec(sourceName, sourceExecutableRelativePath, getBentoDir(), argsToPass, options)
+			break
+		}
+		// For some reason argcomplete (https://github.com/kislyuk/argcomplete/) executes `bento exec SOURCE_NAME EXECUTABLE_NAME -m argcomplete._check_console_script PATH_TO_SCRIPT`, when these 4 conditions are simultaneously met:
+		// - Argcomplete is setup in the users shell using the "global completion" strategy
+		// - The user has typed the name of a script that is in their path and a space into their shell prompt
+		// - The script uses a shebang like `#!/usr/bin/env bento exec SOURCE_NAME EXECUTABLE_NAME`
+		// - The user presses tab
+		// This causes a problem if bento ignores `lastArg` and the executable EXECUTABLE_NAME runs forever when there is no user input to stdin, because then when the user presses tab to autocomplete options for the script which has a shebang:
+		// 1. The users shell executes argcomplete
+		// 2. Argcomplete executes bento with the above arguments
+		// 3. Bento would execute the executable as normal
+		// 4. The users shell would freeze because bento never exits
+		// To mitagate this, this condition is necersarry
+		if lastArg == "-m" {
+			os.Exit(1)
+		}
+		argsToPass = append(argsToPass, os.Args[index:]...)
+		exec(sourceName, sourceExecutableRelativePath, inferBentoDir(lastArg), argsToPass, options)
+	default:
+		utils.Fail("`" + subcommand + "` is not a valid subcommand. Expected either `help`, `update`, `install`, `fetch`, `exec`, `exec-url`, `dev`, `env`, `daemon`, `jobs`, `history`, `hash`, `resume`, `rollback`, `shims`, `repo`, `diff`, `info`, `why`, `lint-repo`, `gc`, `cache`, `completion`, `path-setup`, `upgrade`, `env-diff`, or `doctor`")
+	}
+}
+
+func (r *resolver) loadExecutable(sourceName string, sourceExecutableRelativePath string) (string, error) {
+	if executable, ok := r.executables[sourceName+" "+sourceExecutableRelativePath]; ok {
+		r.trace.Log("Executable `" + sourceExecutableRelativePath + "` from the source `" + sourceName + "` is already loaded")
+		return executable, nil
+	}
+	r.trace.Log("Loading executable `" + sourceExecutableRelativePath + "` from the source `" + sourceName + "`")
+
+	if overridePath, ok := r.config.ExecutableOverrides[sourceName+"/"+sourceExecutableRelativePath]; ok {
+		r.warnings = append(r.warnings, "Using `"+overridePath+"` instead of `"+sourceExecutableRelativePath+"` from the source `"+sourceName+"` because of an executable override in your bento config. Programs that depend on it may not behave the same way that they do on other systems.")
+		r.executables[sourceName+" "+sourceExecutableRelativePath] = overridePath
+		return overridePath, nil
+	}
+
+	sourceConf, err := r.loadSource(sourceName)
+	if err != nil {
+		return "", err
+	}
+	sourceExecutable := path.Join(sourceConf.path, sourceExecutableRelativePath)
+
+	executableDependencies := sourceConf.executableDependencies
+	directSharedLibraryDependencies, _ := sourceConf.directSharedLibraryDependencies[sourceExecutableRelativePath]
+	// The dependencies after these are from features, so they are optional
+	numberOfRequiredExecutableDependencies := len(executableDependencies)
+	numberOfRequiredLibraryDependencies := len(directSharedLibraryDependencies)
+	for _, featureName := range r.selectedFeatures[sourceName] {
+		feature := sourceConf.features[featureName]
+		executableDependencies = append(slices.Clip(executableDependencies), feature.ExecutableDependencies...)
+		directSharedLibraryDependencies = append(slices.Clip(directSharedLibraryDependencies), feature.DirectSharedLibraryDependencies[sourceExecutableRelativePath]...)
+	}
+
+	for index, executable := range executableDependencies {
+		r.trace.Log("Executable `" + sourceExecutableRelativePath + "` from the source `" + sourceName + "` depends on the executable `" + executable[1] + "` from the source `" + executable[0] + "`")
+		_, err := r.loadExecutable(executable[0], executable[1])
+		if err != nil {
+			return "", err
+		}
+		r.recordDependency(sourceName, executable[0], describeDependentExecutable(sourceExecutableRelativePath)+" runs `"+executable[1]+"`", index >= numberOfRequiredExecutableDependencies)
+	}
+
+	executableEnvironmentConfig, _ := sourceConf.env[sourceExecutableRelativePath]
+	for envName, envValue := range executableEnvironmentConfig {
+		replacedValue, err := utils.InterpolateStringLiteral(envValue, func(interpolation string) (string, error) {
+			if variable, isEnvironmentVariable := utils.TrimPrefix(interpolation, "env."); isEnvironmentVariable {
+				return r.hostEnvironmentVariable(variable)
+			}
+			if interpolation == "data" {
+				// A writable directory that belongs to the source, so that sources do not need to write to the home
+				// directory of the user
+				dataDir := getSourceDataDir(sourceName)
+				err := os.MkdirAll(dataDir, 0755)
+				if err != nil {
+					return "", utils.WrapError("Failed to create the data directory of `"+sourceName+"`", explainWriteError(err))
+				}
+				return dataDir, nil
+			}
+			source, err := r.loadSource(interpolation)
+			if err != nil {
+				return "", err
+			}
+			r.recordDependency(sourceName, interpolation, "the environment of "+describeDependentExecutable(sourceExecutableRelativePath)+" refers to it", false)
+			return source.path, nil
+		})
+		if err != nil {
+			return "", err
+		}
+		r.trace.Log("Interpolated `" + envValue + "` to `" + replacedValue + "` for the environment variable `" + envName + "`")
+		r.environment[envName] = replacedValue
+	}
+
+	for index, directSharedLibraryDependency := range directSharedLibraryDependencies {
+		r.trace.Log("Executable `" + sourceExecutableRelativePath + "` from the source `" + sourceName + "` depends on the library `" + directSharedLibraryDependency + "`")
+		err := r.loadLibrary(directSharedLibraryDependency)
+		if err != nil {
+			return "", err
+		}
+		r.recordLibraryDependency(sourceName, directSharedLibraryDependency, describeDependentExecutable(sourceExecutableRelativePath)+" uses the library `"+directSharedLibraryDependency+"`", index >= numberOfRequiredLibraryDependencies)
+	}
+
+	r.executables[sourceName+" "+sourceExecutableRelativePath] = sourceExecutable
+	return sourceExecutable, nil
+}
+
+// Returns the value of an environment variable on the host system for an `${env.NAME}` or `${env.NAME:-FALLBACK}`
+// interpolation. The fallback is used when the variable is unset or empty.
+func (r *resolver) hostEnvironmentVariable(interpolation string) (string, error) {
+	name, fallback, hasFallback := strings.Cut(interpolation, ":-")
+	if value := r.hostEnvironment[name]; value != "" {
+		return value, nil
+	}
+	if !hasFallback {
+		return "", errors.New("The environment variable `" + name + "` is not set. Use `${env." + name + ":-FALLBACK}` to use FALLBACK when it is not set.")
+	}
+	return fallback, nil
+}
+
+// Returns the command that runs an executable, which starts with the runner or the interpreter of the source of the
+// executable if the source has one
+func (r *resolver) resolveCommand(sourceName string, sourceExecutableRelativePath string) ([]string, error) {
+	executable, err := r.loadExecutable(sourceName, sourceExecutableRelativePath)
+	if err != nil {
+		return []string{}, err
+	}
+	sourceConf, sourceLoaded := r.sources[sourceName]
+	if sourceLoaded && len(sourceConf.interpreter) > 0 {
+		interpreter, err := r.loadInterpreter(sourceConf.interpreter, sourceName)
+		if err != nil {
+			return []string{}, err
+		}
+		return slices.Concat([]string{interpreter}, sourceConf.interpreter[2:], []string{executable}), nil
+	}
+	if !sourceLoaded || sourceConf.runner == "" {
+		return []string{executable}, nil
+	}
+	runner, err := r.loadRunner(sourceConf.runner, sourceName)
+	if err != nil {
+		return []string{}, err
+	}
+	return []string{runner, executable}, nil
+}
+
+func (r *resolver) loadRunner(runner string, sourceName string) (string, error) {
+	runnerSource, runnerExecutable, hasExecutable := strings.Cut(runner, "/")
+	if !hasExecutable {
+		runnerExecutable = "bin/" + runner
+	}
+	if path.Base(runnerExecutable) == "wine" || path.Base(runnerExecutable) == "wine64" {
+		// Give each source its own wine prefix, so that windows programs cannot interfere with each other
+		winePrefix := path.Join(getSourceDataDir(sourceName), "wine")
+		err := os.MkdirAll(winePrefix, 0755)
+		if err != nil {
+			return "", utils.WrapError("Failed to create the wine prefix for `"+sourceName+"`", err)
+		}
+		r.environment["WINEPREFIX"] = winePrefix
+	}
+	err := r.fetchRepositoryFileIfMissing(path.Join("sources", runnerSource+".toml"))
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path.Join(r.sourcesDir, runnerSource+".toml")); err == nil {
+		r.trace.Log("The source `" + sourceName + "` is run with `" + runnerExecutable + "` from the source `" + runnerSource + "`")
+		r.recordDependency(sourceName, runnerSource, "its executables are run with `"+runnerExecutable+"`", false)
+		return r.loadExecutable(runnerSource, runnerExecutable)
+	}
+	hostRunner, err := osExec.LookPath(path.Base(runnerExecutable))
+	if err != nil {
+		return "", errors.New("The executables in the source `" + sourceName + "` are run with `" + runner + "`, but there is no source called `" + runnerSource + "`, and `" + path.Base(runnerExecutable) + "` is not installed on your system")
+	}
+	r.trace.Log("The source `" + sourceName + "` is run with `" + hostRunner + "` from the system")
+	return hostRunner, nil
+}
+
+// Returns the limits on the resources that an executable can use, from its source config and the bento config of the
+// user
+func (r *resolver) resourceLimits(sourceName string, sourceExecutableRelativePath string) utils.ResourceLimits {
+	limits := r.sources[sourceName].resourceLimits[sourceExecutableRelativePath]
+	return limits.Merge(r.config.ResourceLimits[sourceName+"/"+sourceExecutableRelativePath])
+}
+
+// Returns the absolute paths that the executables of a source expect files to be at, mapped to the files in the source
+// that are mounted there
+func (r *resolver) pathMappings(sourceName string) map[string]string {
+	sourceConf := r.sources[sourceName]
+	mappings := map[string]string{}
+	for target, sourcePath := range sourceConf.fhsPaths {
+		mappings[path.Clean(target)] = path.Join(sourceConf.path, sourcePath)
+	}
+	return mappings
+}
+
+// Loads the executable that the executables of a source are passed to, from the source in its `Interpreter`
+func (r *resolver) loadInterpreter(interpreter []string, sourceName string) (string, error) {
+	r.trace.Log("The source `" + sourceName + "` is interpreted by `" + interpreter[1] + "` from the source `" + interpreter[0] + "`")
+	r.recordDependency(sourceName, interpreter[0], "its executables are interpreted by `"+interpreter[1]+"`", false)
+	executable, err := r.loadExecutable(interpreter[0], interpreter[1])
+	if err != nil {
+		return "", utils.WrapError("Failed to load the interpreter of `"+sourceName+"`", err)
+	}
+	return executable, nil
+}
+
+// Loads a source along with the dependencies of every executable in the source that has dependencies configured
+func (r *resolver) loadAllExecutables(sourceName string) error {
+	sourceConf, err := r.loadSource(sourceName)
+	if err != nil {
+		return err
+	}
+	executablePaths := utils.Collect(maps.Keys(sourceConf.env))
+	executablePaths = append(executablePaths, utils.Collect(maps.Keys(sourceConf.directSharedLibraryDependencies))...)
+	for _, featureName := range r.selectedFeatures[sourceName] {
+		executablePaths = append(executablePaths, utils.Collect(maps.Keys(sourceConf.features[featureName].DirectSharedLibraryDependencies))...)
+	}
+	if len(executablePaths) == 0 {
+		// Still load the executable dependencies of the source
+		executablePaths = append(executablePaths, "")
+	}
+	for _, executablePath := range executablePaths {
+		_, err := r.loadExecutable(sourceName, executablePath)
+		if err != nil {
+			return err
+		}
+	}
+	if len(sourceConf.interpreter) > 0 {
+		_, err := r.loadInterpreter(sourceConf.interpreter, sourceName)
+		return err
+	}
+	return nil
+}
+
+// Fetches the checksums of the artifacts of sources that are published in a checksum file next to the artifact, rather
+// than being in the source config. Returns warnings that say which checksums were fetched, since they are only as
+// trustworthy as the mirror that they were fetched from.
+func (r *resolver) fetchPublishedChecksums(sourceNames []string) ([]string, error) {
+	warnings := []string{}
+	for _, sourceName := range utils.SortedNames(slices.Values(sourceNames)) {
+		sourceConf := r.sources[sourceName]
+		artifacts := slices.Clone(sourceConf.artifacts)
+		for index, artifact := range artifacts {
+			if artifact.checksumUrls == nil {
+				continue
+			}
+			if len(artifact.checksumUrls) == 0 {
+				return warnings, errors.New("The checksum of `" + artifact.description + "` from `" + sourceName + "` cannot be fetched, since none of the mirrors of its checksum file are allowed")
+			}
+			r.trace.Log("Fetching the checksum of `" + artifact.description + "` from " + strings.Join(artifact.checksumUrls, ", "))
+			checksum, checksumUrl, err := utils.FetchPublishedChecksum(artifact.checksumUrls, artifact.description)
+			if err != nil {
+				return warnings, utils.WrapError("Failed to fetch the checksum of `"+artifact.description+"` from `"+sourceName+"`", err)
+			}
+			artifacts[index].parsedChecksum = checksum
+			artifacts[index].checksumUrls = nil
+			warnings = append(warnings, "The checksum of `"+artifact.description+"` from `"+sourceName+"` was fetched from `"+checksumUrl+"` instead of being in its source config, so the download is only verified against that mirror")
+		}
+		sourceConf.artifacts = artifacts
+		r.sources[sourceName] = sourceConf
+	}
+	return warnings, nil
+}
+
+// Asks the user whether they want to download the sources that are not downloaded yet, and downloads them if they do.
+// Returns false if the user declined.
+func downloadMissingSources(r *resolver, requestedSources []string, reason string, options installOptions) bool {
+	bentoDir := r.bentoDir
+	sources := r.sources
+	sourcesToDownload := []string{}
+	for _, refreshedSource := range options.refreshedSources {
+		sourceConf, loaded := sources[refreshedSource]
+		if !loaded {
+			utils.Fail("`" + refreshedSource + "` cannot be refreshed because it is not needed by " + strings.Join(requestedSources, ", "))
+		}
+		if !strings.HasPrefix(sourceConf.path, r.installedSourcesDir+"/") {
+			utils.Fail("`" + refreshedSource + "` cannot be refreshed because it is installed in a system store at `" + sourceConf.path + "`")
+		}
+	}
+	for sourceName, sourceConf := range sources {
+		_, err := os.Stat(sourceConf.path)
+		if os.IsNotExist(err) || (err == nil && slices.Contains(options.refreshedSources, sourceName)) {
+			sourcesToDownload = append(sourcesToDownload, sourceName)
+		} else if err != nil {
+			utils.Fail("Failed to stat `" + sourceConf.path + "`: " + err.Error())
+		}
+	}
+	for _, skippedSource := range options.skippedSources {
+		if reason, required := r.explainRequiredSource(requestedSources, skippedSource); required {
+			utils.Fail("`" + skippedSource + "` cannot be skipped because " + reason)
+		}
+	}
+	sourcesToDownload = slices.DeleteFunc(sourcesToDownload, func(sourceName string) bool {
+		return slices.Contains(options.skippedSources, sourceName)
+	})
+	if options.reportEndpoints {
+		printEndpoints(r, sourcesToDownload)
+		return false
+	}
+	if len(sourcesToDownload) == 0 {
+		return true
+	}
+
+	redirectingSources, allowed := checkSourcePolicy(r, sourcesToDownload, reason, options.job != nil, options.redirectingSources)
+	if !allowed {
+		return false
+	}
+	checksumWarnings, err := r.fetchPublishedChecksums(sourcesToDownload)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	printWarnings(checksumWarnings)
+	trustedSources, err := readTrustedSources()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	var reportProgress func(progress []string)
+	newlySkippedSources := []string{}
+	if options.job != nil {
+		// The user was already asked before the job was started
+		reportProgress = options.job.reportProgress
+	} else if sourcesAreTrusted(trustedSources, sources, sourcesToDownload) {
+		recordConsent(sources, sourcesToDownload, consentPreviouslyApproved, reason)
+		println("Downloading " + utils.CreateNoun(len(sourcesToDownload), "a source", "sources") + " that you have already approved " + reason)
+	} else {
+		numberedSources := printDownloadConsentPrompt(sources, sourcesToDownload, reason)
+		var approved bool
+		newlySkippedSources, approved = askWhichSourcesToSkip(r, requestedSources, numberedSources)
+		if !approved {
+			recordConsent(sources, sourcesToDownload, consentDeclined, reason)
+			return false
+		}
+		recordConsent(sources, newlySkippedSources, consentSkipped, reason)
+		sourcesToDownload = slices.DeleteFunc(sourcesToDownload, func(sourceName string) bool {
+			return slices.Contains(newlySkippedSources, sourceName)
+		})
+		recordConsent(sources, sourcesToDownload, consentApproved, reason)
+		err := recordTrustedSources(trustedSources, sources, sourcesToDownload)
+		if err != nil {
+			println("Failed to record that you approved the sources: " + err.Error())
+		}
+	}
+	if options.background && len(sourcesToDownload) > 0 {
+		args := slices.DeleteFunc(slices.Clone(os.Args[2:]), func(arg string) bool { return arg == "--background" })
+		for _, skippedSource := range newlySkippedSources {
+			args = append(args, "--skip", skippedSource)
+		}
+		for _, redirectingSource := range redirectingSources {
+			args = append(args, "--allow-cross-site-redirects", redirectingSource)
+		}
+		id, err := startInstallJob(getBentoDir(), args)
+		if err != nil {
+			utils.Fail("Failed to start job: " + err.Error())
+		}
+		println("Started job " + strconv.Itoa(id) + ". Run `bento jobs` to see its progress, or `bento jobs wait` to wait for it to finish.")
+		return false
+	}
+
+	journal := planDownloads(r, requestedSources, sourcesToDownload, reason, options)
+	journalLock, _, err := lockJournal(bentoDir, journal.Transaction)
+	if err != nil {
+		utils.Fail("Failed to lock the journal of the downloads: " + err.Error())
+	}
+	defer journalLock.Close()
+	removed, _, err := removeStaleTemporaryFiles(bentoDir, staleTemporaryFileAge)
+	if err != nil {
+		println("Failed to remove stale temporary files: " + err.Error())
+	} else if len(removed) > 0 {
+		println("Removed " + utils.CreateNoun(len(removed), "a stale temporary file", "stale temporary files") + " left behind by a bento process that crashed")
+	}
+	lock, _, err := lockInstalledSources(bentoDir, false, true)
+	if err != nil {
+		utils.Fail("Failed to lock the installed sources: " + err.Error())
+	}
+	defer lock.Close()
+	started := time.Now()
+	endDownloads := r.trace.Measure(downloadTiming)
+	succeeded := finishJournal(bentoDir, &journal, reportProgress)
+	endDownloads()
+	notifyDownloadsFinished(r.config, started, sourcesToDownload, succeeded)
+	if !succeeded {
+		if options.job != nil {
+			options.job.finish(false)
+		}
+		if options.returnOnFailure {
+			return false
+		}
+		os.Exit(1)
+	}
+	return true
+}
+
+// Links files in a newly installed version of a source that are identical to files in the other installed versions of
+// the source, so that keeping several versions of a source installed does not use much more space than one version.
+// The version that the new tree will be installed to is skipped, since it is only installed when it is being refreshed,
+// in which case its files may be corrupted.
+func deduplicateSourceVersions(temporaryPath string, sourcePath string) {
+	entries, err := os.ReadDir(path.Dir(sourcePath))
+	if err != nil {
+		utils.Fail("Failed to read the installed versions of `" + path.Base(path.Dir(sourcePath)) + "`: " + err.Error())
+	}
+	otherVersions := []string{}
+	for _, entry := range entries {
+		// Names that start with `.` are temporary directories that sources are being extracted into
+		if entry.Name() != path.Base(sourcePath) && !strings.HasPrefix(entry.Name(), ".") {
+			otherVersions = append(otherVersions, path.Join(path.Dir(sourcePath), entry.Name()))
+		}
+	}
+	if len(otherVersions) == 0 {
+		return
+	}
+	bytesSaved, err := utils.DeduplicateTree(temporaryPath, otherVersions)
+	if err != nil {
+		utils.Fail("Failed to deduplicate `" + temporaryPath + "`: " + err.Error())
+	}
+	if bytesSaved > 0 {
+		println("Saved " + utils.FormatSize(bytesSaved) + " by sharing files between versions of `" + path.Base(path.Dir(sourcePath)) + "`")
+	}
+}
+
+// Adds the files of a source that was extracted to `temporaryPath` to the content-addressed store, which shares them
+// with every other source that has the same files. Failing to add them only wastes space, so it is not fatal.
+func addSourceToContentStore(bentoDir string, temporaryPath string, sourcePath string) {
+	bytesSaved, err := utils.AddTreeToContentStore(temporaryPath, path.Join(bentoDir, contentStoreDirName))
+	if err != nil {
+		println("Failed to add `" + sourcePath + "` to the content-addressed store: " + err.Error())
+	}
+	if bytesSaved > 0 {
+		println("Saved " + utils.FormatSize(bytesSaved) + " by sharing the files of `" + path.Base(path.Dir(sourcePath)) + "` with other sources")
+	}
+}
+
+// Removes the files in the content-addressed store that no installed source uses anymore. The installed sources must be
+// locked exclusively, so that no source is being added to the store.
+func pruneContentStore(bentoDir string) int64 {
+	bytesFreed, err := utils.PruneContentStore(path.Join(bentoDir, contentStoreDirName))
+	if err != nil {
+		utils.Fail("Failed to prune the content-addressed store: " + err.Error())
+	}
+	return bytesFreed
+}
+
+const installedFeaturesFileName = "installedFeatures.toml"
+
+// Reads the features that the user has chosen to install for each source
+func readInstalledFeatures(bentoDir string) (map[string][]string, error) {
+	installedFeatures := map[string][]string{}
+	_, err := toml.DecodeFile(path.Join(bentoDir, installedFeaturesFileName), &installedFeatures)
+	if err != nil && !os.IsNotExist(err) {
+		return installedFeatures, utils.WrapError("Failed to read installed features", err)
+	}
+	return installedFeatures, nil
+}
+
+// Saves the features that the user has chosen to install, and records the change in the history if they changed
+func saveInstalledFeatures(bentoDir string, previousFeatures map[string][]string, installedFeatures map[string][]string) {
+	if maps.EqualFunc(previousFeatures, installedFeatures, slices.Equal) {
+		return
+	}
+	recordHistory(historyRecord{Action: historyFeatures, PreviousFeatures: previousFeatures})
+	err := writeInstalledFeatures(bentoDir, installedFeatures)
+	if err != nil {
+		utils.Fail("Failed to save installed features: " + err.Error())
+	}
+}
+
+func writeInstalledFeatures(bentoDir string, installedFeatures map[string][]string) error {
+	file, err := os.Create(path.Join(bentoDir, installedFeaturesFileName))
+	if err != nil {
+		return explainWriteError(err)
+	}
+	defer file.Close()
+	return toml.NewEncoder(file).Encode(installedFeatures)
+}
+
+// Options that change how sources are installed
+type installOptions struct {
+	// Normalize the modification times and permissions of extracted files, so that the same archive always produces
+	// an identical tree
+	reproducible bool
+	// Download the sources in a background job after asking the user whether to download them
+	background bool
+	// The job that the current process is running, which is nil if the current process is not running a job
+	job *jobReporter
+	// Sources that are only needed by optional features, and which the user chose not to download
+	skippedSources []string
+	// Sources that the user agreed can be downloaded from mirrors that redirect to another site, when the policy of the
+	// project requires them to be confirmed
+	redirectingSources []string
+	// Glob patterns of paths that are extracted, and that are not extracted, from every source that is downloaded, in
+	// addition to the patterns in the source configs
+	extractionFilter utils.ExtractionFilter
+	// The features to save once the sources are installed, along with the features that were installed before, so
+	// that `bento resume` can save them if bento is killed while downloading. They are nil if the features are not
+	// changed.
+	installedFeatures map[string][]string
+	previousFeatures  map[string][]string
+	// Sources that are downloaded and extracted again even though they are installed, which replace the installed
+	// trees once they are verified
+	refreshedSources []string
+	// Print the hosts that downloading the sources would contact instead of downloading them
+	reportEndpoints bool
+	// Return false when a download fails instead of exiting, so that `bento dev --watch` can keep watching
+	returnOnFailure bool
+}
+
+func install(bentoDir string, sourceNames []string, selectedFeatures map[string][]string, options installOptions) {
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	previousFeatures := maps.Clone(installedFeatures)
+	for sourceName, features := range selectedFeatures {
+		for _, feature := range features {
+			if !slices.Contains(installedFeatures[sourceName], feature) {
+				installedFeatures[sourceName] = append(installedFeatures[sourceName], feature)
+			}
+		}
+	}
+
+	r := newResolver(bentoDir, map[string]string{}, installedFeatures, config)
+	r.requestedFeatures = selectedFeatures
+	hideProgress := r.showProgress(false)
+	requestedNames := sourceNames
+	sourceNames, bundles, err := r.expandBundles(sourceNames)
+	if err != nil {
+		hideProgress()
+		utils.Fail(err.Error())
+	}
+	for _, bundle := range bundles {
+		if len(selectedFeatures[bundle]) > 0 {
+			hideProgress()
+			utils.Fail("`" + bundle + "` is a bundle, so it does not have any features. Use `--with` after one of its members instead.")
+		}
+	}
+	for _, sourceName := range sourceNames {
+		err := r.loadAllExecutables(sourceName)
+		if err != nil {
+			hideProgress()
+			utils.Fail(err.Error())
+		}
+	}
+	hideProgress()
+	for _, sourceName := range sourceNames {
+		if replacement := r.sources[sourceName].deprecatedInFavorOf; replacement != "" {
+			r.warnings = append(r.warnings, deprecationWarning(sourceName, replacement))
+		}
+	}
+	printWarnings(r.warnings)
+
+	if !maps.EqualFunc(previousFeatures, installedFeatures, slices.Equal) {
+		options.installedFeatures = installedFeatures
+		options.previousFeatures = previousFeatures
+	}
+	reason := "to install " + utils.CreateNoun(len(sourceNames), "the source "+sourceNames[0], "sources")
+	if len(requestedNames) == 1 && slices.Contains(bundles, requestedNames[0]) {
+		reason = "to install the bundle " + requestedNames[0]
+	}
+	if !downloadMissingSources(r, sourceNames, reason, options) {
+		return
+	}
+	saveInstalledFeatures(bentoDir, previousFeatures, installedFeatures)
+	if options.job != nil {
+		options.job.finish(true)
+	}
+}
+
+type execOptions struct {
+	installOptions
+	// Log the steps taken to resolve the executable, and how long each step took
+	trace bool
+	// Point `HOME` and the XDG base directories at a directory that is private to the source
+	isolateHome bool
+	// Run the executable in a network namespace that only has a loopback interface
+	noNetwork bool
+	// Download the source again if its files were modified since it was installed
+	heal bool
+	// Print how long each step of running the executable took, and record it in the history
+	time bool
+	// Print the file that every shared library of the executable is loaded from, instead of running it
+	ldd bool
+	// Only let the executable contact the domains that its source declares in `ContactedDomains`
+	onlyDeclaredDomains bool
+}
+
+// Makes an executable use a home directory in the data directory of its source instead of the home directory of the
+// user, so that trying out a source does not leave files in the home directory of the user
+func isolateHome(environment map[string]string, sourceName string, trace *utils.Tracer) {
+	home := path.Join(getSourceDataDir(sourceName), "home")
+	directories := map[string]string{
+		"HOME":            home,
+		"XDG_CONFIG_HOME": path.Join(home, ".config"),
+		"XDG_CACHE_HOME":  path.Join(home, ".cache"),
+		"XDG_DATA_HOME":   path.Join(home, ".local", "share"),
+		"XDG_STATE_HOME":  path.Join(home, ".local", "state"),
+	}
+	for name, directory := range directories {
+		err := os.MkdirAll(directory, 0700)
+		if err != nil {
+			utils.Fail("Failed to create `" + directory + "`: " + explainWriteError(err).Error())
+		}
+		environment[name] = directory
+	}
+	trace.Log("Using " + home + " as the home directory of `" + sourceName + "`")
+}
+
+func exec(sourceName string, sourceExecutableRelativePath string, bentoDir string, argsToPass []string, options execOptions) {
+	trace := utils.NewTracer(options.trace)
+	executableEnvironment := environmentToMap(os.Environ())
+	if options.isolateHome {
+		isolateHome(executableEnvironment, sourceName, trace)
+	}
+
+	var beforeExec func()
+	if options.time {
+		beforeExec = func() { reportTimings(trace, sourceName, sourceExecutableRelativePath) }
+	}
+
+	// Tracing, refreshing, healing, reporting endpoints, finding libraries, and limiting the domains that an executable
+	// contacts are done by this process, so the daemon is not used for them
+	if !options.trace && len(options.refreshedSources) == 0 && !options.heal && !options.reportEndpoints && !options.ldd && !options.onlyDeclaredDomains {
+		endResolution := trace.Measure(resolutionTiming)
+		response, err := requestResolutionFromDaemon(daemonRequest{BentoDir: bentoDir, Source: sourceName, Executable: sourceExecutableRelativePath, Environment: environmentToMap(os.Environ())})
+		endResolution()
+		if err == nil && response.Error == "" && response.AllSourcesDownloaded {
+			printWarnings(response.Warnings)
+			if options.noNetwork && response.NeedsNetwork {
+				printWarnings([]string{noNetworkWarning(sourceName)})
+			}
+			maps.Copy(executableEnvironment, response.Environment)
+			var crashContext *crashReportContext
+			if response.CrashReports {
+				crashContext = &crashReportContext{source: sourceName, version: response.Version, executable: sourceExecutableRelativePath, libraryPaths: response.LibraryPaths}
+			}
+			executeResolvedCommand(response.Command, argsToPass, executableEnvironment, response.LibraryPaths, response.ResourceLimits, response.PathMappings, options.noNetwork, nil, crashContext, trace, beforeExec)
+		}
+	}
+
+	endPhase := trace.StartPhase("resolving `" + sourceExecutableRelativePath + "` from the source `" + sourceName + "`")
+	endResolution := trace.Measure(resolutionTiming)
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	r := newResolver(bentoDir, executableEnvironment, installedFeatures, config)
+	r.trace = trace
+	hideProgress := r.showProgress(options.trace)
+	command, err := r.resolveCommand(sourceName, sourceExecutableRelativePath)
+	hideProgress()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	if options.noNetwork && r.sources[sourceName].needsNetwork {
+		r.warnings = append(r.warnings, noNetworkWarning(sourceName))
+	}
+	if !options.heal {
+		r.checkSourceIntegrity(sourceName)
+	} else if modifiedFiles := r.findModifiedFiles(sourceName); len(modifiedFiles) > 0 {
+		println("Downloading `" + sourceName + "` again to restore " + utils.CreateNoun(len(modifiedFiles), "a file that was", "files that were") + " modified since it was installed")
+		options.refreshedSources = append(options.refreshedSources, sourceName)
+	}
+	endResolution()
+	printWarnings(r.warnings)
+	endPhase()
+
+	endPhase = trace.StartPhase("downloading missing sources")
+	if !downloadMissingSources(r, []string{sourceName}, "to run the binary "+sourceExecutableRelativePath+" from the source "+sourceName, options.installOptions) {
+		return
+	}
+	endPhase()
+
+	if _, err := os.Stat(command[len(command)-1]); os.IsNotExist(err) {
+		utils.Fail("There is no `" + sourceExecutableRelativePath + "` in the source `" + sourceName + "`. Run `bento exec " + sourceName + " --list-executables` to see the executables that it has.")
+	}
+	if options.ldd {
+		if !printLoadedLibraries(r, command, executableEnvironment) {
+			os.Exit(1)
+		}
+		return
+	}
+	var crashContext *crashReportContext
+	if config.CrashReports {
+		crashContext = &crashReportContext{source: sourceName, version: r.sources[sourceName].version, executable: sourceExecutableRelativePath, libraryPaths: r.libraryPaths()}
+	}
+	var domainProxy *utils.DomainProxy
+	// Without network access, the executable cannot contact any domain, including the proxy
+	if options.onlyDeclaredDomains && !options.noNetwork {
+		domainProxy = limitToContactedDomains(executableEnvironment, sourceName, r.sources[sourceName], trace)
+	}
+	executeResolvedCommand(command, argsToPass, executableEnvironment, r.libraryPaths(), r.resourceLimits(sourceName, sourceExecutableRelativePath), r.pathMappings(sourceName), options.noNetwork, domainProxy, crashContext, trace, beforeExec)
+}
+
+func noNetworkWarning(sourceName string) string {
+	return "`" + sourceName + "` needs network access, so it may not work without it"
+}
+
+func deprecationWarning(sourceName string, replacement string) string {
+	return "The source `" + sourceName + "` is deprecated. Install `" + replacement + "` instead with `bento install " + replacement + "`."
+}
+
+func printWarnings(warnings []string) {
+	for _, warning := range warnings {
+		println(utils.AnsiFgYellow + "Warning: " + warning + utils.AnsiReset)
+	}
+}
+
+func environmentToMap(environment []string) map[string]string {
+	environmentMap := map[string]string{}
+	for _, environmentVariable := range environment {
+		name, value, _ := strings.Cut(environmentVariable, "=")
+		environmentMap[name] = value
+	}
+	return environmentMap
+}
+
+// Returns the directories of the libraries that have been loaded, without duplicates
+func (r *resolver) libraryPaths() []string {
+	// Use a hash map to de-duplicate libraries with the same path
+	librariesPathsMap := map[string]struct{}{}
+	for _, library := range r.libraries {
+		librariesPathsMap[library.absoluteDirectory] = struct{}{}
+	}
+	return utils.Collect(maps.Keys(librariesPathsMap))
+}
+
+// Returns the environment that a resolved command is run with, in the format that `os.Environ` uses
+func commandEnvironment(executableEnvironment map[string]string, libraryPaths []string, trace *utils.Tracer) []string {
+	executableEnvironment["LD_LIBRARY_PATH"] = strings.Join(libraryPaths, ":")
+	trace.Log("Set `LD_LIBRARY_PATH` to `" + executableEnvironment["LD_LIBRARY_PATH"] + "`")
+
+	executableEnv := make([]string, 0, len(executableEnvironment))
+	for key, value := range executableEnvironment {
+		executableEnv = append(executableEnv, key+"="+value)
+	}
+	return executableEnv
+}
+
+// Replaces bento with a command. `beforeExec` is called just before the command is run, if it is not nil. When
+// `crashContext` or `domainProxy` is not nil, or there are paths to mount, the command is run as a child of bento
+// instead, so that a crash report can be saved if it crashes, so that the proxy keeps running, or so that it can be run
+// in a new namespace.
+func executeResolvedCommand(command []string, argsToPass []string, executableEnvironment map[string]string, libraryPaths []string, limits utils.ResourceLimits, pathMappings map[string]string, withoutNetwork bool, domainProxy *utils.DomainProxy, crashContext *crashReportContext, trace *utils.Tracer, beforeExec func()) {
+	endHandoff := trace.Measure(handoffTiming)
+	executableEnv := commandEnvironment(executableEnvironment, libraryPaths, trace)
+	endHandoff()
+	if beforeExec != nil {
+		beforeExec()
+	}
+	if !limits.IsEmpty() {
+		trace.Log("Applying the resource limits of `" + command[len(command)-1] + "`")
+		err := utils.ApplyResourceLimits(limits)
+		if err != nil {
+			utils.Fail("Failed to apply the resource limits of `" + command[len(command)-1] + "`: " + err.Error())
+		}
+	}
+	if withoutNetwork || domainProxy != nil || crashContext != nil || len(pathMappings) > 0 {
+		// The process cannot be replaced with the command, because the command has to be started in a new namespace, or
+		// bento has to find out whether it crashed
+		options := utils.RunOptions{WithoutNetwork: withoutNetwork, AllowCoreDumps: crashContext != nil, PathMappings: pathMappings}
+		for _, target := range slices.Sorted(maps.Keys(pathMappings)) {
+			trace.Log("Mounting `" + pathMappings[target] + "` at `" + target + "`")
+		}
+		if withoutNetwork {
+			trace.Log("Executing `" + strings.Join(command, " ") + "` without network access")
+		} else if domainProxy != nil {
+			trace.Log("Executing `" + strings.Join(command, " ") + "` as a child of bento, so that the proxy keeps running")
+		} else {
+			trace.Log("Executing `" + strings.Join(command, " ") + "` as a child of bento, so that it can be reported if it crashes")
+		}
+		result, err := utils.RunCommand(append(slices.Clone(command), argsToPass...), executableEnv, options)
+		if err != nil && withoutNetwork {
+			utils.Fail("Failed to execute binary `" + command[0] + "` without network access: " + err.Error())
+		} else if err != nil {
+			utils.Fail("Failed to execute binary `" + command[0] + "`: " + err.Error())
+		}
+		if domainProxy != nil {
+			reportBlockedDomains(domainProxy)
+		}
+		if crashContext != nil {
+			crashContext.recordIfCrashed(command, result)
+		}
+		os.Exit(result.ExitCode)
 	}
-	err = syscall.Exec(sourceExecutable, append([]string{sourceExecutable}, argsToPass...), executableEnv)
+	trace.Log("Executing `" + strings.Join(command, " ") + "`")
+	err := syscall.Exec(command[0], append(slices.Clone(command), argsToPass...), executableEnv)
 	if err != nil {
-		utils.Fail("Failed to execute binary `" + sourceExecutable + "`: " + err.Error())
+		utils.Fail("Failed to execute binary `" + command[0] + "`: " + err.Error())
 	}
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+// Runs the same benchmarks as `bento bench`, so that they can be compared with `benchstat` too
+func BenchmarkBento(b *testing.B) {
+	for _, variable := range benchmarkIsolatedVariables {
+		b.Setenv(variable, b.TempDir())
+	}
+	for _, bench := range benchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			run, err := bench.prepare(b.TempDir(), b.Cleanup)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for b.Loop() {
+				err := run()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
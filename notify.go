@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	osExec "os/exec"
+	"strings"
+	"time"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// Tells the user that downloads which took longer than `NotifyAfter` finished, so that they notice even if they
+// switched to another window while waiting. A desktop notification is sent with `notify-send` when it is installed, and
+// the terminal bell is rung when it is not.
+func notifyDownloadsFinished(config userConfig, started time.Time, sourceNames []string, succeeded bool) {
+	threshold, enabled := config.notificationThreshold()
+	duration := time.Since(started)
+	if !enabled || duration < threshold {
+		return
+	}
+	utils.SortNames(sourceNames)
+	summary := "Finished downloading " + utils.CreateNoun(len(sourceNames), sourceNames[0], "sources")
+	urgency := "normal"
+	if !succeeded {
+		summary = "Failed to download " + utils.CreateNoun(len(sourceNames), sourceNames[0], "sources")
+		urgency = "critical"
+	}
+	body := "Downloading " + strings.Join(sourceNames, ", ") + " took " + duration.Round(time.Second).String()
+	if notifySend, err := osExec.LookPath("notify-send"); err == nil {
+		if osExec.Command(notifySend, "--app-name=bento", "--urgency="+urgency, summary, body).Run() == nil {
+			return
+		}
+	}
+	// Background jobs write to a log file, which the bell would not be heard from
+	if utils.IsTerminal(os.Stderr) {
+		os.Stderr.WriteString("\a")
+	}
+}
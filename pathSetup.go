@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// The lines that `bento path-setup` adds to a shell config are between these, so that they can be found and removed
+const (
+	pathSetupStart = "# Added by `bento path-setup`, and removed by `bento path-setup --remove`"
+	pathSetupEnd   = "# End of the lines added by `bento path-setup`"
+)
+
+type pathSetupOptions struct {
+	// The shell to set up, which is detected from `SHELL` when it is empty
+	shell  string
+	remove bool
+	// Print the lines to add instead of adding them
+	print bool
+}
+
+// Returns the shell that the user uses, from `SHELL`
+func detectShell() string {
+	shell := path.Base(os.Getenv("SHELL"))
+	if shell == "." || shell == "/" {
+		return ""
+	}
+	return shell
+}
+
+// Returns the config of a shell that bento adds the shims directory to the `PATH` in. Fish reads every file in its
+// `conf.d` directory, so bento has a file of its own there instead of changing the config of the user.
+func shellConfigPath(shell string) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", utils.WrapError("Failed to get home directory", err)
+	}
+	switch shell {
+	case "bash":
+		return path.Join(homeDir, ".bashrc"), nil
+	case "zsh":
+		if zdotdir := os.Getenv("ZDOTDIR"); zdotdir != "" {
+			return path.Join(zdotdir, ".zshrc"), nil
+		}
+		return path.Join(homeDir, ".zshrc"), nil
+	case "fish":
+		configHome := os.Getenv("XDG_CONFIG_HOME")
+		if configHome == "" {
+			configHome = path.Join(homeDir, ".config")
+		}
+		return path.Join(configHome, "fish", "conf.d", "bento.fish"), nil
+	}
+	return "", errors.New("Expected the shell to be either `bash`, `zsh`, or `fish`, but got `" + shell + "`")
+}
+
+// Returns `dir` with the home directory replaced by `$HOME`, so that the shell config still works if the home
+// directory moves
+func pathFromHome(dir string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return dir
+	}
+	if relativePath, inHome := utils.TrimPrefix(dir, homeDir+"/"); inHome {
+		return "$HOME/" + relativePath
+	}
+	return dir
+}
+
+// Returns the lines that add the shims directory to the `PATH` in a shell
+func pathSetupLines(shell string, shimsDir string) []string {
+	line := "export PATH=\"" + pathFromHome(shimsDir) + ":$PATH\""
+	if shell == "fish" {
+		line = "set -gx PATH \"" + pathFromHome(shimsDir) + "\" $PATH"
+	}
+	return []string{pathSetupStart, line, pathSetupEnd}
+}
+
+// Returns the lines of a shell config without the lines that `bento path-setup` added to it, and whether there were any
+func removePathSetupLines(lines []string) ([]string, bool) {
+	start := slices.Index(lines, pathSetupStart)
+	if start < 0 {
+		return lines, false
+	}
+	end := slices.Index(lines[start:], pathSetupEnd)
+	if end < 0 {
+		// Only the line after the start was added when the end is missing
+		end = min(1, len(lines)-start-1)
+	}
+	end += start
+	// The empty line that separates the added lines from the rest of the config was added too
+	if start > 0 && lines[start-1] == "" {
+		start -= 1
+	}
+	return slices.Delete(slices.Clone(lines), start, end+1), true
+}
+
+func readShellConfig(configPath string) ([]string, error) {
+	contents, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n"), nil
+}
+
+// Adds the shims directory to the `PATH` in the config of a shell, unless it was already added. Returns false if it
+// was already added.
+func addPathSetup(shell string, configPath string, shimsDir string) (bool, error) {
+	lines, err := readShellConfig(configPath)
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(lines, pathSetupStart) {
+		return false, nil
+	}
+	if len(lines) > 0 && lines[len(lines)-1] != "" {
+		lines = append(lines, "")
+	}
+	lines = append(lines, pathSetupLines(shell, shimsDir)...)
+	err = os.MkdirAll(path.Dir(configPath), 0755)
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(configPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// Removes the lines that `bento path-setup` added to the config of a shell. Returns false if there were none.
+func removePathSetup(shell string, configPath string) (bool, error) {
+	lines, err := readShellConfig(configPath)
+	if err != nil {
+		return false, err
+	}
+	lines, removed := removePathSetupLines(lines)
+	if !removed {
+		return false, nil
+	}
+	if shell == "fish" && len(lines) == 0 {
+		return true, os.Remove(configPath)
+	}
+	return true, os.WriteFile(configPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// Adds the shims directory to the `PATH` in the config of the shell of the user, removes it, or prints the lines that
+// would add it
+func setupPath(bentoDir string, options pathSetupOptions) {
+	if options.shell == "" {
+		options.shell = detectShell()
+	}
+	shimsDir := getShimsDir(bentoDir)
+	configPath, err := shellConfigPath(options.shell)
+	if err != nil {
+		utils.Fail(err.Error() + ". Choose the shell with `--shell`.")
+	}
+	if options.print {
+		println("Add these lines to `" + configPath + "`:")
+		os.Stdout.WriteString(strings.Join(pathSetupLines(options.shell, shimsDir), "\n") + "\n")
+		return
+	}
+	if options.remove {
+		removed, err := removePathSetup(options.shell, configPath)
+		if err != nil {
+			utils.Fail("Failed to update `" + configPath + "`: " + err.Error())
+		}
+		if !removed {
+			println("`" + configPath + "` does not have any lines that `bento path-setup` added")
+			return
+		}
+		println("Removed `" + shimsDir + "` from your PATH in `" + configPath + "`. Restart your shell for it to take effect.")
+		return
+	}
+	added, err := addPathSetup(options.shell, configPath, shimsDir)
+	if err != nil {
+		utils.Fail("Failed to update `" + configPath + "`: " + err.Error())
+	}
+	if !added {
+		println("`" + configPath + "` already adds `" + shimsDir + "` to your PATH")
+		return
+	}
+	println("Added `" + shimsDir + "` to your PATH in `" + configPath + "`. Restart your shell for it to take effect.")
+}
+
+// Offers to add the shims directory to the `PATH` the first time that it is created, if it is not in the `PATH`
+// already, or says how to add it when the user cannot be asked
+func offerPathSetup(shimsDir string) {
+	if slices.Contains(filepath.SplitList(os.Getenv("PATH")), shimsDir) {
+		return
+	}
+	shell := detectShell()
+	configPath, err := shellConfigPath(shell)
+	if lines, err := readShellConfig(configPath); err == nil && slices.Contains(lines, pathSetupStart) {
+		// The shell has not been restarted since the shims directory was added to its config
+		return
+	}
+	if err != nil || !utils.IsTerminal(os.Stdin) {
+		println("`" + shimsDir + "` is not in your PATH, so the shims in it will not be found. Run `bento path-setup` to add it to the config of your shell, or `bento path-setup --print` to see the lines to add yourself.")
+		return
+	}
+	println("`" + shimsDir + "` is not in your PATH, so the shims in it will not be found. Add it to your PATH in `" + configPath + "`?")
+	if !utils.GetBoolDefaultYes() {
+		println("Run `bento path-setup` to add it later")
+		return
+	}
+	_, err = addPathSetup(shell, configPath, shimsDir)
+	if err != nil {
+		println("Failed to update `" + configPath + "`: " + err.Error())
+		return
+	}
+	println("Added `" + shimsDir + "` to your PATH in `" + configPath + "`. Restart your shell for it to take effect.")
+}
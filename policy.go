@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The file that a team can commit to a project to limit what bento downloads in it. It is found in the same way as
+// `bento.toml`, so it applies to every directory inside the project.
+const policyFileName = "bento-policy.toml"
+
+// Limits on what bento downloads, from a `bento-policy.toml`. Lists that are not set do not limit anything.
+type sourcePolicy struct {
+	// The names of the sources that can be downloaded
+	AllowedSources []string
+	// The licenses that sources can be downloaded under, like `MIT`. Every license of a source must be allowed, and
+	// sources with an unknown license are outside of the policy when this is set.
+	AllowedLicenses []string
+	// The domains that sources can be downloaded from, like `example.com`, which allows its subdomains too. Mirrors on
+	// other domains are not used, and sources that only have mirrors on other domains are outside of the policy.
+	AllowedMirrorDomains []string
+	// Refuses sources that run scripts when they are installed. Bento never runs scripts while installing sources, so
+	// every source follows this, but a policy can require it so that it is checked if that ever changes.
+	ForbidInstallScripts bool
+	// Follows the redirects of every mirror before anything is downloaded, and treats mirrors that redirect to a host on
+	// another site as outside of the policy, so that the user has to confirm them. Bento always warns about these
+	// redirects while downloading, but by then the download has already started. Mirrors that redirect to another site
+	// while downloading, without the user confirming it, are not used.
+	ConfirmCrossSiteRedirects bool
+}
+
+// Returns the policy of the project in the current directory, along with the path of its `bento-policy.toml`, which is
+// empty if there is no policy
+func loadSourcePolicy() (sourcePolicy, string, error) {
+	var policy sourcePolicy
+	policyPath, found := findProjectFile(policyFileName)
+	if !found {
+		return policy, "", nil
+	}
+	_, err := toml.DecodeFile(policyPath, &policy)
+	if err != nil {
+		return policy, policyPath, utils.WrapError("Failed to load `"+policyPath+"`", err)
+	}
+	return policy, policyPath, nil
+}
+
+func (policy sourcePolicy) allowsDomain(rawUrl string) bool {
+	if policy.AllowedMirrorDomains == nil {
+		return true
+	}
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsedUrl.Hostname())
+	for _, domain := range policy.AllowedMirrorDomains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the mirrors of a source that the policy does not allow, and returns the ways that the source is outside of
+// the policy
+func (policy sourcePolicy) apply(sourceName string, sourceConf *parsedSourceConfig) []string {
+	violations := []string{}
+	if policy.AllowedSources != nil && !slices.Contains(policy.AllowedSources, sourceName) {
+		violations = append(violations, "`"+sourceName+"` is not an allowed source")
+	}
+	if policy.AllowedLicenses != nil {
+		if len(sourceConf.licenses) == 0 {
+			violations = append(violations, "`"+sourceName+"` has an unknown license")
+		}
+		for _, license := range sourceConf.licenses {
+			if !slices.Contains(policy.AllowedLicenses, license) {
+				violations = append(violations, "`"+sourceName+"` is licensed under "+license+", which is not an allowed license")
+			}
+		}
+	}
+	artifacts := slices.Clone(sourceConf.artifacts)
+	for index, artifact := range artifacts {
+		allowedUrls := slices.DeleteFunc(slices.Clone(artifact.parsedUrls), func(artifactUrl string) bool {
+			return !policy.allowsDomain(artifactUrl)
+		})
+		if len(allowedUrls) == 0 {
+			domains := sourceDomains(parsedSourceConfig{artifacts: []parsedArtifact{artifact}})
+			violation := "`" + sourceName + "` is only downloaded from " + strings.Join(domains, ", ") + ", which " + utils.CreateNoun(len(domains), "is not an allowed domain", "are not allowed domains")
+			// Artifacts often share the same mirrors
+			if !slices.Contains(violations, violation) {
+				violations = append(violations, violation)
+			}
+			continue
+		}
+		artifacts[index].parsedUrls = allowedUrls
+		artifacts[index].refuseCrossSiteRedirects = policy.ConfirmCrossSiteRedirects
+		artifacts[index].checksumUrls = slices.DeleteFunc(slices.Clone(artifact.checksumUrls), func(checksumUrl string) bool {
+			return !policy.allowsDomain(checksumUrl)
+		})
+	}
+	sourceConf.artifacts = artifacts
+	return violations
+}
+
+// Returns the mirrors of the sources that redirect to a host on another site, which are found by following their
+// redirects concurrently without downloading them, along with the sources that they belong to. The mirrors that cannot
+// be reached are not returned, but `fetchBody` still refuses to follow their redirects to another site.
+func findCrossSiteRedirects(r *resolver, sourceNames []string) ([]string, []string) {
+	type mirror struct {
+		sourceName string
+		url        string
+	}
+	mirrors := []mirror{}
+	for _, sourceName := range utils.SortedNames(slices.Values(sourceNames)) {
+		for _, artifact := range r.sources[sourceName].artifacts {
+			for _, artifactUrl := range artifact.parsedUrls {
+				mirrors = append(mirrors, mirror{sourceName, artifactUrl})
+			}
+		}
+	}
+	violations := make([]string, len(mirrors))
+	var waitGroup sync.WaitGroup
+	for index, mirror := range mirrors {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			finalUrl, err := utils.ResolveRedirects(mirror.url)
+			if err != nil {
+				// Mirrors that cannot be reached are reported when they are downloaded from
+				return
+			}
+			requested, requestedErr := url.Parse(mirror.url)
+			final, finalErr := url.Parse(finalUrl)
+			if requestedErr == nil && finalErr == nil && !utils.IsSameSiteRedirect(requested.Hostname(), final.Hostname()) {
+				violations[index] = "`" + mirror.sourceName + "` is downloaded from `" + mirror.url + "`, which redirects to `" + final.Hostname() + "` on another site"
+			}
+		}()
+	}
+	waitGroup.Wait()
+	redirectingSources := []string{}
+	for index, violation := range violations {
+		if violation != "" && !slices.Contains(redirectingSources, mirrors[index].sourceName) {
+			redirectingSources = append(redirectingSources, mirrors[index].sourceName)
+		}
+	}
+	return slices.DeleteFunc(violations, func(violation string) bool { return violation == "" }), redirectingSources
+}
+
+// Prints the ways that a download is outside of the policy of the project, and asks the user whether to download it
+// anyway. The user is only asked when they are at a terminal, so scripts and jobs never go outside of the policy.
+func confirmPolicyViolations(policyPath string, violations []string, download string) bool {
+	println(utils.AnsiBold + utils.AnsiFgRed + "Downloading " + download + " goes against the policy in `" + policyPath + "`:" + utils.AnsiReset)
+	for _, violation := range violations {
+		println(utils.AnsiFgRed + "  " + violation + utils.AnsiReset)
+	}
+	if !utils.IsTerminal(os.Stdin) {
+		println("Refusing to download " + download + ", since you cannot be asked whether to go against the policy")
+		return false
+	}
+	println(utils.AnsiBold + "The policy was probably set by the team that manages this project. Download " + download + " anyway?" + utils.AnsiReset)
+	return utils.GetBoolDefaultNo()
+}
+
+// Checks the sources that are about to be downloaded against the policy of the project in the current directory, and
+// removes the mirrors that it does not allow. Returns false if any source is outside of the policy and the user does not
+// want to download it anyway. The user is not asked again if they were asked before a job was started, in which case
+// `confirmedRedirectingSources` are the sources that they agreed can be downloaded from mirrors that redirect to
+// another site. Returns the sources that the user agreed to this for.
+func checkSourcePolicy(r *resolver, sourceNames []string, reason string, alreadyAsked bool, confirmedRedirectingSources []string) ([]string, bool) {
+	policy, policyPath, err := loadSourcePolicy()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	if policyPath == "" {
+		return nil, true
+	}
+	violations := []string{}
+	for _, sourceName := range utils.SortedNames(slices.Values(sourceNames)) {
+		sourceConf := r.sources[sourceName]
+		violations = append(violations, policy.apply(sourceName, &sourceConf)...)
+		r.sources[sourceName] = sourceConf
+	}
+	if policy.ConfirmCrossSiteRedirects && !alreadyAsked {
+		var redirectViolations []string
+		redirectViolations, confirmedRedirectingSources = findCrossSiteRedirects(r, sourceNames)
+		violations = append(violations, redirectViolations...)
+	}
+	if len(violations) > 0 && !alreadyAsked && !confirmPolicyViolations(policyPath, violations, utils.CreateNoun(len(sourceNames), "a source", "sources")+" "+reason) {
+		return nil, false
+	}
+	// The other mirrors are still refused if they redirect to another site while they are downloaded, since following
+	// their redirects beforehand can fail, and a mirror can redirect somewhere else the next time
+	for _, sourceName := range confirmedRedirectingSources {
+		for index := range r.sources[sourceName].artifacts {
+			r.sources[sourceName].artifacts[index].refuseCrossSiteRedirects = false
+		}
+	}
+	return confirmedRedirectingSources, true
+}
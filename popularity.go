@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The file in the package repository that ranks sources by how popular they are. It is published by the package
+// repository, and bento never sends anything to make it, so it is only downloaded and read when the user enables
+// `PopularityHints`.
+const popularityFileName = "popularity.toml"
+
+// How many of the most popular sources `bento repo status` shows
+const popularSourcesShown = 10
+
+type popularityHints struct {
+	// The names of sources, most popular first
+	Ranking []string
+}
+
+// Fetches the popularity hints from the revision of the package repository that was downloaded, unless the package
+// repository already included them
+func fetchPopularityHints(bentoDir string) error {
+	hintsPath := path.Join(bentoDir, popularityFileName)
+	if _, err := os.Stat(hintsPath); err == nil {
+		return nil
+	}
+	info, err := readRepositoryInfo(bentoDir)
+	if err != nil {
+		return utils.WrapError("Failed to read information about the package repository", err)
+	}
+	contents, found, err := utils.FetchPackageRepositoryFile(info.Revision, popularityFileName, path.Join(bentoDir, httpCacheDirName))
+	if err != nil {
+		return utils.WrapError("Failed to fetch the popularity hints", err)
+	}
+	if !found {
+		return nil
+	}
+	return os.WriteFile(hintsPath, contents, 0644)
+}
+
+// Returns the rank of each source in the popularity hints, where 0 is the most popular. Returns nothing if the user has
+// not enabled popularity hints, or they have not been downloaded yet.
+func readPopularityRanks(bentoDir string, config userConfig) (map[string]int, error) {
+	ranks := map[string]int{}
+	if !config.PopularityHints {
+		return ranks, nil
+	}
+	var hints popularityHints
+	_, err := toml.DecodeFile(path.Join(bentoDir, popularityFileName), &hints)
+	if os.IsNotExist(err) {
+		return ranks, nil
+	} else if err != nil {
+		return ranks, utils.WrapError("Failed to read the popularity hints", err)
+	}
+	for rank, sourceName := range hints.Ranking {
+		if _, ranked := ranks[sourceName]; !ranked {
+			ranks[sourceName] = rank
+		}
+	}
+	return ranks, nil
+}
+
+// Moves the sources that have a rank to the start of `sourceNames`, most popular first, and keeps the order of the rest
+func sortByPopularity(sourceNames []string, ranks map[string]int) {
+	slices.SortStableFunc(sourceNames, func(a string, b string) int {
+		rankA, rankedA := ranks[a]
+		rankB, rankedB := ranks[b]
+		switch {
+		case rankedA && rankedB:
+			return rankA - rankB
+		case rankedA:
+			return -1
+		case rankedB:
+			return 1
+		}
+		return 0
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The configuration of a project that uses bento, which is stored in a `bento.toml` file in the project's directory
+type projectConfig struct {
+	// The executables that the project uses, in the same format as `ExecutableDependencies` in a source
+	Executables [][2]string
+	// The features of each source that the project uses
+	Features map[string][]string
+}
+
+// Searches for a file in the current directory and its parents
+func findProjectFile(fileName string) (string, bool) {
+	directory, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+	for {
+		filePath := path.Join(directory, fileName)
+		if _, err := os.Stat(filePath); err == nil {
+			return filePath, true
+		}
+		if directory == "/" {
+			return "", false
+		}
+		directory = path.Dir(directory)
+	}
+}
+
+func loadProjectConfig() (projectConfig, error) {
+	var config projectConfig
+	configPath, found := findProjectFile("bento.toml")
+	if !found {
+		return config, errors.New("Could not find a `bento.toml` file in the current directory or any of its parents")
+	}
+	_, err := toml.DecodeFile(configPath, &config)
+	if err != nil {
+		return config, utils.WrapError("Failed to load `"+configPath+"`", err)
+	}
+	return config, nil
+}
+
+// The environment that makes the executables of a project available
+type projectEnvironment struct {
+	executableDirectories []string
+	libraryPaths          []string
+	variables             map[string]string
+}
+
+// Resolves the executables of the project in the current directory, and downloads any sources that are missing
+func resolveProjectEnvironment(bentoDir string) (projectEnvironment, error) {
+	project, err := loadProjectConfig()
+	if err != nil {
+		return projectEnvironment{}, err
+	}
+	return resolveExecutablesEnvironment(bentoDir, project.Executables, project.Features, "for the executables of this project")
+}
+
+// Resolves executables in the same format as `ExecutableDependencies` in a source, with the features in
+// `extraFeatures` along with the features that the user installed, and downloads any sources that are missing
+func resolveExecutablesEnvironment(bentoDir string, executables [][2]string, extraFeatures map[string][]string, reason string) (projectEnvironment, error) {
+	features, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		return projectEnvironment{}, err
+	}
+	for sourceName, sourceFeatures := range extraFeatures {
+		features[sourceName] = append(features[sourceName], sourceFeatures...)
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		return projectEnvironment{}, err
+	}
+
+	r := newResolver(bentoDir, map[string]string{}, features, config)
+	r.requestedFeatures = extraFeatures
+	executableDirectories := []string{}
+	requestedSources := []string{}
+	hideProgress := r.showProgress(false)
+	for _, executable := range executables {
+		requestedSources = append(requestedSources, executable[0])
+		executablePath, err := r.loadExecutable(executable[0], executable[1])
+		if err != nil {
+			hideProgress()
+			return projectEnvironment{}, err
+		}
+		if !slices.Contains(executableDirectories, path.Dir(executablePath)) {
+			executableDirectories = append(executableDirectories, path.Dir(executablePath))
+		}
+	}
+	hideProgress()
+	printWarnings(r.warnings)
+	if !downloadMissingSources(r, requestedSources, reason, installOptions{}) {
+		os.Exit(1)
+	}
+	printWarnings(findShadowedExecutables(executables, r, executableDirectories))
+	return projectEnvironment{
+		executableDirectories: executableDirectories,
+		libraryPaths:          r.libraryPaths(),
+		variables:             r.environment,
+	}, nil
+}
+
+// Returns warnings about the executables of a project that are hidden by an executable with the same name in a
+// directory that comes before them in the `PATH`, since every executable in the directory of an executable is added to
+// the `PATH`, not just the executables that the project uses
+func findShadowedExecutables(executables [][2]string, r *resolver, executableDirectories []string) []string {
+	warnings := []string{}
+	for _, executable := range executables {
+		executablePath := r.executables[executable[0]+" "+executable[1]]
+		for _, directory := range executableDirectories {
+			if directory == path.Dir(executablePath) {
+				break
+			}
+			shadowingPath := path.Join(directory, path.Base(executablePath))
+			if _, err := os.Stat(shadowingPath); err == nil {
+				warnings = append(warnings, "`"+path.Base(executablePath)+"` runs `"+shadowingPath+"` instead of `"+executable[1]+"` from the source `"+executable[0]+"`, because it comes first in the PATH. Move `"+executable[0]+"` earlier in `Executables` to use it instead.")
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// Returns the environment variables of a project environment, with `PATH` referencing the existing `PATH` using
+// `existingPathReference`
+func (e projectEnvironment) toVariables(existingPathReference string) map[string]string {
+	variables := maps.Clone(e.variables)
+	variables["PATH"] = strings.Join(append(slices.Clone(e.executableDirectories), existingPathReference), ":")
+	if len(e.libraryPaths) > 0 {
+		variables["LD_LIBRARY_PATH"] = strings.Join(e.libraryPaths, ":")
+	}
+	return variables
+}
+
+func shellQuote(str string) string {
+	return "'" + strings.ReplaceAll(str, "'", "'\\''") + "'"
+}
+
+// Quotes a value in a `.env` file only when it needs to be quoted, since some tools that read `.env` files, like
+// `docker run --env-file`, do not remove quotes
+func dotenvQuote(str string) string {
+	if str != "" && !strings.ContainsAny(str, " \t\n\"'#$\\`") {
+		return str
+	}
+	if !strings.ContainsAny(str, "'\n") {
+		return "'" + str + "'"
+	}
+	replacer := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "$", "\\$", "`", "\\`")
+	return "\"" + replacer.Replace(str) + "\""
+}
+
+// Returns a delimiter for a multiline value in `$GITHUB_ENV` that does not appear in the value
+func githubEnvDelimiter(value string) string {
+	delimiter := "BENTO_EOF"
+	for strings.Contains(value, delimiter) {
+		delimiter += "_"
+	}
+	return delimiter
+}
+
+// The formats that `bento env --export` can print an environment in
+var exportFormats = []string{"dotenv", "github", "shell"}
+
+// Prints an environment for a later step of a CI job or a container, which cannot expand variables, so the current
+// `PATH` is included in the `PATH`. `github` is the format of `$GITHUB_ENV` in GitHub Actions.
+func exportEnvironment(environment projectEnvironment, format string) {
+	variables := environment.toVariables(os.Getenv("PATH"))
+	for _, name := range slices.Sorted(maps.Keys(variables)) {
+		value := variables[name]
+		switch format {
+		case "dotenv":
+			fmt.Println(name + "=" + dotenvQuote(value))
+		case "github":
+			if strings.Contains(value, "\n") {
+				delimiter := githubEnvDelimiter(value)
+				fmt.Println(name + "<<" + delimiter + "\n" + value + "\n" + delimiter)
+			} else {
+				fmt.Println(name + "=" + value)
+			}
+		case "shell":
+			fmt.Println("export " + name + "=" + shellQuote(value))
+		}
+	}
+}
+
+// Prints the environment of the project in the current directory, or of an executable when `executable` is not empty,
+// in a format that can be used by a shell or an editor, or exports it when `export` is true
+func printProjectEnvironment(bentoDir string, executable []string, format string, export bool) {
+	if export && !slices.Contains(exportFormats, format) {
+		// Checked before resolving the environment, which can download sources
+		utils.Fail("`" + format + "` is not a valid format to export. Expected either `dotenv`, `github`, or `shell`")
+	}
+	var environment projectEnvironment
+	var err error
+	if len(executable) == 2 {
+		environment, err = resolveExecutablesEnvironment(bentoDir, [][2]string{{executable[0], executable[1]}}, nil, "for `"+executable[1]+"`")
+	} else {
+		environment, err = resolveProjectEnvironment(bentoDir)
+	}
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	if export {
+		exportEnvironment(environment, format)
+		return
+	}
+	switch format {
+	case "shell":
+		variables := environment.toVariables("$PATH")
+		for _, name := range slices.Sorted(maps.Keys(variables)) {
+			value := shellQuote(variables[name])
+			if name == "PATH" {
+				// Let the shell expand `$PATH`
+				value = shellQuote(strings.Join(environment.executableDirectories, ":")) + ":\"$PATH\""
+			}
+			fmt.Println("export " + name + "=" + value)
+		}
+	case "vscode":
+		settings := map[string]map[string]string{
+			"terminal.integrated.env.linux": environment.toVariables("${env:PATH}"),
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode(settings)
+		if err != nil {
+			utils.Fail("Failed to encode settings: " + err.Error())
+		}
+	case "jetbrains":
+		// JetBrains IDEs do not expand variables in env files, so include the current `PATH`
+		variables := environment.toVariables(os.Getenv("PATH"))
+		for _, name := range slices.Sorted(maps.Keys(variables)) {
+			fmt.Println(name + "=" + variables[name])
+		}
+	default:
+		utils.Fail("`" + format + "` is not a valid format. Expected either `shell`, `vscode`, or `jetbrains`")
+	}
+}
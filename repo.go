@@ -0,0 +1,394 @@
+package main
+
+import (
+	"embed"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The file in the bento directory that information about the downloaded package repository is stored in
+const repositoryInfoFileName = "repository.toml"
+
+// The source configs, libraries, and templates that are built into bento, so that a few sources can be run before the
+// package repository can be fetched, like on a machine that cannot reach GitHub yet
+//
+//go:embed fallbackRepository
+var fallbackRepository embed.FS
+
+const fallbackRepositoryDirName = "fallbackRepository"
+
+// The directory in the bento directory that responses from GitHub are saved in, so that fetching the same files from
+// the package repository again does not use up its rate limit. It is not kept when the package repository is updated,
+// so that it does not keep growing.
+const httpCacheDirName = "httpCache"
+
+// How old the package repository can get before `bento repo status` suggests updating it
+const repositoryMaxAge = 14 * 24 * time.Hour
+
+type repositoryInfo struct {
+	// The commit of the package repository that was downloaded, which is empty if it is unknown
+	Revision string
+	Updated  time.Time
+	// Only the files of the package repository that were needed have been fetched, and the rest are fetched from
+	// `Revision` when they are needed
+	Partial bool
+}
+
+func writeRepositoryInfo(bentoDir string, info repositoryInfo) error {
+	err := os.MkdirAll(bentoDir, 0755)
+	if err != nil {
+		return explainWriteError(err)
+	}
+	file, err := os.Create(path.Join(bentoDir, repositoryInfoFileName))
+	if err != nil {
+		return explainWriteError(err)
+	}
+	defer file.Close()
+	return toml.NewEncoder(file).Encode(info)
+}
+
+// Records the revision of a package repository that was fully downloaded with `bento update`
+func writeDownloadedRepositoryInfo(bentoDir string, archive *os.File) error {
+	revision, err := utils.ZipComment(archive)
+	if err != nil {
+		return err
+	}
+	return writeRepositoryInfo(bentoDir, repositoryInfo{Revision: strings.TrimSpace(revision), Updated: time.Now()})
+}
+
+// The fraction of its sources that the package repository has to lose in an update for bento to warn that it may have
+// been truncated or tampered with
+const suspiciousRepositoryShrinkage = 0.5
+
+// The package repository has to have had at least this many sources for shrinking to be suspicious, since a few sources
+// being removed from a small repository is normal
+const minimumSourcesToCompare = 20
+
+// How many of the sources that were added, removed, or changed are named after `bento update`
+const repositoryChangesShown = 10
+
+// Returns the checksum of every source config in a manifest of the package repository, by the name of the source
+func sourceChecksums(manifest map[string]utils.ManifestEntry) map[string]string {
+	checksums := map[string]string{}
+	for filePath, entry := range manifest {
+		relativePath, inSources := utils.TrimPrefix(filePath, "sources/")
+		name, isToml := strings.CutSuffix(relativePath, ".toml")
+		if inSources && isToml && !strings.Contains(name, "/") {
+			checksums[name] = entry.Sha256
+		}
+	}
+	return checksums
+}
+
+// Prints the names of some sources after a heading, in the colour `colour`
+func printChangedSources(heading string, colour string, sourceNames []string) {
+	if len(sourceNames) == 0 {
+		return
+	}
+	utils.SortNames(sourceNames)
+	shown := strings.Join(sourceNames[:min(len(sourceNames), repositoryChangesShown)], ", ")
+	if len(sourceNames) > repositoryChangesShown {
+		shown += ", and " + strconv.Itoa(len(sourceNames)-repositoryChangesShown) + " more"
+	}
+	println("  " + colour + heading + utils.AnsiReset + ": " + shown)
+}
+
+// Prints how the sources in the package repository changed in an update, and warns if it lost so many sources that it
+// may have been truncated or tampered with
+func printRepositoryChanges(previous map[string]string, current map[string]string) {
+	added, removed, changed := []string{}, []string{}, []string{}
+	for name, checksum := range current {
+		previousChecksum, existed := previous[name]
+		if !existed {
+			added = append(added, name)
+		} else if previousChecksum != checksum {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previous {
+		if _, exists := current[name]; !exists {
+			removed = append(removed, name)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 && len(changed) == 0 {
+		println("No sources were added, removed, or changed")
+		return
+	}
+	println(utils.CreateNoun(len(added), "1 source", "sources") + " added, " + utils.CreateNoun(len(removed), "1 source", "sources") + " removed, and " + utils.CreateNoun(len(changed), "1 source", "sources") + " changed:")
+	printChangedSources("Added", utils.AnsiFgGreen, added)
+	printChangedSources("Removed", utils.AnsiFgRed, removed)
+	printChangedSources("Changed", utils.AnsiAttention, changed)
+	if len(previous) >= minimumSourcesToCompare && float64(len(current)) < float64(len(previous))*suspiciousRepositoryShrinkage {
+		printWarnings([]string{"The package repository went from " + utils.CreateNoun(len(previous), "1 source", "sources") + " to " + strconv.Itoa(len(current)) + ", which can mean that the download was truncated, or that the package repository was tampered with. Check that the new revision is expected before installing anything from it."})
+	}
+}
+
+// Downloads the whole package repository, replacing the files that changed, and prints what changed
+func updatePackageRepository(bentoDir string) {
+	previousInfo, previousInfoErr := readRepositoryInfo(bentoDir)
+	previousManifest, err := utils.ReadPackageRepositoryManifest(bentoDir)
+	if err != nil {
+		printWarnings([]string{"Failed to read which files the package repository had, so what changed cannot be shown: " + err.Error()})
+	}
+	archiveSize := int64(-1)
+	errs := utils.FetchPackageRepository(bentoDir, []string{installedSourcesDirName, installedFeaturesFileName, jobsDirName, urlSourcesDirName, quarantineDirName, journalsDirName}, maxParrellelDownloads, func(archive *os.File) error {
+		if info, err := archive.Stat(); err == nil {
+			archiveSize = info.Size()
+		}
+		return writeDownloadedRepositoryInfo(bentoDir, archive)
+	})
+	if len(errs) != 0 {
+		os.Exit(1)
+	}
+
+	info, err := readRepositoryInfo(bentoDir)
+	if err != nil {
+		utils.Fail("Failed to read information about the package repository: " + err.Error())
+	}
+	revision := "an unknown revision"
+	if info.Revision != "" {
+		revision = "revision " + info.Revision[:min(len(info.Revision), 12)]
+	}
+	size := ""
+	if archiveSize >= 0 {
+		size = " (" + utils.FormatSize(archiveSize) + ")"
+	}
+	switch {
+	case previousInfoErr != nil || len(previousManifest) == 0:
+		println("Downloaded " + revision + " of the package repository" + size)
+	case previousInfo.Revision != "" && previousInfo.Revision == info.Revision:
+		println("The package repository is already at " + revision + size)
+	case previousInfo.Revision != "":
+		println("Updated the package repository from revision " + previousInfo.Revision[:min(len(previousInfo.Revision), 12)] + " to " + revision + size)
+	default:
+		println("Updated the package repository to " + revision + size)
+	}
+
+	currentManifest, err := utils.ReadPackageRepositoryManifest(bentoDir)
+	if err != nil {
+		printWarnings([]string{"Failed to read which files the package repository has, so what changed cannot be shown: " + err.Error()})
+		return
+	}
+	currentSources := sourceChecksums(currentManifest)
+	if len(previousManifest) == 0 {
+		println("It has " + utils.CreateNoun(len(currentSources), "1 source", "sources"))
+		return
+	}
+	printRepositoryChanges(sourceChecksums(previousManifest), currentSources)
+}
+
+// Fetches a single file from the package repository if it is missing, and the package repository has not been fully
+// downloaded with `bento update`. This means that `exec` and `install` work before the first `bento update`, without
+// waiting for the whole package repository to download. Every file is fetched from the same revision, so that the
+// files are consistent with each other. If the file cannot be fetched, the copy of it in the fallback repository is
+// used.
+func fetchRepositoryFileIfMissing(bentoDir string, relativePath string, trace *utils.Tracer) error {
+	defer trace.Measure(metadataTiming)()
+	filePath := path.Join(bentoDir, relativePath)
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		return nil
+	}
+	err := fetchRepositoryFile(bentoDir, relativePath, trace)
+	if err == nil {
+		return nil
+	}
+	contents, fallbackErr := fallbackRepository.ReadFile(path.Join(fallbackRepositoryDirName, relativePath))
+	if fallbackErr != nil {
+		return err
+	}
+	printWarnings([]string{"Using the copy of `" + relativePath + "` that is built into bento, because fetching it failed: " + err.Error() + ". It is used until `bento update` downloads the package repository."})
+	if _, err := readRepositoryInfo(bentoDir); os.IsNotExist(err) {
+		// Otherwise the bento directory would look like a package repository that an older version of bento
+		// downloaded, and the other files would not be fetched. The revision is pinned when it can be fetched.
+		err = writeRepositoryInfo(bentoDir, repositoryInfo{Updated: time.Now(), Partial: true})
+		if err != nil {
+			return utils.WrapError("Failed to record that the package repository has not been downloaded", err)
+		}
+	}
+	return writeRepositoryFile(filePath, contents)
+}
+
+// Fetches a file from the package repository if it is missing, unless the resolver only reads the files that are
+// already in the bento directory
+func (r *resolver) fetchRepositoryFileIfMissing(relativePath string) error {
+	if r.onlyLocalFiles {
+		return nil
+	}
+	return fetchRepositoryFileIfMissing(r.bentoDir, relativePath, r.trace)
+}
+
+func writeRepositoryFile(filePath string, contents []byte) error {
+	err := os.MkdirAll(path.Dir(filePath), 0755)
+	if err != nil {
+		return explainWriteError(err)
+	}
+	return explainWriteError(os.WriteFile(filePath, contents, 0644))
+}
+
+func fetchRepositoryFile(bentoDir string, relativePath string, trace *utils.Tracer) error {
+	info, err := readRepositoryInfo(bentoDir)
+	if os.IsNotExist(err) || (err == nil && info.Partial && info.Revision == "") {
+		trace.Log("The package repository has not been downloaded, so pinning it to the latest revision")
+		revision, err := utils.FetchPackageRepositoryRevision(path.Join(bentoDir, httpCacheDirName))
+		if err != nil {
+			return utils.WrapError("Failed to get the latest revision of the package repository", err)
+		}
+		info = repositoryInfo{Revision: revision, Updated: time.Now(), Partial: true}
+		err = writeRepositoryInfo(bentoDir, info)
+		if err != nil {
+			return utils.WrapError("Failed to record the revision of the package repository", err)
+		}
+	} else if err != nil {
+		return utils.WrapError("Failed to read information about the package repository", err)
+	}
+	if !info.Partial {
+		return nil
+	}
+	trace.Log("Fetching `" + relativePath + "` from revision " + info.Revision + " of the package repository")
+	contents, found, err := utils.FetchPackageRepositoryFile(info.Revision, relativePath, path.Join(bentoDir, httpCacheDirName))
+	if err != nil {
+		return utils.WrapError("Failed to fetch `"+relativePath+"` from the package repository", err)
+	}
+	if !found {
+		return nil
+	}
+	return writeRepositoryFile(path.Join(bentoDir, relativePath), contents)
+}
+
+// Reads the information about the package repository. Package repositories that were downloaded by older versions of
+// bento do not have this information, so the modification time of the sources directory is used instead.
+func readRepositoryInfo(bentoDir string) (repositoryInfo, error) {
+	var info repositoryInfo
+	_, err := toml.DecodeFile(path.Join(bentoDir, repositoryInfoFileName), &info)
+	if os.IsNotExist(err) {
+		fileInfo, err := os.Stat(path.Join(bentoDir, "sources"))
+		if err != nil {
+			return info, err
+		}
+		info.Updated = fileInfo.ModTime()
+		return info, nil
+	}
+	return info, err
+}
+
+// Returns the names of the TOML files in a directory without their extension
+func listTomlNames(directory string) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return []string{}, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if name, isToml := strings.CutSuffix(entry.Name(), ".toml"); isToml {
+			names = append(names, name)
+		}
+	}
+	utils.SortNames(names)
+	return names, nil
+}
+
+func printRepositoryStatus(bentoDir string) {
+	info, err := readRepositoryInfo(bentoDir)
+	if os.IsNotExist(err) {
+		utils.Fail("The package repository has not been downloaded yet. Run `bento update` to download it.")
+	} else if err != nil {
+		utils.Fail("Failed to read information about the package repository: " + err.Error())
+	}
+	sourceNames, err := listTomlNames(path.Join(bentoDir, "sources"))
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the sources in the package repository: " + err.Error())
+	}
+	libraryNames, err := listTomlNames(path.Join(bentoDir, "lib"))
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the libraries in the package repository: " + err.Error())
+	}
+
+	println(utils.AnsiBold + "Package repository" + utils.AnsiReset)
+	if info.Revision == "" {
+		println("- Revision: unknown")
+	} else {
+		println("- Revision: " + info.Revision)
+	}
+	if info.Partial {
+		println("- Only the files that were needed have been fetched. Run `bento update` to download the whole package repository.")
+	}
+	age := time.Since(info.Updated)
+	println("- Last updated: " + utils.FormatTime(info.Updated) + " (" + utils.FormatAge(age) + ")")
+	println("- " + utils.CreateNoun(len(sourceNames), "1 source", "sources"))
+	println("- " + utils.CreateNoun(len(libraryNames), "1 library", "libraries"))
+
+	installedSourceNames := []string{}
+	entries, err := os.ReadDir(path.Join(bentoDir, installedSourcesDirName))
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the installed sources: " + err.Error())
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			installedSourceNames = append(installedSourceNames, entry.Name())
+		}
+	}
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	r := newResolver(bentoDir, map[string]string{}, installedFeatures, config)
+	outdatedSources := []string{}
+	orphanedSources := []string{}
+	unloadableSources := []string{}
+	for _, sourceName := range installedSourceNames {
+		if !slices.Contains(sourceNames, sourceName) {
+			orphanedSources = append(orphanedSources, sourceName)
+			continue
+		}
+		sourceConf, err := r.loadSource(sourceName)
+		if err != nil {
+			unloadableSources = append(unloadableSources, err.Error())
+			continue
+		}
+		if _, err := os.Stat(sourceConf.path); os.IsNotExist(err) {
+			outdatedSources = append(outdatedSources, sourceName)
+		}
+	}
+
+	println(utils.AnsiBold + utils.CreateNoun(len(installedSourceNames), "1 installed source", "installed sources") + utils.AnsiReset)
+	if len(outdatedSources) > 0 {
+		println("- " + utils.AnsiFgYellow + "Outdated" + utils.AnsiReset + ": " + strings.Join(outdatedSources, ", "))
+		println("  Run `bento install " + strings.Join(outdatedSources, " ") + "` to install the latest versions.")
+	}
+	if len(orphanedSources) > 0 {
+		println("- " + utils.AnsiFgRed + "No longer in the package repository" + utils.AnsiReset + ": " + strings.Join(orphanedSources, ", "))
+	}
+	for _, unloadableSource := range unloadableSources {
+		println("- " + utils.AnsiFgRed + "Failed to load" + utils.AnsiReset + ": " + unloadableSource)
+	}
+	if len(outdatedSources) == 0 && len(orphanedSources) == 0 && len(unloadableSources) == 0 {
+		println("- Every installed source is up to date")
+	}
+	ranks, err := readPopularityRanks(bentoDir, config)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	popularSources := slices.DeleteFunc(slices.Clone(sourceNames), func(sourceName string) bool {
+		_, ranked := ranks[sourceName]
+		return !ranked
+	})
+	if len(popularSources) > 0 {
+		sortByPopularity(popularSources, ranks)
+		println(utils.AnsiBold + "Popular sources" + utils.AnsiReset)
+		println("- " + strings.Join(popularSources[:min(len(popularSources), popularSourcesShown)], ", "))
+	}
+	if age > repositoryMaxAge {
+		println("The package repository is more than " + strconv.Itoa(int(repositoryMaxAge.Hours()/24)) + " days old. Run `bento update` to update it.")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// Returns the most recent transaction that changed the installed sources or features and has not been rolled back,
+// along with the records of the changes that it made
+func findTransactionToRollBack(records []historyRecord) (string, []historyRecord) {
+	undone := map[string]bool{}
+	for index := len(records) - 1; index >= 0; index-- {
+		record := records[index]
+		switch record.Action {
+		case historyRollback:
+			undone[record.UndoneTransaction] = true
+			// A rollback can not be rolled back, since the versions that it removed are no longer available
+			undone[record.Transaction] = true
+		case historyInstall, historyUpgrade, historyFeatures:
+			if undone[record.Transaction] {
+				continue
+			}
+			changes := []historyRecord{}
+			for _, other := range records {
+				if other.Transaction == record.Transaction && other.Action != historyConsent {
+					changes = append(changes, other)
+				}
+			}
+			return record.Transaction, changes
+		}
+	}
+	return "", []historyRecord{}
+}
+
+// Returns the path that a version of a source in an `install` or `upgrade` record was installed to
+func installedPathOfRecord(bentoDir string, record historyRecord) string {
+	if strings.Contains(record.Source, "://") {
+		// Executables run with `bento exec-url` are stored by their checksum
+		return path.Join(bentoDir, urlSourcesDirName, record.Checksums[0])
+	}
+	return path.Join(bentoDir, installedSourcesDirName, record.Source, record.Version)
+}
+
+// Describes the files in an installed version of a source that were changed since it was installed, according to its
+// manifest
+func describeChangesSinceInstall(sourcePath string) string {
+	installed, err := readManifest(sourcePath)
+	if err != nil {
+		return ""
+	}
+	current, err := utils.CreateManifest(sourcePath)
+	if err != nil {
+		return ""
+	}
+	changed := 0
+	for filePath := range maps.Keys(installed) {
+		if entry, ok := current[filePath]; !ok || describeManifestChange(installed[filePath], entry) != "" {
+			changed += 1
+		}
+	}
+	for filePath := range maps.Keys(current) {
+		if _, ok := installed[filePath]; !ok {
+			changed += 1
+		}
+	}
+	if changed == 0 {
+		return ""
+	}
+	return ", and " + utils.CreateNoun(changed, "1 file in it was", "files in it were") + " changed since it was installed"
+}
+
+// Undoes everything that the most recent install changed, by removing the versions of sources that it installed and
+// restoring the features that were installed before it
+func rollbackLastTransaction(bentoDir string) {
+	transaction, changes := findTransactionToRollBack(readHistory())
+	if transaction == "" {
+		utils.Fail("There are no installs in the history to roll back")
+	}
+	lock, locked, err := lockInstalledSources(bentoDir, true, false)
+	if err != nil {
+		utils.Fail("Failed to lock the installed sources: " + err.Error())
+	}
+	if !locked {
+		utils.Fail("Another bento process is downloading sources. Try again when it has finished.")
+	}
+	defer lock.Close()
+
+	println("Rolling back transaction " + transaction + " will:")
+	sourcePaths := []string{}
+	var previousFeatures map[string][]string
+	for _, change := range changes {
+		switch change.Action {
+		case historyInstall, historyUpgrade:
+			sourcePath := installedPathOfRecord(bentoDir, change)
+			if _, err := os.Stat(sourcePath); err != nil {
+				println("- Skip `" + change.Source + "` " + change.Version + ", which is no longer installed")
+				continue
+			}
+			sourcePaths = append(sourcePaths, sourcePath)
+			println("- Remove `" + change.Source + "` " + change.Version + describeChangesSinceInstall(sourcePath))
+		case historyFeatures:
+			// The first change has the features from before the transaction
+			if previousFeatures == nil {
+				previousFeatures = change.PreviousFeatures
+				if previousFeatures == nil {
+					previousFeatures = map[string][]string{}
+				}
+				println("- Restore the installed features")
+			}
+		}
+	}
+	print("Continue? Y/n: ")
+	input := strings.ToLower(strings.TrimSpace(utils.ReadLine()))
+	if input != "" && input != "y" && input != "yes" {
+		return
+	}
+
+	records := []historyRecord{}
+	for _, change := range changes {
+		sourcePath := installedPathOfRecord(bentoDir, change)
+		if !slices.Contains(sourcePaths, sourcePath) {
+			continue
+		}
+		err := os.RemoveAll(sourcePath)
+		if err != nil {
+			utils.Fail("Failed to remove `" + sourcePath + "`: " + err.Error())
+		}
+		os.Remove(manifestPath(sourcePath))
+		os.Remove(installedSourceInfoPath(sourcePath))
+		records = append(records, historyRecord{
+			Action:    historyRemove,
+			Source:    change.Source,
+			Version:   change.Version,
+			Checksums: change.Checksums,
+			Reason:    "to roll back transaction " + transaction,
+		})
+	}
+	if previousFeatures != nil {
+		installedFeatures, err := readInstalledFeatures(bentoDir)
+		if err != nil {
+			utils.Fail(err.Error())
+		}
+		err = writeInstalledFeatures(bentoDir, previousFeatures)
+		if err != nil {
+			utils.Fail("Failed to save installed features: " + err.Error())
+		}
+		records = append(records, historyRecord{Action: historyFeatures, PreviousFeatures: installedFeatures})
+	}
+	records = append(records, historyRecord{Action: historyRollback, UndoneTransaction: transaction})
+	recordHistory(records...)
+	println("Removed " + utils.CreateNoun(len(sourcePaths), "1 version of a source", "versions of sources") + " that transaction " + transaction + " installed")
+	syncShims(bentoDir, true)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"maps"
+	"os"
+	osExec "os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The directory in the bento directory that contains a shim for every executable, which the user adds to their `PATH`
+const shimsDirName = "bin"
+
+// A shim is a script that runs an executable from a source with `bento exec`, so that the source is only downloaded
+// when the executable is first run
+func shimContent(sourceName string, sourceExecutableRelativePath string) string {
+	return "#!/usr/bin/env -S bento exec " + sourceName + " " + sourceExecutableRelativePath + "\n"
+}
+
+// Returns the source and executable that a shim runs. Shims written by older versions of bento, or that run bento
+// using an absolute path, are also understood so that they can be regenerated.
+func parseShim(content string) (string, string, bool) {
+	shebang, isScript := strings.CutPrefix(strings.SplitN(content, "\n", 2)[0], "#!")
+	if !isScript {
+		return "", "", false
+	}
+	fields := strings.Fields(shebang)
+	for index := 0; index+3 < len(fields); index++ {
+		if path.Base(fields[index]) == "bento" && fields[index+1] == "exec" {
+			return fields[index+2], fields[index+3], true
+		}
+	}
+	return "", "", false
+}
+
+type shimSyncer struct {
+	shimsDir string
+	r        *resolver
+	// Whether the package repository only contains the files that have been fetched, in which case a missing source
+	// config does not mean that the source was removed from the package repository
+	partialRepository bool
+	// Maps the name of each shim to the source and executable that it runs
+	shims                                  map[string][2]string
+	created, regenerated, removed, skipped []string
+	// Maps executable names that several sources provide to the source that the user chose to run
+	providers map[string]string
+	// Whether the user can be asked which source should provide an executable that several sources provide
+	interactive bool
+	// Executable names that several sources provide, which the user has not chosen a source for
+	conflicts []string
+	// Whether the shims directory did not exist until a shim was written to it
+	createdShimsDir bool
+	// Executable names that have a file in the shims directory that is not a shim. When sources are installed for every
+	// user, the shims directory is shared with programs that were not installed by bento, so shims are not written over
+	// them.
+	notReplaced []string
+}
+
+// The file in the data directory that records which source the user chose to run for each executable name that
+// several sources provide
+const executableProvidersFileName = "executableProviders.toml"
+
+func readExecutableProviders() (map[string]string, error) {
+	providers := map[string]string{}
+	_, err := toml.DecodeFile(path.Join(getDataDir(), executableProvidersFileName), &providers)
+	if err != nil && !os.IsNotExist(err) {
+		return providers, utils.WrapError("Failed to read the sources that you chose to provide executables", err)
+	}
+	return providers, nil
+}
+
+func writeExecutableProviders(providers map[string]string) error {
+	err := os.MkdirAll(getDataDir(), 0755)
+	if err != nil {
+		return explainWriteError(err)
+	}
+	file, err := os.Create(path.Join(getDataDir(), executableProvidersFileName))
+	if err != nil {
+		return explainWriteError(err)
+	}
+	defer file.Close()
+	return toml.NewEncoder(file).Encode(providers)
+}
+
+// Returns the source that should provide the shim for an executable name that several sources provide, asking the
+// user if they have not chosen one yet. Returns false if there is no choice and the user cannot be asked.
+func (s *shimSyncer) chooseProvider(name string, sourceNames []string) (string, bool) {
+	if provider, ok := s.providers[name]; ok && slices.Contains(sourceNames, provider) {
+		return provider, true
+	}
+	if !s.interactive {
+		s.conflicts = append(s.conflicts, "`"+name+"` is provided by "+strings.Join(sourceNames, ", "))
+		return "", false
+	}
+	println("The executable `" + name + "` is provided by several sources:")
+	for index, sourceName := range sourceNames {
+		println("  " + strconv.Itoa(index+1) + ". " + sourceName)
+	}
+	for {
+		print("Which source should `" + name + "` run? ")
+		choice, err := strconv.Atoi(strings.TrimSpace(utils.ReadLine()))
+		if err == nil && choice >= 1 && choice <= len(sourceNames) {
+			s.providers[name] = sourceNames[choice-1]
+			err := writeExecutableProviders(s.providers)
+			if err != nil {
+				println("Failed to record your choice: " + err.Error())
+			}
+			return sourceNames[choice-1], true
+		}
+		println("Expected a number from 1 to " + strconv.Itoa(len(sourceNames)))
+	}
+}
+
+// Removes shims that run executables which no longer exist, and regenerates shims that are not in the current format
+func (s *shimSyncer) syncExistingShims() {
+	entries, err := os.ReadDir(s.shimsDir)
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read `" + s.shimsDir + "`: " + err.Error())
+	}
+	for _, entry := range entries {
+		shimPath := path.Join(s.shimsDir, entry.Name())
+		content, err := os.ReadFile(shimPath)
+		if err != nil {
+			s.skipped = append(s.skipped, entry.Name()+" ("+err.Error()+")")
+			continue
+		}
+		sourceName, executable, isShim := parseShim(string(content))
+		if !isShim {
+			if systemInstall {
+				// Most of the files in the shared shims directory are not shims
+				continue
+			}
+			s.skipped = append(s.skipped, entry.Name()+" (it does not run `bento exec`)")
+			continue
+		}
+		if s.isDangling(sourceName, executable) {
+			err = os.Remove(shimPath)
+			if err != nil {
+				utils.Fail("Failed to remove `" + shimPath + "`: " + err.Error())
+			}
+			s.removed = append(s.removed, entry.Name())
+			continue
+		}
+		s.shims[entry.Name()] = [2]string{sourceName, executable}
+		if string(content) != shimContent(sourceName, executable) {
+			s.writeShim(entry.Name(), sourceName, executable)
+			s.regenerated = append(s.regenerated, entry.Name())
+		}
+	}
+}
+
+// Returns true if a shim runs an executable from a source that no longer exists, or that is not in the installed
+// version of its source
+func (s *shimSyncer) isDangling(sourceName string, executable string) bool {
+	if _, err := os.Stat(path.Join(s.r.sourcesDir, sourceName+".toml")); os.IsNotExist(err) {
+		return !s.partialRepository
+	}
+	sourceConf, err := s.r.loadSource(sourceName)
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(sourceConf.path); err != nil {
+		// The source has not been downloaded yet, so there is no way to know which executables it has
+		return false
+	}
+	_, err = os.Stat(path.Join(sourceConf.path, executable))
+	return os.IsNotExist(err)
+}
+
+// Adds shims for the executables in the `bin` directory of every installed source that does not have a shim yet. When
+// several sources provide an executable with the same name, the shim runs the source that the user chose.
+func (s *shimSyncer) addMissingShims() {
+	sourceNames, err := os.ReadDir(s.r.installedSourcesDir)
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail("Failed to read the installed sources: " + err.Error())
+	}
+	// Maps each executable name to the sources that provide it
+	providers := map[string][]string{}
+	for name, shim := range s.shims {
+		providers[name] = []string{shim[0]}
+	}
+	for _, entry := range sourceNames {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		sourceConf, err := s.r.loadSource(entry.Name())
+		if err != nil {
+			continue
+		}
+		executables, err := os.ReadDir(path.Join(sourceConf.path, "bin"))
+		if err != nil {
+			continue
+		}
+		for _, executable := range executables {
+			if !executable.IsDir() && !slices.Contains(providers[executable.Name()], entry.Name()) {
+				providers[executable.Name()] = append(providers[executable.Name()], entry.Name())
+			}
+		}
+	}
+
+	for _, name := range utils.SortedNames(maps.Keys(providers)) {
+		sourceNames := providers[name]
+		provider := sourceNames[0]
+		if len(sourceNames) > 1 {
+			utils.SortNames(sourceNames)
+			chosen, ok := s.chooseProvider(name, sourceNames)
+			if _, exists := s.shims[name]; !ok && exists {
+				// Keep the shim that already exists until the user chooses a source
+				continue
+			}
+			provider = chosen
+			if !ok {
+				provider = sourceNames[0]
+			}
+		}
+		shim, exists := s.shims[name]
+		if exists && shim[0] == provider {
+			continue
+		}
+		if _, err := os.Lstat(path.Join(s.shimsDir, name)); systemInstall && !exists && err == nil {
+			s.notReplaced = append(s.notReplaced, name)
+			continue
+		}
+		executable := "bin/" + name
+		s.writeShim(name, provider, executable)
+		s.shims[name] = [2]string{provider, executable}
+		if exists {
+			s.regenerated = append(s.regenerated, name)
+		} else {
+			s.created = append(s.created, name)
+		}
+	}
+}
+
+func (s *shimSyncer) writeShim(name string, sourceName string, executable string) {
+	if _, err := os.Stat(s.shimsDir); os.IsNotExist(err) {
+		s.createdShimsDir = true
+	}
+	err := os.MkdirAll(s.shimsDir, 0755)
+	if err != nil {
+		utils.Fail("Failed to create `" + s.shimsDir + "`: " + explainWriteError(err).Error())
+	}
+	shimPath := path.Join(s.shimsDir, name)
+	err = os.WriteFile(shimPath+".tmp", []byte(shimContent(sourceName, executable)), 0755)
+	if err == nil {
+		err = os.Rename(shimPath+".tmp", shimPath)
+	}
+	if err != nil {
+		utils.Fail("Failed to write `" + shimPath + "`: " + err.Error())
+	}
+}
+
+// Returns the problems with the `PATH` that would stop the shims from being run
+func (s *shimSyncer) findPathProblems() []string {
+	problems := []string{}
+	if _, err := osExec.LookPath("bento"); err != nil {
+		problems = append(problems, "`bento` is not in your PATH, so the shims cannot run it")
+	}
+	if !slices.Contains(filepath.SplitList(os.Getenv("PATH")), s.shimsDir) {
+		problems = append(problems, "`"+s.shimsDir+"` is not in your PATH, so the shims in it will not be found. Run `bento path-setup` to add it to the config of your shell.")
+		return problems
+	}
+	for _, name := range utils.SortedNames(maps.Keys(s.shims)) {
+		found, err := osExec.LookPath(name)
+		if err == nil && found != path.Join(s.shimsDir, name) {
+			problems = append(problems, "`"+name+"` runs `"+found+"` instead of the shim, because it comes first in your PATH")
+		}
+	}
+	return problems
+}
+
+func printShimChanges(description string, names []string) {
+	if len(names) > 0 {
+		utils.SortNames(names)
+		println(description + ": " + strings.Join(names, ", "))
+	}
+}
+
+// Makes the shims match the sources in the package repository and the installed sources. Returns false if there are
+// problems that stop the shims from working. When `quiet` is true, only changes are reported.
+func syncShims(bentoDir string, quiet bool) bool {
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	info, err := readRepositoryInfo(bentoDir)
+	if err != nil && !os.IsNotExist(err) {
+		utils.Fail(err.Error())
+	}
+	providers, err := readExecutableProviders()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	s := shimSyncer{
+		shimsDir:          getShimsDir(bentoDir),
+		r:                 newResolver(bentoDir, map[string]string{}, installedFeatures, config),
+		partialRepository: info.Partial,
+		shims:             map[string][2]string{},
+		providers:         providers,
+		interactive:       !quiet && utils.IsTerminal(os.Stdin),
+	}
+	s.syncExistingShims()
+	s.addMissingShims()
+
+	printShimChanges("Created shims", s.created)
+	printShimChanges("Regenerated shims", s.regenerated)
+	printShimChanges("Removed dangling shims", s.removed)
+	printShimChanges("Did not replace files that are not shims", s.notReplaced)
+	if len(s.conflicts) > 0 {
+		utils.SortNames(s.conflicts)
+		println("Several sources provide the same executables, so run `bento shims sync` to choose which of them to run: " + strings.Join(s.conflicts, "; "))
+	}
+	if quiet {
+		if s.createdShimsDir {
+			offerPathSetup(s.shimsDir)
+		}
+		return true
+	}
+	printShimChanges("Skipped files that are not shims", s.skipped)
+	problems := s.findPathProblems()
+	for _, problem := range problems {
+		println(utils.AnsiFgRed + "- " + utils.AnsiReset + problem)
+	}
+	println("Synced " + utils.CreateNoun(len(s.shims), "1 shim", "shims") + ", and found " + utils.CreateNoun(len(problems), "1 problem", "problems") + " with your PATH")
+	return len(problems) == 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// The directory that the shims are written to when sources are installed for every user of the system. It is in the
+// `PATH` of every user on most systems, so the shims work without setting up the `PATH`.
+const systemShimsDir = "/usr/local/bin"
+
+// Whether bento installs sources for every user of the system into the first system store, instead of into the bento
+// directory of the user that runs it. This is the case when bento is run with `--system`, or as root without a bento
+// directory of its own.
+var systemInstall = false
+
+// Returns true if bento is run as root, and root has not chosen a bento directory and does not have one yet, in which
+// case the sources that it installs are probably meant for every user
+func runAsRootWithoutBentoDir() bool {
+	if os.Geteuid() != 0 || os.Getenv("BENTO_DIR") != "" || os.Getenv(writableDirVariable) != "" {
+		return false
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return true
+	}
+	_, err = os.Stat(path.Join(cacheDir, "bento"))
+	return os.IsNotExist(err)
+}
+
+// Makes bento install sources for every user of the system. Everything that bento writes is owned by root, since it
+// must be run as root, and can be read by every user, but not changed by them.
+func enableSystemInstall() {
+	if os.Geteuid() != 0 {
+		utils.Fail("`--system` installs sources for every user of the system, so bento must be run as root to use it")
+	}
+	systemInstall = true
+	syscall.Umask(0o022)
+}
+
+// Returns the bento directory that sources are installed into for every user of the system, which is the first system
+// store, so that the sources in it are used by every user that has not configured different system stores
+func systemBentoDir() string {
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	systemStores := config.systemStores()
+	if len(systemStores) == 0 {
+		utils.Fail("Sources are installed for every user of the system into the first system store, but `SystemStores` is empty in your config")
+	}
+	return systemStores[0]
+}
+
+// Returns the directory that the shims of a bento directory are written to
+func getShimsDir(bentoDir string) string {
+	if systemInstall {
+		return systemShimsDir
+	}
+	return path.Join(bentoDir, shimsDirName)
+}
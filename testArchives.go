@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/godalming123/bento/utils"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// An entry in an archive that `bento make-test-archive` writes
+type testArchiveEntry struct {
+	name string
+	// Either `tar.TypeReg`, `tar.TypeDir`, `tar.TypeSymlink`, or `tar.TypeLink`
+	kind       byte
+	mode       int64
+	contents   string
+	linkTarget string
+}
+
+// A layout of files that `bento make-test-archive` writes an archive of, in every compression that it is asked for
+type testArchiveLayout struct {
+	name        string
+	description string
+	// The `RootPath` that a source config would extract the archive with
+	rootPath string
+	entries  []testArchiveEntry
+	// Names that are outside of the directory that the archive is extracted into if they are resolved naively, which
+	// must not exist after bento extracts it
+	escapes []string
+}
+
+func testFile(name string, mode int64, contents string) testArchiveEntry {
+	return testArchiveEntry{name: name, kind: tar.TypeReg, mode: mode, contents: contents}
+}
+
+func testDir(name string) testArchiveEntry {
+	return testArchiveEntry{name: name, kind: tar.TypeDir, mode: 0755}
+}
+
+func testLink(name string, kind byte, linkTarget string) testArchiveEntry {
+	return testArchiveEntry{name: name, kind: kind, mode: 0777, linkTarget: linkTarget}
+}
+
+var testArchiveLayouts = []testArchiveLayout{
+	{"plain", "an executable, a data file, and an empty directory in a versioned root directory", "tool-*/", []testArchiveEntry{
+		testDir("tool-1.0/"),
+		testFile("tool-1.0/bin/tool", 0755, "#!/bin/sh\necho tool\n"),
+		testFile("tool-1.0/share/tool/data.txt", 0644, "data\n"),
+		testDir("tool-1.0/share/empty/"),
+	}, nil},
+	{"symlinks", "relative symlinks to a file and a directory, and a dangling symlink", "tool/", []testArchiveEntry{
+		testFile("tool/bin/tool-1.0", 0755, "#!/bin/sh\necho tool\n"),
+		testLink("tool/bin/tool", tar.TypeSymlink, "tool-1.0"),
+		testFile("tool/share/data.txt", 0644, "data\n"),
+		testLink("tool/data", tar.TypeSymlink, "share"),
+		testLink("tool/dangling", tar.TypeSymlink, "does-not-exist"),
+	}, nil},
+	{"hardlinks", "hard links to a file in the archive, which zip archives cannot have", "tool/", []testArchiveEntry{
+		testFile("tool/bin/tool", 0755, "#!/bin/sh\necho tool\n"),
+		testLink("tool/bin/tool-alias", tar.TypeLink, "tool/bin/tool"),
+		testLink("tool/libexec/tool", tar.TypeLink, "tool/bin/tool"),
+	}, nil},
+	{"nested-roots", "files two directories deep, next to directories and files that the root path glob must not match", "dist/tool-*-linux/", []testArchiveEntry{
+		testFile("dist/tool-1.0-linux/bin/tool", 0755, "#!/bin/sh\necho tool\n"),
+		testFile("dist/tool-1.0-linux.sig", 0644, "signature\n"),
+		testFile("dist/tool-1.0-linux-debug/bin/tool", 0755, "#!/bin/sh\necho debug\n"),
+		testFile("dist/README", 0644, "readme\n"),
+	}, nil},
+	{"path-traversal", "entries that try to write outside of the directory that the archive is extracted into", "", []testArchiveEntry{
+		testFile("tool/bin/tool", 0755, "#!/bin/sh\necho tool\n"),
+		testFile("../escaped-dotdot", 0644, "escaped\n"),
+		testFile("tool/../../escaped-nested-dotdot", 0644, "escaped\n"),
+		testFile("/escaped-absolute", 0644, "escaped\n"),
+		testLink("tool/hardlink", tar.TypeLink, "../escaped-hardlink-target"),
+		testLink("tool/up", tar.TypeSymlink, "../.."),
+		testFile("tool/up/escaped-through-symlink", 0644, "escaped\n"),
+	}, []string{"escaped-dotdot", "escaped-nested-dotdot", "escaped-hardlink-target", "escaped-through-symlink"}},
+	{"symlink-chain", "a symlink that only points outside of the directory that the archive is extracted into once the symlink that it goes through is resolved, and a file written through it", "", []testArchiveEntry{
+		testDir("tool/"),
+		testLink("tool/here", tar.TypeSymlink, "."),
+		testLink("tool/up", tar.TypeSymlink, "here/../.."),
+		testFile("tool/up/escaped-through-symlink-chain", 0644, "escaped\n"),
+	}, []string{"escaped-through-symlink-chain"}},
+}
+
+// The compressions that test archives can be written with
+var testArchiveCompressions = []string{".tar.gz", ".tar.xz", ".tar.zst", ".zip"}
+
+// The modification time of every entry, so that the same archive is written every time, and its checksum can be kept
+var testArchiveTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func writeTestTarball(writer io.Writer, layout testArchiveLayout) error {
+	archive := tar.NewWriter(writer)
+	for _, entry := range layout.entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.kind, Mode: entry.mode, Linkname: entry.linkTarget, Size: int64(len(entry.contents)), ModTime: testArchiveTime, Format: tar.FormatPAX}
+		err := archive.WriteHeader(header)
+		if err == nil {
+			_, err = archive.Write([]byte(entry.contents))
+		}
+		if err != nil {
+			return utils.WrapError("Failed to write `"+entry.name+"`", err)
+		}
+	}
+	return archive.Close()
+}
+
+func writeTestZip(writer io.Writer, layout testArchiveLayout) error {
+	archive := zip.NewWriter(writer)
+	for _, entry := range layout.entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: testArchiveTime}
+		contents := entry.contents
+		switch entry.kind {
+		case tar.TypeLink:
+			// Zip archives cannot have hard links
+			continue
+		case tar.TypeSymlink:
+			header.SetMode(fs.ModeSymlink | fs.FileMode(entry.mode))
+			contents = entry.linkTarget
+		case tar.TypeDir:
+			header.SetMode(fs.ModeDir | fs.FileMode(entry.mode))
+		default:
+			header.SetMode(fs.FileMode(entry.mode))
+		}
+		file, err := archive.CreateHeader(header)
+		if err == nil {
+			_, err = file.Write([]byte(contents))
+		}
+		if err != nil {
+			return utils.WrapError("Failed to write `"+entry.name+"`", err)
+		}
+	}
+	return archive.Close()
+}
+
+func writeTestArchive(writer io.Writer, layout testArchiveLayout, compression string) error {
+	var compressed io.WriteCloser
+	var err error
+	switch compression {
+	case ".zip":
+		return writeTestZip(writer, layout)
+	case ".tar.gz":
+		compressed = gzip.NewWriter(writer)
+	case ".tar.xz":
+		compressed, err = xz.NewWriter(writer)
+	case ".tar.zst":
+		compressed, err = zstd.NewWriter(writer)
+	default:
+		return errors.New("Cannot write a test archive with the compression `" + compression + "`. Expected either `" + strings.Join(testArchiveCompressions, "`, `") + "`")
+	}
+	if err != nil {
+		return err
+	}
+	err = writeTestTarball(compressed, layout)
+	if err != nil {
+		return err
+	}
+	return compressed.Close()
+}
+
+// Extracts a test archive with the same code that extracts downloads, in a directory that is two levels inside a
+// temporary directory, so that entries that escape it are still inside the temporary directory. Returns the error that
+// extracting it failed with, and the names that escaped.
+func checkTestArchive(archivePath string, layout testArchiveLayout, compression string) (error, []string, error) {
+	checkDir, err := os.MkdirTemp("", "bento-test-archive-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(checkDir)
+	destination := filepath.Join(checkDir, "extracted", "destination")
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer archive.Close()
+	extractionErr := utils.Extract(archive, compression, destination, layout.rootPath)
+	escaped := []string{}
+	err = filepath.WalkDir(checkDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == destination {
+			return filepath.SkipDir
+		}
+		if slices.Contains(layout.escapes, entry.Name()) {
+			escaped = append(escaped, filePath)
+		}
+		return nil
+	})
+	if _, err := os.Lstat("/escaped-absolute"); err == nil {
+		escaped = append(escaped, "/escaped-absolute")
+	}
+	return extractionErr, escaped, err
+}
+
+// Writes an archive of every layout in `layoutNames` in every compression in `compressions`, or every layout and
+// compression when they are empty, to `outputDir`. When `check` is true, each archive is then extracted by bento, to
+// make sure that it does not write outside of the directory that it extracts into. Returns false if a check failed.
+func makeTestArchives(outputDir string, layoutNames []string, compressions []string, check bool) bool {
+	for _, layoutName := range layoutNames {
+		if !slices.ContainsFunc(testArchiveLayouts, func(layout testArchiveLayout) bool { return layout.name == layoutName }) {
+			names := []string{}
+			for _, layout := range testArchiveLayouts {
+				names = append(names, layout.name)
+			}
+			utils.Fail("There is no test archive layout called `" + layoutName + "`. Expected either `" + strings.Join(names, "`, `") + "`")
+		}
+	}
+	if len(compressions) == 0 {
+		compressions = testArchiveCompressions
+	}
+	err := os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		utils.Fail("Failed to create `" + outputDir + "`: " + err.Error())
+	}
+
+	passed := true
+	for _, layout := range testArchiveLayouts {
+		if len(layoutNames) > 0 && !slices.Contains(layoutNames, layout.name) {
+			continue
+		}
+		println(utils.AnsiBold + layout.name + utils.AnsiReset + ": " + layout.description + ", extracted with `RootPath = \"" + layout.rootPath + "\"`")
+		rows := [][]string{}
+		for _, compression := range compressions {
+			archivePath := path.Join(outputDir, layout.name+compression)
+			file, err := os.Create(archivePath)
+			if err != nil {
+				utils.Fail("Failed to create `" + archivePath + "`: " + err.Error())
+			}
+			hash := sha256.New()
+			err = writeTestArchive(io.MultiWriter(file, hash), layout, compression)
+			file.Close()
+			if err != nil {
+				utils.Fail("Failed to write `" + archivePath + "`: " + err.Error())
+			}
+			row := []string{archivePath, "sha256 " + hex.EncodeToString(hash.Sum(nil))}
+			if check {
+				extractionErr, escaped, err := checkTestArchive(archivePath, layout, compression)
+				switch {
+				case err != nil:
+					row = append(row, utils.AnsiFgRed+"could not be checked: "+err.Error()+utils.AnsiReset)
+					passed = false
+				case len(escaped) > 0:
+					row = append(row, utils.AnsiFgRed+"wrote outside of the destination: "+strings.Join(escaped, ", ")+utils.AnsiReset)
+					passed = false
+				case extractionErr != nil:
+					row = append(row, utils.AnsiAttention+"refused: "+extractionErr.Error()+utils.AnsiReset)
+				default:
+					row = append(row, utils.AnsiFgGreen+"extracted"+utils.AnsiReset)
+				}
+			}
+			rows = append(rows, row)
+		}
+		for _, line := range utils.AlignColumns(rows) {
+			println("  " + line)
+		}
+	}
+	return passed
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Extracts every layout that `bento make-test-archive` writes, in every compression, in the same way as
+// `bento make-test-archive --check`
+func TestTestArchivesStayInDestination(t *testing.T) {
+	for _, layout := range testArchiveLayouts {
+		for _, compression := range testArchiveCompressions {
+			t.Run(layout.name+compression, func(t *testing.T) {
+				archivePath := filepath.Join(t.TempDir(), layout.name+compression)
+				file, err := os.Create(archivePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = writeTestArchive(file, layout, compression)
+				file.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				extractionErr, escaped, err := checkTestArchive(archivePath, layout, compression)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(escaped) > 0 {
+					t.Errorf("Wrote outside of the destination: %v", escaped)
+				}
+				// Only archives that try to escape are refused
+				if len(layout.escapes) == 0 && extractionErr != nil {
+					t.Error(extractionErr)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// When the bento process started, as closely as it can be measured, so that the time before `main` runs is counted
+var processStarted = time.Now()
+
+// What the time that `bento exec --time` reports is spent on
+const (
+	// Starting bento and parsing its arguments
+	startupTiming = "startup"
+	// Reading source configs, templates, and libraries, and fetching them from the package repository when they are
+	// missing
+	metadataTiming = "metadata loading"
+	// Working out which sources, executables, and libraries the executable needs, including asking the daemon
+	resolutionTiming = "resolution"
+	// Fetching the sources that are missing, not including extracting them
+	downloadTiming = "download"
+	// Extracting the sources that were downloaded
+	extractionTiming = "extraction"
+	// Preparing the environment of the executable and replacing bento with it
+	handoffTiming = "exec handoff"
+)
+
+var timingCategories = []string{startupTiming, metadataTiming, resolutionTiming, downloadTiming, extractionTiming, handoffTiming}
+
+// Returns the time spent on each of `timingCategories` by an invocation of `bento exec`
+func collectTimings(trace *utils.Tracer) map[string]time.Duration {
+	extraction := utils.ExtractionTime()
+	return map[string]time.Duration{
+		startupTiming:    time.Since(processStarted) - trace.Elapsed(),
+		metadataTiming:   trace.Timing(metadataTiming),
+		resolutionTiming: trace.Timing(resolutionTiming),
+		// Downloads are extracted while other downloads are fetched, so this is the time that the downloads took with
+		// the time spent extracting taken away
+		downloadTiming:   max(trace.Timing(downloadTiming)-extraction, 0),
+		extractionTiming: extraction,
+		handoffTiming:    trace.Timing(handoffTiming),
+	}
+}
+
+func formatDuration(duration time.Duration) string {
+	return strconv.FormatFloat(float64(duration.Microseconds())/1000, 'f', 3, 64) + "ms"
+}
+
+func describeTimings(timings map[string]time.Duration) string {
+	descriptions := []string{}
+	for _, category := range timingCategories {
+		descriptions = append(descriptions, category+" "+formatDuration(timings[category]))
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// Prints how long each step of `bento exec` took to stderr, and records it in the history, so that regressions in how
+// long bento takes to start an executable can be found
+func reportTimings(trace *utils.Tracer, sourceName string, sourceExecutableRelativePath string) {
+	timings := collectTimings(trace)
+	rows := [][]string{}
+	for _, category := range timingCategories {
+		rows = append(rows, []string{"  " + category, formatDuration(timings[category])})
+	}
+	// The total includes the time that is not in any category, like waiting for the user to approve downloads
+	rows = append(rows, []string{"  " + utils.AnsiBold + "total" + utils.AnsiReset, utils.AnsiBold + formatDuration(time.Since(processStarted)) + utils.AnsiReset})
+	println("Time spent before running `" + sourceExecutableRelativePath + "`:")
+	for _, line := range utils.AlignColumns(rows) {
+		println(line)
+	}
+	recordHistory(historyRecord{Action: historyTiming, Source: sourceName, Reason: "to run " + sourceExecutableRelativePath, Timings: timings})
+}
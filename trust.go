@@ -0,0 +1,229 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The file in the data directory that records which versions of each source the user has approved downloading. This is
+// not stored in the bento directory, so that the approvals are kept when the cache is cleared.
+const trustedSourcesFileName = "trustedSources.toml"
+
+func readTrustedSources() (map[string][]string, error) {
+	trustedSources := map[string][]string{}
+	_, err := toml.DecodeFile(path.Join(getDataDir(), trustedSourcesFileName), &trustedSources)
+	if err != nil && !os.IsNotExist(err) {
+		return trustedSources, utils.WrapError("Failed to read the sources that you have approved", err)
+	}
+	return trustedSources, nil
+}
+
+// Returns true if the user has already approved downloading the current version of every source in `sourceNames`
+func sourcesAreTrusted(trustedSources map[string][]string, sources map[string]parsedSourceConfig, sourceNames []string) bool {
+	for _, sourceName := range sourceNames {
+		if !slices.Contains(trustedSources[sourceName], sources[sourceName].version) {
+			return false
+		}
+	}
+	return true
+}
+
+func recordTrustedSources(trustedSources map[string][]string, sources map[string]parsedSourceConfig, sourceNames []string) error {
+	for _, sourceName := range sourceNames {
+		if !slices.Contains(trustedSources[sourceName], sources[sourceName].version) {
+			trustedSources[sourceName] = append(trustedSources[sourceName], sources[sourceName].version)
+		}
+	}
+	err := os.MkdirAll(getDataDir(), 0755)
+	if err != nil {
+		return explainWriteError(err)
+	}
+	file, err := os.Create(path.Join(getDataDir(), trustedSourcesFileName))
+	if err != nil {
+		return explainWriteError(err)
+	}
+	defer file.Close()
+	return toml.NewEncoder(file).Encode(trustedSources)
+}
+
+// Returns the domains that a source is downloaded from
+func sourceDomains(sourceConf parsedSourceConfig) []string {
+	domains := []string{}
+	for _, artifact := range sourceConf.artifacts {
+		for _, artifactUrl := range artifact.parsedUrls {
+			parsedUrl, err := url.Parse(artifactUrl)
+			if err == nil && !slices.Contains(domains, parsedUrl.Host) {
+				domains = append(domains, parsedUrl.Host)
+			}
+		}
+	}
+	return domains
+}
+
+// Returns a description of the total size of the artifacts of each source, from the `Sizes` in its source config. The
+// mirrors are not asked for the sizes, since that would tell them which sources are being installed before the user
+// has agreed to download them.
+func describeDownloadSizes(sources map[string]parsedSourceConfig, sourceNames []string) map[string]string {
+	descriptions := map[string]string{}
+	for _, sourceName := range sourceNames {
+		total := int64(0)
+		for _, artifact := range sources[sourceName].artifacts {
+			if artifact.size == 0 {
+				total = -1
+				break
+			}
+			total += artifact.size
+		}
+		if total < 0 {
+			descriptions[sourceName] = utils.AnsiAttention + "unknown size" + utils.AnsiReset
+		} else {
+			descriptions[sourceName] = utils.AnsiSize + utils.FormatSize(total) + utils.AnsiReset
+		}
+	}
+	return descriptions
+}
+
+// Asks the user whether they want to download some sources, and summarizes what each source is, where it is downloaded
+// from, and anything that the user should know before downloading it. Each source is numbered so that the user can
+// skip it, and the sources are returned in the order that they are numbered in.
+func printDownloadConsentPrompt(sources map[string]parsedSourceConfig, sourceNames []string, reason string) []string {
+	utils.SortNames(sourceNames)
+	sizes := describeDownloadSizes(sources, sourceNames)
+	sourcesSortedByLicense := map[string][]string{}
+	for _, sourceName := range sourceNames {
+		licenseDescription := sources[sourceName].licenseDescription
+		sourcesSortedByLicense[licenseDescription] = append(sourcesSortedByLicense[licenseDescription], sourceName)
+	}
+	println("Download the following " + utils.CreateNoun(len(sourceNames), "source", "sources") + " " + reason + "?")
+	licenseHeaders := utils.SortedNames(maps.Keys(sourcesSortedByLicense))
+	numberedSources := []string{}
+	rows := [][]string{}
+	for _, licenseHeader := range licenseHeaders {
+		for _, sourceName := range sourcesSortedByLicense[licenseHeader] {
+			numberedSources = append(numberedSources, sourceName)
+			rows = append(rows, []string{
+				strconv.Itoa(len(numberedSources)) + ".",
+				utils.AnsiBold + sourceName + utils.AnsiReset,
+				sources[sourceName].version,
+				sizes[sourceName],
+				"from " + strings.Join(sourceDomains(sources[sourceName]), ", "),
+			})
+		}
+	}
+	// The columns line up across every license, so the sizes and versions of all of the sources can be compared
+	lines := utils.AlignColumns(rows)
+	for _, licenseHeader := range licenseHeaders {
+		sourcesWithLicense := sourcesSortedByLicense[licenseHeader]
+		licenseColor := utils.AnsiLicense
+		if licenseHeader == unknownLicenseDescription {
+			licenseColor = utils.AnsiAttention
+		}
+		println(utils.AnsiBold + utils.CreateNoun(len(sourcesWithLicense), "A source", "sources") + " " + licenseColor + licenseHeader + utils.AnsiReset)
+		for _, sourceName := range sourcesWithLicense {
+			sourceConf := sources[sourceName]
+			println("  " + lines[slices.Index(numberedSources, sourceName)])
+			notes := [][]string{}
+			if sourceConf.homepage != "" {
+				notes = append(notes, []string{"Homepage:", sourceConf.homepage})
+			}
+			for _, installationWarning := range sourceConf.installationWarnings {
+				notes = append(notes, []string{utils.AnsiAttention + "Warning:" + utils.AnsiReset, installationWarning})
+			}
+			if sourceConf.needsNetwork {
+				notes = append(notes, []string{"Network:", "needs network access"})
+			}
+			if len(sourceConf.contactedDomains) > 0 {
+				notes = append(notes, []string{"Contacts:", strings.Join(sourceConf.contactedDomains, ", ")})
+			}
+			if sourceConf.deprecatedInFavorOf != "" {
+				notes = append(notes, []string{utils.AnsiAttention + "Deprecated:" + utils.AnsiReset, "use `" + sourceConf.deprecatedInFavorOf + "` instead"})
+			}
+			for _, knownIssue := range sourceConf.knownIssues {
+				notes = append(notes, []string{utils.AnsiAttention + "Known issue:" + utils.AnsiReset, knownIssue})
+			}
+			for _, note := range utils.AlignColumns(notes) {
+				println("      " + note)
+			}
+		}
+	}
+	println("Bento does not run any scripts while installing sources, so the sources can only run when you execute them.")
+	return numberedSources
+}
+
+// Prints the installation warnings and known issues of the sources that were just installed, so that they are not
+// lost in the output of the downloads. Notes that several sources share are only printed once.
+func printInstallationSummary(installedSources []journaledSource) {
+	notes := [][2]string{}
+	sourcesByNote := map[[2]string][]string{}
+	for _, source := range installedSources {
+		sourceNotes := [][2]string{}
+		for _, installationWarning := range source.InstallationWarnings {
+			sourceNotes = append(sourceNotes, [2]string{"Warning:", installationWarning})
+		}
+		for _, knownIssue := range source.KnownIssues {
+			sourceNotes = append(sourceNotes, [2]string{"Known issue:", knownIssue})
+		}
+		for _, note := range sourceNotes {
+			if _, seen := sourcesByNote[note]; !seen {
+				notes = append(notes, note)
+			}
+			sourcesByNote[note] = append(sourcesByNote[note], source.Name)
+		}
+	}
+	if len(notes) == 0 {
+		return
+	}
+	println(utils.AnsiBold + "Before using the newly installed " + utils.CreateNoun(len(installedSources), "source", "sources") + ", note that:" + utils.AnsiReset)
+	rows := [][]string{}
+	for _, note := range notes {
+		utils.SortNames(sourcesByNote[note])
+		rows = append(rows, []string{"  " + utils.AnsiAttention + note[0] + utils.AnsiReset, strings.Join(sourcesByNote[note], ", ") + ":", note[1]})
+	}
+	for _, line := range utils.AlignColumns(rows) {
+		println(line)
+	}
+}
+
+// Asks the user whether to download the sources in `numberedSources`, and which of them to skip. Sources that are only
+// needed by optional features can be skipped, but sources that `requestedSources` need cannot be. Returns false if the
+// user does not want to download any of the sources.
+func askWhichSourcesToSkip(r *resolver, requestedSources []string, numberedSources []string) ([]string, bool) {
+	for {
+		print("Y/n, or the numbers of the sources to skip: ")
+		input := strings.ToLower(strings.TrimSpace(utils.ReadLine()))
+		switch input {
+		case "", "y", "yes":
+			return []string{}, true
+		case "n", "no":
+			return []string{}, false
+		}
+		skippedSources := []string{}
+		valid := true
+		for _, field := range strings.FieldsFunc(input, func(char rune) bool { return char == ' ' || char == ',' }) {
+			number, err := strconv.Atoi(field)
+			if err != nil || number < 1 || number > len(numberedSources) {
+				println("Expected either `y`, `n`, or numbers between 1 and " + strconv.Itoa(len(numberedSources)) + ", but got `" + field + "`")
+				valid = false
+				break
+			}
+			sourceName := numberedSources[number-1]
+			if reason, required := r.explainRequiredSource(requestedSources, sourceName); required {
+				println("`" + sourceName + "` cannot be skipped because " + reason)
+				valid = false
+				break
+			}
+			skippedSources = append(skippedSources, sourceName)
+		}
+		if valid {
+			return skippedSources, true
+		}
+	}
+}
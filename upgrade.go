@@ -0,0 +1,187 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// What the source config of an installed version of a source said when it was installed, so that `bento upgrade` can
+// show what changes in a newer version. It is stored next to the installed version, like its manifest.
+type installedSourceInfo struct {
+	Licenses string
+	// The sources whose executables the source runs, and the libraries that it links to, not including the ones from
+	// its features
+	Dependencies []string
+}
+
+func installedSourceInfoPath(sourcePath string) string {
+	return path.Join(path.Dir(sourcePath), "."+path.Base(sourcePath)+".source.toml")
+}
+
+func newInstalledSourceInfo(sourceConf parsedSourceConfig) installedSourceInfo {
+	dependencies := map[string]bool{}
+	for _, executable := range sourceConf.executableDependencies {
+		dependencies[executable[0]] = true
+	}
+	if len(sourceConf.interpreter) > 0 {
+		dependencies[sourceConf.interpreter[0]] = true
+	}
+	for _, libraries := range sourceConf.directSharedLibraryDependencies {
+		for _, library := range libraries {
+			dependencies["library "+library] = true
+		}
+	}
+	return installedSourceInfo{Licenses: sourceConf.licenseDescription, Dependencies: utils.SortedNames(maps.Keys(dependencies))}
+}
+
+func writeInstalledSourceInfo(sourcePath string, info installedSourceInfo) error {
+	file, err := os.Create(installedSourceInfoPath(sourcePath))
+	if err != nil {
+		return explainWriteError(err)
+	}
+	defer file.Close()
+	return toml.NewEncoder(file).Encode(info)
+}
+
+// Returns what the source config of an installed version of a source said, or false if it was installed before bento
+// recorded it
+func readInstalledSourceInfo(sourcePath string) (installedSourceInfo, bool) {
+	var info installedSourceInfo
+	_, err := toml.DecodeFile(installedSourceInfoPath(sourcePath), &info)
+	return info, err == nil
+}
+
+// Returns the version of a source that was installed most recently, or false if no version is installed. The manifest
+// of a version is written when it is installed, so it says when it was installed even if the modification times of
+// its files were normalized.
+func latestInstalledVersion(sourceDir string) (string, bool) {
+	entries, err := os.ReadDir(sourceDir)
+	if err != nil {
+		return "", false
+	}
+	latest := ""
+	var latestTime time.Time
+	for _, entry := range entries {
+		// Names that start with `.` are manifests and temporary directories
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := os.Stat(manifestPath(path.Join(sourceDir, entry.Name())))
+		if err != nil {
+			info, err = entry.Info()
+		}
+		if err == nil && (latest == "" || info.ModTime().After(latestTime)) {
+			latest, latestTime = entry.Name(), info.ModTime()
+		}
+	}
+	return latest, latest != ""
+}
+
+// An installed source whose source config has a newer version than the version that was installed most recently
+type sourceUpgrade struct {
+	name       string
+	oldVersion string
+	newVersion string
+	oldInfo    installedSourceInfo
+	// The old version was installed before bento recorded its licenses and dependencies
+	oldInfoMissing bool
+	newInfo        installedSourceInfo
+}
+
+// Returns the lines that describe how the licenses and dependencies of a source change in an upgrade
+func describeUpgradeChanges(upgrade sourceUpgrade) []string {
+	if upgrade.oldInfoMissing {
+		return []string{utils.AnsiAttention + "the licenses and dependencies of " + upgrade.oldVersion + " were not recorded when it was installed" + utils.AnsiReset}
+	}
+	lines := []string{}
+	for _, dependency := range upgrade.newInfo.Dependencies {
+		if !slices.Contains(upgrade.oldInfo.Dependencies, dependency) {
+			lines = append(lines, utils.AnsiFgGreen+"+ needs "+dependency+utils.AnsiReset)
+		}
+	}
+	for _, dependency := range upgrade.oldInfo.Dependencies {
+		if !slices.Contains(upgrade.newInfo.Dependencies, dependency) {
+			lines = append(lines, utils.AnsiFgRed+"- no longer needs "+dependency+utils.AnsiReset)
+		}
+	}
+	if upgrade.oldInfo.Licenses != upgrade.newInfo.Licenses {
+		lines = append(lines, utils.AnsiAttention+"license changed: "+upgrade.oldInfo.Licenses+" -> "+upgrade.newInfo.Licenses+utils.AnsiReset)
+	}
+	return lines
+}
+
+// Finds the installed sources, or the sources in `sourceNames` when it is not empty, that have a newer version in the
+// package repository, and prints how each of them changes. Unless `preview` is true, the new versions are then
+// installed, and the user can skip any of them when they are asked to download them.
+func upgradeSources(bentoDir string, sourceNames []string, preview bool) {
+	if len(sourceNames) == 0 {
+		var err error
+		sourceNames, err = installedSourceNames(bentoDir)
+		if err != nil {
+			utils.Fail(err.Error())
+		}
+	}
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	r := newResolver(bentoDir, environmentToMap(os.Environ()), installedFeatures, config)
+	hideProgress := r.showProgress(false)
+	upgrades := []sourceUpgrade{}
+	for _, sourceName := range utils.SortedNames(slices.Values(sourceNames)) {
+		oldVersion, installed := latestInstalledVersion(path.Join(r.installedSourcesDir, sourceName))
+		if !installed {
+			hideProgress()
+			utils.Fail("`" + sourceName + "` is not installed")
+		}
+		sourceConf, err := r.loadSource(sourceName)
+		if err != nil {
+			r.warnings = append(r.warnings, "Failed to check `"+sourceName+"` for a newer version: "+err.Error())
+			continue
+		}
+		if _, err := os.Stat(sourceConf.path); err == nil {
+			continue
+		}
+		upgrade := sourceUpgrade{name: sourceName, oldVersion: oldVersion, newVersion: sourceConf.version, newInfo: newInstalledSourceInfo(sourceConf)}
+		upgrade.oldInfo, installed = readInstalledSourceInfo(path.Join(r.installedSourcesDir, sourceName, oldVersion))
+		upgrade.oldInfoMissing = !installed
+		upgrades = append(upgrades, upgrade)
+	}
+	hideProgress()
+	printWarnings(r.warnings)
+	if len(upgrades) == 0 {
+		println("Every source is up to date")
+		return
+	}
+
+	println(utils.CreateNoun(len(upgrades), "A source has", "sources have") + " a newer version:")
+	rows := [][]string{}
+	for _, upgrade := range upgrades {
+		rows = append(rows, []string{utils.AnsiBold + upgrade.name + utils.AnsiReset, utils.AnsiFgRed + upgrade.oldVersion + utils.AnsiReset, "->", utils.AnsiFgGreen + upgrade.newVersion + utils.AnsiReset})
+	}
+	for index, line := range utils.AlignColumns(rows) {
+		println("  " + line)
+		for _, change := range describeUpgradeChanges(upgrades[index]) {
+			println("      " + change)
+		}
+	}
+	if preview {
+		return
+	}
+	upgradedSources := []string{}
+	for _, upgrade := range upgrades {
+		upgradedSources = append(upgradedSources, upgrade.name)
+	}
+	install(bentoDir, upgradedSources, map[string][]string{}, installOptions{})
+}
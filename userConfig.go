@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/godalming123/bento/utils"
+)
+
+// The configuration that the user can set in `~/.config/bento/config.toml`
+type userConfig struct {
+	// Maps `SOURCE/EXECUTABLE` to the absolute path of an executable on the host system that is used instead of the
+	// executable from the source
+	ExecutableOverrides map[string]string
+	// The bento directories that an administrator has installed sources to, which are read-only and are used instead of
+	// downloading a source when they have the same version of it installed. Defaults to `/opt/bento`.
+	SystemStores []string
+	// Prefixes of the URLs of mirrors that are tried before every other mirror, in order, like `https://eu.example.com`
+	MirrorOrder []string
+	// The regions that mirrors are tagged with in the package repository, like `eu`, in the order that they are
+	// preferred. Mirrors in these regions are tried after the mirrors in `MirrorOrder`, but before every other mirror.
+	PreferredRegions []string
+	// The most memory that downloads should use at once, like `256MiB`, which is used on devices with little memory.
+	// Fewer downloads are done at once, and archives are decompressed with less memory, to stay within it.
+	MemoryBudget string
+	// How long downloads have to take for a desktop notification to be sent when they finish, like `30s`, so that users
+	// who switched to another window while waiting notice. No notifications are sent when it is not set.
+	NotifyAfter string
+	// Downloads a ranking of how popular sources are when the package repository is updated, which is used to show
+	// popular sources first. Nothing is sent to make the ranking, but it is off by default.
+	PopularityHints bool
+	// A GitHub token that is sent with requests to GitHub, which raises the number of requests that GitHub allows.
+	// `GITHUB_TOKEN` is used instead when it is set.
+	GithubToken string
+	// Lets sources be installed outside of the bento directory, which bento refuses to do by default, because it is
+	// almost always caused by a mistake in a source config
+	AllowDestinationsOutsideBentoDir bool
+	// Refuses to fetch anything without HTTPS, including from the mirrors in source configs, so that downloads cannot be
+	// downgraded to a connection that anyone on the network can read and change
+	RequireHttps bool
+	// Refuses sources whose checksums are fetched from a checksum file next to their download with
+	// `ChecksumUrlInMirror`, instead of being in their source config, since a compromised mirror can change both
+	ForbidChecksumUrls bool
+	// Maps the host of a mirror, like `mirror.example.com`, to the public keys that its certificate chain must have one
+	// of, like `sha256/BASE64`, so that internal mirrors cannot be intercepted by a certificate from another authority
+	PinnedPublicKeys map[string][]string
+	// Either `fast`, which is the default, or `safe`, which syncs extracted files and directories to disk before a source
+	// is moved into place, so that a crash cannot leave a source with empty or partly written files
+	ExtractionSync string
+	// Maps `SOURCE/EXECUTABLE` to the limits on the resources that the executable can use, like
+	// `{ OpenFiles = 1024, AddressSpace = "4GiB", Nice = 10, OomScoreAdjust = 500 }`, which replace the limits that its
+	// source config sets
+	ResourceLimits map[string]utils.ResourceLimits
+	// Runs executables as a child of bento, instead of replacing bento with them, so that when one crashes, a report
+	// of how it crashed is saved in `~/.local/state/bento/crashes` to attach to a bug report. Core dumps are allowed
+	// too. Off by default, since bento then stays running alongside every executable.
+	CrashReports bool
+	// Experimental: stores every file of every installed source in `contentStore` in the bento directory, named after
+	// its checksum, and hard links installed sources to it, so that identical files in different sources and versions
+	// are only stored once. The files of installed sources are made read-only, since changing one would change it in every
+	// source that shares it.
+	ContentAddressedStore bool
+}
+
+// The smallest memory budget that bento can download anything within
+const minMemoryBudget = 1024 * 1024
+
+// Returns how long downloads have to take for a notification to be sent when they finish, or false if notifications
+// are not enabled
+func (config userConfig) notificationThreshold() (time.Duration, bool) {
+	threshold, err := time.ParseDuration(config.NotifyAfter)
+	return threshold, config.NotifyAfter != "" && err == nil
+}
+
+// The system store that is used when the user has not configured any system stores
+const defaultSystemStore = "/opt/bento"
+
+func (config userConfig) systemStores() []string {
+	if config.SystemStores == nil {
+		return []string{defaultSystemStore}
+	}
+	return config.SystemStores
+}
+
+// Orders mirrors by the preferences of the user. Mirrors that the user has no preference between are shuffled, so that
+// the load is spread between them.
+func (config userConfig) sortMirrors(mirrors []string, mirrorRegions map[string]string) []string {
+	rank := func(mirror string) int {
+		for index, prefix := range config.MirrorOrder {
+			if strings.HasPrefix(mirror, prefix) {
+				return index
+			}
+		}
+		if region, tagged := mirrorRegions[mirror]; tagged {
+			if index := slices.Index(config.PreferredRegions, region); index >= 0 {
+				return len(config.MirrorOrder) + index
+			}
+		}
+		return len(config.MirrorOrder) + len(config.PreferredRegions)
+	}
+	sortedMirrors := utils.ShuffleSlice(mirrors)
+	slices.SortStableFunc(sortedMirrors, func(a string, b string) int {
+		return rank(a) - rank(b)
+	})
+	return sortedMirrors
+}
+
+func getUserConfigDir() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		utils.Fail("Failed to get config directory: " + err.Error())
+	}
+	return path.Join(configDir, "bento")
+}
+
+func loadUserConfig() (userConfig, error) {
+	configPath := path.Join(getUserConfigDir(), "config.toml")
+	var config userConfig
+	_, err := toml.DecodeFile(configPath, &config)
+	if err != nil && !os.IsNotExist(err) {
+		return config, utils.WrapError("Failed to load `"+configPath+"`", err)
+	}
+	for executable, overridePath := range config.ExecutableOverrides {
+		if !strings.Contains(executable, "/") {
+			return config, errors.New("Failed to load `" + configPath + "`: Expected the executable override `" + executable + "` to be like `SOURCE/EXECUTABLE`")
+		}
+		if !path.IsAbs(overridePath) {
+			return config, errors.New("Failed to load `" + configPath + "`: Expected the executable override for `" + executable + "` to be an absolute path, but got `" + overridePath + "`")
+		}
+	}
+	for executable, limits := range config.ResourceLimits {
+		if err := limits.Validate(); err != nil {
+			return config, utils.WrapError("Failed to load `"+configPath+"`: Invalid resource limits for `"+executable+"`", err)
+		}
+	}
+	for _, systemStore := range config.SystemStores {
+		if !path.IsAbs(systemStore) {
+			return config, errors.New("Failed to load `" + configPath + "`: Expected the system store `" + systemStore + "` to be an absolute path")
+		}
+	}
+	if config.MemoryBudget != "" {
+		memoryBudget, err := utils.ParseSize(config.MemoryBudget)
+		if err != nil {
+			return config, utils.WrapError("Failed to load `"+configPath+"`: Invalid memory budget", err)
+		}
+		if memoryBudget < minMemoryBudget {
+			return config, errors.New("Failed to load `" + configPath + "`: Expected the memory budget to be at least " + utils.FormatSize(minMemoryBudget) + ", but got `" + config.MemoryBudget + "`")
+		}
+		utils.SetMemoryBudget(memoryBudget)
+	}
+	utils.SetGithubToken(config.GithubToken)
+	if err := utils.SetExtractionSync(config.ExtractionSync); err != nil {
+		return config, utils.WrapError("Failed to load `"+configPath+"`", err)
+	}
+	if err := utils.SetTlsPolicy(config.RequireHttps, config.PinnedPublicKeys); err != nil {
+		return config, utils.WrapError("Failed to load `"+configPath+"`", err)
+	}
+	if _, err := time.ParseDuration(config.NotifyAfter); config.NotifyAfter != "" && err != nil {
+		return config, errors.New("Failed to load `" + configPath + "`: Expected `NotifyAfter` to be a duration like `30s` or `2m`, but got `" + config.NotifyAfter + "`")
+	}
+	return config, nil
+}
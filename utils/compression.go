@@ -4,7 +4,11 @@ import "errors"
 import "io"
 import "os"
 import "path"
-import "bytes"
+import "path/filepath"
+import "bufio"
+import "strconv"
+import "slices"
+import "strings"
 import "archive/zip"
 import "archive/tar"
 import "compress/gzip"
@@ -12,80 +16,253 @@ import "compress/bzip2"
 import "github.com/ulikunitz/xz"
 import "github.com/klauspost/compress/zstd"
 
-func archivePathToSystemPath(pathRelativeToArchiveRoot string, rootPath string, absoluteDestination string) (absolutePath string, inRoot bool) {
+// The root path of an archive, which may contain glob patterns (see `path.Match`) so that archives with a top-level
+// directory that cannot be predicted (for example one that contains a build hash) can still be extracted. The root path
+// is resolved against the first path in the archive that matches it, so that if several directories match, only one
+// of them is extracted.
+type archiveRoot struct {
+	pattern    string
+	isGlob     bool
+	isResolved bool
+	resolved   string
+	matched    bool
+	filter     ExtractionFilter
+}
+
+// Glob patterns of the paths in an archive, relative to the root path of the archive, that are extracted, and that are
+// not extracted. A pattern that matches a directory also matches everything in the directory. If there are no patterns
+// to include, then every path that is not excluded is extracted.
+type ExtractionFilter struct {
+	Include []string
+	Exclude []string
+}
+
+func (f ExtractionFilter) allows(pathRelativeToDestination string) bool {
+	if pathRelativeToDestination == "" {
+		// The root of the archive is always extracted
+		return true
+	}
+	for _, pattern := range f.Exclude {
+		if MatchPathOrParent(pattern, pathRelativeToDestination) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if MatchPathOrParent(pattern, pathRelativeToDestination) {
+			return true
+		}
+	}
+	return false
+}
+
+func newArchiveRoot(rootPath string, filter ExtractionFilter) *archiveRoot {
+	if !strings.ContainsAny(rootPath, "*?[") {
+		return &archiveRoot{pattern: rootPath, isResolved: true, resolved: rootPath, filter: filter}
+	}
+	return &archiveRoot{pattern: strings.TrimSuffix(rootPath, "/"), isGlob: true, filter: filter}
+}
+
+func (r *archiveRoot) resolve(cleanPathRelativeToArchiveRoot string) bool {
+	patternComponents := strings.Split(r.pattern, "/")
+	pathComponents := strings.Split(cleanPathRelativeToArchiveRoot, "/")
+	if len(pathComponents) < len(patternComponents) {
+		return false
+	}
+	for i, patternComponent := range patternComponents {
+		matches, err := path.Match(patternComponent, pathComponents[i])
+		if err != nil || !matches {
+			return false
+		}
+	}
+	r.resolved = strings.Join(pathComponents[:len(patternComponents)], "/")
+	r.isResolved = true
+	return true
+}
+
+// Converts a path in an archive into the path on the filesystem that it is extracted to, relative to the directory
+// that the archive is extracted into, which is empty for the root path itself. Paths in archives are always separated
+// by `/`, and are handled with the `path` package, while paths on the filesystem are handled with the `path/filepath`
+// package. This is the only place that one is converted into the other.
+func (r *archiveRoot) archivePathToSystemPath(pathRelativeToArchiveRoot string) (relativePath string, inRoot bool) {
 	// Use `path.Clean` to stop a path like `ROOT_PATH/../../../../../../` being able to pass the inRoot check
 	// SECURITY: This is necersarry to stop compressed files from being able to create directories/files outside the destination
-	pathRelativeToDestination, inRoot := TrimPrefix(path.Clean(pathRelativeToArchiveRoot), rootPath)
+	cleanPath := path.Clean(pathRelativeToArchiveRoot)
+	if !r.isResolved && !r.resolve(cleanPath) {
+		return "", false
+	}
+	pathRelativeToDestination, inRoot := TrimPrefix(cleanPath, r.resolved)
 	if !inRoot {
 		return "", false
 	}
-	return path.Join(absoluteDestination, pathRelativeToDestination), true
+	if r.isGlob && pathRelativeToDestination != "" && pathRelativeToDestination[0] != '/' {
+		// Do not let a root path resolved from `tool-*` to `tool-1` match `tool-10`
+		return "", false
+	}
+	r.matched = true
+	if !r.filter.allows(strings.TrimPrefix(pathRelativeToDestination, "/")) {
+		return "", false
+	}
+	if pathRelativeToDestination == "" {
+		return "", true
+	}
+	// On systems that do not separate paths with `/`, a name in an archive could contain the separator of the system,
+	// like `..\..\file` on Windows, or a reserved name, so the path has to stay inside the destination once it is
+	// converted as well
+	localPath := filepath.FromSlash(strings.TrimPrefix(pathRelativeToDestination, "/"))
+	if !filepath.IsLocal(localPath) {
+		return "", false
+	}
+	return localPath, true
+}
+
+// Returns the path of an entry in a zip archive. Zip archives should always separate paths with `/`, but some tools on
+// Windows separate them with `\`, which would otherwise be extracted as part of the name of a file in the root of the
+// archive. Tarballs are not changed like this, since `\` is a valid character in a name on POSIX systems.
+func zipEntryName(file *zip.File) string {
+	return strings.ReplaceAll(file.Name, "\\", "/")
 }
 
+func (r *archiveRoot) errorIfUnmatched() error {
+	if !r.matched {
+		return errors.New("No path in the archive matches the root path `" + r.pattern + "`")
+	}
+	return nil
+}
+
+// The compression methods that zip entries can use besides the ones that `archive/zip` supports, which are stored and
+// deflate
+const (
+	zipMethodBzip2 uint16 = 12
+	zipMethodZstd  uint16 = 93
+	zipMethodXz    uint16 = 95
+)
+
+// The names of the zip compression methods that bento does not support, which are used to explain why an entry could
+// not be extracted
+var unsupportedZipMethodNames = map[uint16]string{
+	9:  "deflate64",
+	14: "lzma",
+	98: "ppmd",
+	99: "AES encryption",
+}
+
+func bzip2ZipDecompressor(compressed io.Reader) io.ReadCloser {
+	return io.NopCloser(bzip2.NewReader(compressed))
+}
+
+func zstdZipDecompressor(compressed io.Reader) io.ReadCloser {
+	decompressed, err := zstd.NewReader(compressed, zstdDecoderOptions()...)
+	if err != nil {
+		return io.NopCloser(&errorReader{err})
+	}
+	return decompressed.IOReadCloser()
+}
+
+func xzZipDecompressor(compressed io.Reader) io.ReadCloser {
+	decompressed, err := xz.NewReader(compressed)
+	if err != nil {
+		return io.NopCloser(&errorReader{err})
+	}
+	return io.NopCloser(decompressed)
+}
+
+// A reader that always fails, which is used when a decompressor cannot be created
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// Extracts a zip archive. Archives larger than 4 GiB, and entries that are compressed with bzip2, zstd, or xz, are
+// supported. Zip archives are read from a file rather than from memory, because the entries of a zip archive have to be
+// read in a different order to the order that they are downloaded in.
 func extractZip(
-	stream *bytes.Reader,
+	archive *os.File,
 	destination string,
-	rootPath string,
+	rootPath *archiveRoot,
 ) error {
-	unzipped, err := zip.NewReader(stream, int64(stream.Len()))
+	archiveInfo, err := archive.Stat()
+	if err != nil {
+		return err
+	}
+	unzipped, err := zip.NewReader(archive, archiveInfo.Size())
 	if err != nil {
 		return err
 	}
+	unzipped.RegisterDecompressor(zipMethodBzip2, bzip2ZipDecompressor)
+	unzipped.RegisterDecompressor(zipMethodZstd, zstdZipDecompressor)
+	unzipped.RegisterDecompressor(zipMethodXz, xzZipDecompressor)
+	dir, err := openExtractionDir(destination)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
 	for _, file := range unzipped.File {
-		filePath, inRoot := archivePathToSystemPath(file.Name, rootPath, destination)
+		filePath, inRoot := rootPath.archivePathToSystemPath(zipEntryName(file))
 		if !inRoot {
 			continue
 		}
 
 		if file.FileInfo().IsDir() {
-			err := os.MkdirAll(filePath, file.Mode())
+			err := dir.mkdirAll(filePath, file.Mode().Perm())
 			if err != nil {
 				return err
 			}
 		} else {
-			err := os.MkdirAll(path.Dir(filePath), 0755)
-			if err != nil {
-				return err
-			}
-
-			zipFile, err := file.Open()
-			if err != nil {
-				return err
+			err = extractZipFile(file, dir, filePath)
+			if errors.Is(err, zip.ErrAlgorithm) {
+				methodName, known := unsupportedZipMethodNames[file.Method]
+				if !known {
+					methodName = "unknown"
+				}
+				return errors.New("`" + file.Name + "` is compressed with the zip compression method " + strconv.Itoa(int(file.Method)) + " (" + methodName + "), which is not supported. Supported methods are 0 (stored), 8 (deflate), 12 (bzip2), 93 (zstd), and 95 (xz).")
+			} else if err != nil {
+				return WrapError("Failed to extract `"+file.Name+"`", err)
 			}
-			defer zipFile.Close()
+		}
+	}
+	return rootPath.errorIfUnmatched()
+}
 
-			if file.Mode()&os.ModeSymlink != 0 {
-				symlinkTarget, err := io.ReadAll(zipFile)
-				if err != nil {
-					return err
-				}
-				err = os.Symlink(string(symlinkTarget), filePath)
-				if err != nil {
-					return err
-				}
-			} else {
-				destFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-				if err != nil {
-					return err
-				}
-				defer destFile.Close()
+// Extracts a single file from a zip archive. This is a separate function so that the files are closed after each file
+// is extracted, rather than after the whole archive is extracted.
+func extractZipFile(file *zip.File, dir *extractionDir, filePath string) error {
+	zipFile, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer zipFile.Close()
 
-				_, err = io.Copy(destFile, zipFile)
-				if err != nil {
-					return err
-				}
-			}
+	if file.Mode()&os.ModeSymlink != 0 {
+		symlinkTarget, err := io.ReadAll(zipFile)
+		if err != nil {
+			return err
 		}
+		return dir.symlink(filePath, string(symlinkTarget))
 	}
-	return nil
+	destFile, err := dir.createFile(filePath, file.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	return writeExtractedFile(destFile, zipFile)
 }
 
 func extractTar(
 	stream io.Reader,
 	destination string,
-	rootPath string,
+	rootPath *archiveRoot,
 ) error {
 	untarredStream := tar.NewReader(stream)
+	dir, err := openExtractionDir(destination)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
 	for true {
 		header, err := untarredStream.Next()
 		if err == io.EOF {
@@ -94,34 +271,29 @@ func extractTar(
 			return err
 		}
 
-		headerOutputPath, inRoot := archivePathToSystemPath(header.Name, rootPath, destination)
+		headerOutputPath, inRoot := rootPath.archivePathToSystemPath(header.Name)
 		if !inRoot {
 			continue
 		}
 
 		switch header.Typeflag {
 		case tar.TypeReg:
-			err = os.MkdirAll(path.Dir(headerOutputPath), 0755)
-			if err != nil {
-				return err
-			}
 			var outFile *os.File
-			outFile, err = os.OpenFile(headerOutputPath, os.O_CREATE|os.O_WRONLY, header.FileInfo().Mode().Perm())
+			outFile, err = dir.createFile(headerOutputPath, header.FileInfo().Mode().Perm())
 			if err != nil {
 				return err
 			}
-			defer outFile.Close()
-			_, err = io.Copy(outFile, untarredStream)
+			err = writeExtractedFile(outFile, untarredStream)
 		case tar.TypeLink:
-			linkOldPath, linkOldPathInRoot := archivePathToSystemPath(header.Linkname, rootPath, destination)
+			linkOldPath, linkOldPathInRoot := rootPath.archivePathToSystemPath(header.Linkname)
 			if !linkOldPathInRoot {
 				continue
 			}
-			err = os.Link(linkOldPath, headerOutputPath)
+			err = dir.link(headerOutputPath, linkOldPath)
 		case tar.TypeSymlink:
-			err = os.Symlink(header.Linkname, headerOutputPath)
+			err = dir.symlink(headerOutputPath, header.Linkname)
 		case tar.TypeDir:
-			err = os.MkdirAll(headerOutputPath, 0755)
+			err = dir.mkdirAll(headerOutputPath, 0755)
 		default:
 			return errors.New("Unknown type: " + string([]byte{header.Typeflag}) + " in " + header.Name)
 		}
@@ -129,17 +301,81 @@ func extractTar(
 			return err
 		}
 	}
-	return nil
+	return rootPath.errorIfUnmatched()
 }
 
-func extract(
-	data []byte,
+// An archive inside another archive that contains the files that are extracted, for downloads like a zip that
+// contains a tarball
+type InnerArchive struct {
+	// The path of the inner archive in the archive that contains it. This is ignored if the archive that contains it is
+	// a single compressed file, like a `.gz` file.
+	Path        string
+	Compression string
+}
+
+// Extracts the innermost archive in `innerArchives`, or `archive` itself if there are no inner archives. Each inner
+// archive is extracted into a temporary directory next to the destination, which is removed afterwards.
+func extractNested(
+	archive *os.File,
 	compressionType string,
+	innerArchives []InnerArchive,
 	destination string,
-	rootPath string,
+	unresolvedRootPath string,
+	filter ExtractionFilter,
 ) error {
-	stream := bytes.NewReader(data)
-	var uncompressedFileStream io.Reader
+	for _, innerArchive := range innerArchives {
+		err := os.MkdirAll(filepath.Dir(destination), 0755)
+		if err != nil {
+			return err
+		}
+		temporaryDir, err := os.MkdirTemp(filepath.Dir(destination), ".inner.tmp-"+strconv.Itoa(os.Getpid())+"-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(temporaryDir)
+		innerArchivePath := filepath.Join(temporaryDir, filepath.FromSlash(innerArchive.Path))
+		if compressionType == ".gz" || compressionType == "none" {
+			innerArchivePath = filepath.Join(temporaryDir, "archive")
+			err = extract(archive, compressionType, innerArchivePath, "", ExtractionFilter{})
+		} else {
+			err = extract(archive, compressionType, temporaryDir, "", ExtractionFilter{Include: []string{innerArchive.Path}})
+		}
+		if err != nil {
+			return WrapError("Failed to extract the inner archive `"+innerArchive.Path+"`", err)
+		}
+		archive, err = os.Open(innerArchivePath)
+		if os.IsNotExist(err) {
+			return errors.New("The archive does not contain the inner archive `" + innerArchive.Path + "`")
+		} else if err != nil {
+			return err
+		}
+		defer archive.Close()
+		compressionType = innerArchive.Compression
+	}
+	return extract(archive, compressionType, destination, unresolvedRootPath, filter)
+}
+
+// Returns the comment of a zip archive. Archives that are generated by GitHub have the commit that they were generated
+// from as their comment.
+func ZipComment(archive *os.File) (string, error) {
+	archiveInfo, err := archive.Stat()
+	if err != nil {
+		return "", err
+	}
+	unzipped, err := zip.NewReader(archive, archiveInfo.Size())
+	if err != nil {
+		return "", err
+	}
+	return unzipped.Comment, nil
+}
+
+// Returns true if a compression format is a compressed tarball, which can be extracted from a stream that cannot seek
+func isTarball(compressionType string) bool {
+	return slices.Contains([]string{".tar.gz", ".tar.xz", ".tar.zst", ".tbz"}, compressionType)
+}
+
+// Decompresses and extracts a tarball as it is read from `stream`
+func extractTarball(stream io.Reader, compressionType string, destination string, rootPath *archiveRoot) error {
 	switch compressionType {
 	case ".tar.gz":
 		partiallyUncompressedStream, err := gzip.NewReader(stream)
@@ -154,16 +390,43 @@ func extract(
 		}
 		return extractTar(partiallyUncompressedStream, destination, rootPath)
 	case ".tar.zst":
-		partiallyUncompressedStream, err := zstd.NewReader(stream)
+		partiallyUncompressedStream, err := zstd.NewReader(stream, zstdDecoderOptions()...)
 		if err != nil {
 			return err
 		}
+		defer partiallyUncompressedStream.Close()
 		return extractTar(partiallyUncompressedStream, destination, rootPath)
 	case ".tbz":
-		partiallyUncompressedStream := bzip2.NewReader(stream)
-		return extractTar(partiallyUncompressedStream, destination, rootPath)
+		return extractTar(bzip2.NewReader(stream), destination, rootPath)
+	}
+	return errors.New("`" + compressionType + "` is not a compressed tarball")
+}
+
+// Extracts an archive into `destination`, like a download without inner archives or a filter
+func Extract(archive *os.File, compressionType string, destination string, rootPath string) error {
+	return extract(archive, compressionType, destination, rootPath, ExtractionFilter{})
+}
+
+func extract(
+	archive *os.File,
+	compressionType string,
+	destination string,
+	unresolvedRootPath string,
+	filter ExtractionFilter,
+) error {
+	_, err := archive.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	stream := bufio.NewReader(archive)
+	rootPath := newArchiveRoot(unresolvedRootPath, filter)
+	if isTarball(compressionType) {
+		return extractTarball(stream, compressionType, destination, rootPath)
+	}
+	var uncompressedFileStream io.Reader
+	switch compressionType {
 	case ".zip":
-		return extractZip(stream, destination, rootPath)
+		return extractZip(archive, destination, rootPath)
 	case ".gz":
 		var err error
 		uncompressedFileStream, err = gzip.NewReader(stream)
@@ -175,7 +438,7 @@ func extract(
 	default:
 		return errors.New("Unknown compression format `" + compressionType + "`. Supported compression formats are `.tar.gz`, `.tar.xz`, `.tar.zst`, `.tbz`, `.zip`, `.gz` and `none`.")
 	}
-	err := os.MkdirAll(path.Dir(destination), 0755)
+	err = os.MkdirAll(filepath.Dir(destination), 0755)
 	if err != nil {
 		return err
 	}
@@ -183,7 +446,5 @@ func extract(
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
-	_, err = io.Copy(outFile, uncompressedFileStream)
-	return err
+	return writeExtractedFile(outFile, uncompressedFileStream)
 }
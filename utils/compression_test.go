@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testEntry struct {
+	name string
+	// Either `tar.TypeReg`, `tar.TypeDir`, `tar.TypeSymlink`, or `tar.TypeLink`
+	kind       byte
+	linkTarget string
+}
+
+func writeTestTar(t *testing.T, file *os.File, entries []testEntry) {
+	archive := tar.NewWriter(file)
+	for _, entry := range entries {
+		contents := ""
+		if entry.kind == tar.TypeReg {
+			contents = "contents\n"
+		}
+		err := archive.WriteHeader(&tar.Header{Name: entry.name, Typeflag: entry.kind, Mode: 0644, Linkname: entry.linkTarget, Size: int64(len(contents))})
+		if err == nil {
+			_, err = archive.Write([]byte(contents))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, file *os.File, entries []testEntry) {
+	archive := zip.NewWriter(file)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name}
+		contents := "contents\n"
+		switch entry.kind {
+		case tar.TypeLink:
+			// Zip archives cannot have hard links
+			continue
+		case tar.TypeSymlink:
+			header.SetMode(fs.ModeSymlink | 0777)
+			contents = entry.linkTarget
+		case tar.TypeDir:
+			header.SetMode(fs.ModeDir | 0755)
+			contents = ""
+		default:
+			header.SetMode(0644)
+		}
+		writer, err := archive.CreateHeader(header)
+		if err == nil {
+			_, err = writer.Write([]byte(contents))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns the paths in `dir` that are not in `destination`
+func pathsOutside(t *testing.T, dir string, destination string) []string {
+	outside := []string{}
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == destination {
+			return filepath.SkipDir
+		}
+		if filePath != dir && filePath != filepath.Dir(destination) {
+			outside = append(outside, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outside
+}
+
+func TestExtractKeepsEntriesInDestination(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testEntry
+		// Paths that must be extracted into the destination
+		extracted []string
+		// Whether extracting the archive must fail, rather than skipping the entries that would escape
+		fails bool
+	}{
+		{"plain", []testEntry{
+			{"tool/bin/tool", tar.TypeReg, ""},
+			{"tool/share/", tar.TypeDir, ""},
+		}, []string{"tool/bin/tool", "tool/share"}, false},
+		{"dot dot", []testEntry{
+			{"../x", tar.TypeReg, ""},
+			{"tool/bin/tool", tar.TypeReg, ""},
+		}, []string{"tool/bin/tool"}, false},
+		{"nested dot dot", []testEntry{
+			{"a/../../x", tar.TypeReg, ""},
+			{"a/../b", tar.TypeReg, ""},
+		}, []string{"b"}, false},
+		{"backslash dot dot", []testEntry{
+			{"..\\x", tar.TypeReg, ""},
+		}, nil, false},
+		{"absolute", []testEntry{
+			{"/x", tar.TypeReg, ""},
+		}, nil, false},
+		{"relative symlinks", []testEntry{
+			{"tool/bin/tool-1.0", tar.TypeReg, ""},
+			{"tool/bin/tool", tar.TypeSymlink, "tool-1.0"},
+			{"tool/lib", tar.TypeSymlink, "bin"},
+			{"tool/lib/through-symlink", tar.TypeReg, ""},
+			{"tool/dangling", tar.TypeSymlink, "does-not-exist"},
+		}, []string{"tool/bin/tool", "tool/bin/through-symlink", "tool/dangling"}, false},
+		{"symlink out of destination then write", []testEntry{
+			{"tool/", tar.TypeDir, ""},
+			{"tool/up", tar.TypeSymlink, "../.."},
+			{"tool/up/x", tar.TypeReg, ""},
+		}, nil, true},
+		{"absolute symlink", []testEntry{
+			{"tool/", tar.TypeDir, ""},
+			{"tool/etc", tar.TypeSymlink, "/etc"},
+		}, nil, true},
+		{"symlink chain out of destination then write", []testEntry{
+			{"tool/", tar.TypeDir, ""},
+			{"tool/here", tar.TypeSymlink, "."},
+			{"tool/up", tar.TypeSymlink, "here/../.."},
+			{"tool/up/x", tar.TypeReg, ""},
+		}, nil, true},
+		{"hard link into directory without an entry", []testEntry{
+			{"tool/bin/tool", tar.TypeReg, ""},
+			{"tool/libexec/tool", tar.TypeLink, "tool/bin/tool"},
+		}, []string{"tool/bin/tool"}, false},
+		{"hard link to outside of destination", []testEntry{
+			{"tool/bin/tool", tar.TypeReg, ""},
+			{"tool/x", tar.TypeLink, "../x"},
+		}, []string{"tool/bin/tool"}, false},
+	}
+	for _, test := range tests {
+		for _, format := range []string{"tar", "zip"} {
+			t.Run(test.name+" "+format, func(t *testing.T) {
+				archive, err := os.Create(filepath.Join(t.TempDir(), "archive"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer archive.Close()
+				// Entries that escape the destination are still inside of the temporary directory
+				dir := t.TempDir()
+				destination := filepath.Join(dir, "outer", "destination")
+				if format == "zip" {
+					writeTestZip(t, archive, test.entries)
+					err = extract(archive, ".zip", destination, "", ExtractionFilter{})
+				} else {
+					writeTestTar(t, archive, test.entries)
+					_, err = archive.Seek(0, io.SeekStart)
+					if err == nil {
+						err = extractTar(archive, destination, newArchiveRoot("", ExtractionFilter{}))
+					}
+				}
+				if test.fails && err == nil {
+					t.Error("Expected extracting the archive to fail")
+				} else if !test.fails && err != nil {
+					t.Error(err)
+				}
+				if outside := pathsOutside(t, dir, destination); len(outside) > 0 {
+					t.Errorf("Wrote outside of the destination: %v", outside)
+				}
+				if _, err := os.Lstat("/x"); err == nil {
+					t.Error("Wrote `/x`")
+				}
+				for _, extractedPath := range test.extracted {
+					if _, err := os.Lstat(filepath.Join(destination, extractedPath)); err != nil {
+						t.Error(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Returns the path that a file with `checksum` and `mode` is stored at in a content-addressed store. Hard links share
+// permissions, so files with the same contents but different permissions are stored separately.
+func contentStorePath(storeDir string, checksum string, mode fs.FileMode) string {
+	return filepath.Join(storeDir, checksum[:2], checksum+"-"+strconv.FormatUint(uint64(mode.Perm()), 8))
+}
+
+// Replaces `filePath` with a hard link to `storePath`
+func linkFromStore(storePath string, filePath string) error {
+	temporaryPath := filePath + ".bento-dedup"
+	err := os.Link(storePath, temporaryPath)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temporaryPath, filePath)
+	if err != nil {
+		os.Remove(temporaryPath)
+	}
+	return err
+}
+
+// Adds a file to a content-addressed store, or replaces it with a hard link to the file in the store that has the same
+// contents. The file is made read-only first, since a file in the store is shared by every tree that links to it, so
+// writing to it through one tree would change the others. Returns true if the file was replaced.
+func addFileToContentStore(filePath string, info fs.FileInfo, storeDir string) (bool, error) {
+	checksum, err := hashFile(filePath)
+	if err != nil {
+		return false, err
+	}
+	mode := info.Mode() &^ 0222
+	if mode != info.Mode() {
+		err = os.Chmod(filePath, mode)
+		if err != nil {
+			return false, err
+		}
+	}
+	storePath := contentStorePath(storeDir, checksum, mode)
+	err = os.MkdirAll(filepath.Dir(storePath), 0755)
+	if err != nil {
+		return false, err
+	}
+	storeInfo, err := os.Lstat(storePath)
+	if err == nil && os.SameFile(info, storeInfo) {
+		return false, nil
+	} else if err == nil {
+		// A file in the store can still be made writable and changed through one of the trees that link to it, so if it
+		// was, it is replaced with the file that is being added, instead of spreading the change to another tree
+		same, err := filesHaveSameContents(storePath, filePath)
+		if err != nil {
+			return false, err
+		}
+		if same && storeInfo.Mode() == mode {
+			return true, linkFromStore(storePath, filePath)
+		}
+		err = os.Remove(storePath)
+		if err != nil {
+			return false, err
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	err = os.Link(filePath, storePath)
+	if errors.Is(err, fs.ErrExist) {
+		// Another bento process added the same file at the same time
+		return true, linkFromStore(storePath, filePath)
+	}
+	return false, err
+}
+
+// Adds every regular file in `tree` to the content-addressed store in `storeDir`, where each file is named after its
+// checksum, so that identical files in any source or version share the same data on disk. Files that are already in the
+// store are replaced with hard links to it. Returns the number of bytes saved.
+func AddTreeToContentStore(tree string, storeDir string) (int64, error) {
+	bytesSaved := int64(0)
+	err := filepath.WalkDir(tree, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		replaced, err := addFileToContentStore(filePath, info, storeDir)
+		if replaced && err == nil {
+			bytesSaved += info.Size()
+		}
+		return err
+	})
+	return bytesSaved, err
+}
+
+// Removes the files in a content-addressed store that no tree links to anymore. Returns the number of bytes freed.
+// Nothing is removed on systems where the number of links to a file is not known.
+func PruneContentStore(storeDir string) (int64, error) {
+	bytesFreed := int64(0)
+	err := filepath.WalkDir(storeDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && filePath == storeDir {
+			return filepath.SkipAll
+		} else if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if links, known := linkCount(info); known && links == 1 {
+			err = os.Remove(filePath)
+			if err == nil {
+				bytesFreed += info.Size()
+			}
+		}
+		return err
+	})
+	return bytesFreed, err
+}
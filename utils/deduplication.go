@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+func filesHaveSameContents(pathA string, pathB string) (bool, error) {
+	fileA, err := os.Open(pathA)
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(pathB)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+	bufferA := make([]byte, 64*1024)
+	bufferB := make([]byte, 64*1024)
+	for {
+		lengthA, errA := io.ReadFull(fileA, bufferA)
+		lengthB, errB := io.ReadFull(fileB, bufferB)
+		if !bytes.Equal(bufferA[:lengthA], bufferB[:lengthB]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		} else if errA != nil {
+			return false, errA
+		} else if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+	}
+}
+
+// Replaces `duplicatePath` with a reflink of `originalPath` if the filesystem supports reflinks, or a hard link to
+// `originalPath` if it does not
+func linkDuplicate(originalPath string, duplicatePath string, duplicateMode fs.FileMode) error {
+	temporaryPath := duplicatePath + ".bento-dedup"
+	err := reflink(originalPath, temporaryPath)
+	if err == nil {
+		err = os.Chmod(temporaryPath, duplicateMode)
+	} else {
+		err = os.Link(originalPath, temporaryPath)
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+		return err
+	}
+	return os.Rename(temporaryPath, duplicatePath)
+}
+
+// Finds the files in `tree` that are identical to the file at the same path in one of `otherTrees`, and replaces them
+// with links to the identical file to save disk space. Returns the number of bytes saved.
+func DeduplicateTree(tree string, otherTrees []string) (int64, error) {
+	bytesSaved := int64(0)
+	err := filepath.WalkDir(tree, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(tree, filePath)
+		if err != nil {
+			return err
+		}
+		for _, otherTree := range otherTrees {
+			otherPath := filepath.Join(otherTree, relativePath)
+			otherInfo, err := os.Lstat(otherPath)
+			if err != nil || !otherInfo.Mode().IsRegular() || otherInfo.Size() != info.Size() {
+				continue
+			}
+			if os.SameFile(info, otherInfo) {
+				break
+			}
+			// Hard links share permissions, so only link files with the same permissions
+			if otherInfo.Mode() != info.Mode() {
+				continue
+			}
+			same, err := filesHaveSameContents(filePath, otherPath)
+			if err != nil {
+				return err
+			}
+			if same {
+				err := linkDuplicate(otherPath, filePath, info.Mode())
+				if err != nil {
+					return err
+				}
+				bytesSaved += info.Size()
+				break
+			}
+		}
+		return nil
+	})
+	return bytesSaved, err
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Returns an absolute, clean version of `target`, with the symbolic links in the part of it that exists resolved, so
+// that a path through a link to somewhere else is compared by where it really is
+func resolveExistingPath(target string) string {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return path.Clean(target)
+	}
+	missing := []string{}
+	for {
+		resolved, err := filepath.EvalSymlinks(target)
+		if err == nil {
+			return path.Join(append([]string{resolved}, missing...)...)
+		}
+		parent := path.Dir(target)
+		if parent == target {
+			return path.Join(append([]string{target}, missing...)...)
+		}
+		missing = append([]string{path.Base(target)}, missing...)
+		target = parent
+	}
+}
+
+// Returns true if `target` is `root`, or is inside it, once both are resolved
+func IsWithin(target string, root string) bool {
+	resolvedTarget, resolvedRoot := resolveExistingPath(target), resolveExistingPath(root)
+	return resolvedRoot == "/" || resolvedTarget == resolvedRoot || strings.HasPrefix(resolvedTarget, resolvedRoot+"/")
+}
+
+// Returns true if a relative path from a config could refer to something outside of the directory that it is
+// relative to
+func escapesDirectory(relativePath string) bool {
+	cleaned := path.Clean(relativePath)
+	return path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../")
+}
+
+// Checks a path from a config that is joined onto a directory, like a subdirectory that an artifact is extracted to
+func ValidateRelativePath(relativePath string) error {
+	if escapesDirectory(relativePath) {
+		return errors.New("Expected `" + relativePath + "` to be a relative path that stays inside the directory that it is relative to")
+	}
+	return nil
+}
+
+// Cleans the path in an archive that is extracted. Paths in archives are cleaned before they are compared with it, so
+// a root path like `./tool` would never match anything otherwise. A trailing slash is kept.
+func NormalizeRootPath(rootPath string) (string, error) {
+	if rootPath == "" {
+		return "", nil
+	}
+	if escapesDirectory(rootPath) {
+		return "", errors.New("Expected the root path `" + rootPath + "` to be a relative path inside the archive")
+	}
+	normalized := path.Clean(rootPath)
+	if normalized == "." {
+		return "", nil
+	}
+	if strings.HasSuffix(rootPath, "/") {
+		normalized += "/"
+	}
+	return normalized, nil
+}
+
+// Returns true if two paths are the same, or if one of them is inside the other
+func pathsOverlap(a string, b string) bool {
+	a, b = path.Clean(a), path.Clean(b)
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+func (options DownloadOptions) destinationOwner() string {
+	if options.DestinationOwner != "" {
+		return options.DestinationOwner
+	}
+	return options.Name
+}
+
+// Returns true if two downloads would extract the same files to the same place, so that only one of them needs to be
+// done
+func isSameExtraction(a DownloadOptions, b DownloadOptions) bool {
+	return path.Clean(a.Destination) == path.Clean(b.Destination) &&
+		a.UseChecksum && b.UseChecksum && a.Checksum == b.Checksum &&
+		a.Compression == b.Compression &&
+		a.RootPath == b.RootPath &&
+		slices.Equal(a.InnerArchives, b.InnerArchives) &&
+		slices.Equal(a.Filter.Include, b.Filter.Include) &&
+		slices.Equal(a.Filter.Exclude, b.Filter.Exclude) &&
+		slices.Equal(a.FilesToMakeExecutable, b.FilesToMakeExecutable) &&
+		!a.DeleteExistingFilesAtDestination && !b.DeleteExistingFilesAtDestination &&
+		a.OnExtracted == nil && b.OnExtracted == nil
+}
+
+// Removes the downloads that would extract the same files to the same place as an earlier download, and makes the
+// downloads whose destinations overlap extract one at a time, because extracting several archives into the same tree
+// at once can leave it with a mix of the files from each of them. Downloads are still fetched at the same time. Returns
+// a warning for each pair of downloads with different owners whose destinations overlap, because they are expected to
+// be extracted to different places.
+func guardDestinations(downloads []DownloadOptions) ([]DownloadOptions, []string) {
+	guarded := []DownloadOptions{}
+	warnings := []string{}
+	// The index of the group of overlapping downloads that each guarded download is in
+	groups := []int{}
+	for _, options := range downloads {
+		if index := slices.IndexFunc(guarded, func(kept DownloadOptions) bool { return isSameExtraction(kept, options) }); index >= 0 {
+			warnings = append(warnings, "`"+options.Name+"` extracts the same files to `"+options.Destination+"` as `"+guarded[index].Name+"`, so it is only extracted once")
+			onFinished, duplicateOnFinished := guarded[index].OnFinished, options.OnFinished
+			if duplicateOnFinished != nil {
+				guarded[index].OnFinished = func() {
+					if onFinished != nil {
+						onFinished()
+					}
+					duplicateOnFinished()
+				}
+			}
+			continue
+		}
+		group := len(guarded)
+		for index, kept := range guarded {
+			if !pathsOverlap(kept.Destination, options.Destination) {
+				continue
+			}
+			if kept.destinationOwner() != options.destinationOwner() {
+				warnings = append(warnings, "`"+options.Name+"` is extracted to `"+options.Destination+"`, which overlaps with `"+kept.Destination+"` that `"+kept.Name+"` is extracted to, so they are extracted one at a time")
+			}
+			// Merge the group of the earlier download into the group of this download
+			oldGroup := groups[index]
+			for otherIndex := range groups {
+				if groups[otherIndex] == oldGroup {
+					groups[otherIndex] = group
+				}
+			}
+		}
+		guarded = append(guarded, options)
+		groups = append(groups, group)
+	}
+
+	groupSizes := map[int]int{}
+	for _, group := range groups {
+		groupSizes[group] += 1
+	}
+	locks := map[int]*sync.Mutex{}
+	for index, group := range groups {
+		// A download that does not overlap with any other download does not need to wait for anything
+		if groupSizes[group] == 1 {
+			continue
+		}
+		if locks[group] == nil {
+			locks[group] = &sync.Mutex{}
+		}
+		guarded[index].extractionLock = locks[group]
+	}
+	return guarded, warnings
+}
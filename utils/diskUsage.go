@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Measures the space that trees use. Files that are hard linked into several trees, like the files that are shared
+// between the versions of a source, are only counted in the first tree that they are found in, so the sizes of several
+// trees can be added up.
+type DiskUsage struct {
+	seen map[[2]uint64]bool
+	// The size of every file that was measured, including the files that were not counted because they were hard linked
+	// into an earlier tree, which is the space that the trees would use without hard links
+	ApparentSize int64
+}
+
+func NewDiskUsage() *DiskUsage {
+	return &DiskUsage{seen: map[[2]uint64]bool{}}
+}
+
+// Returns the size of the files in a tree that were not counted in an earlier tree, along with the last time that any
+// file in the tree was accessed. Trees that do not exist are empty.
+func (u *DiskUsage) Measure(root string) (int64, time.Time, error) {
+	size := int64(0)
+	lastAccessed := time.Time{}
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && filePath == root {
+			return filepath.SkipAll
+		} else if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		identity, accessed, hasIdentity := fileIdentity(info)
+		u.ApparentSize += info.Size()
+		if info.Mode().IsRegular() && accessed.After(lastAccessed) {
+			lastAccessed = accessed
+		}
+		if hasIdentity {
+			if u.seen[identity] {
+				return nil
+			}
+			u.seen[identity] = true
+		}
+		size += info.Size()
+		return nil
+	})
+	if lastAccessed.IsZero() {
+		if info, err := os.Stat(root); err == nil {
+			lastAccessed = info.ModTime()
+		}
+	}
+	return size, lastAccessed, err
+}
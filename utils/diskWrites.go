@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// How the files that are extracted from archives are written to disk
+const (
+	// Files are not synced to disk, so a crash soon after a source is installed can leave some of its files empty or
+	// partly written, but extracting many small files is much faster
+	FastExtraction = "fast"
+	// Files, and the directories that contain them, are synced to disk before a source is moved into place, so that a
+	// source is either complete or missing after a crash
+	SafeExtraction = "safe"
+)
+
+// Whether extracted files and directories are synced to disk
+var syncExtractedFiles bool
+
+// Sets how extracted files are written to disk, which is either `FastExtraction` or `SafeExtraction`
+func SetExtractionSync(mode string) error {
+	switch mode {
+	case FastExtraction, "":
+		syncExtractedFiles = false
+	case SafeExtraction:
+		syncExtractedFiles = true
+	default:
+		return errors.New("Expected the extraction sync mode to be either `" + FastExtraction + "` or `" + SafeExtraction + "`, but got `" + mode + "`")
+	}
+	return nil
+}
+
+// The size of the buffers that extracted files are copied with. It is larger than the buffers that `io.Copy` uses, so
+// that large files are written with fewer system calls.
+const extractionBufferSize = 256 * 1024
+
+var extractionBuffers = sync.Pool{New: func() any {
+	buffer := make([]byte, extractionBufferSize)
+	return &buffer
+}}
+
+// Writes the contents of a file that is extracted from an archive, syncs it if extracted files are synced, and closes
+// it. The file is closed as soon as it is written, so that extracting an archive with many files does not keep all of
+// them open.
+func writeExtractedFile(file *os.File, contents io.Reader) error {
+	buffer := extractionBuffers.Get().(*[]byte)
+	defer extractionBuffers.Put(buffer)
+	// The file is wrapped so that `io.CopyBuffer` uses the buffer, rather than the smaller buffer of `os.File.ReadFrom`
+	_, err := io.CopyBuffer(struct{ io.Writer }{file}, contents, *buffer)
+	if err == nil && syncExtractedFiles {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Syncs a directory, so that the files that were created in it, renamed into it, or removed from it are on disk. Does
+// nothing if extracted files are not synced.
+func SyncDirectory(directory string) error {
+	if !syncExtractedFiles {
+		return nil
+	}
+	dir, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	closeErr := dir.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Syncs every directory in a tree that was extracted, which is done before it is moved into place. The files in it were
+// synced when they were extracted. Does nothing if extracted files are not synced.
+func SyncExtractedTree(root string) error {
+	if !syncExtractedFiles {
+		return nil
+	}
+	return filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		return SyncDirectory(filePath)
+	})
+}
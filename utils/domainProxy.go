@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returns true if `host` is one of `domains`, or a subdomain of one of them
+func DomainAllowed(host string, domains []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns an error if `domain` is not a domain name like `api.example.com`
+func ValidateDomain(domain string) error {
+	if domain == "" || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") || strings.Contains(domain, "..") {
+		return errors.New("Expected a domain like `example.com`, but got `" + domain + "`")
+	}
+	for _, character := range domain {
+		if !(character >= 'a' && character <= 'z' || character >= '0' && character <= '9' || character == '-' || character == '.') {
+			return errors.New("Expected a domain like `example.com` with only lowercase letters, digits, `-`, and `.`, but got `" + domain + "`")
+		}
+	}
+	return nil
+}
+
+// An HTTP proxy that only lets the programs that use it contact a list of domains and their subdomains. Programs find
+// it through `HTTP_PROXY` and similar variables, so it only limits the programs that respect them, which most HTTP
+// clients do. The domain of an HTTPS connection is checked in both the `CONNECT` request and the server name that the
+// TLS handshake asks for, so that a program cannot connect to an allowed domain and then ask for another one.
+type DomainProxy struct {
+	listener       net.Listener
+	allowedDomains []string
+	transport      *http.Transport
+	mutex          sync.Mutex
+	blocked        map[string]bool
+}
+
+// Starts a proxy that only allows `allowedDomains` on a random port of the loopback interface
+func StartDomainProxy(allowedDomains []string) (*DomainProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	proxy := &DomainProxy{
+		listener:       listener,
+		allowedDomains: allowedDomains,
+		transport:      &http.Transport{Proxy: nil},
+		blocked:        map[string]bool{},
+	}
+	go http.Serve(listener, proxy)
+	return proxy, nil
+}
+
+// The URL that the proxy is used with, like `http://127.0.0.1:PORT`
+func (p *DomainProxy) Url() string {
+	return "http://" + p.listener.Addr().String()
+}
+
+// Returns the hosts that the proxy did not let a program contact, sorted by name
+func (p *DomainProxy) Blocked() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return SortedNames(maps.Keys(p.blocked))
+}
+
+func (p *DomainProxy) Close() error {
+	return p.listener.Close()
+}
+
+func (p *DomainProxy) allows(host string) bool {
+	if DomainAllowed(host, p.allowedDomains) {
+		return true
+	}
+	p.mutex.Lock()
+	p.blocked[strings.ToLower(host)] = true
+	p.mutex.Unlock()
+	return false
+}
+
+func (p *DomainProxy) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	host := request.URL.Hostname()
+	if !p.allows(host) {
+		http.Error(writer, "bento: `"+host+"` is not one of the domains that the source declares that it contacts", http.StatusForbidden)
+		return
+	}
+	if request.Method == http.MethodConnect {
+		p.tunnel(writer, request)
+		return
+	}
+	if !request.URL.IsAbs() {
+		http.Error(writer, "bento: expected a request for an absolute URL", http.StatusBadRequest)
+		return
+	}
+	request.RequestURI = ""
+	request.Header.Del("Proxy-Connection")
+	request.Header.Del("Proxy-Authorization")
+	response, err := p.transport.RoundTrip(request)
+	if err != nil {
+		http.Error(writer, "bento: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+	for name, values := range response.Header {
+		writer.Header()[name] = values
+	}
+	writer.WriteHeader(response.StatusCode)
+	io.Copy(writer, response.Body)
+}
+
+// How long the proxy waits for a program to start a TLS handshake through a tunnel before deciding that the tunnel is
+// not used for TLS, since some protocols wait for the server to send something first
+const clientHelloTimeout = 10 * time.Second
+
+// Connects a program to the host in a `CONNECT` request, after checking the server name in its TLS handshake
+func (p *DomainProxy) tunnel(writer http.ResponseWriter, request *http.Request) {
+	upstream, err := net.DialTimeout("tcp", request.Host, 30*time.Second)
+	if err != nil {
+		http.Error(writer, "bento: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+	hijacker, ok := writer.(http.Hijacker)
+	if !ok {
+		http.Error(writer, "bento: the connection cannot be tunnelled", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	_, err = client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err != nil {
+		return
+	}
+
+	var clientHello bytes.Buffer
+	client.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	serverName := readServerName(io.TeeReader(buffered, &clientHello))
+	client.SetReadDeadline(time.Time{})
+	if serverName != "" && !p.allows(serverName) {
+		return
+	}
+	_, err = upstream.Write(clientHello.Bytes())
+	if err != nil {
+		return
+	}
+	go func() {
+		io.Copy(upstream, buffered)
+		if tcpConnection, ok := upstream.(*net.TCPConn); ok {
+			tcpConnection.CloseWrite()
+		}
+	}()
+	io.Copy(client, upstream)
+}
+
+// A connection that the TLS handshake of a program is read from, without ever replying to it
+type clientHelloConn struct {
+	// Only `Read` and `Write` are used by the handshake
+	net.Conn
+	reader io.Reader
+}
+
+func (c clientHelloConn) Read(buffer []byte) (int, error) {
+	return c.reader.Read(buffer)
+}
+
+func (c clientHelloConn) Write(buffer []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+var errClientHelloRead = errors.New("read the client hello")
+
+// Returns the server name that a TLS handshake that is read from `reader` asks for, or nothing if it does not ask for
+// one, or if `reader` does not start with a TLS handshake
+func readServerName(reader io.Reader) string {
+	serverName := ""
+	tls.Server(clientHelloConn{reader: reader}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	return serverName
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"strconv"
+)
+
+// An error that adds context to another error, so that the message explains what failed while `errors.Is` and
+// `errors.As` can still find the error that caused it
+type wrappedError struct {
+	message string
+	err     error
+}
+
+func (e *wrappedError) Error() string {
+	return e.message + ": " + e.err.Error()
+}
+
+func (e *wrappedError) Unwrap() error {
+	return e.err
+}
+
+// Returns an error with the message `message: ERR`, which unwraps to `err`
+func WrapError(message string, err error) error {
+	return &wrappedError{message: message, err: err}
+}
+
+// The sha256 checksum of a download or a file was not the checksum that it was expected to have, which can mean that
+// it was tampered with
+type ChecksumMismatchError struct {
+	// The name of the download, or the path of the file
+	Name string
+	// The URL that the download was fetched from, which is empty for files
+	Url      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	if e.Url == "" {
+		return "`" + e.Name + "` has the checksum " + e.Actual + ", but " + e.Expected + " was expected"
+	}
+	return "Expected sha256 checksum of `" + e.Name + "` from `" + e.Url + "` to be 0x" + e.Expected + ", but got 0x" + e.Actual
+}
+
+// A server responded with a status other than 200
+type HTTPStatusError struct {
+	// The URL that was fetched, which is left out of the message when it is empty because the caller mentions it
+	Url        string
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Url == "" {
+		return "Expected status 200, but got " + strconv.Itoa(e.StatusCode)
+	}
+	return "Expected `" + e.Url + "` to respond with status 200, but got " + strconv.Itoa(e.StatusCode)
+}
+
+// A download could not be fetched from a URL, because of a network failure or because the server responded with an
+// error, as opposed to being fetched but having the wrong checksum
+type FetchError struct {
+	Name string
+	Url  string
+	Err  error
+}
+
+func (e *FetchError) Error() string {
+	return "Failed to fetch `" + e.Name + "` from `" + e.Url + "`: " + e.Err.Error()
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// A download was fetched and verified, but could not be extracted
+type ExtractionError struct {
+	Name string
+	Err  error
+}
+
+func (e *ExtractionError) Error() string {
+	return "Failed to extract `" + e.Name + "`: " + e.Err.Error()
+}
+
+func (e *ExtractionError) Unwrap() error {
+	return e.Err
+}
+
+// None of the URLs of a download worked. It unwraps to the error from each URL, which are either a `FetchError` or a
+// `ChecksumMismatchError`.
+type DownloadError struct {
+	Name     string
+	UrlCount int
+	Attempts []error
+}
+
+func (e *DownloadError) Error() string {
+	return "Tried fetching `" + e.Name + "` from all " + strconv.Itoa(e.UrlCount) + " URLs, but none worked"
+}
+
+func (e *DownloadError) Unwrap() []error {
+	return e.Attempts
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A directory that an archive is extracted into. Files and directories are created through an `os.Root`, which
+// refuses to follow a symlink out of the directory, so that an entry cannot write outside of it through a symlink that
+// an earlier entry created, like `tool/up -> ../..` followed by `tool/up/file`. Every path is relative to the
+// directory.
+type extractionDir struct {
+	path string
+	root *os.Root
+}
+
+func openExtractionDir(destination string) (*extractionDir, error) {
+	err := os.MkdirAll(destination, 0755)
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(destination)
+	if err != nil {
+		return nil, err
+	}
+	return &extractionDir{path: destination, root: root}, nil
+}
+
+func (d *extractionDir) Close() error {
+	return d.root.Close()
+}
+
+// Creates a directory and every directory that contains it, like `os.Root.MkdirAll` in Go 1.25. An error is returned
+// if one of them is a symlink that points outside of the extraction directory.
+func (d *extractionDir) mkdirAll(relativePath string, perm fs.FileMode) error {
+	if relativePath == "" || relativePath == "." {
+		return nil
+	}
+	current := ""
+	for _, component := range strings.Split(relativePath, string(filepath.Separator)) {
+		current = filepath.Join(current, component)
+		err := d.root.Mkdir(current, perm)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Creates a file, along with the directories that contain it, and opens it for writing
+func (d *extractionDir) createFile(relativePath string, perm fs.FileMode) (*os.File, error) {
+	err := d.mkdirAll(filepath.Dir(relativePath), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return d.root.OpenFile(relativePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+// Creates a symlink to `target`. Symlinks that point outside of the extraction directory are refused, since anything
+// that later follows them, like making the files of a source executable, would change files outside of it.
+func (d *extractionDir) symlink(relativePath string, target string) error {
+	resolvedTarget := path.Join(path.Dir(filepath.ToSlash(relativePath)), target)
+	if path.IsAbs(target) || !filepath.IsLocal(filepath.FromSlash(resolvedTarget)) {
+		return errors.New("`" + filepath.ToSlash(relativePath) + "` is a symlink to `" + target + "`, which is outside of the directory that the archive is extracted into")
+	}
+	err := d.mkdirAll(filepath.Dir(relativePath), 0755)
+	if err != nil {
+		return err
+	}
+	// `os.Root` cannot create symlinks or hard links before Go 1.25, but creating the directory that contains the
+	// symlink through it checked that the directory is inside of the extraction directory
+	return os.Symlink(target, filepath.Join(d.path, relativePath))
+}
+
+// Creates a hard link to a file that was extracted earlier
+func (d *extractionDir) link(relativePath string, existingRelativePath string) error {
+	err := d.mkdirAll(filepath.Dir(relativePath), 0755)
+	if err != nil {
+		return err
+	}
+	// Fails if a symlink in the path of the existing file points outside of the extraction directory
+	_, err = d.root.Lstat(existingRelativePath)
+	if err != nil {
+		return err
+	}
+	return os.Link(filepath.Join(d.path, existingRelativePath), filepath.Join(d.path, relativePath))
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Sets the modification and access times of a symlink itself, rather than the file that it points to
+func setSymlinkModificationTime(symlinkPath string, modificationTime time.Time) error {
+	times := []unix.Timespec{
+		unix.NsecToTimespec(modificationTime.UnixNano()),
+		unix.NsecToTimespec(modificationTime.UnixNano()),
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, symlinkPath, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+const ficlone = 0x40049409
+
+// Makes `destination` share the data blocks of `source` on filesystems that support reflinks (copy-on-write clones),
+// like btrfs and XFS
+func reflink(source string, destination string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	destinationFile, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer destinationFile.Close()
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, destinationFile.Fd(), ficlone, sourceFile.Fd())
+	if errno != 0 {
+		os.Remove(destination)
+		return errno
+	}
+	return nil
+}
+
+// Returns the device and inode of a file, so that a file with several hard links can be recognised, along with the time
+// that the file was last accessed
+func fileIdentity(info os.FileInfo) ([2]uint64, time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return [2]uint64{}, info.ModTime(), false
+	}
+	return [2]uint64{uint64(stat.Dev), uint64(stat.Ino)}, time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec)), true
+}
+
+// Returns how many hard links a file has, or false if it is not known
+func linkCount(info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Nlink), true
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// Setting the times of a symlink itself is only supported on linux
+func setSymlinkModificationTime(symlinkPath string, modificationTime time.Time) error {
+	return nil
+}
+
+// Reflinks are only supported on linux
+func reflink(source string, destination string) error {
+	return errors.ErrUnsupported
+}
+
+// Hard links and access times are only recognised on linux, so every file is treated as a different file that was last
+// accessed when it was last modified
+func fileIdentity(info os.FileInfo) ([2]uint64, time.Time, bool) {
+	return [2]uint64{}, info.ModTime(), false
+}
+
+// Hard links are only counted on linux
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
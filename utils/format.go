@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"iter"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Compares names case-insensitively, so that `Zig` comes after `git`, and compares names that only differ in case by
+// their bytes so that the order is always the same. Unlike `sort` in a shell, the order does not depend on the locale.
+func CompareNames(a string, b string) int {
+	if order := strings.Compare(strings.ToLower(a), strings.ToLower(b)); order != 0 {
+		return order
+	}
+	return strings.Compare(a, b)
+}
+
+// Sorts names in the order that every list that bento prints uses
+func SortNames(names []string) {
+	slices.SortFunc(names, CompareNames)
+}
+
+// Returns the names in `names` sorted in the order that every list that bento prints uses
+func SortedNames(names iter.Seq[string]) []string {
+	return slices.SortedFunc(names, CompareNames)
+}
+
+// Formats the date of a time in the local timezone, like `2024-01-31`
+func FormatDate(t time.Time) string {
+	return t.Local().Format(time.DateOnly)
+}
+
+// Formats a time in the local timezone along with its offset from UTC, like `2024-01-31 14:05:00 +00:00`, so that the
+// time is not ambiguous when it is read in another timezone
+func FormatTime(t time.Time) string {
+	return t.Local().Format(time.DateTime + " -07:00")
+}
+
+// Formats how long ago something happened, like `3 days ago`
+func FormatAge(age time.Duration) string {
+	switch {
+	case age < time.Hour:
+		return CreateNoun(int(age.Minutes()), "1 minute", "minutes") + " ago"
+	case age < 24*time.Hour:
+		return CreateNoun(int(age.Hours()), "1 hour", "hours") + " ago"
+	default:
+		days := int(age.Hours() / 24)
+		return CreateNoun(days, "1 day", "days") + " ago"
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,6 +19,21 @@ func CreateNoun(quantity int, singularNoun string, pluralNoun string) string {
 	}
 }
 
+// Formats a number of bytes using binary prefixes, like `1.5 MiB`
+func FormatSize(bytes int64) string {
+	if bytes < 1024 {
+		return strconv.FormatInt(bytes, 10) + " B"
+	}
+	size := float64(bytes)
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		size /= 1024
+		if size < 1024 {
+			return strconv.FormatFloat(size, 'f', 1, 64) + " " + unit
+		}
+	}
+	return strconv.FormatFloat(size/1024, 'f', 1, 64) + " TiB"
+}
+
 const AnsiReset = "\033[0m"
 const AnsiClearBetweenCursorAndScreenEnd = "\033[0J"
 const AnsiBold = "\033[1m"
@@ -31,8 +47,9 @@ func AnsiMoveCursorUp(numberOfLines int) string {
 	return "\033[" + strconv.Itoa(numberOfLines) + "A"
 }
 
-func GetBoolDefaultYes() bool {
-	print("Y/n: ")
+// Reads a line from stdin without the trailing newline. Stdin is read one byte at a time, so that nothing after the
+// line is consumed.
+func ReadLine() string {
 	char := []byte{'0'}
 	input := ""
 	for true {
@@ -45,6 +62,12 @@ func GetBoolDefaultYes() bool {
 		}
 		input += string(char)
 	}
+	return input
+}
+
+func GetBoolDefaultYes() bool {
+	print("Y/n: ")
+	input := ReadLine()
 	switch strings.ToLower(input) {
 	case "n", "no":
 		return false
@@ -56,6 +79,20 @@ func GetBoolDefaultYes() bool {
 	}
 }
 
+func GetBoolDefaultNo() bool {
+	print("y/N: ")
+	input := ReadLine()
+	switch strings.ToLower(input) {
+	case "y", "yes":
+		return true
+	case "n", "no", "":
+		return false
+	default:
+		println("Expected either `y`, `n`, `yes`, `no`, or ``, but got `" + input + "`")
+		return GetBoolDefaultNo()
+	}
+}
+
 type InterpolationError struct {
 	CharacterIndex int
 	MessageLines   []string
@@ -161,6 +198,8 @@ const (
 type log struct {
 	message  string
 	severity logSeverity
+	// The error that the message describes, which is nil if the log is not an error or if the error has no type
+	err error
 }
 
 func info(message string) log {
@@ -175,15 +214,25 @@ func fatalError(message string) log {
 	return log{message: message, severity: fatalErrorSeverity}
 }
 
+func fatalErrorFrom(err error) log {
+	return log{message: err.Error(), severity: fatalErrorSeverity, err: err}
+}
+
 type stateWithNotifier[dataType any] struct {
 	state    *dataType
 	notifier chan struct{}
+	// Held while the state is changed, so that it can be read from another goroutine
+	mutex *sync.Mutex
 }
 
 func (s *stateWithNotifier[dataType]) setState(newState dataType) {
+	s.mutex.Lock()
 	*s.state = newState
-	if len(s.notifier) == 0 {
-		s.notifier <- struct{}{}
+	s.mutex.Unlock()
+	// The notifier only has to say that something changed, so it is not sent to when it already says that
+	select {
+	case s.notifier <- struct{}{}:
+	default:
 	}
 }
 
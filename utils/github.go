@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The token that is sent with requests to GitHub, which raises its rate limit, from the bento config of the user.
+// `GITHUB_TOKEN` is used instead when it is set.
+var githubToken string
+
+func SetGithubToken(token string) {
+	githubToken = token
+}
+
+func githubTokenForRequests() string {
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		return token
+	}
+	return githubToken
+}
+
+// The hosts that GitHub serves the package repository, its API, and release downloads from. Release downloads are
+// redirected to signed URLs on other hosts, which reject requests that also have a token, so those hosts are not here.
+var githubHosts = []string{"github.com", "api.github.com", "raw.githubusercontent.com", "codeload.github.com"}
+
+// GitHub refused a request because too many requests were made
+type RateLimitError struct {
+	Url string
+	// When GitHub accepts requests again, which is zero if GitHub did not say
+	Reset time.Time
+	// A token was sent with the request, so setting one would not raise the limit
+	Authenticated bool
+}
+
+func (e *RateLimitError) Error() string {
+	message := "GitHub's rate limit was reached when fetching `" + e.Url + "`."
+	if !e.Reset.IsZero() {
+		message += " It resets at " + FormatTime(e.Reset) + " (in " + time.Until(e.Reset).Round(time.Second).String() + ")."
+	}
+	if !e.Authenticated {
+		message += " Set `GITHUB_TOKEN`, or `GithubToken` in your bento config, to a GitHub token to raise the limit."
+	}
+	return message
+}
+
+// Returns the error for a response from GitHub that says that the rate limit was reached, or false if it does not
+func detectRateLimit(request *http.Request, response *http.Response, authenticated bool) (*RateLimitError, bool) {
+	if response.StatusCode != http.StatusForbidden && response.StatusCode != http.StatusTooManyRequests {
+		return nil, false
+	}
+	err := &RateLimitError{Url: request.URL.String(), Authenticated: authenticated}
+	if response.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, parseErr := strconv.ParseInt(response.Header.Get("X-RateLimit-Reset"), 10, 64); parseErr == nil {
+			err.Reset = time.Unix(reset, 0)
+		}
+		return err, true
+	}
+	// Secondary rate limits, which stop too many requests being made at once, only say how long to wait
+	if seconds, parseErr := strconv.Atoi(response.Header.Get("Retry-After")); parseErr == nil {
+		err.Reset = time.Now().Add(time.Duration(seconds) * time.Second)
+		return err, true
+	}
+	return nil, false
+}
+
+// Adds the GitHub token to requests to GitHub, and turns responses that say that the rate limit was reached into a
+// `RateLimitError`, so that every way that bento fetches from GitHub reports it in the same way
+type githubTransport struct {
+	next http.RoundTripper
+}
+
+func (t *githubTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.URL.Scheme != "https" || !slices.Contains(githubHosts, request.URL.Hostname()) {
+		return t.next.RoundTrip(request)
+	}
+	token := githubTokenForRequests()
+	if token != "" && request.Header.Get("Authorization") == "" {
+		request = request.Clone(request.Context())
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := t.next.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+	if rateLimitErr, limited := detectRateLimit(request, response, token != ""); limited {
+		response.Body.Close()
+		return nil, rateLimitErr
+	}
+	return response, nil
+}
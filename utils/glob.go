@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Returns true if a path that is split into components matches a pattern that is split into components. Each
+// component of the pattern is matched using `path.Match`, except for `**`, which matches any number of components.
+func matchPathComponents(patternComponents []string, pathComponents []string) bool {
+	if len(patternComponents) == 0 {
+		return len(pathComponents) == 0
+	}
+	if patternComponents[0] == "**" {
+		for index := 0; index <= len(pathComponents); index++ {
+			if matchPathComponents(patternComponents[1:], pathComponents[index:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(pathComponents) == 0 {
+		return false
+	}
+	matched, err := path.Match(patternComponents[0], pathComponents[0])
+	return err == nil && matched && matchPathComponents(patternComponents[1:], pathComponents[1:])
+}
+
+// Returns true if a pattern matches a path, or one of the directories that contain the path, so that a pattern like
+// `docs` or `share/*` matches everything in the directories that it matches
+func MatchPathOrParent(pattern string, relativePath string) bool {
+	patternComponents := strings.Split(path.Clean(pattern), "/")
+	pathComponents := strings.Split(path.Clean(relativePath), "/")
+	for length := 1; length <= len(pathComponents); length++ {
+		if matchPathComponents(patternComponents, pathComponents[:length]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns an error if a glob pattern is malformed
+func ValidateGlob(pattern string) error {
+	for _, component := range strings.Split(path.Clean(pattern), "/") {
+		if _, err := path.Match(component, ""); err != nil {
+			return errors.New("Invalid pattern `" + pattern + "`: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func IsGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// Returns the paths relative to `root` of the files in `root` that match `pattern`. Directories are never matched,
+// but `**` can be used to match the files in a directory recursively, like `bin/**`.
+func GlobFiles(root string, pattern string) ([]string, error) {
+	patternComponents := strings.Split(path.Clean(pattern), "/")
+	for _, component := range patternComponents {
+		if _, err := path.Match(component, ""); err != nil {
+			return []string{}, err
+		}
+	}
+	matches := []string{}
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if matchPathComponents(patternComponents, strings.Split(relativePath, "/")) {
+			matches = append(matches, relativePath)
+		}
+		return nil
+	})
+	return matches, err
+}
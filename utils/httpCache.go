@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A response to a small request that is saved, so that the same request can be answered without fetching it again,
+// or with a conditional request that the server can answer without sending the body again. The body is saved in a
+// file next to the record.
+type cachedResponse struct {
+	StatusCode   int
+	ETag         string
+	LastModified string
+	// The response can be used without asking the server whether it changed until this time
+	FreshUntil time.Time
+}
+
+// Returns the path that the response to a request is saved at, without an extension. Requests with a different
+// `Accept` header get a different response, so the header is part of the key.
+func cachedResponsePath(cacheDir string, url string, header http.Header) string {
+	key := sha256.Sum256([]byte(url + "\n" + header.Get("Accept")))
+	return path.Join(cacheDir, hex.EncodeToString(key[:]))
+}
+
+func readCachedResponse(responsePath string) (cachedResponse, []byte, bool) {
+	var response cachedResponse
+	_, err := toml.DecodeFile(responsePath+".toml", &response)
+	if err != nil {
+		return response, []byte{}, false
+	}
+	body, err := os.ReadFile(responsePath)
+	if err != nil {
+		return response, []byte{}, false
+	}
+	return response, body, true
+}
+
+// Saves a response. Failing to save it only means that it is fetched again, so errors are ignored.
+func writeCachedResponse(responsePath string, response cachedResponse, body []byte) {
+	err := os.MkdirAll(path.Dir(responsePath), 0755)
+	if err != nil {
+		return
+	}
+	// The body is written first, so that a record is never read with the body of an older response
+	if writeFileAtomically(responsePath, body) != nil {
+		return
+	}
+	encoded := strings.Builder{}
+	if toml.NewEncoder(&encoded).Encode(response) != nil {
+		return
+	}
+	writeFileAtomically(responsePath+".toml", []byte(encoded.String()))
+}
+
+func writeFileAtomically(filePath string, contents []byte) error {
+	temporaryPath := filePath + ".tmp-" + strconv.Itoa(os.Getpid())
+	err := os.WriteFile(temporaryPath, contents, 0644)
+	if err == nil {
+		err = os.Rename(temporaryPath, filePath)
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+	}
+	return err
+}
+
+// Returns true if a revision is the full hash of a commit, rather than a branch that can move
+func isCommitHash(revision string) bool {
+	_, err := hex.DecodeString(revision)
+	return len(revision) == 40 && err == nil
+}
+
+// Returns when a response stops being fresh, according to its `Cache-Control` header. Returns false if the response
+// must not be saved.
+func freshUntil(header http.Header, immutable bool) (time.Time, bool) {
+	maxAge := time.Duration(0)
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(strings.ToLower(directive))
+		if directive == "no-store" {
+			return time.Time{}, false
+		}
+		if seconds, isMaxAge := strings.CutPrefix(directive, "max-age="); isMaxAge {
+			parsed, err := strconv.Atoi(seconds)
+			if err == nil {
+				maxAge = time.Duration(parsed) * time.Second
+			}
+		}
+	}
+	if immutable {
+		// Nothing can change the response, so it never needs to be fetched again
+		maxAge = 100 * 365 * 24 * time.Hour
+	}
+	return time.Now().Add(maxAge), true
+}
+
+// Fetches a small file, using the response that is saved in `cacheDir` when it is still fresh, and asking the server
+// whether it changed with `If-None-Match` and `If-Modified-Since` when it is not. Successful responses and missing
+// files are saved. When `immutable` is true, the response to the URL can never change, like a file at a commit, so a
+// saved response is always used. Responses are not saved when `cacheDir` is empty.
+func fetchSmallFileWithCache(url string, header http.Header, cacheDir string, immutable bool) ([]byte, int, error) {
+	if cacheDir == "" {
+		body, statusCode, _, err := fetchSmallFile(url, header)
+		return body, statusCode, err
+	}
+	responsePath := cachedResponsePath(cacheDir, url, header)
+	cached, cachedBody, isCached := readCachedResponse(responsePath)
+	if isCached && time.Now().Before(cached.FreshUntil) {
+		return cachedBody, cached.StatusCode, nil
+	}
+
+	conditionalHeader := header.Clone()
+	if isCached && cached.ETag != "" {
+		conditionalHeader.Set("If-None-Match", cached.ETag)
+	}
+	if isCached && cached.LastModified != "" {
+		conditionalHeader.Set("If-Modified-Since", cached.LastModified)
+	}
+	body, statusCode, responseHeader, err := fetchSmallFile(url, conditionalHeader)
+	if err != nil {
+		return body, statusCode, err
+	}
+	if statusCode == http.StatusNotModified && isCached {
+		if fresh, cacheable := freshUntil(responseHeader, immutable); cacheable {
+			cached.FreshUntil = fresh
+			writeCachedResponse(responsePath, cached, cachedBody)
+		}
+		return cachedBody, cached.StatusCode, nil
+	}
+	if statusCode == http.StatusOK || statusCode == http.StatusNotFound {
+		if fresh, cacheable := freshUntil(responseHeader, immutable); cacheable {
+			writeCachedResponse(responsePath, cachedResponse{
+				StatusCode:   statusCode,
+				ETag:         responseHeader.Get("ETag"),
+				LastModified: responseHeader.Get("Last-Modified"),
+				FreshUntil:   fresh,
+			}, body)
+		}
+	}
+	return body, statusCode, nil
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Returns true if the file at `filePath` is the same as the file that `entry` describes. The checksum in `previous`,
+// which describes the file when it was last extracted, is trusted if the size and modification time of the file still
+// match it, so that the files that did not change do not have to be read.
+func fileMatchesEntry(filePath string, entry ManifestEntry, previous ManifestEntry, hasPrevious bool) (bool, error) {
+	info, err := os.Lstat(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if uint32(info.Mode()) != entry.Mode {
+		return false, nil
+	}
+	switch {
+	case info.IsDir():
+		return true, nil
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(filePath)
+		return target == entry.LinkTarget, err
+	case info.Size() != entry.Size:
+		return false, nil
+	case hasPrevious && previous.Size == info.Size() && previous.ModTime == info.ModTime().UnixNano():
+		return previous.Sha256 == entry.Sha256, nil
+	}
+	checksum, err := hashFile(filePath)
+	return checksum == entry.Sha256, err
+}
+
+// Moves the files in `extracted` into `destination`, and removes the other files in `destination` except for the ones
+// with names in `namesToKeep`, like `removeAllExcept` followed by extracting into `destination` does. The files that did
+// not change are left alone, so that their modification times are kept, and the caches that use them stay valid.
+// `manifestName` is the name of the file in `destination` that records the checksum of every file, so that the next
+// time only the files with a different size or modification time have to be read.
+func replaceChangedFiles(extracted string, destination string, namesToKeep []string, manifestName string) (int, error) {
+	previousManifest := map[string]ManifestEntry{}
+	toml.DecodeFile(filepath.Join(destination, manifestName), &previousManifest)
+	manifest, err := CreateManifest(extracted)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	// Sorting the paths puts every directory before the files in it. The paths in a manifest are separated by `/` on
+	// every system, like the paths in an archive.
+	for _, filePath := range slices.Sorted(maps.Keys(manifest)) {
+		entry := manifest[filePath]
+		target := filepath.Join(destination, filepath.FromSlash(filePath))
+		previous, hasPrevious := previousManifest[filePath]
+		unchanged, err := fileMatchesEntry(target, entry, previous, hasPrevious)
+		if err != nil {
+			return changed, err
+		}
+		if unchanged {
+			if info, err := os.Lstat(target); err == nil && info.Mode().IsRegular() {
+				entry.ModTime = info.ModTime().UnixNano()
+				manifest[filePath] = entry
+			}
+			continue
+		}
+		info, err := os.Lstat(target)
+		if err == nil && info.IsDir() && fs.FileMode(entry.Mode).IsDir() {
+			// Only the permissions of the directory changed
+			err = os.Chmod(target, fs.FileMode(entry.Mode).Perm())
+			if err != nil {
+				return changed, err
+			}
+			continue
+		} else if err == nil && (info.IsDir() || fs.FileMode(entry.Mode).IsDir()) {
+			err = os.RemoveAll(target)
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return changed, err
+		}
+		if fs.FileMode(entry.Mode).IsDir() {
+			err = os.Mkdir(target, fs.FileMode(entry.Mode).Perm())
+		} else {
+			changed += 1
+			err = os.Rename(filepath.Join(extracted, filepath.FromSlash(filePath)), target)
+		}
+		if err != nil {
+			return changed, err
+		}
+	}
+
+	err = filepath.WalkDir(destination, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || filePath == destination {
+			return err
+		}
+		relativePath, err := filepath.Rel(destination, filePath)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if relativePath == manifestName || slices.Contains(namesToKeep, relativePath) || filePath == extracted {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, inTree := manifest[relativePath]; inTree {
+			return nil
+		}
+		changed += 1
+		err = os.RemoveAll(filePath)
+		if err == nil && entry.IsDir() {
+			return filepath.SkipDir
+		}
+		return err
+	})
+	if err != nil {
+		return changed, err
+	}
+
+	file, err := os.Create(filepath.Join(destination, manifestName))
+	if err != nil {
+		return changed, err
+	}
+	defer file.Close()
+	return changed, toml.NewEncoder(file).Encode(manifest)
+}
+
+// Extracts a download into a temporary directory in its destination, and then replaces only the files in the
+// destination that changed. Returns how many files were replaced or removed.
+func extractChangedFiles(payload *os.File, options DownloadOptions) (int, error) {
+	err := os.MkdirAll(options.Destination, 0755)
+	if err != nil {
+		return 0, err
+	}
+	// If bento crashes, the directory is removed the next time that the destination is extracted, since it is not one of
+	// the extracted files
+	temporaryDir, err := os.MkdirTemp(options.Destination, ".extract.tmp-"+strconv.Itoa(os.Getpid())+"-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(temporaryDir)
+	err = extractNested(payload, options.Compression, options.InnerArchives, temporaryDir, options.RootPath, options.Filter)
+	if err != nil {
+		return 0, err
+	}
+	return replaceChangedFiles(temporaryDir, options.Destination, options.FilesToKeepAtDestination, options.ManifestName)
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Takes an advisory lock on a file, which is created if it does not exist. Any number of processes can hold a shared
+// lock at once, but an exclusive lock cannot be held at the same time as any other lock. If `wait` is false and the
+// lock is held by another process, then false is returned instead of waiting for the lock to be released. The lock is
+// released when the returned file is closed.
+func LockFile(filePath string, exclusive bool, wait bool) (*os.File, bool, error) {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	err = syscall.Flock(int(file.Fd()), how)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		file.Close()
+		return nil, false, nil
+	} else if err != nil {
+		file.Close()
+		return nil, false, err
+	}
+	return file, true, nil
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Describes a file in a tree, so that two trees can be compared without reading every file in both of them
+type ManifestEntry struct {
+	// The sha256 checksum of the file, which is empty for directories and symlinks
+	Sha256 string `toml:",omitempty"`
+	Size   int64
+	Mode   uint32
+	// The target of the symlink, which is empty if the file is not a symlink
+	LinkTarget string `toml:",omitempty"`
+	// The modification time of the file in nanoseconds since the Unix epoch, which is only recorded for regular files,
+	// and is zero in manifests that were written by older versions of bento
+	ModTime int64 `toml:",omitempty"`
+}
+
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Checks that files in a tree have the sha256 checksums in `checksums`, which maps the path of each file relative to
+// the root of the tree to its checksum in hexadecimal
+func VerifyFileChecksums(root string, checksums map[string]string) error {
+	for _, filePath := range slices.Sorted(maps.Keys(checksums)) {
+		absolutePath := filepath.Join(root, filePath)
+		info, err := os.Lstat(absolutePath)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return errors.New("`" + filePath + "` should have a checksum, but it is not a regular file")
+		}
+		checksum, err := hashFile(absolutePath)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(checksum, checksums[filePath]) {
+			return &ChecksumMismatchError{Name: filePath, Expected: checksums[filePath], Actual: checksum}
+		}
+	}
+	return nil
+}
+
+// Returns an entry for every file, directory, and symlink in a tree, keyed by its path relative to the root of the tree
+func CreateManifest(root string) (map[string]ManifestEntry, error) {
+	manifest := map[string]ManifestEntry{}
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || filePath == root {
+			return err
+		}
+		relativePath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		manifestEntry := ManifestEntry{Mode: uint32(info.Mode())}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			manifestEntry.LinkTarget, err = os.Readlink(filePath)
+		case info.Mode().IsRegular():
+			manifestEntry.Size = info.Size()
+			manifestEntry.ModTime = info.ModTime().UnixNano()
+			manifestEntry.Sha256, err = hashFile(filePath)
+		}
+		manifest[filepath.ToSlash(relativePath)] = manifestEntry
+		return err
+	})
+	return manifest, err
+}
+
+// Returns the paths in a manifest of files that were removed from the tree, or that were changed since the manifest was
+// created. Files are compared by their type, size, mode, and modification time without reading them, so this is quick
+// enough to do every time that an executable is run, but a file that was changed without changing any of those is not
+// found.
+func FindModifiedFiles(root string, manifest map[string]ManifestEntry) []string {
+	modified := []string{}
+	for _, filePath := range slices.Sorted(maps.Keys(manifest)) {
+		entry := manifest[filePath]
+		info, err := os.Lstat(filepath.Join(root, filePath))
+		if err != nil || uint32(info.Mode()) != entry.Mode {
+			modified = append(modified, filePath)
+			continue
+		}
+		if info.Mode().IsRegular() && (info.Size() != entry.Size || (entry.ModTime != 0 && info.ModTime().UnixNano() != entry.ModTime)) {
+			modified = append(modified, filePath)
+		}
+	}
+	return modified
+}
+
+// Returns the paths in a manifest of files that were removed from the tree, or whose type, mode, symlink target, or
+// contents are not the same as when the manifest was created. Unlike `FindModifiedFiles`, every regular file is read,
+// so this is slow, but a file that was changed without changing its size or modification time is found too.
+func FindCorruptedFiles(root string, manifest map[string]ManifestEntry) []string {
+	corrupted := []string{}
+	for _, filePath := range slices.Sorted(maps.Keys(manifest)) {
+		entry := manifest[filePath]
+		absolutePath := filepath.Join(root, filePath)
+		info, err := os.Lstat(absolutePath)
+		if err != nil || uint32(info.Mode()) != entry.Mode {
+			corrupted = append(corrupted, filePath)
+			continue
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(absolutePath)
+			if err != nil || target != entry.LinkTarget {
+				corrupted = append(corrupted, filePath)
+			}
+		case info.Mode().IsRegular():
+			checksum, err := hashFile(absolutePath)
+			if err != nil || checksum != entry.Sha256 {
+				corrupted = append(corrupted, filePath)
+			}
+		}
+	}
+	return corrupted
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The most memory that downloads should use at once, or 0 if there is no limit. Downloads are written to a file next to
+// their destination, or extracted as they are fetched, rather than held in memory, so the memory that a download uses
+// is mostly the memory that decompressing it uses.
+var memoryBudget int64
+
+// The memory that one download is assumed to use, which is enough to decompress an archive that was compressed with
+// `xz -9`, whose dictionary is 64 MiB
+const memoryPerDownload = 64 * 1024 * 1024
+
+// Limits the memory that downloads use to `bytes`, by starting fewer downloads at once and by decompressing with less
+// memory. When `bytes` is 0, there is no limit.
+func SetMemoryBudget(bytes int64) {
+	memoryBudget = bytes
+}
+
+// Returns how many downloads can be done at once without using more memory than the memory budget
+func parallelDownloadsWithinBudget(maxParallelDownloads uint) uint {
+	if memoryBudget <= 0 {
+		return maxParallelDownloads
+	}
+	return max(1, min(maxParallelDownloads, uint(memoryBudget/memoryPerDownload)))
+}
+
+// Returns the options for zstd decoders. By default, a decoder decodes blocks on every CPU at once and keeps buffers
+// for all of them, so when there is a memory budget, blocks are decoded one at a time, and archives that need a window
+// larger than the budget fail to decompress instead of using more memory than the budget.
+func zstdDecoderOptions() []zstd.DOption {
+	if memoryBudget <= 0 {
+		return nil
+	}
+	return []zstd.DOption{
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderLowmem(true),
+		zstd.WithDecoderMaxMemory(uint64(memoryBudget)),
+		zstd.WithDecoderMaxWindow(uint64(min(memoryBudget, zstd.MaxWindowSize))),
+	}
+}
+
+var sizeUnits = []string{"KiB", "MiB", "GiB", "TiB"}
+
+// Parses a number of bytes with an optional binary prefix, like `512MiB` or `1 GiB`, which is the inverse of
+// `FormatSize`
+func ParseSize(size string) (int64, error) {
+	trimmedSize := strings.TrimSpace(size)
+	multiplier := int64(1)
+	for index, unit := range sizeUnits {
+		if number, hasUnit := strings.CutSuffix(trimmedSize, unit); hasUnit {
+			trimmedSize = number
+			multiplier = int64(1) << (10 * (index + 1))
+			break
+		}
+	}
+	trimmedSize = strings.TrimSpace(strings.TrimSuffix(trimmedSize, "B"))
+	number, err := strconv.ParseInt(trimmedSize, 10, 64)
+	if err != nil || number < 0 || number > (1<<62)/multiplier {
+		return 0, errors.New("Expected `" + size + "` to be a size like `512MiB`, with a unit of `B`, `KiB`, `MiB`, `GiB`, or `TiB`")
+	}
+	return number * multiplier, nil
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long each step of diagnosing a connection can take before it is considered to have failed
+const diagnosticTimeout = 10 * time.Second
+
+// The most data that is fetched to measure the throughput of a connection
+const maxThroughputSampleSize = 4 * 1024 * 1024
+
+// One hop of the path that bento takes to fetch a URL, like resolving a host name or doing a TLS handshake
+type DiagnosticStep struct {
+	Name     string
+	Detail   string
+	Duration time.Duration
+	Err      error
+}
+
+// The URLs that bento fetches the package repository from
+func PackageRepositoryUrls() []string {
+	return []string{
+		"https://github.com/" + packageRepository + "/archive/refs/heads/main.zip",
+		"https://api.github.com/repos/" + packageRepository + "/commits/main",
+		"https://raw.githubusercontent.com/" + packageRepository + "/main/README.md",
+	}
+}
+
+func hostAndPort(parsedUrl *url.URL) string {
+	port := parsedUrl.Port()
+	if port == "" {
+		port = "80"
+		if parsedUrl.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(parsedUrl.Hostname(), port)
+}
+
+// Tests every hop between this machine and `rawUrl` in the same way that bento fetches it, including going through
+// the proxy that is configured with `HTTPS_PROXY`, `HTTP_PROXY`, and `NO_PROXY`. The steps stop at the first hop that
+// fails, except for the hops that are not needed to fetch the URL.
+func DiagnoseUrl(rawUrl string) []DiagnosticStep {
+	steps := []DiagnosticStep{}
+	step := func(name string, run func() (string, error)) bool {
+		start := time.Now()
+		detail, err := run()
+		steps = append(steps, DiagnosticStep{Name: name, Detail: detail, Duration: time.Since(start), Err: err})
+		return err == nil
+	}
+
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return append(steps, DiagnosticStep{Name: "parse URL", Err: err})
+	}
+	request, err := http.NewRequest(http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return append(steps, DiagnosticStep{Name: "parse URL", Err: err})
+	}
+	proxyUrl, err := http.ProxyFromEnvironment(request)
+	if err != nil {
+		return append(steps, DiagnosticStep{Name: "find proxy", Err: err})
+	}
+
+	// When there is a proxy, the proxy resolves the host name, so failing to resolve it here does not matter
+	if !step("resolve "+parsedUrl.Hostname(), func() (string, error) {
+		addresses, err := net.DefaultResolver.LookupHost(request.Context(), parsedUrl.Hostname())
+		if err != nil && proxyUrl != nil {
+			return "could not be resolved, which does not matter because the proxy resolves it", nil
+		} else if err != nil {
+			return "", err
+		}
+		return strings.Join(addresses, ", "), nil
+	}) {
+		return steps
+	}
+
+	var conn net.Conn
+	dialer := net.Dialer{Timeout: diagnosticTimeout}
+	if proxyUrl == nil {
+		if !step("connect to "+hostAndPort(parsedUrl), func() (string, error) {
+			conn, err = dialer.Dial("tcp", hostAndPort(parsedUrl))
+			if err != nil {
+				return "", err
+			}
+			return "connected to " + conn.RemoteAddr().String(), nil
+		}) {
+			return steps
+		}
+	} else {
+		if !step("resolve proxy "+proxyUrl.Hostname(), func() (string, error) {
+			addresses, err := net.DefaultResolver.LookupHost(request.Context(), proxyUrl.Hostname())
+			if err != nil {
+				return "", err
+			}
+			return addresses[0], nil
+		}) {
+			return steps
+		}
+		if !step("connect to proxy "+hostAndPort(proxyUrl), func() (string, error) {
+			conn, err = dialer.Dial("tcp", hostAndPort(proxyUrl))
+			if err != nil {
+				return "", err
+			}
+			if proxyUrl.Scheme == "https" {
+				tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyUrl.Hostname()})
+				conn = tlsConn
+				err = tlsConn.Handshake()
+				if err != nil {
+					return "", errors.New("TLS handshake with the proxy failed: " + err.Error())
+				}
+			} else if proxyUrl.Scheme != "http" {
+				return "", errors.New("Bento cannot diagnose proxies that use `" + proxyUrl.Scheme + "`")
+			}
+			return "connected to " + conn.RemoteAddr().String(), nil
+		}) {
+			return steps
+		}
+		if parsedUrl.Scheme == "https" && !step("CONNECT through proxy", func() (string, error) {
+			connectRequest := &http.Request{
+				Method: http.MethodConnect,
+				URL:    &url.URL{Opaque: hostAndPort(parsedUrl)},
+				Host:   hostAndPort(parsedUrl),
+				Header: http.Header{},
+			}
+			if proxyUrl.User != nil {
+				password, _ := proxyUrl.User.Password()
+				credentials := base64.StdEncoding.EncodeToString([]byte(proxyUrl.User.Username() + ":" + password))
+				connectRequest.Header.Set("Proxy-Authorization", "Basic "+credentials)
+			}
+			conn.SetDeadline(time.Now().Add(diagnosticTimeout))
+			err := connectRequest.Write(conn)
+			if err != nil {
+				return "", err
+			}
+			response, err := http.ReadResponse(bufio.NewReader(conn), connectRequest)
+			if err != nil {
+				return "", err
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				return "", errors.New("The proxy responded with `" + response.Status + "`")
+			}
+			return "", nil
+		}) {
+			conn.Close()
+			return steps
+		}
+	}
+
+	if parsedUrl.Scheme == "https" {
+		if !step("TLS handshake with "+parsedUrl.Hostname(), func() (string, error) {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: parsedUrl.Hostname()})
+			tlsConn.SetDeadline(time.Now().Add(diagnosticTimeout))
+			err := tlsConn.Handshake()
+			if err != nil {
+				return "", err
+			}
+			state := tlsConn.ConnectionState()
+			certificate := state.PeerCertificates[0]
+			return tls.VersionName(state.Version) + ", certificate issued by " + certificate.Issuer.CommonName, nil
+		}) {
+			conn.Close()
+			return steps
+		}
+	}
+	conn.Close()
+
+	step("fetch "+rawUrl, func() (string, error) {
+		client := http.Client{Transport: httpTransport, Timeout: 3 * diagnosticTimeout}
+		response, err := client.Get(rawUrl)
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		start := time.Now()
+		bytesRead, err := io.Copy(io.Discard, io.LimitReader(response.Body, maxThroughputSampleSize))
+		if err != nil {
+			return "", err
+		}
+		if response.StatusCode != http.StatusOK {
+			return "", errors.New("Expected status 200, but got " + strconv.Itoa(response.StatusCode))
+		}
+		throughput := float64(bytesRead) / max(time.Since(start).Seconds(), 0.001)
+		return FormatSize(bytesRead) + " at " + FormatSize(int64(throughput)) + "/s", nil
+	})
+	return steps
+}
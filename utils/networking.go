@@ -1,18 +1,27 @@
 package utils
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/sync/errgroup"
 )
 
 type downloadStatus = uint8
@@ -32,57 +41,134 @@ const (
 func downloadStatusToAnsiString(status downloadStatus) string {
 	switch status {
 	case failed:
-		return AnsiFgRed + "failed" + AnsiReset
+		return AnsiFgRed + downloadStatusToString(status) + AnsiReset
+	case queued:
+		return AnsiFgYellow + downloadStatusToString(status) + AnsiReset
+	case checkingHash, deletingOldFiles, makingFilesExecutable:
+		return downloadStatusToString(status) + AnsiReset
+	case extracting:
+		return AnsiFgBlue + downloadStatusToString(status) + AnsiReset
+	case done:
+		return AnsiFgGreen + downloadStatusToString(status) + AnsiReset
+	default:
+		return AnsiFgCyan + downloadStatusToString(status) + AnsiReset
+	}
+}
+
+func downloadStatusToString(status downloadStatus) string {
+	switch status {
+	case failed:
+		return "failed"
 	case queued:
-		return AnsiFgYellow + "queued" + AnsiReset
+		return "queued"
 	case fetchingUnknownPercentage:
-		return AnsiFgCyan + "fetching" + AnsiReset
+		return "fetching"
 	default:
-		return fmt.Sprintf(AnsiFgCyan+"fetching (%3d%%)"+AnsiReset, status-fetchingKnownPercentage)
+		return fmt.Sprintf("fetching (%3d%%)", status-fetchingKnownPercentage)
 	case checkingHash:
-		return "checking hash" + AnsiReset
+		return "checking hash"
+	case deletingOldFiles:
+		return "deleting old files"
 	case extracting:
-		return AnsiFgBlue + "extracting" + AnsiReset
+		return "extracting"
 	case makingFilesExecutable:
-		return "making files executable" + AnsiReset
+		return "making files executable"
 	case done:
-		return AnsiFgGreen + "done" + AnsiReset
+		return "done"
 	}
 }
 
-func fetch(url string, status stateWithNotifier[downloadStatus]) ([]byte, error) {
+// Shared by every request that bento makes, so that requests to the same host reuse connections instead of doing a
+// TCP and TLS handshake each time. HTTP/2 connections are used when the server supports them, which lets every
+// download from a host like github.com share a single connection.
+var httpTransport = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+	// The default is 2, which means that most of the connections that parallel downloads from the same host open
+	// over HTTP/1.1 are closed as soon as the downloads finish
+	MaxIdleConnsPerHost: 16,
+}
+
+var httpClient = &http.Client{Transport: &httpsPolicyTransport{next: &githubTransport{next: &registryAuthTransport{tokens: map[string]string{}}}}}
+
+// Requests a URL, and returns its body, which shows how much of it has been read in `status`. A warning is logged if
+// the URL redirects to another site, or an error is returned if `refuseCrossSiteRedirects` is set.
+func fetchBody(url string, refuseCrossSiteRedirects bool, status stateWithNotifier[downloadStatus], logs chan<- log) (io.ReadCloser, http.Header, error) {
 	status.setState(fetchingUnknownPercentage)
-	response, err := http.Get(url)
+	response, err := httpClient.Get(url)
 	if err != nil {
-		return []byte{}, err
+		return nil, nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, nil, &HTTPStatusError{StatusCode: response.StatusCode}
+	}
+	if host, crossSite := crossSiteRedirectHost(url, response); crossSite && refuseCrossSiteRedirects {
+		response.Body.Close()
+		return nil, nil, errors.New("Redirected to `" + host + "`, which is on a different site to the mirror, and the policy of the project does not allow this redirect without confirming it")
+	} else if crossSite {
+		logs <- nonFatalError(crossSiteRedirectWarning(url, host))
 	}
-	defer response.Body.Close()
 
-	responseReader := response.Body
 	contentLength := response.Header.Get("Content-Length")
-	if contentLength != "" {
-		var length int64
-		length, err = strconv.ParseInt(contentLength, 10, 64)
-		if err != nil {
-			return []byte{}, err
-		}
-		responseReader = &progressReader{
-			progress{int(length), 0},
-			response.Body,
-			func(p progress) {
-				status.setState(fetchingKnownPercentage + downloadStatus(((p.contentReadInBytes * 100) / p.contentLengthInBytes)))
-			},
-		}
+	if contentLength == "" {
+		return response.Body, response.Header, nil
 	}
+	length, err := strconv.ParseInt(contentLength, 10, 64)
+	if err != nil {
+		response.Body.Close()
+		return nil, nil, err
+	}
+	return &progressReader{
+		progress{int(length), 0},
+		response.Body,
+		func(p progress) {
+			status.setState(fetchingKnownPercentage + downloadStatus(((p.contentReadInBytes * 100) / p.contentLengthInBytes)))
+		},
+	}, response.Header, nil
+}
+
+// Fetches a URL into `payload`, replacing anything that was already in it, and returns the sha256 checksum of it
+func fetch(url string, refuseCrossSiteRedirects bool, status stateWithNotifier[downloadStatus], payload *os.File, logs chan<- log) ([32]byte, http.Header, error) {
+	responseReader, header, err := fetchBody(url, refuseCrossSiteRedirects, status, logs)
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+	defer responseReader.Close()
 
-	responseBuffer := bytes.NewBuffer([]byte{})
+	err = payload.Truncate(0)
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+	_, err = payload.Seek(0, io.SeekStart)
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+	// The payload is written to a file rather than kept in memory, so that large archives do not use lots of memory
+	hash := sha256.New()
 	// TODO: Add a timeout (something to stop bento from trying to fetch the URL
 	// after a certain amount of time in which no data is received)
-	_, err = io.Copy(responseBuffer, responseReader)
+	_, err = io.Copy(io.MultiWriter(payload, hash), responseReader)
 	if err != nil {
-		return []byte{}, err
+		return [32]byte{}, nil, err
 	}
-	return responseBuffer.Bytes(), nil
+	return [32]byte(hash.Sum(nil)), header, nil
+}
+
+// Creates the file that a download is fetched into before it is extracted. It is created next to the destination so
+// that it is on the same disk as the destination, rather than in a temporary directory that may be stored in memory,
+// and its name matches the temporary files that `bento gc` removes if bento crashes.
+func createPayloadFile(destination string) (*os.File, error) {
+	err := os.MkdirAll(path.Dir(destination), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(path.Dir(destination), ".download.tmp-"+strconv.Itoa(os.Getpid())+"-*")
 }
 
 type DownloadOptions struct {
@@ -93,137 +179,499 @@ type DownloadOptions struct {
 	Checksum                         [32]byte
 	FilesToMakeExecutable            []string
 	RootPath                         string
+	InnerArchives                    []InnerArchive
+	Filter                           ExtractionFilter
 	Destination                      string
 	DeleteExistingFilesAtDestination bool
+	// The names of the files in the destination that are not deleted when `DeleteExistingFilesAtDestination` is set
+	FilesToKeepAtDestination []string
+	// The name of a file in the destination that records the checksum of every extracted file, if it is not empty. When
+	// it is set along with `DeleteExistingFilesAtDestination`, only the files that changed since the download was last
+	// extracted are replaced, and the rest keep their modification times.
+	ManifestName string
+	// Called with the downloaded archive after it is extracted, if it is not nil
+	OnExtracted func(archive *os.File) error `toml:"-"`
+	// Called after the download is extracted and its files are made executable, if it is not nil
+	OnFinished func() `toml:"-"`
+	// The directory that downloads which do not match their checksum are saved in, so that the mirrors that served
+	// them can be investigated, if it is not empty
+	QuarantineDir string
+	// What the destination belongs to, like the source that the download is an artifact of, which defaults to the name
+	// of the download. Downloads with different owners are not expected to be extracted to the same place.
+	DestinationOwner string
+	// Downloads with a higher priority are started before downloads with a lower priority, so that the downloads that
+	// are needed first can be extracted while the rest are fetched
+	Priority int
+	// Fails a mirror that redirects to a host on another site, instead of only warning about it, for mirrors that the
+	// user has not agreed can redirect there
+	RefuseCrossSiteRedirects bool
+	// Held while the download is extracted, when other downloads are extracted to the same place
+	extractionLock *sync.Mutex
+}
+
+// Removes `directory` and everything in it, except for the files and directories in it with names in `namesToKeep`
+func removeAllExcept(directory string, namesToKeep []string) error {
+	if len(namesToKeep) == 0 {
+		return os.RemoveAll(directory)
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !slices.Contains(namesToKeep, entry.Name()) {
+			err := os.RemoveAll(path.Join(directory, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Expands the glob patterns in `filesToMakeExecutable` into the files that they match in `destination`. Paths that
+// are not glob patterns are kept as they are, so that a missing file is reported when it is made executable.
+func expandFilesToMakeExecutable(destination string, filesToMakeExecutable []string) ([]string, error) {
+	expanded := []string{}
+	for _, pattern := range filesToMakeExecutable {
+		if !IsGlob(pattern) {
+			expanded = append(expanded, pattern)
+			continue
+		}
+		matches, err := GlobFiles(destination, pattern)
+		if err != nil {
+			return []string{}, WrapError("Invalid pattern `"+pattern+"`", err)
+		}
+		if len(matches) == 0 {
+			return []string{}, errors.New("The pattern `" + pattern + "` does not match any files")
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+// The time that every download in this process has spent extracting, added together
+var extractionNanoseconds atomic.Int64
+
+// Returns the time that every download in this process has spent extracting, added together. Downloads are extracted
+// at the same time as other downloads are fetched, so this can be more than the time that the downloads took.
+func ExtractionTime() time.Duration {
+	return time.Duration(extractionNanoseconds.Load())
 }
 
 func download(options DownloadOptions, status stateWithNotifier[downloadStatus], logs chan<- log) {
+	if canExtractWhileFetching(options) {
+		extractWhileFetching(options, status, logs)
+		return
+	}
+	payload, err := createPayloadFile(options.Destination)
+	if err != nil {
+		logs <- fatalError("Failed to create a file to download `" + options.Name + "` into: " + err.Error())
+		status.setState(failed)
+		return
+	}
+	defer os.Remove(payload.Name())
+	defer payload.Close()
+
+	mismatchedUrls := []string{}
+	attempts := []error{}
 	for _, url := range options.Urls {
-		response, err := fetch(url, status)
+		dataChecksum, header, err := fetch(url, options.RefuseCrossSiteRedirects, status, payload, logs)
 		if err != nil {
-			logs <- nonFatalError("Failed to fetch `" + options.Name + "` from `" + url + "`: " + err.Error())
+			err = &FetchError{Name: options.Name, Url: url, Err: err}
+			attempts = append(attempts, err)
+			logs <- nonFatalError(err.Error())
 			continue
 		}
 		logs <- info("Fetched `" + options.Name + "` from `" + url + "`")
 
 		if options.UseChecksum {
 			status.setState(checkingHash)
-			dataChecksum := sha256.Sum256(response)
 			if dataChecksum != options.Checksum {
-				logs <- nonFatalError("Expected sha256 checksum of `" + options.Name + "` to be 0x" + hex.EncodeToString(options.Checksum[:]) + ", but got 0x" + hex.EncodeToString(dataChecksum[:]))
+				err := &ChecksumMismatchError{Name: options.Name, Url: url, Expected: hex.EncodeToString(options.Checksum[:]), Actual: hex.EncodeToString(dataChecksum[:])}
+				attempts = append(attempts, err)
+				logs <- nonFatalError(err.Error())
+				mismatchedUrls = append(mismatchedUrls, url)
+				if options.QuarantineDir != "" {
+					quarantinePath, err := quarantine(options.QuarantineDir, quarantinedDownload{
+						Name:             options.Name,
+						Url:              url,
+						ExpectedChecksum: hex.EncodeToString(options.Checksum[:]),
+						Checksum:         hex.EncodeToString(dataChecksum[:]),
+						Fetched:          time.Now(),
+						Header:           header,
+					}, payload)
+					if err != nil {
+						logs <- nonFatalError("Failed to quarantine `" + options.Name + "` from `" + url + "`: " + err.Error())
+					} else {
+						logs <- info("Saved `" + options.Name + "` from `" + url + "` to " + quarantinePath)
+					}
+				}
 				continue
 			}
 			logs <- log{message: "Cryptographically verified `" + options.Name + "` using sha256 hash"}
 		}
 
-		if options.DeleteExistingFilesAtDestination {
+		if options.extractionLock != nil {
+			options.extractionLock.Lock()
+			defer options.extractionLock.Unlock()
+		}
+		incremental := options.DeleteExistingFilesAtDestination && options.ManifestName != ""
+		if options.DeleteExistingFilesAtDestination && !incremental {
 			status.setState(deletingOldFiles)
-			err := os.RemoveAll(options.Destination)
+			err := removeAllExcept(options.Destination, options.FilesToKeepAtDestination)
 			if err != nil && !os.IsNotExist(err) {
 				logs <- fatalError(err.Error())
 			}
 		}
 
 		status.setState(extracting)
-		err = extract(response, options.Compression, options.Destination, options.RootPath)
+		extractionStart := time.Now()
+		changedFiles := 0
+		if incremental {
+			changedFiles, err = extractChangedFiles(payload, options)
+		} else {
+			err = extractNested(payload, options.Compression, options.InnerArchives, options.Destination, options.RootPath, options.Filter)
+		}
+		extractionNanoseconds.Add(int64(time.Since(extractionStart)))
 		if err != nil {
-			logs <- fatalError("Failed to extract `" + options.Name + "`: " + err.Error())
+			logs <- fatalErrorFrom(&ExtractionError{Name: options.Name, Err: err})
 			status.setState(failed)
 			return
 		}
-		logs <- info("Extracted `" + options.Name + "` into " + options.Destination)
-
-		for _, fileName := range options.FilesToMakeExecutable {
-			status.setState(makingFilesExecutable)
-			absoluteFileName := path.Join(options.Destination, fileName)
-			fileInfo, err := os.Stat(absoluteFileName)
-			if err != nil {
-				logs <- fatalError("Failed to make the file `" + fileName + "` executable: " + err.Error())
-				continue
-			}
-			err = os.Chmod(absoluteFileName, fileInfo.Mode()|0111)
+		if incremental {
+			logs <- info("Extracted `" + options.Name + "` into " + options.Destination + ", replacing or removing " + CreateNoun(changedFiles, "1 file", "files") + " that changed")
+		} else {
+			logs <- info("Extracted `" + options.Name + "` into " + options.Destination)
+		}
+		if options.OnExtracted != nil {
+			err = options.OnExtracted(payload)
 			if err != nil {
-				logs <- fatalError("Failed to make the file `" + fileName + "` executable: " + err.Error())
-				continue
+				logs <- fatalError(err.Error())
+				status.setState(failed)
+				return
 			}
-			logs <- info("Made `" + absoluteFileName + "` executable")
 		}
+		finishDownload(options, status, logs)
+		return
+	}
+	failDownload(options, mismatchedUrls, attempts, status, logs)
+}
 
-		status.setState(done)
+// Makes the files of a download that was extracted executable, and marks it as done
+func finishDownload(options DownloadOptions, status stateWithNotifier[downloadStatus], logs chan<- log) {
+	filesToMakeExecutable, err := expandFilesToMakeExecutable(options.Destination, options.FilesToMakeExecutable)
+	if err != nil {
+		logs <- fatalError("Failed to make the files in `" + options.Name + "` executable: " + err.Error())
+		status.setState(failed)
 		return
 	}
-	logs <- fatalError(fmt.Sprintf("Tried fetching `%s` from all %d URLs, but none worked", options.Name, len(options.Urls)))
+	if runtime.GOOS == "windows" && len(filesToMakeExecutable) > 0 {
+		// Windows decides whether a file is executable using its extension, so there is nothing to do
+		filesToMakeExecutable = []string{}
+	}
+	allMadeExecutable := true
+	for _, fileName := range filesToMakeExecutable {
+		status.setState(makingFilesExecutable)
+		absoluteFileName := path.Join(options.Destination, fileName)
+		fileInfo, err := os.Stat(absoluteFileName)
+		if err != nil {
+			logs <- fatalError("Failed to make the file `" + fileName + "` executable: " + err.Error())
+			allMadeExecutable = false
+			continue
+		}
+		err = os.Chmod(absoluteFileName, fileInfo.Mode()|0111)
+		if err != nil {
+			logs <- fatalError("Failed to make the file `" + fileName + "` executable: " + err.Error())
+			allMadeExecutable = false
+			continue
+		}
+		logs <- info("Made `" + absoluteFileName + "` executable")
+	}
+
+	if options.OnFinished != nil && allMadeExecutable {
+		options.OnFinished()
+	}
+	status.setState(done)
+}
+
+// Reports that every URL of a download failed
+func failDownload(options DownloadOptions, mismatchedUrls []string, attempts []error, status stateWithNotifier[downloadStatus], logs chan<- log) {
+	if len(mismatchedUrls) > 0 {
+		logs <- nonFatalError(fmt.Sprintf("`%s` had the wrong checksum when it was fetched from %s, which can mean that a mirror is out of date or compromised: %s", options.Name, CreateNoun(len(mismatchedUrls), "1 URL", "URLs"), strings.Join(mismatchedUrls, ", ")))
+	}
+	logs <- fatalErrorFrom(&DownloadError{Name: options.Name, UrlCount: len(options.Urls), Attempts: attempts})
 	status.setState(failed)
 }
 
-func DownloadConcurrently(sources []DownloadOptions, maxParallelDownloads uint) []error {
+// The most lines that the statuses of downloads are drawn in when the height of the terminal is not known
+const defaultMaxStatusLines = 20
+
+// Returns the most lines that the statuses of downloads can be drawn in, which leaves a line for the cursor, since the
+// cursor cannot be moved up to lines that have scrolled off the screen
+func maxStatusLines() int {
+	height, ok := TerminalHeight(os.Stderr)
+	if !ok {
+		return defaultMaxStatusLines
+	}
+	return max(height-1, 2)
+}
+
+// Returns the lines that show the statuses of downloads, without using more than `maxLines`. When the status of every
+// download does not fit, the first line summarises them, and is followed by the downloads that are in progress, and
+// then by the downloads that finished most recently.
+func downloadStatusLines(sources []DownloadOptions, statuses []downloadStatus, finishedOrder []int, maxLines int) []string {
+	statusLine := func(index int) string {
+		return sources[index].Name + ": " + downloadStatusToAnsiString(statuses[index])
+	}
+	lines := []string{}
+	if len(sources) <= maxLines {
+		for index := range sources {
+			lines = append(lines, statusLine(index))
+		}
+		return lines
+	}
+
+	counts := map[downloadStatus]int{}
+	inProgress := []int{}
+	for index, status := range statuses {
+		if status != done && status != failed && status != queued {
+			inProgress = append(inProgress, index)
+			continue
+		}
+		counts[status] += 1
+	}
+	lines = append(lines, AnsiBold+strconv.Itoa(counts[done])+" done, "+strconv.Itoa(counts[failed])+" failed, "+strconv.Itoa(len(inProgress))+" running, "+strconv.Itoa(counts[queued])+" queued"+AnsiReset)
+	for _, index := range inProgress {
+		if len(lines) == maxLines {
+			return lines
+		}
+		lines = append(lines, statusLine(index))
+	}
+	for index := len(finishedOrder) - 1; index >= 0 && len(lines) < maxLines; index-- {
+		lines = append(lines, statusLine(finishedOrder[index]))
+	}
+	return lines
+}
+
+// Downloads several sources at once while drawing their statuses to the terminal. If `reportProgress` is not nil, it
+// is also called with a line describing the status of each source whenever the statuses are redrawn.
+func DownloadConcurrently(sources []DownloadOptions, maxParallelDownloads uint, reportProgress func(progress []string)) []error {
+	sources = slices.Clone(sources)
+	slices.SortStableFunc(sources, func(a DownloadOptions, b DownloadOptions) int {
+		return b.Priority - a.Priority
+	})
+	sources, warnings := guardDestinations(sources)
+	for _, warning := range warnings {
+		os.Stderr.WriteString(warning + "\n")
+	}
 	statuses := make([]downloadStatus, len(sources))
 	for index := range statuses {
 		statuses[index] = queued
 	}
-	statusUpdated := make(chan struct{}, 1)
+	progress := &downloadProgress{statuses: statuses, updated: make(chan struct{}, 1)}
 	logs := make(chan log, 10)
 
+	ctx, stopRendering := context.WithCancel(context.Background())
+	renderedErrs := make(chan []error)
+	go func() {
+		renderedErrs <- renderDownloads(ctx, sources, progress, logs, reportProgress)
+	}()
+
+	// The downloads are started in order of priority, and a download that fails does not stop the others, since the
+	// downloads that succeed are still useful, so failures are reported through `logs` rather than through the group
+	var group errgroup.Group
+	group.SetLimit(int(max(parallelDownloadsWithinBudget(maxParallelDownloads), 1)))
+	for index, source := range sources {
+		group.Go(func() error {
+			download(source, stateWithNotifier[downloadStatus]{state: &statuses[index], notifier: progress.updated, mutex: &progress.mutex}, logs)
+			return nil
+		})
+	}
+	group.Wait()
+	stopRendering()
+	return <-renderedErrs
+}
+
+// The statuses of the downloads that are done at once, which the downloads change, and `renderDownloads` reads
+type downloadProgress struct {
+	mutex    sync.Mutex
+	statuses []downloadStatus
+	// Receives a value when a status changes, unless it already has one that has not been received
+	updated chan struct{}
+}
+
+// The least time between redraws of the statuses of downloads, so that the terminal does not flash
+const downloadRedrawInterval = 30 * time.Millisecond
+
+// Draws the statuses of downloads and the logs that they send to the terminal until `ctx` is cancelled, which must only
+// happen once every download has finished. Returns the errors of the downloads that failed.
+func renderDownloads(ctx context.Context, sources []DownloadOptions, progress *downloadProgress, logs <-chan log, reportProgress func(progress []string)) []error {
 	errs := []error{}
-	startedDownloads := 0
-	downloadsInProgress := uint(0)
-	lastRedrawTime := time.Date(0, time.January, 0, 0, 0, 0, 0, time.UTC)
+	// The indexes of the downloads that have finished or failed, in the order that they did
+	finishedOrder := []int{}
+	var lastRedrawTime time.Time
 	var printBuffer strings.Builder
-	for true {
-		for downloadsInProgress < maxParallelDownloads && startedDownloads < len(sources) {
-			go download(sources[startedDownloads], stateWithNotifier[downloadStatus]{state: &statuses[startedDownloads], notifier: statusUpdated}, logs)
-			startedDownloads += 1
-			downloadsInProgress += 1
-		}
-		if downloadsInProgress > 0 || len(statusUpdated) > 0 {
-			<-statusUpdated
-		}
-		printBuffer.Write([]byte(AnsiClearBetweenCursorAndScreenEnd))
-		// Debounce the list redraws to mitagate the terminal flashing
-		now := time.Now()
-		if now.Sub(lastRedrawTime).Milliseconds() < 30 {
-			time.Sleep(lastRedrawTime.Add(time.Millisecond * 30).Sub(now))
-		}
-		lastRedrawTime = now
+	writeLog := func(log log) {
+		if log.severity < nonFatalErrorSeverity {
+			printBuffer.WriteString(log.message + "\n")
+			return
+		}
+		os.Stderr.WriteString(log.message + "\n")
+		if log.severity != fatalErrorSeverity {
+			return
+		}
+		// TODO: Cancel other downloads when one download has a fatal error
+		if log.err != nil {
+			errs = append(errs, log.err)
+		} else {
+			errs = append(errs, errors.New(log.message))
+		}
+	}
+	for {
+		finished := false
+		var receivedLog *log
+		select {
+		case <-ctx.Done():
+			finished = true
+		case <-progress.updated:
+		case log := <-logs:
+			receivedLog = &log
+		}
+		if !finished {
+			time.Sleep(time.Until(lastRedrawTime.Add(downloadRedrawInterval)))
+			lastRedrawTime = time.Now()
+		}
+		printBuffer.WriteString(AnsiClearBetweenCursorAndScreenEnd)
+		if receivedLog != nil {
+			writeLog(*receivedLog)
+		}
 		for len(logs) > 0 {
-			log := <-logs
-			if log.severity >= nonFatalErrorSeverity {
-				os.Stderr.WriteString(log.message + "\n")
-				if log.severity == fatalErrorSeverity {
-					// TODO: Cancel other downloads when one download has a fatal error
-					errs = append(errs, errors.New(log.message))
-				}
-			} else {
-				printBuffer.Write([]byte(log.message))
-				printBuffer.WriteByte('\n')
-			}
+			writeLog(<-logs)
 		}
-		if downloadsInProgress == 0 {
+		if finished {
 			print(printBuffer.String())
-			break
+			return errs
 		}
-		downloadsInProgress = 0
-		for i, source := range sources {
-			if statuses[i] != done && statuses[i] != failed && statuses[i] != queued {
-				downloadsInProgress += 1
+
+		progress.mutex.Lock()
+		currentStatuses := slices.Clone(progress.statuses)
+		progress.mutex.Unlock()
+		lines := make([]string, len(sources))
+		for index, source := range sources {
+			if (currentStatuses[index] == done || currentStatuses[index] == failed) && !slices.Contains(finishedOrder, index) {
+				finishedOrder = append(finishedOrder, index)
 			}
-			printBuffer.Write([]byte(source.Name + ": " + downloadStatusToAnsiString(statuses[i]) + "\n"))
+			lines[index] = source.Name + ": " + downloadStatusToString(currentStatuses[index])
+		}
+		// The full progress is reported even when only some of it fits in the terminal
+		if reportProgress != nil {
+			reportProgress(lines)
 		}
-		printBuffer.Write([]byte(AnsiMoveCursorUp(len(sources))))
+		lines = downloadStatusLines(sources, currentStatuses, finishedOrder, maxStatusLines())
+		for _, line := range lines {
+			printBuffer.WriteString(line + "\n")
+		}
+		printBuffer.WriteString(AnsiMoveCursorUp(len(lines)))
 		print(printBuffer.String()) // Print everything in one go to mitagate the terminal flashing
 		printBuffer.Reset()
 	}
-	return errs
 }
 
-func FetchPackageRepository(packageCacheDir string, maxParallelDownloads uint) []error {
+// Fetches a URL and writes its body to `writer`, which is used for files that are processed as they are fetched
+// instead of being saved
+func FetchInto(url string, writer io.Writer) error {
+	response, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return &HTTPStatusError{StatusCode: response.StatusCode}
+	}
+	_, err = io.Copy(writer, response.Body)
+	return err
+}
+
+// The GitHub repository that contains the package repository
+const packageRepository = "godalming123/binary-repository"
+
+// The file in the package cache that records the checksum of every file in the package repository, so that updating it
+// only replaces the files that changed
+const packageRepositoryManifestName = ".packageRepository.manifest.toml"
+
+// Returns the manifest of the package repository that was last downloaded with `FetchPackageRepository`, which maps
+// the path of every file in it, separated by `/`, to its checksum. The manifest is empty if the whole package
+// repository has never been downloaded.
+func ReadPackageRepositoryManifest(packageCacheDir string) (map[string]ManifestEntry, error) {
+	manifest := map[string]ManifestEntry{}
+	_, err := toml.DecodeFile(filepath.Join(packageCacheDir, packageRepositoryManifestName), &manifest)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	return manifest, err
+}
+
+func fetchSmallFile(url string, header http.Header) ([]byte, int, http.Header, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return []byte{}, 0, http.Header{}, err
+	}
+	request.Header = header
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return []byte{}, 0, http.Header{}, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	return body, response.StatusCode, response.Header, err
+}
+
+// Returns the commit that the main branch of the package repository currently points to. The response is saved in
+// `cacheDir`, so that asking again only needs a conditional request, which GitHub does not count towards its rate
+// limit when nothing changed.
+func FetchPackageRepositoryRevision(cacheDir string) (string, error) {
+	url := "https://api.github.com/repos/" + packageRepository + "/commits/main"
+	body, statusCode, err := fetchSmallFileWithCache(url, http.Header{"Accept": {"application/vnd.github.sha"}}, cacheDir, false)
+	if err != nil {
+		return "", err
+	}
+	if statusCode != http.StatusOK {
+		return "", &HTTPStatusError{Url: url, StatusCode: statusCode}
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// Fetches a single file from the package repository at a revision, without fetching the rest of the package
+// repository. Returns false if the file does not exist. The response is saved in `cacheDir`, and since a file at a
+// revision never changes, files that do not exist are not asked for again.
+func FetchPackageRepositoryFile(revision string, relativePath string, cacheDir string) ([]byte, bool, error) {
+	url := "https://raw.githubusercontent.com/" + packageRepository + "/" + revision + "/" + relativePath
+	body, statusCode, err := fetchSmallFileWithCache(url, http.Header{}, cacheDir, isCommitHash(revision))
+	if err != nil {
+		return []byte{}, false, err
+	}
+	switch statusCode {
+	case http.StatusOK:
+		return body, true, nil
+	case http.StatusNotFound:
+		return []byte{}, false, nil
+	default:
+		return []byte{}, false, &HTTPStatusError{Url: url, StatusCode: statusCode}
+	}
+}
+
+func FetchPackageRepository(packageCacheDir string, filesToKeep []string, maxParallelDownloads uint, onExtracted func(archive *os.File) error) []error {
 	return DownloadConcurrently([]DownloadOptions{{
 		Name:                             "Package repository",
-		Urls:                             []string{"https://github.com/godalming123/binary-repository/archive/refs/heads/main.zip"},
+		Urls:                             []string{"https://github.com/" + packageRepository + "/archive/refs/heads/main.zip"},
 		Compression:                      ".zip",
 		UseChecksum:                      false,
 		RootPath:                         "binary-repository-main",
 		Destination:                      packageCacheDir,
 		DeleteExistingFilesAtDestination: true,
-	}}, maxParallelDownloads)
+		FilesToKeepAtDestination:         filesToKeep,
+		ManifestName:                     packageRepositoryManifestName,
+		OnExtracted:                      onExtracted,
+	}}, maxParallelDownloads, nil)
 }
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Parses a reference to a blob in an OCI registry, like `ghcr.io/OWNER/REPOSITORY@sha256:DIGEST`, and returns the URL
+// that the blob is fetched from with the registry API, along with its sha256 checksum
+func ParseOciBlob(reference string) (string, [32]byte, error) {
+	name, digest, found := strings.Cut(reference, "@")
+	if !found {
+		return "", [32]byte{}, errors.New("Expected the OCI blob `" + reference + "` to be like `REGISTRY/REPOSITORY@sha256:DIGEST`")
+	}
+	registry, repository, found := strings.Cut(name, "/")
+	if !found || repository == "" {
+		return "", [32]byte{}, errors.New("Expected the OCI blob `" + reference + "` to include the registry and the repository, like `ghcr.io/OWNER/REPOSITORY@sha256:DIGEST`")
+	}
+	encodedChecksum, isSha256 := strings.CutPrefix(digest, "sha256:")
+	if !isSha256 {
+		return "", [32]byte{}, errors.New("Expected the digest of the OCI blob `" + reference + "` to be a sha256 digest, like `sha256:DIGEST`")
+	}
+	checksum, err := hex.DecodeString(encodedChecksum)
+	if err != nil || len(checksum) != 32 {
+		return "", [32]byte{}, errors.New("Expected the digest of the OCI blob `" + reference + "` to be 64 hexadecimal characters after `sha256:`")
+	}
+	return "https://" + registry + "/v2/" + repository + "/blobs/" + digest, [32]byte(checksum), nil
+}
+
+// Fetches requests through `httpTransport`, and answers the bearer token challenges that OCI registries respond to
+// requests without a token with, like `docker pull` does. Registries that require credentials are given the
+// credentials that `docker login` stored in the docker config of the user.
+type registryAuthTransport struct {
+	mutex sync.Mutex
+	// Maps the realm, service, and scope of each challenge that has been answered to the token that answered it
+	tokens map[string]string
+}
+
+func (t *registryAuthTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := httpTransport.RoundTrip(request)
+	if err != nil || response.StatusCode != http.StatusUnauthorized || (request.Method != http.MethodGet && request.Method != http.MethodHead) || request.Header.Get("Authorization") != "" {
+		return response, err
+	}
+	challenge, isBearer := strings.CutPrefix(response.Header.Get("WWW-Authenticate"), "Bearer ")
+	if !isBearer {
+		return response, nil
+	}
+	token, err := t.answerChallenge(parseChallengeParameters(challenge), request.URL.Host)
+	if err != nil {
+		response.Body.Close()
+		return nil, errors.New("Failed to get a token to fetch `" + request.URL.String() + "`: " + err.Error())
+	}
+	response.Body.Close()
+	authorizedRequest := request.Clone(request.Context())
+	authorizedRequest.Header.Set("Authorization", "Bearer "+token)
+	return httpTransport.RoundTrip(authorizedRequest)
+}
+
+// Parses the parameters of a challenge like `realm="https://ghcr.io/token",service="ghcr.io",scope="repository:a/b:pull"`
+func parseChallengeParameters(challenge string) map[string]string {
+	parameters := map[string]string{}
+	for challenge != "" {
+		name, rest, found := strings.Cut(strings.TrimLeft(challenge, ", "), "=")
+		if !found {
+			break
+		}
+		value := ""
+		if strings.HasPrefix(rest, "\"") {
+			value, rest, _ = strings.Cut(rest[1:], "\"")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		parameters[strings.ToLower(name)] = value
+		challenge = rest
+	}
+	return parameters
+}
+
+func (t *registryAuthTransport) answerChallenge(parameters map[string]string, host string) (string, error) {
+	if parameters["realm"] == "" {
+		return "", errors.New("The registry did not say where to get a token from")
+	}
+	key := parameters["realm"] + " " + parameters["service"] + " " + parameters["scope"]
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if token, ok := t.tokens[key]; ok {
+		return token, nil
+	}
+
+	tokenUrl, err := url.Parse(parameters["realm"])
+	if err != nil {
+		return "", err
+	}
+	query := tokenUrl.Query()
+	for _, name := range []string{"service", "scope"} {
+		if parameters[name] != "" {
+			query.Set(name, parameters[name])
+		}
+	}
+	tokenUrl.RawQuery = query.Encode()
+	if err := CheckHttpsPolicy(tokenUrl); err != nil {
+		return "", err
+	}
+	request, err := http.NewRequest(http.MethodGet, tokenUrl.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if credentials := registryCredentials(host); credentials != "" {
+		request.Header.Set("Authorization", "Basic "+credentials)
+	}
+	response, err := httpTransport.RoundTrip(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", errors.New("The token endpoint `" + parameters["realm"] + "` responded with `" + response.Status + "`. If the registry is private, log in to it with `docker login " + host + "`.")
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return "", errors.New("The token endpoint `" + parameters["realm"] + "` did not respond with a token")
+	}
+	t.tokens[key] = token
+	return token, nil
+}
+
+// Returns the base64 encoded `USERNAME:PASSWORD` that `docker login` stored for a registry, or an empty string if the
+// user has not logged in to it. Credentials that are stored with a credential helper are not supported.
+func registryCredentials(host string) string {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configDir = path.Join(homeDir, ".docker")
+	}
+	content, err := os.ReadFile(path.Join(configDir, "config.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(content, &config) != nil {
+		return ""
+	}
+	for _, key := range []string{host, "https://" + host, "https://" + host + "/v1/"} {
+		if auth := config.Auths[key].Auth; auth != "" {
+			if _, err := base64.StdEncoding.DecodeString(auth); err == nil {
+				return auth
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	osExec "os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"syscall"
+)
+
+// The variable that bento is started with when it is started to mount the path mappings of a command, and then replace
+// itself with the command
+const mountHelperVariable = "BENTO_MOUNT_HELPER"
+
+// The file descriptor that the command and its path mappings are sent to the mount helper through. The environment of
+// the command is not passed to the helper directly, since bento itself could fail to start with its `LD_LIBRARY_PATH`.
+const mountHelperFd = 3
+
+// `CAP_SYS_ADMIN`, which is needed to mount, and which the mount helper is given in its user namespace
+const capSysAdmin = 21
+
+type mountHelperRequest struct {
+	Argv        []string
+	Environment []string
+	// Maps absolute paths to the paths that are mounted over them
+	PathMappings map[string]string
+}
+
+// Returns a command that starts bento as a mount helper, which mounts the path mappings of `argv` and replaces itself
+// with it, and the pipe that the request has to be written to once the command is started
+func mountHelperCommand() (*osExec.Cmd, *os.File, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	command := osExec.Command(self)
+	command.Env = append(os.Environ(), mountHelperVariable+"="+strconv.Itoa(mountHelperFd))
+	command.ExtraFiles = []*os.File{reader}
+	return command, writer, nil
+}
+
+func writeMountHelperRequest(command *osExec.Cmd, writer *os.File, request mountHelperRequest) error {
+	command.ExtraFiles[0].Close()
+	defer writer.Close()
+	return json.NewEncoder(writer).Encode(request)
+}
+
+// Mounts `source` over `target`, which must already exist and be the same type of file
+func bindMount(source string, target string) error {
+	return syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, "")
+}
+
+// Replaces a directory that cannot be written to with a tmpfs that has the same files in it, so that new files can be
+// created in it. Each file that was in the directory is mounted over an empty file of the same type in the tmpfs.
+func makeWritableCopy(directory string) error {
+	if directory == "/" {
+		return errors.New("Cannot create files in `/`")
+	}
+	original, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+	info, err := original.Stat()
+	if err != nil {
+		return err
+	}
+	entries, err := original.ReadDir(-1)
+	if err != nil {
+		return err
+	}
+	// The files that were in the directory can still be reached through the open directory once it is covered
+	originalPath := "/proc/self/fd/" + strconv.Itoa(int(original.Fd()))
+	err = syscall.Mount("tmpfs", directory, "tmpfs", 0, "mode="+strconv.FormatUint(uint64(info.Mode().Perm()), 8))
+	if err != nil {
+		return WrapError("Failed to mount a tmpfs over `"+directory+"`", err)
+	}
+	for _, entry := range entries {
+		source, target := filepath.Join(originalPath, entry.Name()), filepath.Join(directory, entry.Name())
+		switch {
+		case entry.Type()&os.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(source)
+			if err == nil {
+				err = os.Symlink(linkTarget, target)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		case entry.IsDir():
+			err = os.Mkdir(target, 0755)
+		default:
+			err = os.WriteFile(target, []byte{}, 0644)
+		}
+		if err == nil {
+			err = bindMount(source, target)
+		}
+		if err != nil {
+			return WrapError("Failed to keep `"+target+"`", err)
+		}
+	}
+	return nil
+}
+
+// Creates a file or a directory to mount a file or a directory over. The nearest directory that exists is replaced with
+// a writable copy first, which only this mount namespace sees, so that nothing is created on the filesystem of the
+// host, and so that directories that the user cannot write to can be mounted in. `writable` has the directories that
+// are already writable copies, or that were created in one.
+func createMountPoint(target string, isDir bool, writable map[string]bool) error {
+	existing := filepath.Dir(target)
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	if !writable[existing] {
+		err := makeWritableCopy(existing)
+		if err != nil {
+			return err
+		}
+		writable[existing] = true
+	}
+	for directory := filepath.Dir(target); directory != existing; directory = filepath.Dir(directory) {
+		writable[directory] = true
+	}
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		return os.Mkdir(target, 0755)
+	}
+	return os.WriteFile(target, []byte{}, 0644)
+}
+
+// Mounts every source in `pathMappings` over its target, creating the targets that do not exist
+func mountPathMappings(pathMappings map[string]string) error {
+	// The mounts must not propagate to the mount namespace that bento was started in
+	err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return err
+	}
+	writable := map[string]bool{}
+	// Sorting the targets mounts every directory before the paths inside it
+	for _, target := range slices.Sorted(maps.Keys(pathMappings)) {
+		source := pathMappings[target]
+		sourceInfo, err := os.Stat(source)
+		if err != nil {
+			return err
+		}
+		targetInfo, err := os.Stat(target)
+		if os.IsNotExist(err) {
+			err = createMountPoint(target, sourceInfo.IsDir(), writable)
+		} else if err == nil && targetInfo.IsDir() != sourceInfo.IsDir() {
+			err = errors.New("`" + target + "` and `" + source + "` are not both directories or both files")
+		}
+		if err == nil {
+			err = bindMount(source, target)
+		}
+		if err != nil {
+			return WrapError("Failed to mount `"+source+"` at `"+target+"`", err)
+		}
+	}
+	return nil
+}
+
+// Does nothing, unless bento was started by `RunCommand` to mount the path mappings of a command, in which case the
+// path mappings are mounted, and bento is replaced with the command. This has to be called before bento does anything
+// else.
+func HandleMountHelper() {
+	if os.Getenv(mountHelperVariable) != strconv.Itoa(mountHelperFd) {
+		return
+	}
+	requestFile := os.NewFile(mountHelperFd, "mount helper request")
+	contents, err := io.ReadAll(requestFile)
+	requestFile.Close()
+	if err != nil {
+		Fail("Failed to read the paths to mount: " + err.Error())
+	}
+	var request mountHelperRequest
+	err = json.Unmarshal(contents, &request)
+	if err != nil {
+		Fail("Failed to read the paths to mount: " + err.Error())
+	}
+	err = mountPathMappings(request.PathMappings)
+	if err != nil {
+		Fail(err.Error())
+	}
+	// Capabilities belong to threads, so the thread that drops them has to be the thread that replaces bento
+	runtime.LockOSThread()
+	const prCapAmbient, prCapAmbientClearAll = 47, 4
+	syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0)
+	err = syscall.Exec(request.Argv[0], request.Argv, request.Environment)
+	Fail("Failed to execute binary `" + request.Argv[0] + "`: " + err.Error())
+}
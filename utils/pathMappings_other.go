@@ -0,0 +1,6 @@
+//go:build !linux
+
+package utils
+
+// Paths are only mounted for commands on linux, so bento is never started as a mount helper on other systems
+func HandleMountHelper() {}
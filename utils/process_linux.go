@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	osExec "os/exec"
+	"os/signal"
+	"syscall"
+)
+
+type RunOptions struct {
+	// Runs the command in a new network namespace that only has a loopback interface, so that the command cannot
+	// access the network. A user namespace is also created so that this does not need root permissions.
+	WithoutNetwork bool
+	// Raises the limit on the size of core dumps as far as it can be raised, so that the kernel can dump the core of
+	// the command if it crashes
+	AllowCoreDumps bool
+	// Maps absolute paths to the files or directories that are mounted over them in a new mount namespace that the
+	// command is run in, so that the command can find files in the places that it expects
+	PathMappings map[string]string
+}
+
+// How a command that was run ended
+type CommandResult struct {
+	ExitCode int
+	// The signal that killed the command, which is zero if the command exited
+	Signal syscall.Signal
+	// The kernel dumped the core of the command when it was killed
+	CoreDumped bool
+}
+
+// Runs a command as a child of this process, instead of replacing this process with it, so that this process can find
+// out how the command ended
+func RunCommand(argv []string, environment []string, options RunOptions) (CommandResult, error) {
+	if options.AllowCoreDumps {
+		var limit syscall.Rlimit
+		if syscall.Getrlimit(syscall.RLIMIT_CORE, &limit) == nil {
+			limit.Cur = limit.Max
+			syscall.Setrlimit(syscall.RLIMIT_CORE, &limit)
+		}
+	}
+	command := osExec.Command(argv[0], argv[1:]...)
+	command.Env = environment
+	var requestWriter *os.File
+	if len(options.PathMappings) > 0 {
+		// The paths have to be mounted inside of the new namespace, so bento starts itself in it to mount them, and then
+		// replaces itself with the command
+		var err error
+		command, requestWriter, err = mountHelperCommand()
+		if err != nil {
+			return CommandResult{}, err
+		}
+	}
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	var cloneflags uintptr
+	if options.WithoutNetwork {
+		cloneflags |= syscall.CLONE_NEWNET
+	}
+	if len(options.PathMappings) > 0 {
+		cloneflags |= syscall.CLONE_NEWNS
+	}
+	if cloneflags != 0 {
+		command.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags:                 syscall.CLONE_NEWUSER | cloneflags,
+			UidMappings:                []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}},
+			GidMappings:                []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}},
+			GidMappingsEnableSetgroups: false,
+		}
+	}
+	if requestWriter != nil {
+		command.SysProcAttr.AmbientCaps = []uintptr{capSysAdmin}
+	}
+	err := command.Start()
+	if err != nil && cloneflags != 0 {
+		return CommandResult{}, errors.New("Failed to create a namespace to run the command in, which may mean that user namespaces are disabled on this system: " + err.Error())
+	} else if err != nil {
+		return CommandResult{}, err
+	}
+	if requestWriter != nil {
+		err = writeMountHelperRequest(command, requestWriter, mountHelperRequest{Argv: argv, Environment: environment, PathMappings: options.PathMappings})
+		if err != nil {
+			command.Process.Kill()
+			command.Wait()
+			return CommandResult{}, WrapError("Failed to send the paths to mount", err)
+		}
+	}
+
+	// The terminal sends `SIGINT` and `SIGQUIT` to the command as well, so they are caught so that this process keeps
+	// running until the command exits, but only the other signals are forwarded
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	go func() {
+		for received := range signals {
+			if received == syscall.SIGTERM || received == syscall.SIGHUP {
+				command.Process.Signal(received)
+			}
+		}
+	}()
+	err = command.Wait()
+	signal.Stop(signals)
+	close(signals)
+	var exitError *osExec.ExitError
+	if err != nil && !errors.As(err, &exitError) {
+		return CommandResult{}, err
+	}
+	result := CommandResult{ExitCode: command.ProcessState.ExitCode()}
+	if status, ok := command.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.Signal = status.Signal()
+		result.CoreDumped = status.CoreDump()
+		// Shells report a command that was killed by a signal in the same way
+		result.ExitCode = 128 + int(status.Signal())
+	}
+	return result, nil
+}
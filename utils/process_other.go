@@ -0,0 +1,25 @@
+//go:build !linux
+
+package utils
+
+import (
+	"errors"
+	"syscall"
+)
+
+type RunOptions struct {
+	WithoutNetwork bool
+	AllowCoreDumps bool
+	PathMappings   map[string]string
+}
+
+type CommandResult struct {
+	ExitCode   int
+	Signal     syscall.Signal
+	CoreDumped bool
+}
+
+// Running commands as a child of bento is only supported on linux
+func RunCommand(argv []string, environment []string, options RunOptions) (CommandResult, error) {
+	return CommandResult{}, errors.ErrUnsupported
+}
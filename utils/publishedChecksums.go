@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Checksum files list a few files at most, so anything larger is not a checksum file
+const maxChecksumFileSize = 1024 * 1024
+
+// Returns the sha256 checksum of `fileName` from the contents of a checksum file, which either only has the checksum,
+// like a `.sha256` file, or has a line for each file in the format that `sha256sum` prints, like a `SHA256SUMS` file
+func ParseChecksumFile(contents string, fileName string) ([32]byte, error) {
+	var checksum [32]byte
+	lines := strings.FieldsFunc(contents, func(character rune) bool { return character == '\n' || character == '\r' })
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// `sha256sum` puts `*` before the names of files that it read in binary mode
+		matches := len(fields) == 1 && len(lines) == 1
+		if len(fields) >= 2 {
+			name := strings.TrimPrefix(fields[1], "*")
+			matches = name == fileName || path.Base(name) == fileName
+		}
+		if !matches {
+			continue
+		}
+		decoded, err := hex.DecodeString(fields[0])
+		if err != nil || len(decoded) != 32 {
+			return checksum, errors.New("Expected the checksum of `" + fileName + "` to be 64 hexadecimal characters, but got `" + fields[0] + "`")
+		}
+		copy(checksum[:], decoded)
+		return checksum, nil
+	}
+	return checksum, errors.New("The checksum file does not have a checksum for `" + fileName + "`")
+}
+
+// Fetches the sha256 checksum of `fileName` from a checksum file that is published next to it, trying each of `urls`
+// in order until one works. Only HTTPS URLs are fetched, since nothing else would confirm that the checksum came from
+// the mirror. Returns the checksum and the URL that it was fetched from.
+func FetchPublishedChecksum(urls []string, fileName string) ([32]byte, string, error) {
+	errs := []error{}
+	for _, url := range urls {
+		if !strings.HasPrefix(url, "https://") {
+			errs = append(errs, errors.New("Refusing to fetch a checksum from `"+url+"`, since it does not use HTTPS"))
+			continue
+		}
+		body, statusCode, _, err := fetchSmallFile(url, http.Header{})
+		if err == nil && statusCode != http.StatusOK {
+			err = &HTTPStatusError{Url: url, StatusCode: statusCode}
+		} else if err == nil && len(body) > maxChecksumFileSize {
+			err = errors.New("`" + url + "` is too large to be a checksum file")
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		checksum, err := ParseChecksumFile(string(body), fileName)
+		if err != nil {
+			errs = append(errs, WrapError("Failed to read the checksum file at `"+url+"`", err))
+			continue
+		}
+		return checksum, url, nil
+	}
+	return [32]byte{}, "", errors.Join(errs...)
+}
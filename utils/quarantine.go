@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A record of a download that did not match its checksum, which is kept so that maintainers of a package repository
+// can find out which mirrors are out of date or compromised
+type quarantinedDownload struct {
+	Name             string
+	Url              string
+	ExpectedChecksum string
+	Checksum         string
+	Size             int64
+	Fetched          time.Time
+	Header           http.Header
+}
+
+// Saves a download that did not match its checksum to `quarantineDir`, and returns the path it was saved to. The
+// payload is named after its checksum, so the same payload is only saved once, and every time that it is served is
+// appended to a TOML file next to it.
+func quarantine(quarantineDir string, record quarantinedDownload, payload *os.File) (string, error) {
+	err := os.MkdirAll(quarantineDir, 0755)
+	if err != nil {
+		return "", err
+	}
+	payloadInfo, err := payload.Stat()
+	if err != nil {
+		return "", err
+	}
+	record.Size = payloadInfo.Size()
+	payloadPath := path.Join(quarantineDir, record.Checksum)
+	if _, err := os.Stat(payloadPath); os.IsNotExist(err) {
+		err = copyFileContents(payload, record.Size, payloadPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	encoded := bytes.NewBuffer([]byte{})
+	err = toml.NewEncoder(encoded).Encode(map[string][]quarantinedDownload{"Download": {record}})
+	if err != nil {
+		return "", err
+	}
+	recordFile, err := os.OpenFile(payloadPath+".toml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer recordFile.Close()
+	_, err = recordFile.Write(append(encoded.Bytes(), '\n'))
+	return payloadPath, err
+}
+
+func copyFileContents(source *os.File, size int64, destinationPath string) error {
+	destination, err := os.Create(destinationPath)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+	_, err = io.Copy(destination, io.NewSectionReader(source, 0, size))
+	return err
+}
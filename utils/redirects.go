@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Sites that are well known to redirect downloads to another domain that they own, like GitHub redirecting release
+// downloads to signed URLs on `githubusercontent.com`
+var knownRedirectSites = map[string][]string{
+	"github.com": {"githubusercontent.com"},
+}
+
+// Returns the site that a host is part of, which is its registrable domain, like `example.com` for
+// `downloads.example.com` or `example.co.uk` for `downloads.example.co.uk`, or the host itself if it is an IP address or
+// is a public suffix, like `github.io`
+func siteOfHost(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	site, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return site
+}
+
+// Returns true if a redirect from `fromHost` to `toHost` stays on the same site, or goes to a domain that the site of
+// `fromHost` is well known to redirect to
+func IsSameSiteRedirect(fromHost string, toHost string) bool {
+	fromSite, toSite := siteOfHost(fromHost), siteOfHost(toHost)
+	if fromSite == toSite {
+		return true
+	}
+	// Some of these are public suffixes, like `githubusercontent.com`, so every host on them is a site of its own
+	for _, domain := range knownRedirectSites[fromSite] {
+		if toSite == domain || strings.HasSuffix(toSite, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the host that a response to a request for `requestUrl` came from, if it is on another site, after following
+// redirects
+func crossSiteRedirectHost(requestUrl string, response *http.Response) (string, bool) {
+	requested, err := url.Parse(requestUrl)
+	if err != nil || response.Request == nil || IsSameSiteRedirect(requested.Hostname(), response.Request.URL.Hostname()) {
+		return "", false
+	}
+	return response.Request.URL.Hostname(), true
+}
+
+// Returns a warning about a redirect to `host` on another site. A mirror that redirects to another site can have been
+// hijacked, even if the download still matches its checksum, since a checksum cannot say whether the right server was
+// contacted.
+func crossSiteRedirectWarning(requestUrl string, host string) string {
+	return "`" + requestUrl + "` redirected to `" + host + "`, which is on a different site to the mirror. The download is still checked against its checksum, but make sure that the mirror is meant to redirect there, since it can mean that the mirror was hijacked."
+}
+
+// Follows the redirects of a URL without downloading it, and returns the URL that it ends up at
+func ResolveRedirects(rawUrl string) (string, error) {
+	client := http.Client{Transport: httpClient.Transport, Timeout: 10 * time.Second}
+	response, err := client.Head(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	return response.Request.URL.String(), nil
+}
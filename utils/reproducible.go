@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// The modification time given to every file by `NormalizeTree`
+var reproducibleModificationTime = time.Unix(0, 0)
+
+// Sets the modification time of every file and directory in a tree to a fixed time, and normalizes their permissions,
+// so that extracting the same archive on different machines always produces an identical tree
+func NormalizeTree(root string) error {
+	directories := []string{}
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			return setSymlinkModificationTime(filePath, reproducibleModificationTime)
+		case entry.IsDir():
+			directories = append(directories, filePath)
+			return os.Chmod(filePath, 0755)
+		default:
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			mode := fs.FileMode(0644)
+			if info.Mode()&0111 != 0 {
+				mode = 0755
+			}
+			err = os.Chmod(filePath, mode)
+			if err != nil {
+				return err
+			}
+			return os.Chtimes(filePath, reproducibleModificationTime, reproducibleModificationTime)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	// Set the modification times of directories last, and children before parents, because creating files changes the
+	// modification time of the directory that they are in
+	for _, directory := range slices.Backward(directories) {
+		err := os.Chtimes(directory, reproducibleModificationTime, reproducibleModificationTime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
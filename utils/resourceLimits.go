@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Limits on the resources that an executable can use, which are applied to bento just before it is replaced with the
+// executable, so that the executable inherits them. Limits that are not set are inherited from bento.
+type ResourceLimits struct {
+	// The most files that the executable can have open at once
+	OpenFiles uint64
+	// The most memory that the executable can map, like `4GiB`
+	AddressSpace string
+	// How nice the executable is to other processes, from -20 to 19, where higher values are scheduled less often.
+	// Only root can make an executable less nice than bento.
+	Nice *int
+	// Added to the score that the kernel uses to choose which process to kill when it runs out of memory, from -1000
+	// to 1000, where higher values are killed first. Only root can lower it.
+	OomScoreAdjust *int
+}
+
+func (l ResourceLimits) IsEmpty() bool {
+	return l.OpenFiles == 0 && l.AddressSpace == "" && l.Nice == nil && l.OomScoreAdjust == nil
+}
+
+// Returns the limits with the limits that are set in `overrides` replacing them
+func (l ResourceLimits) Merge(overrides ResourceLimits) ResourceLimits {
+	if overrides.OpenFiles != 0 {
+		l.OpenFiles = overrides.OpenFiles
+	}
+	if overrides.AddressSpace != "" {
+		l.AddressSpace = overrides.AddressSpace
+	}
+	if overrides.Nice != nil {
+		l.Nice = overrides.Nice
+	}
+	if overrides.OomScoreAdjust != nil {
+		l.OomScoreAdjust = overrides.OomScoreAdjust
+	}
+	return l
+}
+
+func (l ResourceLimits) Validate() error {
+	if l.AddressSpace != "" {
+		if _, err := ParseSize(l.AddressSpace); err != nil {
+			return WrapError("Invalid `AddressSpace`", err)
+		}
+	}
+	if l.Nice != nil && (*l.Nice < -20 || *l.Nice > 19) {
+		return errors.New("Expected `Nice` to be between -20 and 19, but got " + strconv.Itoa(*l.Nice))
+	}
+	if l.OomScoreAdjust != nil && (*l.OomScoreAdjust < -1000 || *l.OomScoreAdjust > 1000) {
+		return errors.New("Expected `OomScoreAdjust` to be between -1000 and 1000, but got " + strconv.Itoa(*l.OomScoreAdjust))
+	}
+	return nil
+}
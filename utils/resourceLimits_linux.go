@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Applies resource limits to this process, so that the executable that it is replaced with inherits them
+func ApplyResourceLimits(limits ResourceLimits) error {
+	if limits.OpenFiles != 0 {
+		err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: limits.OpenFiles, Max: limits.OpenFiles})
+		if err != nil {
+			return WrapError("Failed to limit the number of open files", err)
+		}
+	}
+	if limits.AddressSpace != "" {
+		addressSpace, err := ParseSize(limits.AddressSpace)
+		if err != nil {
+			return err
+		}
+		err = syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: uint64(addressSpace), Max: uint64(addressSpace)})
+		if err != nil {
+			return WrapError("Failed to limit the address space", err)
+		}
+	}
+	if limits.Nice != nil {
+		err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *limits.Nice)
+		if err != nil {
+			return WrapError("Failed to set the niceness to "+strconv.Itoa(*limits.Nice), err)
+		}
+	}
+	if limits.OomScoreAdjust != nil {
+		err := os.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(*limits.OomScoreAdjust)), 0644)
+		if err != nil {
+			return WrapError("Failed to adjust the OOM score", err)
+		}
+	}
+	return nil
+}
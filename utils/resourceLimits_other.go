@@ -0,0 +1,13 @@
+//go:build !linux
+
+package utils
+
+import "errors"
+
+// Resource limits are only supported on linux
+func ApplyResourceLimits(limits ResourceLimits) error {
+	if limits.IsEmpty() {
+		return nil
+	}
+	return errors.ErrUnsupported
+}
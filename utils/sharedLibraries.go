@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"debug/elf"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A shared library that the dynamic linker loads for an executable
+type LoadedLibrary struct {
+	Soname string
+	// The file that the library is loaded from, which is empty if the dynamic linker cannot find it
+	Path string
+	// The executable or library that needs the library
+	NeededBy string
+	// Whether the library is found in a directory that the dynamic linker searches by default, rather than in
+	// `LD_LIBRARY_PATH` or in a directory that the file that needs it points to
+	FromSystem bool
+}
+
+// Returns the directories in the `DT_RPATH` or `DT_RUNPATH` of an ELF file, with `$ORIGIN` replaced by the directory
+// of the file
+func elfSearchPath(file *elf.File, tag elf.DynTag, filePath string) []string {
+	values, _ := file.DynString(tag)
+	directories := []string{}
+	for _, value := range values {
+		for _, directory := range strings.Split(value, ":") {
+			directory = strings.ReplaceAll(directory, "${ORIGIN}", path.Dir(filePath))
+			directory = strings.ReplaceAll(directory, "$ORIGIN", path.Dir(filePath))
+			if directory != "" {
+				directories = append(directories, directory)
+			}
+		}
+	}
+	return directories
+}
+
+// Returns true if the dynamic linker can load the file at `filePath` into a program that is built like `file`. The
+// dynamic linker skips libraries that are built for another architecture, and keeps searching.
+func isCompatibleLibrary(filePath string, file *elf.File) bool {
+	library, err := elf.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer library.Close()
+	return library.Class == file.Class && library.Machine == file.Machine
+}
+
+// An ELF file that the dynamic linker loads, and the search paths that it uses to find the libraries that it needs
+type loadedElfFile struct {
+	path    string
+	rpath   []string
+	runpath []string
+}
+
+// Finds the file that the dynamic linker loads for every shared library that an ELF executable needs, including the
+// ones that are needed by its libraries, in the order that the dynamic linker loads them. It searches the
+// directories in the same order as the dynamic linker of glibc, except that it does not read `/etc/ld.so.cache`, which
+// contains the same libraries as the directories in `/etc/ld.so.conf` unless it is out of date. Libraries are found
+// without running any code from the executable. Also returns the dynamic linker that the executable asks for, which
+// is empty if it is statically linked.
+func ResolveSharedLibraries(executable string, libraryPath []string) ([]LoadedLibrary, string, error) {
+	executable, err := filepath.EvalSymlinks(executable)
+	if err != nil {
+		return nil, "", err
+	}
+	file, err := elf.Open(executable)
+	if err != nil {
+		return nil, "", WrapError("`"+executable+"` is not an ELF executable", err)
+	}
+	defer file.Close()
+	interpreter := ""
+	for _, program := range file.Progs {
+		if program.Type == elf.PT_INTERP {
+			contents := make([]byte, program.Filesz)
+			_, err := program.ReadAt(contents, 0)
+			if err != nil {
+				return nil, "", WrapError("Failed to read the dynamic linker of `"+executable+"`", err)
+			}
+			interpreter = strings.TrimRight(string(contents), "\x00")
+		}
+	}
+	executableRpath := elfSearchPath(file, elf.DT_RPATH, executable)
+	systemDirectories := LinkerDefaultDirectories()
+
+	loaded := []LoadedLibrary{}
+	foundSonames := map[string]bool{}
+	queue := []loadedElfFile{{path: executable, rpath: executableRpath, runpath: elfSearchPath(file, elf.DT_RUNPATH, executable)}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		currentFile, err := elf.Open(current.path)
+		if err != nil {
+			return loaded, interpreter, WrapError("Failed to read `"+current.path+"`", err)
+		}
+		needed, err := currentFile.ImportedLibraries()
+		if err != nil {
+			currentFile.Close()
+			return loaded, interpreter, WrapError("Failed to read the libraries that `"+current.path+"` needs", err)
+		}
+		// `DT_RPATH` is ignored when a file has a `DT_RUNPATH`, and the `DT_RPATH` of the executable is searched after the
+		// `DT_RPATH` of the library
+		searchPath := []string{}
+		if len(current.runpath) == 0 {
+			searchPath = append(searchPath, current.rpath...)
+			if current.path != executable {
+				searchPath = append(searchPath, executableRpath...)
+			}
+		}
+		searchPath = append(searchPath, libraryPath...)
+		searchPath = append(searchPath, current.runpath...)
+		for _, soname := range needed {
+			if foundSonames[soname] {
+				continue
+			}
+			foundSonames[soname] = true
+			library := LoadedLibrary{Soname: soname, NeededBy: current.path}
+			if strings.Contains(soname, "/") {
+				if isCompatibleLibrary(soname, currentFile) {
+					library.Path = soname
+				}
+			} else {
+				for _, directory := range searchPath {
+					if candidate := path.Join(directory, soname); isCompatibleLibrary(candidate, currentFile) {
+						library.Path = candidate
+						break
+					}
+				}
+				for _, directory := range systemDirectories {
+					if library.Path != "" {
+						break
+					}
+					if candidate := path.Join(directory, soname); isCompatibleLibrary(candidate, currentFile) {
+						library.Path, library.FromSystem = candidate, true
+					}
+				}
+			}
+			loaded = append(loaded, library)
+			if library.Path == "" {
+				continue
+			}
+			libraryFile, err := elf.Open(library.Path)
+			if err != nil {
+				continue
+			}
+			queue = append(queue, loadedElfFile{path: library.Path, rpath: elfSearchPath(libraryFile, elf.DT_RPATH, library.Path), runpath: elfSearchPath(libraryFile, elf.DT_RUNPATH, library.Path)})
+			libraryFile.Close()
+		}
+		currentFile.Close()
+	}
+	return loaded, interpreter, nil
+}
+
+// Returns the program that runs a script, from its shebang, or an error if the file is not a script
+func ScriptInterpreter(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	contents := make([]byte, 256)
+	length, _ := file.Read(contents)
+	line, _, _ := strings.Cut(string(contents[:length]), "\n")
+	shebang, isScript := strings.CutPrefix(line, "#!")
+	if !isScript || len(strings.Fields(shebang)) == 0 {
+		return nil, errors.New("`" + filePath + "` is neither an ELF executable nor a script")
+	}
+	return strings.Fields(shebang), nil
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Returns the user ID of the process on the other end of a unix socket connection, as it was when the connection was
+// made
+func SocketPeerUid(connection net.Conn) (int, error) {
+	unixConnection, ok := connection.(*net.UnixConn)
+	if !ok {
+		return 0, errors.New("Expected a unix socket connection")
+	}
+	rawConnection, err := unixConnection.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var credentials *syscall.Ucred
+	var credentialsErr error
+	err = rawConnection.Control(func(fd uintptr) {
+		credentials, credentialsErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if credentialsErr != nil {
+		return 0, credentialsErr
+	}
+	return int(credentials.Uid), nil
+}
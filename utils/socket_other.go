@@ -0,0 +1,13 @@
+//go:build !linux
+
+package utils
+
+import (
+	"errors"
+	"net"
+)
+
+// The user ID of the process on the other end of a unix socket connection is not known on this operating system
+func SocketPeerUid(connection net.Conn) (int, error) {
+	return 0, errors.ErrUnsupported
+}
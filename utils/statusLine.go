@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"time"
+)
+
+// How long an operation has to take before a status line is shown for it, so that fast operations do not make the
+// terminal flash
+const statusLineDelay = 200 * time.Millisecond
+
+// A single line on stderr that is redrawn in place to show the progress of an operation, using the same style as the
+// list of downloads
+type StatusLine struct {
+	start          time.Time
+	lastRedrawTime time.Time
+	drawn          bool
+}
+
+func NewStatusLine() *StatusLine {
+	return &StatusLine{start: time.Now()}
+}
+
+// Redraws the status line, unless it was redrawn very recently or the operation only just started
+func (s *StatusLine) Update(message string) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	// Debounce the redraws to mitagate the terminal flashing
+	if now.Sub(s.start) < statusLineDelay || now.Sub(s.lastRedrawTime).Milliseconds() < 30 {
+		return
+	}
+	s.lastRedrawTime = now
+	s.drawn = true
+	print(AnsiClearBetweenCursorAndScreenEnd + message + "\n" + AnsiMoveCursorUp(1))
+}
+
+// Removes the status line, so that whatever is printed next is printed where it was
+func (s *StatusLine) Clear() {
+	if s != nil && s.drawn {
+		print(AnsiClearBetweenCursorAndScreenEnd)
+		s.drawn = false
+	}
+}
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Returns true if a download can be extracted as it is fetched, instead of being fetched into a file first. Only
+// tarballs can be, since the files in a zip are found with the index at its end. Downloads that have an inner archive,
+// or whose archive is needed after it is extracted, are fetched into a file.
+func canExtractWhileFetching(options DownloadOptions) bool {
+	return options.UseChecksum && isTarball(options.Compression) && len(options.InnerArchives) == 0 && options.OnExtracted == nil
+}
+
+// Moves the files in `from` into `to`, merging the directories that are in both, and replacing the other files that are
+// in both
+func moveInto(from string, to string) error {
+	if _, err := os.Lstat(to); os.IsNotExist(err) {
+		err := os.MkdirAll(filepath.Dir(to), 0755)
+		if err != nil {
+			return err
+		}
+		return os.Rename(from, to)
+	}
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		source, target := filepath.Join(from, entry.Name()), filepath.Join(to, entry.Name())
+		targetInfo, err := os.Lstat(target)
+		if err == nil && entry.IsDir() && targetInfo.IsDir() {
+			err = moveInto(source, target)
+		} else {
+			if err == nil {
+				err = os.RemoveAll(target)
+			} else if os.IsNotExist(err) {
+				err = nil
+			}
+			if err == nil {
+				err = os.Rename(source, target)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetches a tarball from a URL and extracts it into `temporaryDir` as it is fetched. The tarball is extracted before
+// its checksum is checked, but only through an `os.Root`, so a changed tarball cannot write outside of `temporaryDir`.
+// If `payload` is not nil, the tarball is also written to it, so that it can be quarantined if its checksum does not
+// match. Returns the sha256 checksum of the tarball, the header of the response, and the error that extracting it
+// failed with, which is only meaningful if the checksum matches, since a tarball that was changed can fail to extract.
+func fetchAndExtract(url string, status stateWithNotifier[downloadStatus], options DownloadOptions, temporaryDir string, payload *os.File, logs chan<- log) ([32]byte, http.Header, error, error) {
+	body, header, err := fetchBody(url, options.RefuseCrossSiteRedirects, status, logs)
+	if err != nil {
+		return [32]byte{}, nil, nil, err
+	}
+	defer body.Close()
+	hash := sha256.New()
+	var stream io.Reader = io.TeeReader(body, hash)
+	if payload != nil {
+		stream = io.TeeReader(stream, payload)
+	}
+	extractionErr := extractTarball(stream, options.Compression, temporaryDir, newArchiveRoot(options.RootPath, options.Filter))
+	// The rest of the tarball is read even if extracting it failed, since the padding at the end of a tarball is not
+	// read by extracting it, and the checksum says whether the extraction failed because the tarball was changed
+	_, err = io.Copy(io.Discard, stream)
+	if err != nil {
+		return [32]byte{}, nil, nil, err
+	}
+	return [32]byte(hash.Sum(nil)), header, extractionErr, nil
+}
+
+// Fetches a tarball and extracts it at the same time, so that the time spent fetching and extracting it overlaps, and
+// it does not have to be read back from a file. The files are extracted into a temporary directory next to the
+// destination, and are only moved into the destination once the checksum of the tarball matches. The tarball is only
+// saved to a file if there is a quarantine to save it to when its checksum does not match.
+func extractWhileFetching(options DownloadOptions, status stateWithNotifier[downloadStatus], logs chan<- log) {
+	mismatchedUrls := []string{}
+	attempts := []error{}
+	for _, url := range options.Urls {
+		err := os.MkdirAll(filepath.Dir(options.Destination), 0755)
+		if err != nil {
+			logs <- fatalError("Failed to create a directory to extract `" + options.Name + "` into: " + err.Error())
+			status.setState(failed)
+			return
+		}
+		// The name matches the temporary files that `bento gc` removes if bento crashes
+		temporaryDir, err := os.MkdirTemp(filepath.Dir(options.Destination), ".extract.tmp-"+strconv.Itoa(os.Getpid())+"-*")
+		if err != nil {
+			logs <- fatalError("Failed to create a directory to extract `" + options.Name + "` into: " + err.Error())
+			status.setState(failed)
+			return
+		}
+		defer os.RemoveAll(temporaryDir)
+		var payload *os.File
+		if options.QuarantineDir != "" {
+			payload, err = createPayloadFile(options.Destination)
+			if err != nil {
+				logs <- fatalError("Failed to create a file to download `" + options.Name + "` into: " + err.Error())
+				status.setState(failed)
+				return
+			}
+			defer os.Remove(payload.Name())
+			defer payload.Close()
+		}
+		dataChecksum, header, extractionErr, err := fetchAndExtract(url, status, options, temporaryDir, payload, logs)
+		if err != nil {
+			err = &FetchError{Name: options.Name, Url: url, Err: err}
+			attempts = append(attempts, err)
+			logs <- nonFatalError(err.Error())
+			continue
+		}
+		logs <- info("Fetched `" + options.Name + "` from `" + url + "` while extracting it")
+
+		status.setState(checkingHash)
+		if dataChecksum != options.Checksum {
+			err := &ChecksumMismatchError{Name: options.Name, Url: url, Expected: hex.EncodeToString(options.Checksum[:]), Actual: hex.EncodeToString(dataChecksum[:])}
+			attempts = append(attempts, err)
+			logs <- nonFatalError(err.Error())
+			mismatchedUrls = append(mismatchedUrls, url)
+			if payload != nil {
+				quarantinePath, err := quarantine(options.QuarantineDir, quarantinedDownload{
+					Name:             options.Name,
+					Url:              url,
+					ExpectedChecksum: hex.EncodeToString(options.Checksum[:]),
+					Checksum:         hex.EncodeToString(dataChecksum[:]),
+					Fetched:          time.Now(),
+					Header:           header,
+				}, payload)
+				if err != nil {
+					logs <- nonFatalError("Failed to quarantine `" + options.Name + "` from `" + url + "`: " + err.Error())
+				} else {
+					logs <- info("Saved `" + options.Name + "` from `" + url + "` to " + quarantinePath)
+				}
+			}
+			continue
+		}
+		logs <- log{message: "Cryptographically verified `" + options.Name + "` using sha256 hash"}
+		if extractionErr != nil {
+			logs <- fatalErrorFrom(&ExtractionError{Name: options.Name, Err: extractionErr})
+			status.setState(failed)
+			return
+		}
+
+		if options.extractionLock != nil {
+			options.extractionLock.Lock()
+			defer options.extractionLock.Unlock()
+		}
+		if options.DeleteExistingFilesAtDestination {
+			status.setState(deletingOldFiles)
+			err := removeAllExcept(options.Destination, options.FilesToKeepAtDestination)
+			if err != nil && !os.IsNotExist(err) {
+				logs <- fatalError(err.Error())
+			}
+		}
+		status.setState(extracting)
+		extractionStart := time.Now()
+		err = moveInto(temporaryDir, options.Destination)
+		extractionNanoseconds.Add(int64(time.Since(extractionStart)))
+		if err != nil {
+			logs <- fatalErrorFrom(&ExtractionError{Name: options.Name, Err: err})
+			status.setState(failed)
+			return
+		}
+		logs <- info("Extracted `" + options.Name + "` into " + options.Destination)
+		finishDownload(options, status, logs)
+		return
+	}
+	failDownload(options, mismatchedUrls, attempts, status, logs)
+}
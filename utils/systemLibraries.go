@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Directories that are searched by the dynamic linker on most distros, even when they are not listed in
+// `/etc/ld.so.conf`
+var defaultSystemLibraryDirectories = []string{
+	"/lib",
+	"/lib64",
+	"/usr/lib",
+	"/usr/lib64",
+	"/lib/x86_64-linux-gnu",
+	"/usr/lib/x86_64-linux-gnu",
+	"/lib/aarch64-linux-gnu",
+	"/usr/lib/aarch64-linux-gnu",
+}
+
+func readLdSoConf(confPath string, visitedConfs map[string]struct{}, directories []string) []string {
+	if _, visited := visitedConfs[confPath]; visited {
+		return directories
+	}
+	visitedConfs[confPath] = struct{}{}
+	file, err := os.Open(confPath)
+	if err != nil {
+		return directories
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if includePattern, isInclude := TrimPrefix(line, "include "); isInclude {
+			includePattern = strings.TrimSpace(includePattern)
+			if !path.IsAbs(includePattern) {
+				includePattern = path.Join(path.Dir(confPath), includePattern)
+			}
+			includedConfs, _ := filepath.Glob(includePattern)
+			for _, includedConf := range includedConfs {
+				directories = readLdSoConf(includedConf, visitedConfs, directories)
+			}
+		} else if line != "" {
+			directories = append(directories, line)
+		}
+	}
+	return directories
+}
+
+// Returns the directories that the dynamic linker of the host system searches for shared libraries
+func SystemLibraryDirectories() []string {
+	directories := []string{}
+	for _, directory := range strings.Split(os.Getenv("LD_LIBRARY_PATH"), ":") {
+		if directory != "" {
+			directories = append(directories, directory)
+		}
+	}
+	return append(directories, LinkerDefaultDirectories()...)
+}
+
+// Returns the directories that the dynamic linker of the host system searches for shared libraries after the ones in
+// `LD_LIBRARY_PATH`
+func LinkerDefaultDirectories() []string {
+	directories := readLdSoConf("/etc/ld.so.conf", map[string]struct{}{}, []string{})
+	return append(directories, defaultSystemLibraryDirectories...)
+}
+
+// Searches the host system for a shared library with the given soname, and returns its path if it is found
+func FindSystemLibrary(soname string, systemLibraryDirectories []string) (string, bool) {
+	for _, directory := range systemLibraryDirectories {
+		libraryPath := path.Join(directory, soname)
+		if _, err := os.Stat(libraryPath); err == nil {
+			return libraryPath, true
+		}
+	}
+	return "", false
+}
+
+// Returns the `ID` of the host distro followed by the IDs of the distros that it is derived from (`ID_LIKE`), as
+// specified in `/etc/os-release`
+func DistroIds() []string {
+	contents, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		contents, err = os.ReadFile("/usr/lib/os-release")
+		if err != nil {
+			return []string{}
+		}
+	}
+	id := []string{}
+	idLike := []string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		value = strings.Trim(value, "\"'")
+		switch key {
+		case "ID":
+			id = []string{value}
+		case "ID_LIKE":
+			idLike = strings.Fields(value)
+		}
+	}
+	return append(id, idLike...)
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Colors that mean the same thing everywhere that bento prints a list, so that the output is easy to scan
+const (
+	// Something that the user should read before continuing, like an installation warning or an unknown license
+	AnsiAttention = AnsiFgYellow
+	// A license that bento knows the terms of
+	AnsiLicense = AnsiFgGreen
+	// An amount of data
+	AnsiSize = AnsiFgCyan
+)
+
+// Returns the number of characters that a string takes up in a terminal, ignoring ANSI escape sequences
+func VisibleWidth(s string) int {
+	width := 0
+	for len(s) > 0 {
+		if strings.HasPrefix(s, "\033[") {
+			end := strings.IndexFunc(s[2:], func(r rune) bool { return r >= '@' && r <= '~' })
+			if end >= 0 {
+				s = s[2+end+1:]
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		width += 1
+	}
+	return width
+}
+
+// Pads every cell so that the cells in each column line up, and returns each row as a single line. The last cell of
+// each row is not padded, so that lines do not have trailing spaces.
+func AlignColumns(rows [][]string) []string {
+	widths := []int{}
+	for _, row := range rows {
+		for column, cell := range row {
+			if column == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[column] = max(widths[column], VisibleWidth(cell))
+		}
+	}
+	lines := make([]string, len(rows))
+	for index, row := range rows {
+		var line strings.Builder
+		for column, cell := range row {
+			line.WriteString(cell)
+			if column < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[column]-VisibleWidth(cell)+2))
+			}
+		}
+		lines[index] = line.String()
+	}
+	return lines
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Returns true if a file is a terminal, rather than a pipe, a regular file, or a device like `/dev/null`
+func IsTerminal(file *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
+
+// Returns the number of rows in a terminal, or false if the file is not a terminal
+func TerminalHeight(file *os.File) (int, bool) {
+	var size struct{ rows, columns, width, height uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	return int(size.rows), errno == 0 && size.rows > 0
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package utils
+
+import "os"
+
+// Returns true if a file is a terminal. Other character devices, like `/dev/null`, are also treated as terminals.
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Returns the number of rows in a terminal, which is not known on this operating system
+func TerminalHeight(file *os.File) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Refuses requests over plain HTTP, when the user requires HTTPS in their bento config
+var requireHttps bool
+
+// Maps a host to the SHA-256 hashes of the public keys that the certificate chain of its TLS connections must contain
+// one of
+var pinnedPublicKeys = map[string][][32]byte{}
+
+// Sets whether every request must use HTTPS, and the public keys that the certificates of hosts are pinned to. Each pin
+// is like `sha256/BASE64`, which is the base64 encoded SHA-256 hash of the DER encoded public key of a certificate, and
+// is what `curl --pinnedpubkey` takes after its `sha256//` prefix.
+func SetTlsPolicy(https bool, pins map[string][]string) error {
+	parsedPins := map[string][][32]byte{}
+	for host, hostPins := range pins {
+		for _, pin := range hostPins {
+			encoded, hasPrefix := strings.CutPrefix(pin, "sha256/")
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if !hasPrefix || err != nil || len(decoded) != sha256.Size {
+				return errors.New("Expected the pinned public key `" + pin + "` for `" + host + "` to be like `sha256/BASE64`, where BASE64 is the base64 encoded SHA-256 hash of the public key")
+			}
+			parsedPins[strings.ToLower(host)] = append(parsedPins[strings.ToLower(host)], [32]byte(decoded))
+		}
+	}
+	requireHttps = https
+	pinnedPublicKeys = parsedPins
+	if len(parsedPins) > 0 {
+		httpTransport.TLSClientConfig = &tls.Config{VerifyConnection: verifyPinnedPublicKeys}
+	}
+	return nil
+}
+
+// Runs after the certificate chain of a connection has been verified, and rejects it if its host has pinned public
+// keys and none of the certificates in the chain have one of them, so that a certificate from another authority that
+// the system trusts cannot be used to intercept the connection
+func verifyPinnedPublicKeys(state tls.ConnectionState) error {
+	pins, pinned := pinnedPublicKeys[strings.ToLower(state.ServerName)]
+	if !pinned {
+		return nil
+	}
+	for _, certificate := range state.PeerCertificates {
+		if slices.Contains(pins, sha256.Sum256(certificate.RawSubjectPublicKeyInfo)) {
+			return nil
+		}
+	}
+	return errors.New("The certificate of `" + state.ServerName + "` does not have any of the public keys that are pinned for it in your bento config, so the connection may be being intercepted")
+}
+
+// Returns an error if a URL does not use HTTPS, and the user requires HTTPS
+func CheckHttpsPolicy(requestUrl *url.URL) error {
+	if requireHttps && requestUrl.Scheme != "https" {
+		return errors.New("Refusing to fetch `" + requestUrl.String() + "` without HTTPS, because `RequireHttps` is set in your bento config")
+	}
+	return nil
+}
+
+// Refuses requests that do not use HTTPS when the user requires it. Redirects go through the transport too, so a
+// mirror cannot redirect a download to plain HTTP.
+type httpsPolicyTransport struct {
+	next http.RoundTripper
+}
+
+func (t *httpsPolicyTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := CheckHttpsPolicy(request.URL); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(request)
+}
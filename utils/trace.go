@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Logs the steps that bento takes to stderr, along with how long they took, when tracing is enabled
+type Tracer struct {
+	enabled bool
+	start   time.Time
+	// The time spent on each category that has been measured, which is recorded even when tracing is disabled
+	timings map[string]time.Duration
+	// The categories that are being measured, with the innermost one last
+	measuring []string
+	// When the time was last added to the innermost category that is being measured
+	lastSwitch time.Time
+}
+
+func NewTracer(enabled bool) *Tracer {
+	return &Tracer{enabled: enabled, start: time.Now(), timings: map[string]time.Duration{}}
+}
+
+func (t *Tracer) Log(message string) {
+	if t.enabled {
+		os.Stderr.WriteString(fmt.Sprintf("%s[trace %9.3fms]%s %s\n", AnsiFgCyan, float64(time.Since(t.start).Microseconds())/1000, AnsiReset, message))
+	}
+}
+
+// Logs the start of a phase, and returns a function that logs the end of the phase and how long it took
+func (t *Tracer) StartPhase(name string) func() {
+	if !t.enabled {
+		return func() {}
+	}
+	phaseStart := time.Now()
+	t.Log("Started " + name)
+	return func() {
+		t.Log(fmt.Sprintf("Finished %s in %.3fms", name, float64(time.Since(phaseStart).Microseconds())/1000))
+	}
+}
+
+// Adds the time since the innermost category that is being measured started or resumed to it
+func (t *Tracer) addTimeToInnermostCategory() {
+	now := time.Now()
+	if len(t.measuring) > 0 {
+		t.timings[t.measuring[len(t.measuring)-1]] += now.Sub(t.lastSwitch)
+	}
+	t.lastSwitch = now
+}
+
+// Adds the time until the returned function is called to `category`. A category that is measured while another
+// category is being measured pauses the other category, so that the time is only added to one of them.
+func (t *Tracer) Measure(category string) func() {
+	t.addTimeToInnermostCategory()
+	t.measuring = append(t.measuring, category)
+	return func() {
+		t.addTimeToInnermostCategory()
+		t.measuring = t.measuring[:len(t.measuring)-1]
+	}
+}
+
+// Returns the time that was spent on a category
+func (t *Tracer) Timing(category string) time.Duration {
+	return t.timings[category]
+}
+
+// Returns the time since the tracer was created
+func (t *Tracer) Elapsed() time.Duration {
+	return time.Since(t.start)
+}
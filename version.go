@@ -0,0 +1,60 @@
+package main
+
+import (
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// The version of bento, which is set with `-ldflags "-X main.bentoVersion=VERSION"` when a release is built
+var bentoVersion = ""
+
+// Returns the version of bento, or an empty string if bento was built from a checkout of the repository without a
+// version
+func currentBentoVersion() string {
+	if bentoVersion != "" {
+		return strings.TrimPrefix(bentoVersion, "v")
+	}
+	// Bento was installed with `go install github.com/godalming123/bento@VERSION`
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return strings.TrimPrefix(info.Main.Version, "v")
+	}
+	return ""
+}
+
+// Compares versions like `1.2.10` part by part, so that `1.10` is newer than `1.9`. Parts that are not numbers are
+// compared as strings, and anything after a `-` or `+` is ignored.
+func compareVersions(a string, b string) int {
+	a, _, _ = strings.Cut(strings.SplitN(a, "+", 2)[0], "-")
+	b, _, _ = strings.Cut(strings.SplitN(b, "+", 2)[0], "-")
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for index := range max(len(aParts), len(bParts)) {
+		aPart, bPart := "0", "0"
+		if index < len(aParts) {
+			aPart = aParts[index]
+		}
+		if index < len(bParts) {
+			bPart = bParts[index]
+		}
+		aNumber, aErr := strconv.Atoi(aPart)
+		bNumber, bErr := strconv.Atoi(bPart)
+		switch {
+		case aErr == nil && bErr == nil && aNumber != bNumber:
+			return aNumber - bNumber
+		case (aErr != nil || bErr != nil) && aPart != bPart:
+			return strings.Compare(aPart, bPart)
+		}
+	}
+	return 0
+}
+
+// Returns an error message if this version of bento is too old for a source config that needs `minimumVersion`.
+// Versions of bento that were built without a version are assumed to be new enough.
+func checkMinimumBentoVersion(minimumVersion string) (string, bool) {
+	version := currentBentoVersion()
+	if minimumVersion == "" || version == "" || compareVersions(version, minimumVersion) >= 0 {
+		return "", true
+	}
+	return "The source needs bento " + minimumVersion + " or newer, but this is bento " + version + ". Upgrade bento by downloading the latest release from https://github.com/godalming123/bento/releases.", false
+}
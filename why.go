@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// The most dependency chains that `bento why` prints, because a source that many sources depend on can be reached in
+// more ways than anyone would read
+const maxDependencyChains = 20
+
+// Returns every chain of dependencies from one of `requestedSources` to `source` that does not visit a source twice,
+// shortest first
+func (r *resolver) findDependencyChains(requestedSources []string, source string) [][]sourceDependency {
+	chains := [][]sourceDependency{}
+	var search func(current string, chain []sourceDependency, visited map[string]bool)
+	search = func(current string, chain []sourceDependency, visited map[string]bool) {
+		if current == source {
+			chains = append(chains, slices.Clone(chain))
+			return
+		}
+		visited[current] = true
+		for _, dependency := range r.dependencies[current] {
+			if !visited[dependency.source] {
+				search(dependency.source, append(chain, dependency), visited)
+			}
+		}
+		visited[current] = false
+	}
+	for _, requestedSource := range requestedSources {
+		// The requested source is the first step of the chain, and it does not have a reason
+		search(requestedSource, []sourceDependency{{source: requestedSource}}, map[string]bool{})
+	}
+	slices.SortStableFunc(chains, func(a []sourceDependency, b []sourceDependency) int {
+		return len(a) - len(b)
+	})
+	return chains
+}
+
+// Returns the names of the sources that have been installed
+func installedSourceNames(bentoDir string) ([]string, error) {
+	entries, err := os.ReadDir(path.Join(bentoDir, installedSourcesDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, utils.WrapError("Failed to read the installed sources", err)
+	}
+	sourceNames := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			sourceNames = append(sourceNames, entry.Name())
+		}
+	}
+	return sourceNames, nil
+}
+
+// How `bento why` finds the sources that the source it explains could be needed by
+type whyPlan struct {
+	// The sources to install, which are the installed sources when this is empty and `execSource` is empty
+	installSources []string
+	// The source and executable to run, instead of sources to install
+	execSource     string
+	execExecutable string
+}
+
+// Prints the chains of dependencies that cause a source to be downloaded when the sources in a plan are installed, or
+// when an executable is run, so that users can find out which sources make a closure bigger than they expected
+func explainWhySourceIsNeeded(bentoDir string, sourceName string, plan whyPlan) {
+	installedFeatures, err := readInstalledFeatures(bentoDir)
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	config, err := loadUserConfig()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	r := newResolver(bentoDir, environmentToMap(os.Environ()), installedFeatures, config)
+	hideProgress := r.showProgress(false)
+	requestedSources := plan.installSources
+	description := "installing `" + strings.Join(plan.installSources, "`, `") + "`"
+	requestedDescription := "is requested"
+	if plan.execSource != "" {
+		requestedSources = []string{plan.execSource}
+		description = "running `" + plan.execExecutable + "` from `" + plan.execSource + "`"
+		_, err = r.resolveCommand(plan.execSource, plan.execExecutable)
+	} else {
+		if len(requestedSources) == 0 {
+			requestedSources, err = installedSourceNames(bentoDir)
+			description = "the installed sources"
+			requestedDescription = "is installed"
+		}
+		if err == nil {
+			requestedSources, _, err = r.expandBundles(requestedSources)
+		}
+		for _, requestedSource := range requestedSources {
+			if err == nil {
+				err = r.loadAllExecutables(requestedSource)
+			}
+		}
+	}
+	hideProgress()
+	if err != nil {
+		utils.Fail(err.Error())
+	}
+	printWarnings(r.warnings)
+
+	chains := r.findDependencyChains(requestedSources, sourceName)
+	if len(chains) == 0 {
+		println("`" + sourceName + "` is not needed by " + description)
+		return
+	}
+	println("`" + sourceName + "` is needed by " + description + " because of " + utils.CreateNoun(len(chains), "1 chain of dependencies", "chains of dependencies") + ":")
+	for index, chain := range chains[:min(len(chains), maxDependencyChains)] {
+		println(strconv.Itoa(index+1) + ". " + utils.AnsiBold + chain[0].source + utils.AnsiReset + " " + requestedDescription)
+		for _, dependency := range chain[1:] {
+			line := "   needs " + utils.AnsiBold + dependency.source + utils.AnsiReset + " because " + dependency.reason
+			if dependency.optional {
+				line += " (from a feature that you chose to install)"
+			}
+			println(line)
+		}
+	}
+	if len(chains) > maxDependencyChains {
+		println("... and " + utils.CreateNoun(len(chains)-maxDependencyChains, "1 more chain", "more chains"))
+	}
+}
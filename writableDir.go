@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/godalming123/bento/utils"
+)
+
+// The variable that users can set to a writable directory, like a directory on a tmpfs, to make bento keep everything
+// that it writes in it, instead of in the home directory. This lets bento run in containers and CI images where the
+// home directory is read-only or missing. The bento config is still read from the home directory.
+const writableDirVariable = "BENTO_WRITABLE_DIR"
+
+// Returns the directory in `BENTO_WRITABLE_DIR` that bento writes what `name` describes to, or false if it is not set
+func writableDirOverride(name string) (string, bool) {
+	writableDir := os.Getenv(writableDirVariable)
+	if writableDir == "" {
+		return "", false
+	}
+	return path.Join(writableDir, name), true
+}
+
+// The hint that is added to errors caused by a home directory that is missing or read-only
+const writableDirHint = "Set `" + writableDirVariable + "` to a writable directory, like a directory on a tmpfs, to make bento write everything there instead."
+
+// A file or directory could not be written, because it is on a read-only filesystem or the user does not have
+// permission to write to it
+type readOnlyError struct {
+	path string
+	err  error
+}
+
+func (e *readOnlyError) Error() string {
+	message := e.err.Error() + ". "
+	writableDir := os.Getenv(writableDirVariable)
+	switch {
+	case writableDir == "":
+		message += "This happens when the home directory is read-only, like in some containers and CI images. " + writableDirHint
+	case utils.IsWithin(e.path, writableDir):
+		message += "Make sure that `" + writableDir + "`, which `" + writableDirVariable + "` is set to, is writable."
+	default:
+		message += "It is not in `" + writableDir + "`, which `" + writableDirVariable + "` is set to, so choose a writable path for it instead, like by unsetting `BENTO_DIR`."
+	}
+	return message
+}
+
+func (e *readOnlyError) Unwrap() error {
+	return e.err
+}
+
+// Explains how to make bento write somewhere else if `err` is from writing to a file or directory that is on a
+// read-only filesystem, or that the user does not have permission to write to. Other errors are returned unchanged.
+func explainWriteError(err error) error {
+	var pathError *fs.PathError
+	if !errors.As(err, &pathError) || !(errors.Is(err, syscall.EROFS) || errors.Is(err, fs.ErrPermission)) {
+		return err
+	}
+	return &readOnlyError{pathError.Path, err}
+}